@@ -3,8 +3,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -14,6 +16,127 @@ type Config struct {
 	BasePodSpec      string            `yaml:"basePodSpec"`
 	SideCarResources *SideCarResources `yaml:"sideCarResources"`
 	VolumeAttributes *VolumeAttributes `yaml:"volumeAttributes"`
+	Sweep            *Sweep            `yaml:"sweep,omitempty"`
+	Execution        *Execution        `yaml:"execution,omitempty"`
+}
+
+// Execution configures how cases are run
+type Execution struct {
+	// Workers is the number of cases run concurrently, every case on a node of its own
+	Workers int `yaml:"workers,omitempty"`
+	// NodeSelector selects the nodes cases are pinned to when more than one worker runs cases
+	NodeSelector map[string]string `yaml:"nodeSelector,omitempty"`
+	// Trials is the number of times every case is run and measured
+	Trials int `yaml:"trials,omitempty"`
+	// Warmup is the number of unmeasured runs of every case before its trials
+	Warmup int `yaml:"warmup,omitempty"`
+	// ColdStart runs every trial on a node that hasn't run a benchmark pod before
+	ColdStart bool `yaml:"coldStart,omitempty"`
+	// Timeout of a pod becoming ready once it's scheduled, 10m by default
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// ModelLoad detects when the model is loaded, to time loading the model apart from readiness
+	ModelLoad *ModelLoad `yaml:"modelLoad,omitempty"`
+}
+
+// ModelLoad detects when the model is loaded, by a log line or an HTTP endpoint
+type ModelLoad struct {
+	// Container whose logs are matched or whose endpoint is requested, the first container by default
+	Container string `yaml:"container,omitempty"`
+	// LogRegex matches the log line printed once the model is loaded
+	LogRegex string `yaml:"logRegex,omitempty"`
+	// Endpoint is the path of an HTTP endpoint on Port that succeeds once the model is loaded
+	Endpoint string `yaml:"endpoint,omitempty"`
+	Port     int    `yaml:"port,omitempty"`
+}
+
+// Validate checks that the model load is detected either by a valid log regex or by an endpoint
+func (m *ModelLoad) Validate() error {
+	if (m.LogRegex == "") == (m.Endpoint == "") {
+		return fmt.Errorf("invalid value for 'execution.modelLoad': exactly one of 'logRegex' or 'endpoint' must be set")
+	}
+	if m.LogRegex != "" {
+		if _, err := regexp.Compile(m.LogRegex); err != nil {
+			return fmt.Errorf("invalid value for 'execution.modelLoad.logRegex': %v", err)
+		}
+	}
+	if m.Endpoint != "" && (m.Port <= 0 || m.Port > 65535) {
+		return fmt.Errorf("invalid value for 'execution.modelLoad.port': must be set to the port of 'endpoint'")
+	}
+	return nil
+}
+
+// PinsNodes returns whether cases are pinned to distinct nodes
+func (e *Execution) PinsNodes() bool {
+	return e.Workers > 1 || len(e.NodeSelector) > 0 || e.ColdStart
+}
+
+// Sweep strategies generating cases from the configuration
+const (
+	// StrategyCartesian sweeps every field of sideCarResources and volumeAttributes and combines the
+	// sweeps with every combination of the bool fields
+	StrategyCartesian = "cartesian"
+	// StrategyOneFactor varies one parameter at a time around the base values
+	StrategyOneFactor = "one-factor"
+	// StrategyLatinHypercube samples budget cases stratified over the range of every parameter
+	StrategyLatinHypercube = "latin-hypercube"
+	// StrategyRandom samples budget cases uniformly
+	StrategyRandom = "random"
+	// StrategyExplicit runs the listed cases
+	StrategyExplicit = "explicit"
+	// StrategyAdaptive runs the one-factor cases, then the neighbors of the fastest cases for a number of rounds
+	StrategyAdaptive = "adaptive"
+)
+
+// Sweep configures how cases are generated from the base values, steps and maxes
+type Sweep struct {
+	Strategy string `yaml:"strategy"`
+	// Seed of the latin-hypercube and random strategies
+	Seed int64 `yaml:"seed,omitempty"`
+	// Budget is the number of sampled cases, or the maximum number of cases of an adaptive round
+	Budget int `yaml:"budget,omitempty"`
+	// Cases of the explicit strategy, parameter paths such as sideCarResources.cpu-request to values
+	Cases []map[string]string `yaml:"cases,omitempty"`
+	// Rounds of the adaptive strategy
+	Rounds int `yaml:"rounds,omitempty"`
+	// Top is the number of fastest cases whose neighbors are run in an adaptive round
+	Top int `yaml:"top,omitempty"`
+}
+
+func (s *Sweep) setDefaults() {
+	if s.Strategy == "" {
+		s.Strategy = StrategyCartesian
+	}
+	if s.Strategy == StrategyAdaptive {
+		if s.Rounds == 0 {
+			s.Rounds = 2
+		}
+		if s.Top == 0 {
+			s.Top = 3
+		}
+	}
+}
+
+// Validate checks the sweep strategy and its settings
+func (s *Sweep) Validate() error {
+	switch s.Strategy {
+	case StrategyCartesian, StrategyOneFactor:
+	case StrategyLatinHypercube, StrategyRandom:
+		if s.Budget <= 0 {
+			return fmt.Errorf("sweep strategy %q requires a positive 'budget'", s.Strategy)
+		}
+	case StrategyExplicit:
+		if len(s.Cases) == 0 {
+			return fmt.Errorf("sweep strategy %q requires 'cases'", s.Strategy)
+		}
+	case StrategyAdaptive:
+		if s.Rounds < 0 || s.Top < 0 || s.Budget < 0 {
+			return fmt.Errorf("sweep strategy %q requires non-negative 'rounds', 'top' and 'budget'", s.Strategy)
+		}
+	default:
+		return fmt.Errorf("unknown sweep strategy %q, expected one of %s, %s, %s, %s, %s or %s", s.Strategy,
+			StrategyCartesian, StrategyOneFactor, StrategyLatinHypercube, StrategyRandom, StrategyExplicit, StrategyAdaptive)
+	}
+	return nil
 }
 
 // SideCarResources contains GCSFuse sidecar resources
@@ -92,6 +215,25 @@ func (r *Resource) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// SetValue sets the base value of the resource from a value such as 250m or 2Gi, a value without a
+// unit is in the unit of the resource
+func (r *Resource) SetValue(value string) error {
+	base, unit := parseValueUnit(value)
+	if base == 0 && !strings.HasPrefix(value, "0") {
+		return fmt.Errorf("invalid resource value %q", value)
+	}
+	r.Base = base
+	if unit != "" {
+		r.Unit = unit
+	}
+	return nil
+}
+
+// String formats the base value of the resource with its unit
+func (r Resource) String() string {
+	return fmt.Sprintf("%d%s", r.Base, r.Unit)
+}
+
 func (r *Resource) setDefaults() {
 	if r.Step == 0 {
 		r.Step = 1
@@ -156,6 +298,37 @@ func LoadConfig(filename string) (*Config, error) {
 		return nil, fmt.Errorf("invalid value for 'mountOptions.only-dir': must be set and cannot be '0'")
 	}
 
+	if config.Sweep == nil {
+		config.Sweep = &Sweep{}
+	}
+	config.Sweep.setDefaults()
+	if err := config.Sweep.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Execution == nil {
+		config.Execution = &Execution{}
+	}
+	if config.Execution.Workers < 0 || config.Execution.Trials < 0 || config.Execution.Warmup < 0 {
+		return nil, fmt.Errorf("invalid value for 'execution.workers', 'execution.trials' or 'execution.warmup': must not be negative")
+	}
+	if config.Execution.ModelLoad != nil {
+		if err := config.Execution.ModelLoad.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if config.Execution.Timeout < 0 {
+		return nil, fmt.Errorf("invalid value for 'execution.timeout': must not be negative")
+	}
+	if config.Execution.ColdStart && config.Execution.Warmup > 0 {
+		return nil, fmt.Errorf("invalid value for 'execution.warmup': warmup runs can't be combined with 'execution.coldStart'")
+	}
+	if config.Execution.Workers == 0 {
+		config.Execution.Workers = 1
+	}
+	if config.Execution.Trials == 0 {
+		config.Execution.Trials = 1
+	}
+
 	return config, nil
 }
 
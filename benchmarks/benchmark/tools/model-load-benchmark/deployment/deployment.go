@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"strconv"
 	"tool/config"
 
 	"google.golang.org/protobuf/proto"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/yaml"
@@ -16,6 +18,9 @@ import (
 
 const (
 	gcsFuseVolumeName string = "gcs-fuse-csi-ephemeral"
+	// CaseLabel labels benchmark pods with their case number
+	CaseLabel     string = "model-load-benchmark/case"
+	hostnameLabel string = "kubernetes.io/hostname"
 )
 
 type Deployment struct {
@@ -75,6 +80,52 @@ func (d *Deployment) setupDeployment() {
 	}
 }
 
+// SetCase names the pod after its case, so that cases can run concurrently, and labels it with the case number
+func (d *Deployment) SetCase(caseNo int) {
+	d.Pod.Name = fmt.Sprintf("%s-case-%d", d.Pod.Name, caseNo)
+	if d.Pod.Labels == nil {
+		d.Pod.Labels = map[string]string{}
+	}
+	d.Pod.Labels[CaseLabel] = strconv.Itoa(caseNo)
+}
+
+// PinToNode requires the pod to run on a node, without other benchmark pods so that their GCSFuse
+// caches and downloads don't skew the loading time
+func (d *Deployment) PinToNode(nodeName string) {
+	if d.Pod.Spec.Affinity == nil {
+		d.Pod.Spec.Affinity = &v1.Affinity{}
+	}
+	affinity := d.Pod.Spec.Affinity
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &v1.NodeAffinity{}
+	}
+	if affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{}
+	}
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []v1.NodeSelectorTerm{{}}
+	}
+	// node selector terms are ORed, the node is required by every term
+	for i := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[i].MatchExpressions = append(required.NodeSelectorTerms[i].MatchExpressions, v1.NodeSelectorRequirement{
+			Key:      hostnameLabel,
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{nodeName},
+		})
+	}
+
+	if affinity.PodAntiAffinity == nil {
+		affinity.PodAntiAffinity = &v1.PodAntiAffinity{}
+	}
+	affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, v1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: CaseLabel, Operator: metav1.LabelSelectorOpExists}},
+		},
+		TopologyKey: hostnameLabel,
+	})
+}
+
 func getGcsVolMount() v1.VolumeMount {
 	return v1.VolumeMount{
 		Name:      gcsFuseVolumeName,
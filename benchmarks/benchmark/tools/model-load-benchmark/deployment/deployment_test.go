@@ -0,0 +1,63 @@
+package deployment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetCase(t *testing.T) {
+	d := &Deployment{Pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "gcs-fuse-csi-file-cache-example"}}}
+	d.SetCase(12)
+
+	assert.Equal(t, "gcs-fuse-csi-file-cache-example-case-12", d.Pod.Name)
+	assert.Equal(t, map[string]string{CaseLabel: "12"}, d.Pod.Labels)
+}
+
+func TestPinToNode(t *testing.T) {
+	gpuRequirement := v1.NodeSelectorRequirement{Key: "cloud.google.com/gke-accelerator", Operator: v1.NodeSelectorOpExists}
+	nodeRequirement := v1.NodeSelectorRequirement{Key: hostnameLabel, Operator: v1.NodeSelectorOpIn, Values: []string{"node-1"}}
+	antiAffinity := v1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: CaseLabel, Operator: metav1.LabelSelectorOpExists}},
+		},
+		TopologyKey: hostnameLabel,
+	}
+
+	tests := []struct {
+		name     string
+		affinity *v1.Affinity
+		expected []v1.NodeSelectorTerm
+	}{
+		{
+			name:     "no affinity",
+			expected: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{nodeRequirement}}},
+		},
+		{
+			name: "the node is required by every existing term",
+			affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{
+					{MatchExpressions: []v1.NodeSelectorRequirement{gpuRequirement}},
+					{},
+				},
+			}}},
+			expected: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{gpuRequirement, nodeRequirement}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{nodeRequirement}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Deployment{Pod: &v1.Pod{Spec: v1.PodSpec{Affinity: tt.affinity}}}
+			d.PinToNode("node-1")
+
+			affinity := d.Pod.Spec.Affinity
+			assert.Equal(t, tt.expected, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms)
+			assert.Equal(t, []v1.PodAffinityTerm{antiAffinity}, affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+		})
+	}
+}
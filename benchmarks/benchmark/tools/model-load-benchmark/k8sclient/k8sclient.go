@@ -1,28 +1,47 @@
 package k8sclient
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	watchtools "k8s.io/client-go/tools/watch"
 	"k8s.io/client-go/util/homedir"
 )
 
 const (
-	maxRetryInterval = 60 * time.Second
-	failureThreshold = 10
+	schedulingTimeout     = 90 * time.Second
+	defaultMonitorTimeout = 10 * time.Minute
+	// modelLoadProbeInterval is the interval between requests to the model load endpoint
+	modelLoadProbeInterval = time.Second
+	// modelLoadGracePeriod is how long the model load is waited for once the pod is ready
+	modelLoadGracePeriod = 10 * time.Second
 )
 
 // Client holds the k8s clientset
 type Client struct {
-	client      *kubernetes.Clientset
+	client *kubernetes.Clientset
+	// httpClient is the clientset's HTTP client, for requests whose response headers are needed
+	httpClient  *http.Client
 	ClusterName string
 }
 
@@ -36,7 +55,11 @@ func InitClient() (*Client, error) {
 		return nil, fmt.Errorf("failed to load Kubernetes config: %v", err)
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes HTTP client: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfigAndClient(config, httpClient)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kubernetes clientset: %v", err)
 
@@ -44,6 +67,7 @@ func InitClient() (*Client, error) {
 	clusterName, _ := getClusterName()
 	client := &Client{
 		client:      clientset,
+		httpClient:  httpClient,
 		ClusterName: clusterName,
 	}
 
@@ -79,6 +103,30 @@ func (k *Client) GetNodes() (*v1.NodeList, error) {
 	return nodes, nil
 }
 
+// GetSchedulableNodes returns the names of the ready and schedulable nodes matching a label selector
+func (k *Client) GetSchedulableNodes(selector map[string]string) ([]string, error) {
+	nodes, err := k.client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes: %v", err)
+	}
+	var names []string
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == v1.NodeReady && condition.Status == v1.ConditionTrue {
+				names = append(names, node.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 func (k *Client) PodExists(podName, namespace string) (bool, error) {
 	_, err := k.client.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
 	if err != nil {
@@ -125,36 +173,33 @@ func (k *Client) DeletePod(pod *v1.Pod) error {
 	}
 }
 
-// GetPodNode waits until the pod is scheduled to a node or till a timeout.
-func (k *Client) GetPodNode(pod *v1.Pod) error {
-	timeout := 90 * time.Second
-	interval := 5 * time.Second
-	startTime := time.Now()
+// MonitorOptions configure how DeployAndMonitorPod times a pod
+type MonitorOptions struct {
+	// Timeout of the pod becoming ready once it's scheduled
+	Timeout time.Duration
+	// ModelLoadContainer is the container whose logs are matched or whose endpoint is probed to
+	// detect that the model is loaded, the first container if empty
+	ModelLoadContainer string
+	// ModelLoadLogRegex matches the log line printed once the model is loaded
+	ModelLoadLogRegex *regexp.Regexp
+	// ModelLoadEndpoint is the path of an HTTP endpoint on ModelLoadPort that succeeds once the model
+	// is loaded, probed through the API server proxy
+	ModelLoadEndpoint string
+	ModelLoadPort     int
+}
 
-	for {
-		updatedPod, err := k.client.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to get pod status: %v", err)
-		}
-		if updatedPod.Spec.NodeName != "" {
-			return nil
-		}
-		if time.Since(startTime) >= timeout {
-			return fmt.Errorf("90s timeout reached: pod %s in namespace %s is still not scheduled to any node", pod.Name, pod.Namespace)
-		}
-		time.Sleep(interval)
-	}
+func (o MonitorOptions) detectsModelLoad() bool {
+	return o.ModelLoadLogRegex != nil || o.ModelLoadEndpoint != ""
 }
 
-// DeployAndMonitorPod deploys the pod and waits till all pod and its containers are ready
-func (k *Client) DeployAndMonitorPod(pod *v1.Pod) (time.Duration, error) {
-	maxPeriodSeconds := int32(5)
-	maxInitialDelay := int32(0)
-	for _, container := range pod.Spec.Containers {
-		if container.ReadinessProbe != nil && container.ReadinessProbe.PeriodSeconds > maxPeriodSeconds {
-			maxPeriodSeconds = container.ReadinessProbe.PeriodSeconds
-			maxInitialDelay = max(maxInitialDelay, container.ReadinessProbe.InitialDelaySeconds)
-		}
+// DeployAndMonitorPod deploys the pod and watches it until it's ready, timing the phases of loading
+// the model from the pod's status and events
+func (k *Client) DeployAndMonitorPod(pod *v1.Pod, opts MonitorOptions) (*PodTiming, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultMonitorTimeout
+	}
+	if opts.ModelLoadContainer == "" && len(pod.Spec.Containers) > 0 {
+		opts.ModelLoadContainer = pod.Spec.Containers[0].Name
 	}
 	// Create the pod
 	namespace := "default"
@@ -165,61 +210,206 @@ func (k *Client) DeployAndMonitorPod(pod *v1.Pod) (time.Duration, error) {
 	}
 	err := k.DeletePod(pod)
 	if err != nil {
-		return -1, fmt.Errorf("failed to delete existing pod: %v", err)
+		return nil, fmt.Errorf("failed to delete existing pod: %v", err)
 	}
-	pod, err = k.client.CoreV1().Pods(namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+	timing := &PodTiming{}
+	created, err := k.client.CoreV1().Pods(namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
 	if err != nil {
-		return -1, fmt.Errorf("failed to create pod: %v", err)
+		return nil, fmt.Errorf("failed to create pod: %v", err)
 	}
-	// wait for pod to be placed on node
-	err = k.GetPodNode(pod)
+	timing.Created = created.CreationTimestamp.Time
+	if timing.Created.IsZero() {
+		timing.Created = time.Now()
+	}
+	defer k.DeletePod(created)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// watch the pod from its creation, the watch is reestablished if it's closed
+	podWatcher, err := watchtools.NewRetryWatcher(created.ResourceVersion, &cache.ListWatch{
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", created.Name).String()
+			return k.client.CoreV1().Pods(namespace).Watch(ctx, options)
+		},
+	})
 	if err != nil {
-		return -1, fmt.Errorf("failed to deploy pod: %v", err)
+		return nil, fmt.Errorf("failed to watch pod: %v", err)
+	}
+	defer podWatcher.Stop()
+	// image pulls are only reported by events, their timing is left out if they can't be watched
+	var eventResults <-chan watch.Event
+	if eventWatcher, err := k.watchPodEvents(ctx, created); err == nil {
+		defer eventWatcher.Stop()
+		eventResults = eventWatcher.ResultChan()
 	}
-	startTime := time.Now()
-	defer k.DeletePod(pod)
-	time.Sleep(time.Duration(maxInitialDelay) * time.Second)
-	// Monitor the pod status with exponential backoff
-	retryInterval := time.Duration(maxPeriodSeconds) * time.Second
-	failureCount := 0
-	for {
-		pod, err := k.client.CoreV1().Pods("default").Get(context.TODO(), pod.Name, metav1.GetOptions{})
-		if err != nil {
-			return -1, fmt.Errorf("failed to get pod status: %v", err)
-		}
 
-		// Check if all containers are ready
-		allContainersReady := true
-		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if !containerStatus.Ready {
-				allContainersReady = false
-				break
+	modelLoaded := make(chan time.Time, 1)
+	detectingModelLoad := false
+	timeout := time.After(schedulingTimeout)
+	var modelLoadGrace <-chan time.Time
+	timing.observePod(created, timing.Created)
+	for {
+		select {
+		case e, ok := <-podWatcher.ResultChan():
+			if !ok {
+				return timing, fmt.Errorf("pod %s watch closed", created.Name)
+			}
+			if e.Type == watch.Error {
+				return timing, fmt.Errorf("failed to watch pod: %v", errors.FromObject(e.Object))
+			}
+			p, ok := e.Object.(*v1.Pod)
+			if !ok {
+				continue
 			}
+			if e.Type == watch.Deleted {
+				return timing, fmt.Errorf("pod %s was deleted", p.Name)
+			}
+			scheduled := !timing.Scheduled.IsZero()
+			timing.observePod(p, time.Now())
+			if !scheduled && !timing.Scheduled.IsZero() {
+				timeout = time.After(opts.Timeout)
+			}
+			if p.Status.Phase == v1.PodFailed {
+				return timing, fmt.Errorf("pod %s failed: %s", p.Name, p.Status.Reason)
+			}
+			if opts.detectsModelLoad() && !detectingModelLoad && containerRunning(p, opts.ModelLoadContainer) {
+				detectingModelLoad = true
+				go k.detectModelLoad(ctx, p, opts, modelLoaded)
+			}
+			if !timing.Ready.IsZero() {
+				if !opts.detectsModelLoad() || !timing.ModelLoaded.IsZero() {
+					return timing, nil
+				}
+				// the model load may be detected shortly after the pod is ready
+				if modelLoadGrace == nil {
+					modelLoadGrace = time.After(modelLoadGracePeriod)
+				}
+			}
+		case e, ok := <-eventResults:
+			if !ok {
+				eventResults = nil
+				continue
+			}
+			if event, ok := e.Object.(*v1.Event); ok {
+				timing.observeEvent(event, time.Now())
+			}
+		case loaded := <-modelLoaded:
+			timing.ModelLoaded = loaded
+			if !timing.Ready.IsZero() {
+				return timing, nil
+			}
+		case <-modelLoadGrace:
+			return timing, nil
+		case <-timeout:
+			if timing.Scheduled.IsZero() {
+				return timing, fmt.Errorf("%v timeout reached: pod %s in namespace %s is still not scheduled to any node", schedulingTimeout, created.Name, namespace)
+			}
+			return timing, fmt.Errorf("pod monitoring timeout, not all containers ready after %v", opts.Timeout)
 		}
+	}
+}
+
+// watchPodEvents watches the events of a pod
+func (k *Client) watchPodEvents(ctx context.Context, pod *v1.Pod) (*watchtools.RetryWatcher, error) {
+	selector := fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": pod.Name,
+		"involvedObject.uid":  string(pod.UID),
+	}.AsSelector().String()
+	events, err := k.client.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pod events: %v", err)
+	}
+	return watchtools.NewRetryWatcher(events.ResourceVersion, &cache.ListWatch{
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return k.client.CoreV1().Events(pod.Namespace).Watch(ctx, options)
+		},
+	})
+}
 
-		if pod.Status.Phase == v1.PodRunning && allContainersReady {
-			endTime := time.Now()
-			return endTime.Sub(startTime), nil
+func containerRunning(pod *v1.Pod, name string) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == name {
+			return status.State.Running != nil
 		}
+	}
+	return false
+}
 
-		switch pod.Status.Phase {
-		case v1.PodSucceeded:
-			endTime := time.Now()
-			return endTime.Sub(startTime), nil
-		case v1.PodFailed:
-			return -1, fmt.Errorf("pod %s failed: %s", pod.Name, pod.Status.Reason)
+// detectModelLoad sends the time the model is loaded, when the container prints a line matching the
+// log regex or its endpoint succeeds, nothing if it isn't detected before ctx is done. Both are times
+// of the cluster's clocks: the timestamp of the log line, or the Date header of the endpoint's response.
+func (k *Client) detectModelLoad(ctx context.Context, pod *v1.Pod, opts MonitorOptions, loaded chan<- time.Time) {
+	if opts.ModelLoadLogRegex != nil {
+		stream, err := k.client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+			Container:  opts.ModelLoadContainer,
+			Follow:     true,
+			Timestamps: true,
+		}).Stream(ctx)
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+		scanner := bufio.NewScanner(stream)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			// lines are prefixed with the time the container wrote them
+			at, line := time.Now(), scanner.Text()
+			if timestamp, rest, ok := strings.Cut(line, " "); ok {
+				if parsed, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+					at, line = parsed, rest
+				}
+			}
+			if opts.ModelLoadLogRegex.MatchString(line) {
+				loaded <- at
+				return
+			}
 		}
+		return
+	}
 
-		// Exponential backoff with a maximum interval
-		time.Sleep(retryInterval)
-		retryInterval *= 2
-		if retryInterval > maxRetryInterval && retryInterval > time.Duration(maxPeriodSeconds)*time.Second {
-			retryInterval = maxRetryInterval
+	ticker := time.NewTicker(modelLoadProbeInterval)
+	defer ticker.Stop()
+	for {
+		if at, ok := k.probeModelLoad(ctx, pod, opts); ok {
+			loaded <- at
+			return
 		}
-		failureCount++
-		if failureCount > failureThreshold {
-			break
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 	}
-	return -1, fmt.Errorf("pod monitoring timeout, not all containers ready")
+}
+
+// probeModelLoad requests the model load endpoint of a pod through the API server proxy, and returns
+// whether it succeeded and the time of the response's Date header, set by the model server or the API
+// server. The Date header has a resolution of a second, the local time is only used if it's missing.
+func (k *Client) probeModelLoad(ctx context.Context, pod *v1.Pod, opts MonitorOptions) (time.Time, bool) {
+	url := k.client.CoreV1().RESTClient().Get().
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(utilnet.JoinSchemeNamePort("http", pod.Name, strconv.Itoa(opts.ModelLoadPort))).
+		SubResource("proxy").
+		Suffix(opts.ModelLoadEndpoint).
+		URL()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+	if err != nil {
+		return time.Time{}, false
+	}
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return time.Time{}, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return time.Time{}, false
+	}
+	at, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return time.Now(), true
+	}
+	return at, true
 }
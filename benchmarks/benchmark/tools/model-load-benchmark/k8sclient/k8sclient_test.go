@@ -0,0 +1,62 @@
+package k8sclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestProbeModelLoad(t *testing.T) {
+	date := time.Date(2024, 1, 1, 0, 0, 42, 0, time.UTC)
+	tests := []struct {
+		name       string
+		status     int
+		date       string
+		expectedOK bool
+		expectedAt time.Time
+	}{
+		{name: "loaded", status: http.StatusOK, date: date.Format(http.TimeFormat), expectedOK: true, expectedAt: date},
+		{name: "not loaded", status: http.StatusServiceUnavailable, date: date.Format(http.TimeFormat)},
+		{name: "loaded without Date header", status: http.StatusOK, expectedOK: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/v1/namespaces/default/pods/http:vllm:8000/proxy/health", r.URL.Path)
+				// the Date header is set by the server unless it's suppressed with a nil value
+				if tc.date == "" {
+					w.Header()["Date"] = nil
+				} else {
+					w.Header().Set("Date", tc.date)
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+			clientset, err := kubernetes.NewForConfigAndClient(&rest.Config{Host: server.URL}, server.Client())
+			assert.NoError(t, err)
+			client := &Client{client: clientset, httpClient: server.Client()}
+
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "vllm", Namespace: "default"}}
+			before := time.Now()
+			at, ok := client.probeModelLoad(context.Background(), pod, MonitorOptions{ModelLoadEndpoint: "/health", ModelLoadPort: 8000})
+			assert.Equal(t, tc.expectedOK, ok)
+			if !tc.expectedOK {
+				return
+			}
+			if tc.expectedAt.IsZero() {
+				// the local time is used if the Date header is missing
+				assert.False(t, at.Before(before))
+				return
+			}
+			assert.Equal(t, tc.expectedAt, at)
+		})
+	}
+}
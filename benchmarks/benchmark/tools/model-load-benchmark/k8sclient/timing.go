@@ -0,0 +1,217 @@
+package k8sclient
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gcsFuseSidecarName is the name of the GCSFuse sidecar container injected by GKE
+const gcsFuseSidecarName = "gke-gcsfuse-sidecar"
+
+// Phases of loading a model in a pod, measured between consecutive milestones. ImagePull overlaps
+// the phases in which images are pulled.
+const (
+	// PhaseScheduling is from the pod's creation until it's scheduled to a node
+	PhaseScheduling = "scheduling"
+	// PhaseImagePull is from the first image pull until the last image is pulled
+	PhaseImagePull = "imagePull"
+	// PhaseSidecarStart is from scheduling until the GCSFuse sidecar is running
+	PhaseSidecarStart = "sidecarStart"
+	// PhaseMountReady is from the GCSFuse sidecar running until the first main container is running,
+	// containers only start once their volumes are mounted
+	PhaseMountReady = "mountReady"
+	// PhaseContainerStart is until all main containers are running
+	PhaseContainerStart = "containerStart"
+	// PhaseModelLoad is from all main containers running until the model is loaded, detected by a
+	// log line or a readiness endpoint
+	PhaseModelLoad = "modelLoad"
+	// PhaseReadiness is until the pod is ready
+	PhaseReadiness = "readiness"
+	// PhaseTotal is from scheduling until the pod is ready, the elapsed time of the case
+	PhaseTotal = "total"
+)
+
+// PodTiming is when a pod reached the milestones of loading a model, taken from the timestamps in the
+// pod's status and events, or when they were observed by watches if these are missing
+type PodTiming struct {
+	Created               time.Time
+	Scheduled             time.Time
+	SidecarStarted        time.Time
+	FirstContainerStarted time.Time
+	ContainersStarted     time.Time
+	ModelLoaded           time.Time
+	Ready                 time.Time
+	PullStarted           time.Time
+	PullFinished          time.Time
+	// pulling are the containers whose image is being pulled
+	pulling map[string]bool
+}
+
+// Elapsed is the time from scheduling until the pod is ready
+func (t *PodTiming) Elapsed() time.Duration {
+	if t.Scheduled.IsZero() || t.Ready.IsZero() {
+		return -1
+	}
+	return t.Ready.Sub(t.Scheduled)
+}
+
+// observePod records the milestones a pod reached, at the times in its status or at now if these are
+// missing
+func (t *PodTiming) observePod(pod *v1.Pod, now time.Time) {
+	if t.Scheduled.IsZero() && pod.Spec.NodeName != "" {
+		t.Scheduled = conditionTime(pod, v1.PodScheduled, now)
+	}
+	for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if status.Name == gcsFuseSidecarName && status.State.Running != nil && t.SidecarStarted.IsZero() {
+			t.SidecarStarted = orNow(status.State.Running.StartedAt, now)
+		}
+	}
+
+	var started []time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == gcsFuseSidecarName {
+			continue
+		}
+		if status.State.Running != nil {
+			started = append(started, orNow(status.State.Running.StartedAt, now))
+		} else if status.State.Terminated != nil {
+			started = append(started, orNow(status.State.Terminated.StartedAt, now))
+		}
+	}
+	mainContainers := 0
+	for _, container := range pod.Spec.Containers {
+		if container.Name != gcsFuseSidecarName {
+			mainContainers++
+		}
+	}
+	if len(started) > 0 && t.FirstContainerStarted.IsZero() {
+		t.FirstContainerStarted = slices.MinFunc(started, time.Time.Compare)
+	}
+	if len(started) == mainContainers && mainContainers > 0 && t.ContainersStarted.IsZero() {
+		t.ContainersStarted = slices.MaxFunc(started, time.Time.Compare)
+	}
+
+	if t.Ready.IsZero() {
+		if podReady(pod) {
+			t.Ready = conditionTime(pod, v1.PodReady, now)
+		} else if pod.Status.Phase == v1.PodSucceeded {
+			t.Ready = finishedTime(pod, now)
+		}
+	}
+}
+
+func podReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// conditionTime is when the condition of the pod last became true, now if it isn't known
+func conditionTime(pod *v1.Pod, conditionType v1.PodConditionType, now time.Time) time.Time {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == v1.ConditionTrue {
+			return orNow(condition.LastTransitionTime, now)
+		}
+	}
+	return now
+}
+
+// finishedTime is when the last main container of a succeeded pod terminated, now if it isn't known
+func finishedTime(pod *v1.Pod, now time.Time) time.Time {
+	var finished time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != gcsFuseSidecarName && status.State.Terminated != nil && status.State.Terminated.FinishedAt.After(finished) {
+			finished = status.State.Terminated.FinishedAt.Time
+		}
+	}
+	if finished.IsZero() {
+		return now
+	}
+	return finished
+}
+
+func orNow(timestamp metav1.Time, now time.Time) time.Time {
+	if timestamp.IsZero() {
+		return now
+	}
+	return timestamp.Time
+}
+
+// eventTime is when an event first occurred, now if it isn't known
+func eventTime(event *v1.Event, now time.Time) time.Time {
+	switch {
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	case !event.FirstTimestamp.IsZero():
+		return event.FirstTimestamp.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	}
+	return now
+}
+
+// observeEvent records the image pulls of an event of a pod, at the time of the event or at now if
+// it's missing
+func (t *PodTiming) observeEvent(event *v1.Event, now time.Time) {
+	// the field path is spec.containers{name} or spec.initContainers{name}
+	container := event.InvolvedObject.FieldPath
+	if i := strings.Index(container, "{"); i >= 0 {
+		container = strings.TrimSuffix(container[i+1:], "}")
+	}
+	switch event.Reason {
+	case "Pulling":
+		if t.pulling == nil {
+			t.pulling = make(map[string]bool)
+		}
+		t.pulling[container] = true
+		if t.PullStarted.IsZero() {
+			t.PullStarted = eventTime(event, now)
+		}
+	case "Pulled":
+		// images already present on the node aren't pulled
+		if t.pulling[container] {
+			delete(t.pulling, container)
+			if at := eventTime(event, now); at.After(t.PullFinished) {
+				t.PullFinished = at
+			}
+		}
+	}
+}
+
+// Phases returns the durations of the phases the pod went through
+func (t *PodTiming) Phases() map[string]time.Duration {
+	phases := make(map[string]time.Duration)
+	// phases are negative if the clocks of the node, the API server or this client are skewed
+	between := func(phase string, start time.Time, end time.Time) {
+		if !start.IsZero() && !end.IsZero() {
+			phases[phase] = end.Sub(start)
+		}
+	}
+	between(PhaseScheduling, t.Created, t.Scheduled)
+	between(PhaseImagePull, t.PullStarted, t.PullFinished)
+	containersStartFrom := t.Scheduled
+	if !t.SidecarStarted.IsZero() {
+		between(PhaseSidecarStart, t.Scheduled, t.SidecarStarted)
+		between(PhaseMountReady, t.SidecarStarted, t.FirstContainerStarted)
+		containersStartFrom = t.FirstContainerStarted
+	}
+	between(PhaseContainerStart, containersStartFrom, t.ContainersStarted)
+	readinessFrom := t.ContainersStarted
+	if !t.ModelLoaded.IsZero() {
+		between(PhaseModelLoad, t.ContainersStarted, t.ModelLoaded)
+		readinessFrom = t.ModelLoaded
+	}
+	between(PhaseReadiness, readinessFrom, t.Ready)
+	between(PhaseTotal, t.Scheduled, t.Ready)
+	return phases
+}
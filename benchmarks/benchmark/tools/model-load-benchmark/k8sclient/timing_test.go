@@ -0,0 +1,181 @@
+package k8sclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodTimingPhases(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	running := v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+	waiting := v1.ContainerState{Waiting: &v1.ContainerStateWaiting{}}
+	pod := func(nodeName string, sidecar v1.ContainerState, main v1.ContainerState, ready bool) *v1.Pod {
+		p := &v1.Pod{
+			Spec: v1.PodSpec{NodeName: nodeName, Containers: []v1.Container{{Name: "vllm"}}},
+			Status: v1.PodStatus{
+				Phase:                 v1.PodPending,
+				InitContainerStatuses: []v1.ContainerStatus{{Name: gcsFuseSidecarName, State: sidecar}},
+				ContainerStatuses:     []v1.ContainerStatus{{Name: "vllm", State: main}},
+			},
+		}
+		if main.Running != nil {
+			p.Status.Phase = v1.PodRunning
+		}
+		if ready {
+			p.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+		}
+		return p
+	}
+	pullEvent := func(reason string, container string) *v1.Event {
+		return &v1.Event{Reason: reason, InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{" + container + "}"}}
+	}
+
+	tests := []struct {
+		name        string
+		modelLoaded time.Time
+		expected    map[string]time.Duration
+	}{
+		{
+			name: "without model load detection",
+			expected: map[string]time.Duration{
+				PhaseScheduling:     2 * time.Second,
+				PhaseImagePull:      4 * time.Second,
+				PhaseSidecarStart:   3 * time.Second,
+				PhaseMountReady:     5 * time.Second,
+				PhaseContainerStart: 0,
+				PhaseReadiness:      30 * time.Second,
+				PhaseTotal:          38 * time.Second,
+			},
+		},
+		{
+			name:        "with model load detection",
+			modelLoaded: at(35),
+			expected: map[string]time.Duration{
+				PhaseScheduling:     2 * time.Second,
+				PhaseImagePull:      4 * time.Second,
+				PhaseSidecarStart:   3 * time.Second,
+				PhaseMountReady:     5 * time.Second,
+				PhaseContainerStart: 0,
+				PhaseModelLoad:      25 * time.Second,
+				PhaseReadiness:      5 * time.Second,
+				PhaseTotal:          38 * time.Second,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timing := &PodTiming{Created: at(0)}
+			timing.observePod(pod("", waiting, waiting, false), at(1))
+			timing.observePod(pod("node-1", waiting, waiting, false), at(2))
+			timing.observeEvent(pullEvent("Pulling", "vllm"), at(3))
+			timing.observePod(pod("node-1", running, waiting, false), at(5))
+			// the image of the sidecar was already present on the node
+			timing.observeEvent(pullEvent("Pulled", gcsFuseSidecarName), at(6))
+			timing.observeEvent(pullEvent("Pulled", "vllm"), at(7))
+			timing.observePod(pod("node-1", running, running, false), at(10))
+			timing.ModelLoaded = tt.modelLoaded
+			timing.observePod(pod("node-1", running, running, true), at(40))
+			timing.observePod(pod("node-1", running, running, true), at(41))
+
+			assert.Equal(t, tt.expected, timing.Phases())
+			assert.Equal(t, 38*time.Second, timing.Elapsed())
+		})
+	}
+}
+
+func TestPodTimingNotReady(t *testing.T) {
+	timing := &PodTiming{Created: time.Now()}
+	timing.observePod(&v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "vllm"}}}}, time.Now())
+
+	assert.Equal(t, time.Duration(-1), timing.Elapsed())
+	assert.Empty(t, timing.Phases())
+}
+
+func TestPodTimingTimestamps(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) metav1.Time { return metav1.NewTime(start.Add(time.Duration(seconds) * time.Second)) }
+	// the watch delivers every change late, the timestamps in the status and events are used instead
+	observedAt := start.Add(time.Hour)
+
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{NodeName: "node-1", Containers: []v1.Container{{Name: "vllm"}, {Name: "proxy"}}},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			Conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: at(2)},
+				{Type: v1.PodReady, Status: v1.ConditionTrue, LastTransitionTime: at(40)},
+			},
+			InitContainerStatuses: []v1.ContainerStatus{
+				{Name: gcsFuseSidecarName, State: v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: at(5)}}},
+			},
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "vllm", State: v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: at(12)}}},
+				{Name: "proxy", State: v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: at(10)}}},
+			},
+		},
+	}
+	pullEvent := func(reason string, eventTime metav1.MicroTime, firstTimestamp metav1.Time) *v1.Event {
+		return &v1.Event{
+			Reason:         reason,
+			InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{vllm}"},
+			EventTime:      eventTime,
+			FirstTimestamp: firstTimestamp,
+		}
+	}
+
+	timing := &PodTiming{Created: at(0).Time}
+	timing.observeEvent(pullEvent("Pulling", metav1.MicroTime{}, at(3)), observedAt)
+	timing.observeEvent(pullEvent("Pulled", metav1.NewMicroTime(at(7).Time), metav1.Time{}), observedAt)
+	timing.observePod(pod, observedAt)
+
+	assert.Equal(t, map[string]time.Duration{
+		PhaseScheduling:     2 * time.Second,
+		PhaseImagePull:      4 * time.Second,
+		PhaseSidecarStart:   3 * time.Second,
+		PhaseMountReady:     5 * time.Second,
+		PhaseContainerStart: 2 * time.Second,
+		PhaseReadiness:      28 * time.Second,
+		PhaseTotal:          38 * time.Second,
+	}, timing.Phases())
+}
+
+func TestPodTimingSucceeded(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	finished := metav1.NewTime(start.Add(20 * time.Second))
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{NodeName: "node-1", Containers: []v1.Container{{Name: "vllm"}}},
+		Status: v1.PodStatus{
+			Phase: v1.PodSucceeded,
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "vllm", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{StartedAt: metav1.NewTime(start), FinishedAt: finished}}},
+			},
+		},
+	}
+
+	timing := &PodTiming{Created: start}
+	timing.observePod(pod, start.Add(time.Hour))
+
+	assert.Equal(t, finished.Time, timing.Ready)
+	assert.Equal(t, start, timing.ContainersStarted)
+}
+
+func TestPodTimingClockSkew(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// the model server's clock is behind the node's, the skew shows as a negative phase
+	timing := &PodTiming{
+		Scheduled:         start,
+		ContainersStarted: start.Add(10 * time.Second),
+		ModelLoaded:       start.Add(8 * time.Second),
+		Ready:             start.Add(12 * time.Second),
+	}
+
+	phases := timing.Phases()
+	assert.Equal(t, -2*time.Second, phases[PhaseModelLoad])
+	assert.Equal(t, 4*time.Second, phases[PhaseReadiness])
+}
@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"tool/stats"
+
+	"gopkg.in/yaml.v2"
+)
+
+// caseSummary is the summary of the trials of a case
+type caseSummary struct {
+	caseNo     int
+	parameters map[string]string
+	summary    stats.Summary
+}
+
+// Report ranks the cases of a suite by the mean elapsed time of their trials
+type Report struct {
+	Cases []RankedCase `yaml:"cases"`
+	// Failed are the cases without a successful trial
+	Failed []int `yaml:"failed,omitempty"`
+}
+
+// RankedCase is a case of the report
+type RankedCase struct {
+	Rank int `yaml:"rank"`
+	Case int `yaml:"case"`
+	// Parameters are the values of the swept parameters
+	Parameters map[string]string `yaml:"parameters,omitempty"`
+	Summary    stats.Summary     `yaml:"summary"`
+	// TiedWithFastest is whether the confidence interval overlaps the fastest case's, the case
+	// isn't significantly slower
+	TiedWithFastest bool `yaml:"tiedWithFastest"`
+}
+
+// rankCases ranks cases by their mean elapsed time
+func rankCases(summaries []caseSummary) Report {
+	var report Report
+	var ranked []caseSummary
+	for _, s := range summaries {
+		if s.summary.N == 0 {
+			report.Failed = append(report.Failed, s.caseNo)
+			continue
+		}
+		ranked = append(ranked, s)
+	}
+	sort.Ints(report.Failed)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].summary.Mean != ranked[j].summary.Mean {
+			return ranked[i].summary.Mean < ranked[j].summary.Mean
+		}
+		return ranked[i].caseNo < ranked[j].caseNo
+	})
+	for i, s := range ranked {
+		report.Cases = append(report.Cases, RankedCase{
+			Rank:            i + 1,
+			Case:            s.caseNo,
+			Parameters:      s.parameters,
+			Summary:         s.summary,
+			TiedWithFastest: s.summary.Overlaps(ranked[0].summary),
+		})
+	}
+	return report
+}
+
+// saveReport writes the ranking of the cases run so far to report.yaml in the results directory and
+// logs the fastest cases
+func (r *Runner) saveReport() {
+	r.mu.Lock()
+	report := rankCases(r.summaries)
+	r.mu.Unlock()
+
+	for _, c := range report.Cases {
+		if c.Rank > 5 {
+			break
+		}
+		r.logger.Infof("Rank %v: case no %v mean %v (95%% CI %v - %v) over %v trials", c.Rank, c.Case, c.Summary.Mean, c.Summary.CILow, c.Summary.CIHigh, c.Summary.N)
+	}
+	yamlData, err := yaml.Marshal(report)
+	if err != nil {
+		r.logger.Errorf("Failed to marshal report to YAML %v", err)
+		return
+	}
+	filename := filepath.Join(r.resultsDir, "report.yaml")
+	if err := writeFileAtomically(filename, yamlData); err != nil {
+		r.logger.Errorf("Failed to save report %v", err)
+		return
+	}
+	fmt.Println("Report saved:", filename)
+}
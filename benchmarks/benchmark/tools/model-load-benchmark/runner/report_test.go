@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"testing"
+	"time"
+	"tool/stats"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankCases(t *testing.T) {
+	fast := stats.Summary{N: 3, Mean: 10 * time.Second, CILow: 9 * time.Second, CIHigh: 11 * time.Second}
+	close := stats.Summary{N: 3, Mean: 11 * time.Second, CILow: 10 * time.Second, CIHigh: 12 * time.Second}
+	slow := stats.Summary{N: 3, Mean: 20 * time.Second, CILow: 19 * time.Second, CIHigh: 21 * time.Second}
+
+	report := rankCases([]caseSummary{
+		{caseNo: 0, summary: slow},
+		{caseNo: 1, summary: stats.Summary{}},
+		{caseNo: 2, parameters: map[string]string{"sideCarResources.cpu-request": "250m"}, summary: fast},
+		{caseNo: 3, summary: close},
+	})
+
+	assert.Equal(t, Report{
+		Cases: []RankedCase{
+			{Rank: 1, Case: 2, Parameters: map[string]string{"sideCarResources.cpu-request": "250m"}, Summary: fast, TiedWithFastest: true},
+			{Rank: 2, Case: 3, Summary: close, TiedWithFastest: true},
+			{Rank: 3, Case: 0, Summary: slow, TiedWithFastest: false},
+		},
+		Failed: []int{1},
+	}, report)
+}
@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
+	"sync"
 	"time"
 	"tool/config"
 	"tool/deployment"
 	"tool/k8sclient"
+	"tool/stats"
 	suitegenerator "tool/suite-generator"
 
 	"github.com/sirupsen/logrus"
@@ -39,12 +42,35 @@ type Runner struct {
 	baseConfig *config.Config
 	suite      *suitegenerator.Suite
 	resultsDir string
+	// nodes cases are pinned to, one per worker, or none if cases aren't pinned
+	nodes   []string
+	workers int
+	// freshNodes haven't run a benchmark pod yet, trials take one each with execution.coldStart
+	freshNodes []string
+	// summaries of the cases run so far, for the report
+	summaries []caseSummary
+	mu        sync.Mutex
 }
 type CaseResult struct {
+	// ElapsedTime is the median elapsed time of the successful trials, -1 if none succeeded
 	ElapsedTime time.Duration `yaml:"elapsedTime"`
-	Config      config.Config `yaml:"config"`
-	Spec        v1.Pod        `yaml:"spec"`
-	caseNo      string
+	Summary     stats.Summary `yaml:"summary"`
+	// Phases summarizes the durations of the phases of the successful trials, by phase
+	Phases  map[string]stats.Summary `yaml:"phases,omitempty"`
+	Samples []Sample                 `yaml:"samples"`
+	Config  config.Config            `yaml:"config"`
+	Spec    v1.Pod                   `yaml:"spec"`
+	caseNo  string
+}
+
+// Sample is a warmup run or trial of a case
+type Sample struct {
+	ElapsedTime time.Duration `yaml:"elapsedTime"`
+	Node        string        `yaml:"node,omitempty"`
+	Warmup      bool          `yaml:"warmup,omitempty"`
+	Error       string        `yaml:"error,omitempty"`
+	// Phases are the durations of the phases of loading the model, by phase
+	Phases map[string]time.Duration `yaml:"phases,omitempty"`
 }
 
 func InitRunner(configFile string) (*Runner, error) {
@@ -58,14 +84,18 @@ func InitRunner(configFile string) (*Runner, error) {
 		return nil, fmt.Errorf("failed to initialize test config from file %q because %q", configFile, err)
 	}
 	baseConfigCopy := deepCopyConfig(baseConfig)
-	suite := suitegenerator.GenerateCases(*baseConfigCopy)
-	logger.Infof("Number of cases generated %v", len(suite.Cases))
+	suite, err := suitegenerator.GenerateCases(*baseConfigCopy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate cases from file %q because %q", configFile, err)
+	}
+	logger.Infof("Sweep strategy %v planned %v cases", suite.Sweep.Strategy, suite.Planned())
 
 	return &Runner{
 		k8sclient:  client,
 		logger:     logger,
 		baseConfig: baseConfig,
 		suite:      suite,
+		workers:    1,
 	}, nil
 }
 func deepCopyConfig(base *config.Config) *config.Config {
@@ -118,9 +148,82 @@ func (r *Runner) Run() {
 		return
 	}
 	r.logger.Infof("Cluster has %v nodes", len(nodes.Items))
+	if err := r.setupWorkers(); err != nil {
+		r.logger.Errorf("Failed to set up workers %v", err)
+		return
+	}
 	r.DeployAndMonitorCases()
 }
 
+// setupWorkers assigns a distinct node to every worker when cases are pinned to nodes
+func (r *Runner) setupWorkers() error {
+	execution := r.baseConfig.Execution
+	if execution == nil || !execution.PinsNodes() {
+		r.workers = 1
+		return nil
+	}
+	nodes, err := r.k8sclient.GetSchedulableNodes(execution.NodeSelector)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("no ready and schedulable nodes match the node selector %v", execution.NodeSelector)
+	}
+	r.workers = execution.Workers
+	if r.workers > len(nodes) {
+		r.logger.Warnf("Only %v nodes match the node selector %v, running %v workers instead of %v", len(nodes), execution.NodeSelector, len(nodes), r.workers)
+		r.workers = len(nodes)
+	}
+	if execution.ColdStart {
+		// every trial takes a node of its own, the file cache of a node isn't cleared
+		if trials := r.suite.Planned() * execution.Trials; trials > len(nodes) {
+			return fmt.Errorf("'execution.coldStart' needs a fresh node for each of the %v planned trials, only %v nodes match the node selector %v", trials, len(nodes), execution.NodeSelector)
+		}
+		r.freshNodes = nodes
+		r.logger.Infof("Running cases on %v workers, every trial on a fresh node", r.workers)
+		return nil
+	}
+	r.nodes = nodes[:r.workers]
+	r.logger.Infof("Running cases on %v workers pinned to nodes %v", r.workers, r.nodes)
+	return nil
+}
+
+// execution returns the execution settings of the configuration
+func (r *Runner) execution() config.Execution {
+	if r.baseConfig.Execution == nil {
+		return config.Execution{Workers: 1, Trials: 1}
+	}
+	return *r.baseConfig.Execution
+}
+
+// monitorOptions returns how pods are timed
+func (r *Runner) monitorOptions() k8sclient.MonitorOptions {
+	execution := r.execution()
+	opts := k8sclient.MonitorOptions{Timeout: execution.Timeout}
+	if modelLoad := execution.ModelLoad; modelLoad != nil {
+		opts.ModelLoadContainer = modelLoad.Container
+		if modelLoad.LogRegex != "" {
+			// validated by config.LoadConfig
+			opts.ModelLoadLogRegex = regexp.MustCompile(modelLoad.LogRegex)
+		}
+		opts.ModelLoadEndpoint = modelLoad.Endpoint
+		opts.ModelLoadPort = modelLoad.Port
+	}
+	return opts
+}
+
+// takeFreshNode returns a node that hasn't run a benchmark pod yet
+func (r *Runner) takeFreshNode() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.freshNodes) == 0 {
+		return "", fmt.Errorf("no fresh node left for a cold start")
+	}
+	node := r.freshNodes[0]
+	r.freshNodes = r.freshNodes[1:]
+	return node, nil
+}
+
 func (r *Runner) logFailedPodSpecCreation(c config.Config, e error) {
 	cStr, err := c.PrettyPrint()
 	if err != nil {
@@ -130,28 +233,149 @@ func (r *Runner) logFailedPodSpecCreation(c config.Config, e error) {
 }
 
 func (r *Runner) DeployAndMonitorCases() error {
-	for caseNo, c := range r.suite.Cases {
-		r.logger.Infof("Starting Case no %v ", caseNo)
+	defer r.saveReport()
+	observed := r.deployAndMonitorCases(r.suite.Cases, 0)
+	caseNo := len(r.suite.Cases)
+	if r.suite.Sweep.Strategy != config.StrategyAdaptive {
+		return nil
+	}
+	for round := 1; round <= r.suite.Sweep.Rounds; round++ {
+		cases := r.suite.Refine(observed)
+		if len(cases) == 0 {
+			r.logger.Infof("Adaptive round %v has no new cases near the fastest cases, stopping", round)
+			break
+		}
+		r.logger.Infof("Adaptive round %v runs %v cases near the %v fastest cases", round, len(cases), r.suite.Sweep.Top)
+		observed = append(observed, r.deployAndMonitorCases(cases, caseNo)...)
+		caseNo += len(cases)
+	}
+	return nil
+}
+
+// deployAndMonitorCases runs cases numbered from firstCaseNo on the workers and returns the
+// observations of the cases that finished successfully
+func (r *Runner) deployAndMonitorCases(cases []config.Config, firstCaseNo int) []suitegenerator.Observation {
+	type job struct {
+		caseNo int
+		c      config.Config
+	}
+	jobs := make(chan job)
+	var (
+		observed []suitegenerator.Observation
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < r.workers; w++ {
+		node := ""
+		if len(r.nodes) > 0 {
+			node = r.nodes[w]
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if o, ok := r.deployAndMonitorCase(j.caseNo, j.c, node); ok {
+					mu.Lock()
+					observed = append(observed, o)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i, c := range cases {
+		jobs <- job{caseNo: firstCaseNo + i, c: c}
+	}
+	close(jobs)
+	wg.Wait()
+	return observed
+}
+
+// deployAndMonitorCase runs the warmup runs and trials of a case, on a node if it's not empty, and
+// saves its result
+func (r *Runner) deployAndMonitorCase(caseNo int, c config.Config, node string) (suitegenerator.Observation, bool) {
+	r.logger.Infof("Starting Case no %v ", caseNo)
+	execution := r.execution()
+	opts := r.monitorOptions()
+	var (
+		samples []Sample
+		elapsed []time.Duration
+		phases  = make(map[string][]time.Duration)
+		pod     *v1.Pod
+	)
+	for run := 0; run < execution.Warmup+execution.Trials; run++ {
+		sample := Sample{Node: node, Warmup: run < execution.Warmup}
+		if execution.ColdStart {
+			freshNode, err := r.takeFreshNode()
+			if err != nil {
+				r.logger.Errorf("Pod case no:%v trial %v can't run, %v", caseNo, run, err)
+				samples = append(samples, Sample{ElapsedTime: -1, Error: err.Error()})
+				continue
+			}
+			sample.Node = freshNode
+		}
 		d, err := deployment.NewDeployment(&c)
 		if err != nil {
 			r.logFailedPodSpecCreation(c, err)
-			continue
+			return suitegenerator.Observation{}, false
+		}
+		d.SetCase(caseNo)
+		if sample.Node != "" {
+			d.PinToNode(sample.Node)
 		}
 
-		duration, err := r.k8sclient.DeployAndMonitorPod(d.Pod)
+		timing, err := r.k8sclient.DeployAndMonitorPod(d.Pod, opts)
+		sample.ElapsedTime = -1
+		if timing != nil {
+			sample.Phases = timing.Phases()
+		}
 		if err != nil {
-			r.logger.Errorf("Pod case no:%v failed to be ready, %v", caseNo, err)
+			r.logger.Errorf("Pod case no:%v run %v failed to be ready, %v", caseNo, run, err)
+			sample.Error = err.Error()
+		} else {
+			sample.ElapsedTime = timing.Elapsed()
+			r.logger.Infof("Case no %v run %v finished successfully in time %v, phases %v", caseNo, run, sample.ElapsedTime, sample.Phases)
+			if _, ok := sample.Phases[k8sclient.PhaseModelLoad]; !ok && execution.ModelLoad != nil {
+				r.logger.Warnf("Case no %v run %v: model load wasn't detected before the pod was ready", caseNo, run)
+			}
+			if !sample.Warmup {
+				elapsed = append(elapsed, sample.ElapsedTime)
+				for phase, duration := range sample.Phases {
+					phases[phase] = append(phases[phase], duration)
+				}
+			}
 		}
-		r.logger.Infof("Case no %v finished successfully in time %v", caseNo, duration)
-		caseResult := CaseResult{
-			ElapsedTime: duration,
-			Config:      c,
-			Spec:        *d.Pod,
-			caseNo:      strconv.Itoa(caseNo),
+		samples = append(samples, sample)
+		pod = d.Pod
+	}
+
+	summary := stats.Summarize(elapsed)
+	caseResult := CaseResult{
+		ElapsedTime: -1,
+		Summary:     summary,
+		Samples:     samples,
+		Config:      c,
+		caseNo:      strconv.Itoa(caseNo),
+	}
+	if pod != nil {
+		caseResult.Spec = *pod
+	}
+	if len(phases) > 0 {
+		caseResult.Phases = make(map[string]stats.Summary, len(phases))
+		for phase, durations := range phases {
+			caseResult.Phases[phase] = stats.Summarize(durations)
 		}
-		r.saveCaseResultToYAML(caseResult)
 	}
-	return nil
+	if summary.N > 0 {
+		caseResult.ElapsedTime = summary.Median
+		r.logger.Infof("Case no %v finished %v trials with median %v, mean %v and standard deviation %v", caseNo, summary.N, summary.Median, summary.Mean, summary.StdDev)
+	}
+	if err := r.saveCaseResultToYAML(caseResult); err != nil {
+		r.logger.Errorf("Failed to save result of case no %v, %v", caseNo, err)
+	}
+	r.mu.Lock()
+	r.summaries = append(r.summaries, caseSummary{caseNo: caseNo, parameters: r.suite.Parameters(c), summary: summary})
+	r.mu.Unlock()
+	return suitegenerator.Observation{Config: c, ElapsedTime: summary.Median}, summary.N > 0
 }
 
 func (r *Runner) saveCaseResultToYAML(result CaseResult) error {
@@ -162,9 +386,29 @@ func (r *Runner) saveCaseResultToYAML(result CaseResult) error {
 		return fmt.Errorf("failed to marshal CaseResult to YAML: %v", err)
 	}
 	yamlData = append(yamlData, '\n')
-	// Write the YAML data to the file
-	err = os.WriteFile(filename, yamlData, 0644)
+	return writeFileAtomically(filename, yamlData)
+}
+
+// writeFileAtomically writes data to a temporary file renamed to filename, so that the file is never
+// partially written while other cases are running
+func writeFileAtomically(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+"-*")
 	if err != nil {
+		return fmt.Errorf("failed to create temporary YAML file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write YAML file: %v", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write YAML file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write YAML file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
 		return fmt.Errorf("failed to write YAML file: %v", err)
 	}
 
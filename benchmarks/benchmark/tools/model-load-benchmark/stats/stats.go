@@ -0,0 +1,95 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Summary of the elapsed times of the trials of a case
+type Summary struct {
+	N      int           `yaml:"n"`
+	Min    time.Duration `yaml:"min"`
+	Median time.Duration `yaml:"median"`
+	P90    time.Duration `yaml:"p90"`
+	Mean   time.Duration `yaml:"mean"`
+	StdDev time.Duration `yaml:"stddev"`
+	// CILow and CIHigh bound the 95% confidence interval of the mean
+	CILow  time.Duration `yaml:"ciLow"`
+	CIHigh time.Duration `yaml:"ciHigh"`
+}
+
+// tCritical are the two-sided 95% critical values of Student's t-distribution by degrees of freedom
+var tCritical = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// zCritical is the two-sided 95% critical value of the normal distribution, used for more than 30
+// degrees of freedom
+const zCritical = 1.96
+
+// Summarize returns the summary of samples, the zero Summary if there are none
+func Summarize(samples []time.Duration) Summary {
+	n := len(samples)
+	if n == 0 {
+		return Summary{}
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum float64
+	for _, s := range sorted {
+		sum += float64(s)
+	}
+	mean := sum / float64(n)
+	var squares float64
+	for _, s := range sorted {
+		squares += (float64(s) - mean) * (float64(s) - mean)
+	}
+	// sample standard deviation, zero for a single sample
+	var stdDev float64
+	if n > 1 {
+		stdDev = math.Sqrt(squares / float64(n-1))
+	}
+	// a single sample has no confidence interval, it's the sample itself
+	var halfWidth float64
+	if n > 1 {
+		t := zCritical
+		if n-1 <= len(tCritical) {
+			t = tCritical[n-2]
+		}
+		halfWidth = t * stdDev / math.Sqrt(float64(n))
+	}
+
+	return Summary{
+		N:      n,
+		Min:    sorted[0],
+		Median: Percentile(sorted, 50),
+		P90:    Percentile(sorted, 90),
+		Mean:   time.Duration(mean),
+		StdDev: time.Duration(stdDev),
+		CILow:  time.Duration(mean - halfWidth),
+		CIHigh: time.Duration(mean + halfWidth),
+	}
+}
+
+// Percentile returns the p-th percentile of sorted samples, linearly interpolated between the
+// closest ranks
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	fraction := rank - float64(lower)
+	return sorted[lower] + time.Duration(math.Round(fraction*float64(sorted[upper]-sorted[lower])))
+}
+
+// Overlaps returns whether the confidence intervals of two summaries overlap, in which case the
+// difference between their means isn't significant
+func (s Summary) Overlaps(other Summary) bool {
+	return s.CILow <= other.CIHigh && other.CILow <= s.CIHigh
+}
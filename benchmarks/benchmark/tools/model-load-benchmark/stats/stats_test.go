@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func seconds(values ...float64) []time.Duration {
+	var durations []time.Duration
+	for _, v := range values {
+		durations = append(durations, time.Duration(v*float64(time.Second)))
+	}
+	return durations
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		samples  []time.Duration
+		expected Summary
+	}{
+		{
+			name:     "no samples",
+			expected: Summary{},
+		},
+		{
+			name:    "single sample",
+			samples: seconds(60),
+			expected: Summary{
+				N: 1, Min: time.Minute, Median: time.Minute, P90: time.Minute, Mean: time.Minute,
+				CILow: time.Minute, CIHigh: time.Minute,
+			},
+		},
+		{
+			name:    "unsorted samples",
+			samples: seconds(14, 10, 12, 16),
+			expected: Summary{
+				N:      4,
+				Min:    10 * time.Second,
+				Median: 13 * time.Second,
+				P90:    15400 * time.Millisecond,
+				Mean:   13 * time.Second,
+				// sqrt(20/3) seconds
+				StdDev: 2581988897 * time.Nanosecond,
+				// 3.182 * StdDev / 2
+				CILow:  8892056 * time.Microsecond,
+				CIHigh: 17107944 * time.Microsecond,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := Summarize(tt.samples)
+			assert.Equal(t, tt.expected.N, summary.N)
+			assert.Equal(t, tt.expected.Min, summary.Min)
+			assert.Equal(t, tt.expected.Median, summary.Median)
+			assert.Equal(t, tt.expected.P90, summary.P90)
+			assert.Equal(t, tt.expected.Mean, summary.Mean)
+			assert.InDelta(t, tt.expected.StdDev, summary.StdDev, float64(time.Microsecond))
+			assert.InDelta(t, tt.expected.CILow, summary.CILow, float64(time.Microsecond))
+			assert.InDelta(t, tt.expected.CIHigh, summary.CIHigh, float64(time.Microsecond))
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := seconds(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	assert.Equal(t, 5500*time.Millisecond, Percentile(sorted, 50))
+	assert.Equal(t, 9100*time.Millisecond, Percentile(sorted, 90))
+	assert.Equal(t, time.Second, Percentile(sorted, 0))
+	assert.Equal(t, 10*time.Second, Percentile(sorted, 100))
+}
+
+func TestOverlaps(t *testing.T) {
+	fast := Summary{CILow: 10 * time.Second, CIHigh: 12 * time.Second}
+	close := Summary{CILow: 11 * time.Second, CIHigh: 14 * time.Second}
+	slow := Summary{CILow: 13 * time.Second, CIHigh: 15 * time.Second}
+	assert.True(t, fast.Overlaps(close))
+	assert.True(t, close.Overlaps(fast))
+	assert.False(t, fast.Overlaps(slow))
+}
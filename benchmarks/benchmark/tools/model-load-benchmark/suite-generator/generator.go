@@ -2,6 +2,7 @@ package suitegenerator
 
 import (
 	"fmt"
+	"math/rand"
 	"reflect"
 	"tool/config"
 )
@@ -15,13 +16,65 @@ var RequestLimitMap = map[string]string{
 type Suite struct {
 	BaseCase config.Config
 	Cases    []config.Config
+	Sweep    config.Sweep
+
+	parameters []parameter
+	// cases generated so far, including the rounds of the adaptive strategy
+	cases *caseSet
+}
+
+// GenerateCases creates test cases with the sweep strategy of the configuration, the cartesian
+// strategy by default
+func GenerateCases(base config.Config) (*Suite, error) {
+	suite := Suite{BaseCase: deepCopyConfig(base), Sweep: config.Sweep{Strategy: config.StrategyCartesian}}
+	if base.Sweep != nil {
+		suite.Sweep = *base.Sweep
+	}
+	// the sweep and execution aren't part of the generated cases and their results
+	base.Sweep = nil
+	base.Execution = nil
+	suite.parameters = parameters(base)
+
+	rng := rand.New(rand.NewSource(suite.Sweep.Seed))
+	switch suite.Sweep.Strategy {
+	case config.StrategyCartesian:
+		suite.Cases = generateSideCarAndVolumeCases(base)
+	case config.StrategyOneFactor, config.StrategyAdaptive:
+		suite.Cases = generateOneFactorCases(base, suite.parameters)
+	case config.StrategyLatinHypercube:
+		suite.Cases = generateLatinHypercubeCases(base, suite.parameters, suite.Sweep.Budget, rng)
+	case config.StrategyRandom:
+		suite.Cases = generateRandomCases(base, suite.parameters, suite.Sweep.Budget, rng)
+	case config.StrategyExplicit:
+		cases, err := generateExplicitCases(base, suite.Sweep.Cases)
+		if err != nil {
+			return nil, err
+		}
+		suite.Cases = cases
+	default:
+		return nil, fmt.Errorf("unknown sweep strategy %q", suite.Sweep.Strategy)
+	}
+	if len(suite.Cases) == 0 {
+		suite.Cases = append(suite.Cases, deepCopyConfig(base))
+	}
+	suite.cases = newCaseSet()
+	for _, c := range suite.Cases {
+		suite.cases.add(c)
+	}
+	return &suite, nil
 }
 
-// GenerateCases creates test cases
-func GenerateCases(base config.Config) *Suite {
-	suite := Suite{BaseCase: deepCopyConfig(base)}
-	suite.Cases = generateSideCarAndVolumeCases(base)
-	return &suite
+// Planned returns the number of cases the suite plans to run, at most for the adaptive strategy
+func (s *Suite) Planned() int {
+	if s.Sweep.Strategy != config.StrategyAdaptive {
+		return len(s.Cases)
+	}
+	// every parameter of the Top fastest cases has up to two neighbors
+	perRound := 2 * len(s.parameters) * s.Sweep.Top
+	if s.Sweep.Budget > 0 && s.Sweep.Budget < perRound {
+		perRound = s.Sweep.Budget
+	}
+	return len(s.Cases) + s.Sweep.Rounds*perRound
 }
 
 func generateSideCarAndVolumeCases(base config.Config) []config.Config {
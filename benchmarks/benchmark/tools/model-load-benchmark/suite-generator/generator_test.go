@@ -43,7 +43,8 @@ func createBaseConfig() config.Config {
 
 func TestGenerateCases(t *testing.T) {
 	baseConfig := createBaseConfig()
-	suite := GenerateCases(baseConfig)
+	suite, err := GenerateCases(baseConfig)
+	assert.NoError(t, err)
 
 	// Define the expected case as per the configuration you provided
 	expectedCase := config.Config{
@@ -0,0 +1,175 @@
+package suitegenerator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"tool/config"
+)
+
+// parameter is a swept configuration field, named by the path of its YAML keys such as
+// sideCarResources.cpu-request
+type parameter struct {
+	name string
+	// values from the base value to the max value, the base value is values[0]
+	values []string
+}
+
+// index returns the index of the value of the parameter in a case, or -1 if it isn't one of its values
+func (p parameter) index(c config.Config) int {
+	value, err := getParameter(&c, p.name)
+	if err != nil {
+		return -1
+	}
+	for i, v := range p.values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// parameters returns the swept parameters of a configuration: the Resource fields whose max is
+// greater than their base, and the bool fields generated as true and false
+func parameters(base config.Config) []parameter {
+	var params []parameter
+	walkFields(reflect.ValueOf(&base).Elem(), "", func(path string, field reflect.Value) {
+		switch v := field.Interface().(type) {
+		case config.Resource:
+			if v.Step <= 0 || v.Max <= v.Base {
+				return
+			}
+			p := parameter{name: path}
+			for val := v.Base; val <= v.Max; val += v.Step {
+				p.values = append(p.values, config.Resource{Base: val, Unit: v.Unit}.String())
+			}
+			params = append(params, p)
+		case bool:
+			if _, ok := sweptBools[path]; ok {
+				params = append(params, parameter{name: path, values: []string{strconv.FormatBool(v), strconv.FormatBool(!v)}})
+			}
+		}
+	})
+	return params
+}
+
+// sweptBools are the bool fields generated as true and false
+var sweptBools = map[string]struct{}{
+	"volumeAttributes.mountOptions.file-cache.enable-parallel-downloads": {},
+	"volumeAttributes.fileCacheForRangeRead":                             {},
+}
+
+// walkFields calls fn with the YAML path of every Resource and bool field of a struct value
+func walkFields(v reflect.Value, prefix string, fn func(path string, field reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		path := prefix + yamlKey(v.Type().Field(i))
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+		switch {
+		case field.Type() == reflect.TypeOf(config.Resource{}), field.Kind() == reflect.Bool:
+			fn(path, field)
+		case field.Kind() == reflect.Struct:
+			walkFields(field, path+".", fn)
+		}
+	}
+}
+
+func yamlKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if key == "" {
+		return field.Name
+	}
+	return key
+}
+
+// fieldByPath returns the settable field of a configuration at a YAML path
+func fieldByPath(c *config.Config, path string) (reflect.Value, error) {
+	// the sweep and execution settings apply to the whole suite
+	if root, _, _ := strings.Cut(path, "."); root == "sweep" || root == "execution" {
+		return reflect.Value{}, fmt.Errorf("unknown parameter %q", path)
+	}
+	v := reflect.ValueOf(c).Elem()
+	for _, key := range strings.Split(path, ".") {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, fmt.Errorf("parameter %q is not set in the base configuration", path)
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("unknown parameter %q", path)
+		}
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if yamlKey(v.Type().Field(i)) == key {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, fmt.Errorf("unknown parameter %q", path)
+		}
+	}
+	return v, nil
+}
+
+// getParameter returns the value of a parameter in a configuration
+func getParameter(c *config.Config, path string) (string, error) {
+	field, err := fieldByPath(c, path)
+	if err != nil {
+		return "", err
+	}
+	switch v := field.Interface().(type) {
+	case config.Resource:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("parameter %q is not a value", path)
+}
+
+// setParameter sets a parameter of a configuration, the base value for Resource fields
+func setParameter(c *config.Config, path string, value string) error {
+	field, err := fieldByPath(c, path)
+	if err != nil {
+		return err
+	}
+	switch v := field.Interface().(type) {
+	case config.Resource:
+		if err := v.SetValue(value); err != nil {
+			return fmt.Errorf("parameter %q: %v", path, err)
+		}
+		field.Set(reflect.ValueOf(v))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("parameter %q: invalid bool %q", path, value)
+		}
+		field.SetBool(b)
+	case string:
+		field.SetString(value)
+	default:
+		return fmt.Errorf("parameter %q is not a value", path)
+	}
+	return nil
+}
+
+// Parameters returns the values of the swept parameters of a case, by parameter path
+func (s *Suite) Parameters(c config.Config) map[string]string {
+	values := make(map[string]string, len(s.parameters))
+	for _, p := range s.parameters {
+		if value, err := getParameter(&c, p.name); err == nil {
+			values[p.name] = value
+		}
+	}
+	return values
+}
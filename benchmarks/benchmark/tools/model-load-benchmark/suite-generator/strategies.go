@@ -0,0 +1,210 @@
+package suitegenerator
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"time"
+	"tool/config"
+)
+
+// Observation is the elapsed time of a case that finished successfully
+type Observation struct {
+	Config      config.Config
+	ElapsedTime time.Duration
+}
+
+// caseSet collects the distinct valid cases of a strategy
+type caseSet struct {
+	cases []config.Config
+	seen  map[string]bool
+}
+
+func newCaseSet() *caseSet {
+	return &caseSet{seen: make(map[string]bool)}
+}
+
+// add adds a case if it's valid and wasn't added before, and returns whether it was added
+func (s *caseSet) add(c config.Config) bool {
+	normalizeCase(&c)
+	if !validCase(c) {
+		return false
+	}
+	key, err := c.PrettyPrint()
+	if err != nil || s.seen[key] {
+		return false
+	}
+	s.seen[key] = true
+	s.cases = append(s.cases, c)
+	return true
+}
+
+// normalizeCase clears the parallel download settings that aren't applied when parallel downloads
+// are disabled, so that such cases are only run once
+func normalizeCase(c *config.Config) {
+	if c.VolumeAttributes != nil && !c.VolumeAttributes.MountOptions.FileCache.EnableParallelDownloads {
+		c.VolumeAttributes.MountOptions.FileCache.ParallelDownloadsPerFile.Base = 0
+		c.VolumeAttributes.MountOptions.FileCache.MaxParallelDownloads.Base = 0
+	}
+}
+
+// restoreParallelDownloads restores the base parallel download settings of a case whose parallel
+// downloads were enabled after they were cleared by normalizeCase
+func restoreParallelDownloads(c *config.Config, base config.Config) {
+	if c.VolumeAttributes == nil || base.VolumeAttributes == nil || !c.VolumeAttributes.MountOptions.FileCache.EnableParallelDownloads {
+		return
+	}
+	fileCache := &c.VolumeAttributes.MountOptions.FileCache
+	baseFileCache := base.VolumeAttributes.MountOptions.FileCache
+	if fileCache.ParallelDownloadsPerFile.Base == 0 && fileCache.MaxParallelDownloads.Base == 0 {
+		fileCache.ParallelDownloadsPerFile = baseFileCache.ParallelDownloadsPerFile
+		fileCache.MaxParallelDownloads = baseFileCache.MaxParallelDownloads
+	}
+}
+
+// validCase checks that no sidecar resource request is greater than its limit
+func validCase(c config.Config) bool {
+	if c.SideCarResources == nil {
+		return true
+	}
+	resources := reflect.ValueOf(c.SideCarResources).Elem()
+	for requestName, limitName := range RequestLimitMap {
+		request := resources.FieldByName(requestName).Interface().(config.Resource)
+		limit := resources.FieldByName(limitName).Interface().(config.Resource)
+		if limit.Base == 0 {
+			continue
+		}
+		normalizedRequest, err := normalizeToBaseUnit(request.Base, request.Unit)
+		if err != nil {
+			return false
+		}
+		normalizedLimit, err := normalizeToBaseUnit(limit.Base, limit.Unit)
+		if err != nil {
+			return false
+		}
+		if normalizedRequest > normalizedLimit {
+			return false
+		}
+	}
+	return true
+}
+
+// withValues returns a copy of the base case with the parameters set to the values at the indexes
+func withValues(base config.Config, params []parameter, indexes []int) config.Config {
+	c := deepCopyConfig(base)
+	for i, p := range params {
+		// values of swept parameters are generated from the configuration and always valid
+		_ = setParameter(&c, p.name, p.values[indexes[i]])
+	}
+	return c
+}
+
+// generateOneFactorCases generates the base case and, for every parameter, a case for every other
+// value of the parameter with all other parameters at their base values
+func generateOneFactorCases(base config.Config, params []parameter) []config.Config {
+	cases := newCaseSet()
+	cases.add(deepCopyConfig(base))
+	for _, p := range params {
+		for _, value := range p.values[1:] {
+			c := deepCopyConfig(base)
+			_ = setParameter(&c, p.name, value)
+			cases.add(c)
+		}
+	}
+	return cases.cases
+}
+
+// generateLatinHypercubeCases samples budget cases such that the values of every parameter are
+// spread evenly over its range
+func generateLatinHypercubeCases(base config.Config, params []parameter, budget int, rng *rand.Rand) []config.Config {
+	// every parameter's range is split into budget strata, and every stratum is sampled once
+	strata := make([][]int, len(params))
+	for i, p := range params {
+		strata[i] = make([]int, budget)
+		for j, stratum := range rng.Perm(budget) {
+			position := (float64(stratum) + rng.Float64()) / float64(budget)
+			strata[i][j] = int(position * float64(len(p.values)))
+		}
+	}
+	cases := newCaseSet()
+	for j := 0; j < budget; j++ {
+		indexes := make([]int, len(params))
+		for i := range params {
+			indexes[i] = strata[i][j]
+		}
+		cases.add(withValues(base, params, indexes))
+	}
+	return cases.cases
+}
+
+// generateRandomCases samples up to budget distinct cases uniformly
+func generateRandomCases(base config.Config, params []parameter, budget int, rng *rand.Rand) []config.Config {
+	cases := newCaseSet()
+	// small parameter spaces have fewer than budget distinct cases
+	for attempts := 0; len(cases.cases) < budget && attempts < 10*budget; attempts++ {
+		indexes := make([]int, len(params))
+		for i, p := range params {
+			indexes[i] = rng.Intn(len(p.values))
+		}
+		cases.add(withValues(base, params, indexes))
+	}
+	return cases.cases
+}
+
+// generateExplicitCases generates the listed cases, every case sets parameters of the base case
+func generateExplicitCases(base config.Config, explicitCases []map[string]string) ([]config.Config, error) {
+	cases := newCaseSet()
+	for i, values := range explicitCases {
+		c := deepCopyConfig(base)
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := setParameter(&c, name, values[name]); err != nil {
+				return nil, fmt.Errorf("case %d: %v", i, err)
+			}
+		}
+		if !cases.add(c) {
+			fmt.Printf("Skipping case %d, a request exceeds its limit or it duplicates another case\n", i)
+		}
+	}
+	return cases.cases, nil
+}
+
+// Refine returns the cases of the next round of the adaptive strategy: the neighbors of the Top
+// fastest observed cases, with one parameter moved one step up or down, that weren't run before
+func (s *Suite) Refine(observed []Observation) []config.Config {
+	fastest := append([]Observation(nil), observed...)
+	sort.SliceStable(fastest, func(i, j int) bool { return fastest[i].ElapsedTime < fastest[j].ElapsedTime })
+	if len(fastest) > s.Sweep.Top {
+		fastest = fastest[:s.Sweep.Top]
+	}
+
+	var next []config.Config
+	for _, o := range fastest {
+		for _, p := range s.parameters {
+			i := p.index(o.Config)
+			if i < 0 {
+				continue
+			}
+			for _, neighbor := range []int{i - 1, i + 1} {
+				if neighbor < 0 || neighbor >= len(p.values) {
+					continue
+				}
+				if s.Sweep.Budget > 0 && len(next) >= s.Sweep.Budget {
+					return next
+				}
+				c := deepCopyConfig(o.Config)
+				_ = setParameter(&c, p.name, p.values[neighbor])
+				restoreParallelDownloads(&c, s.BaseCase)
+				if s.cases.add(c) {
+					next = append(next, s.cases.cases[len(s.cases.cases)-1])
+				}
+			}
+		}
+	}
+	return next
+}
@@ -0,0 +1,183 @@
+package suitegenerator
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+	"tool/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to create a config with a few swept parameters
+func createSweepConfig(sweep *config.Sweep) config.Config {
+	return config.Config{
+		BasePodSpec: "example-pod.yaml",
+		SideCarResources: &config.SideCarResources{
+			CPULimit:   config.Resource{Base: 250, Max: 250, Step: 1, Unit: "m"},
+			CPURequest: config.Resource{Base: 200, Max: 300, Step: 50, Unit: "m"},
+		},
+		VolumeAttributes: &config.VolumeAttributes{
+			BucketName: "example-bucket",
+			MountOptions: config.MountOptions{
+				OnlyDir: "example-dir",
+				FileCache: config.FileCache{
+					EnableParallelDownloads:  true,
+					ParallelDownloadsPerFile: config.Resource{Base: 1, Max: 4, Step: 1},
+					MaxParallelDownloads:     config.Resource{Base: 2, Max: 2, Step: 1},
+				},
+			},
+			FileCacheForRangeRead: true,
+		},
+		Sweep: sweep,
+	}
+}
+
+func TestParameters(t *testing.T) {
+	params := parameters(createSweepConfig(nil))
+	assert.Equal(t, []parameter{
+		{name: "sideCarResources.cpu-request", values: []string{"200m", "250m", "300m"}},
+		{name: "volumeAttributes.mountOptions.file-cache.enable-parallel-downloads", values: []string{"true", "false"}},
+		{name: "volumeAttributes.mountOptions.file-cache.parallel-downloads-per-file", values: []string{"1", "2", "3", "4"}},
+		{name: "volumeAttributes.fileCacheForRangeRead", values: []string{"true", "false"}},
+	}, params)
+}
+
+func TestOneFactorCases(t *testing.T) {
+	suite, err := GenerateCases(createSweepConfig(&config.Sweep{Strategy: config.StrategyOneFactor}))
+	assert.NoError(t, err)
+
+	// the base case, 250m (300m exceeds the CPU limit), parallel downloads disabled, 3 other
+	// parallel downloads per file and no file cache for range reads
+	assert.Equal(t, 7, len(suite.Cases))
+	assert.Equal(t, 7, suite.Planned())
+	base := createSweepConfig(nil)
+	assert.Equal(t, base, suite.Cases[0])
+	for _, c := range suite.Cases[1:] {
+		changed := 0
+		for _, p := range suite.parameters {
+			if p.index(c) != 0 {
+				changed++
+			}
+		}
+		// disabling parallel downloads also clears the parallel downloads per file
+		assert.LessOrEqual(t, changed, 2)
+		assert.Nil(t, c.Sweep)
+	}
+	assert.Equal(t, 0, suite.Cases[2].VolumeAttributes.MountOptions.FileCache.ParallelDownloadsPerFile.Base)
+	assert.Equal(t, 0, suite.Cases[2].VolumeAttributes.MountOptions.FileCache.MaxParallelDownloads.Base)
+}
+
+func TestSampledCases(t *testing.T) {
+	for _, strategy := range []string{config.StrategyLatinHypercube, config.StrategyRandom} {
+		t.Run(strategy, func(t *testing.T) {
+			suite, err := GenerateCases(createSweepConfig(&config.Sweep{Strategy: strategy, Seed: 7, Budget: 4}))
+			assert.NoError(t, err)
+			assert.NotEmpty(t, suite.Cases)
+			assert.LessOrEqual(t, len(suite.Cases), 4)
+			assert.Equal(t, len(suite.Cases), len(uniqueCases(suite.Cases)))
+			for _, c := range suite.Cases {
+				assert.True(t, validCase(c))
+			}
+
+			// the same seed samples the same cases
+			again, err := GenerateCases(createSweepConfig(&config.Sweep{Strategy: strategy, Seed: 7, Budget: 4}))
+			assert.NoError(t, err)
+			assert.Equal(t, suite.Cases, again.Cases)
+		})
+	}
+}
+
+func TestLatinHypercubeCoversRange(t *testing.T) {
+	params := []parameter{{name: "volumeAttributes.mountOptions.file-cache.parallel-downloads-per-file", values: []string{"1", "2", "3", "4"}}}
+	cases := generateLatinHypercubeCases(createSweepConfig(nil), params, 4, rand.New(rand.NewSource(3)))
+
+	// with a budget of the number of values every value is sampled once
+	var values []int
+	for _, c := range cases {
+		values = append(values, c.VolumeAttributes.MountOptions.FileCache.ParallelDownloadsPerFile.Base)
+	}
+	assert.ElementsMatch(t, []int{1, 2, 3, 4}, values)
+}
+
+func TestExplicitCases(t *testing.T) {
+	tests := []struct {
+		name          string
+		cases         []map[string]string
+		expected      []config.Config
+		expectedError bool
+	}{
+		{
+			name: "cases set parameters",
+			cases: []map[string]string{
+				{"sideCarResources.cpu-request": "250m"},
+				{"volumeAttributes.fileCacheForRangeRead": "false", "volumeAttributes.mountOptions.file-cache.max-parallel-downloads": "8"},
+			},
+			expected: func() []config.Config {
+				first := createSweepConfig(nil)
+				first.SideCarResources.CPURequest.Base = 250
+				second := createSweepConfig(nil)
+				second.VolumeAttributes.FileCacheForRangeRead = false
+				second.VolumeAttributes.MountOptions.FileCache.MaxParallelDownloads.Base = 8
+				return []config.Config{first, second}
+			}(),
+		},
+		{
+			name:     "cases exceeding limits are skipped",
+			cases:    []map[string]string{{"sideCarResources.cpu-request": "300m"}, {}},
+			expected: []config.Config{createSweepConfig(nil)},
+		},
+		{
+			name:          "unknown parameter",
+			cases:         []map[string]string{{"sideCarResources.cpu": "1"}},
+			expectedError: true,
+		},
+		{
+			name:          "invalid value",
+			cases:         []map[string]string{{"volumeAttributes.fileCacheForRangeRead": "sometimes"}},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suite, err := GenerateCases(createSweepConfig(&config.Sweep{Strategy: config.StrategyExplicit, Cases: tt.cases}))
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, suite.Cases)
+		})
+	}
+}
+
+func TestRefine(t *testing.T) {
+	suite, err := GenerateCases(createSweepConfig(&config.Sweep{Strategy: config.StrategyAdaptive, Rounds: 1, Top: 1}))
+	assert.NoError(t, err)
+	assert.Equal(t, 7+8, suite.Planned())
+
+	// the case with 3 parallel downloads per file is the fastest
+	var observed []Observation
+	for i, c := range suite.Cases {
+		elapsed := time.Duration(10+i) * time.Second
+		if c.VolumeAttributes.MountOptions.FileCache.ParallelDownloadsPerFile.Base == 3 {
+			elapsed = time.Second
+		}
+		observed = append(observed, Observation{Config: c, ElapsedTime: elapsed})
+	}
+	next := suite.Refine(observed)
+
+	// its neighbors with 2 and 4 parallel downloads per file were already run
+	expected := createSweepConfig(nil)
+	expected.SideCarResources.CPURequest.Base = 250
+	expected.VolumeAttributes.MountOptions.FileCache.ParallelDownloadsPerFile.Base = 3
+	noRangeRead := createSweepConfig(nil)
+	noRangeRead.VolumeAttributes.MountOptions.FileCache.ParallelDownloadsPerFile.Base = 3
+	noRangeRead.VolumeAttributes.FileCacheForRangeRead = false
+	// disabling parallel downloads gives the case with parallel downloads disabled, which was already run
+	assert.Equal(t, []config.Config{expected, noRangeRead}, next)
+
+	// cases aren't refined twice
+	assert.Empty(t, suite.Refine(observed))
+}
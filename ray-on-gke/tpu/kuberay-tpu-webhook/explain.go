@@ -0,0 +1,111 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/klog/v2"
+)
+
+// sliceExplanation describes the TPU_WORKER_IDs assigned in a Pod Slice (worker group replica).
+type sliceExplanation struct {
+	ReplicaIndex int   `json:"replicaIndex"`
+	NumOfHosts   int32 `json:"numOfHosts"`
+	WorkerIDs    []int `json:"workerIDs"`
+}
+
+// podExplanation describes how the webhook would mutate a Pod and why.
+type podExplanation struct {
+	Allowed  bool     `json:"allowed"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Patch    []patch  `json:"patch,omitempty"`
+	// Slices of the worker group the assignment is based on, including reserved TPU_WORKER_IDs of
+	// Pods that are not in the PodInformer cache yet.
+	Slices       []sliceExplanation `json:"slices,omitempty"`
+	ReplicaIndex *int               `json:"replicaIndex,omitempty"`
+	WorkerID     *int               `json:"workerID,omitempty"`
+	Reason       string             `json:"reason,omitempty"`
+}
+
+// recordAssignment records the slices of a worker group and the reasoning of getReplicaIndex and
+// getNextWorkerID behind an assignment.
+func (e *podExplanation) recordAssignment(sliceToWorkerIDs map[slice][]int, group workerGroup, numOfHosts int32, replicaIndex int, workerID int) {
+	var assignedSlice *sliceExplanation
+	for s, workerIDs := range sliceToWorkerIDs {
+		if s.clusterName != group.clusterName || s.groupName != group.groupName || s.namespace != group.namespace {
+			continue
+		}
+		ids := append([]int{}, workerIDs...)
+		sort.Ints(ids)
+		e.Slices = append(e.Slices, sliceExplanation{s.replicaIndex, s.numOfHosts, ids})
+	}
+	sort.Slice(e.Slices, func(i, j int) bool { return e.Slices[i].ReplicaIndex < e.Slices[j].ReplicaIndex })
+	for i := range e.Slices {
+		if e.Slices[i].ReplicaIndex == replicaIndex {
+			assignedSlice = &e.Slices[i]
+		}
+	}
+
+	e.ReplicaIndex = &replicaIndex
+	e.WorkerID = &workerID
+	if assignedSlice != nil && len(assignedSlice.WorkerIDs) > 0 {
+		e.Reason = fmt.Sprintf("replica %d is the lowest replica with free workers (%d of %d assigned), TPU_WORKER_ID %d is its lowest free ID",
+			replicaIndex, len(assignedSlice.WorkerIDs), numOfHosts, workerID)
+	} else {
+		e.Reason = fmt.Sprintf("all %d replicas of the worker group are full, replica %d is the lowest unused replica index", len(e.Slices), replicaIndex)
+	}
+}
+
+// Explain handles http Request with an AdmissionReview for a Pod and writes how the Pod would be
+// mutated, without reserving the TPU_WORKER_ID for it
+func (t *TPUWebhookServer) Explain(w http.ResponseWriter, r *http.Request) {
+	admissionReview := &admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(admissionReview); err != nil {
+		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	if admissionReview.Request == nil || admissionReview.Request.Kind.Kind != "Pod" {
+		http.Error(w, "Invalid Kind", http.StatusBadRequest)
+		return
+	}
+	klog.V(0).InfoS("Explain", "Received review for Pod", admissionReview.Request.Name)
+	explanation := &podExplanation{}
+	response, err := t.mutatePod(admissionReview, explanation)
+	if err != nil {
+		explanation.Error = err.Error()
+	} else {
+		explanation.Allowed = response.Allowed
+		explanation.Warnings = response.Warnings
+		if response.Patch != nil {
+			if err := json.Unmarshal(response.Patch, &explanation.Patch); err != nil {
+				explanation.Error = err.Error()
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(explanation); err != nil {
+		klog.Errorf("Failed to encode explanation: %s", err)
+	}
+}
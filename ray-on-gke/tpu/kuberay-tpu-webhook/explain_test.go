@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+)
+
+func Test_Explain(t *testing.T) {
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+
+	tests := map[string]struct {
+		existingPods         int
+		existingReplicas     int
+		expectedSlices       []sliceExplanation
+		expectedReplicaIndex int
+		expectedWorkerID     int
+		expectedReason       string
+	}{
+		"fill replica": {
+			// replica 0 has 2 of 4 workers
+			existingPods:         6,
+			existingReplicas:     2,
+			expectedSlices:       []sliceExplanation{{0, 4, []int{0, 1, 2, 3}}, {1, 4, []int{0, 1}}},
+			expectedReplicaIndex: 1,
+			expectedWorkerID:     2,
+			expectedReason:       "replica 1 is the lowest replica with free workers (2 of 4 assigned), TPU_WORKER_ID 2 is its lowest free ID",
+		},
+		"new replica": {
+			// all replicas are full
+			existingPods:         4,
+			existingReplicas:     1,
+			expectedSlices:       []sliceExplanation{{0, 4, []int{0, 1, 2, 3}}},
+			expectedReplicaIndex: 1,
+			expectedWorkerID:     0,
+			expectedReason:       "all 1 replicas of the worker group are full, replica 1 is the lowest unused replica index",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testPodLister := setupInformer(getTestInterceptedTPUPods(testPod, tc.existingPods, tc.existingReplicas, 4)...)
+			tpuWebhookServer := NewTPUWebhookServer(testPodLister, nil)
+			server := httptest.NewServer(http.HandlerFunc(tpuWebhookServer.Explain))
+			defer server.Close()
+
+			admissionReview := getTestAdmissionReview("Pod", "CREATE")
+			admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+			body, _ := json.Marshal(admissionReview)
+			res, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+			assert.Nil(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+
+			explanation := &podExplanation{}
+			assert.Nil(t, json.NewDecoder(res.Body).Decode(explanation))
+			assert.True(t, explanation.Allowed)
+			assert.Empty(t, explanation.Error)
+			assert.NotEmpty(t, explanation.Patch)
+			assert.Equal(t, tc.expectedSlices, explanation.Slices)
+			assert.Equal(t, tc.expectedReplicaIndex, *explanation.ReplicaIndex)
+			assert.Equal(t, tc.expectedWorkerID, *explanation.WorkerID)
+			assert.Equal(t, tc.expectedReason, explanation.Reason)
+
+			// explaining a Pod does not reserve its TPU_WORKER_ID
+			assert.Equal(t, 0, tpuWebhookServer.ledger.(*workerLedger).reserved(workerGroup{"test-namespace", "test-cluster", "test-group"}))
+		})
+	}
+}
+
+func Test_MutatePodDryRun(t *testing.T) {
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+	tpuWebhookServer := NewTPUWebhookServer(setupInformer(), nil)
+	group := workerGroup{"test-namespace", "test-cluster", "test-group"}
+
+	// validate dry-run requests get the same assignment as the next request, without reserving it
+	for _, dryRun := range []bool{true, true, false} {
+		admissionReview := getTestAdmissionReview("Pod", "CREATE")
+		admissionReview.Request.DryRun = pointer.Bool(dryRun)
+		admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+		response, err := tpuWebhookServer.mutatePod(admissionReview, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, workerAssignment{0, 0}, mutateAssignment(t, response))
+	}
+	assert.Equal(t, 1, tpuWebhookServer.ledger.(*workerLedger).reserved(group))
+}
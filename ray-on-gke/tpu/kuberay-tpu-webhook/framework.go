@@ -0,0 +1,276 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// multiHostFramework describes how a workload framework labels the Pods of multi-host TPU slices and
+// names the headless Service that resolves their hostnames. The slice bookkeeping of the webhook is
+// driven by it, so that TPU environment variables are injected for KubeRay and the built-in
+// LeaderWorkerSet and StatefulSet presets. Their label keys are fixed, other frameworks can't be
+// configured.
+type multiHostFramework struct {
+	// name of the framework in logs and errors
+	name string
+	// instanceLabel is the label key of the workload instance (e.g. RayCluster) of a Pod
+	instanceLabel string
+	// groupLabel is the label key of the group of identical slices within the instance, empty if an
+	// instance has a single group
+	groupLabel string
+	// replicaIndexLabel and workerIndexLabel are the label keys of the replica index and TPU_WORKER_ID
+	// set by the framework. The webhook assigns them if workerIndexLabel is empty. If only
+	// replicaIndexLabel is empty, every instance is a single slice.
+	replicaIndexLabel string
+	workerIndexLabel  string
+	// sizeAnnotation is the annotation key of the number of Pods per slice, if the framework sets one
+	sizeAnnotation string
+	// hostname returns the hostname of a TPU worker
+	hostname func(group workerGroup, replicaIndex int, workerID int) string
+	// sliceName returns TPU_NAME of a slice
+	sliceName func(group workerGroup, replicaIndex int) string
+	// headlessService returns the name of the headless Service of a workload instance
+	headlessService func(group workerGroup) string
+}
+
+var (
+	// kubeRayFramework assigns replica indices and TPU_WORKER_IDs to the Pods of RayCluster worker groups.
+	// Host names are of the form {WORKER_GROUP_NAME}-{REPLICA_INDEX}-{HOST_INDEX}.{CLUSTER_NAME}-headless-worker-svc
+	kubeRayFramework = &multiHostFramework{
+		name:          "KubeRay",
+		instanceLabel: "ray.io/cluster",
+		groupLabel:    "ray.io/group",
+		hostname: func(group workerGroup, replicaIndex int, workerID int) string {
+			return fmt.Sprintf("%s-%d-%d", group.groupName, replicaIndex, workerID)
+		},
+		sliceName: func(group workerGroup, replicaIndex int) string {
+			return fmt.Sprintf("%s-%d", group.groupName, replicaIndex)
+		},
+		headlessService: func(group workerGroup) string {
+			return generateHeadlessServiceName(group.clusterName)
+		},
+	}
+
+	// leaderWorkerSetFramework reads the group and worker index of LeaderWorkerSet Pods, every group
+	// is a slice. The leader is worker 0 and named after the group, the workers are suffixed with their
+	// index. Host names are of the form {LWS_NAME}-{GROUP_INDEX}[-{WORKER_INDEX}].{LWS_NAME}
+	leaderWorkerSetFramework = &multiHostFramework{
+		name:              "LeaderWorkerSet",
+		instanceLabel:     "leaderworkerset.sigs.k8s.io/name",
+		replicaIndexLabel: "leaderworkerset.sigs.k8s.io/group-index",
+		workerIndexLabel:  "leaderworkerset.sigs.k8s.io/worker-index",
+		sizeAnnotation:    "leaderworkerset.sigs.k8s.io/size",
+		hostname: func(group workerGroup, replicaIndex int, workerID int) string {
+			if workerID == 0 {
+				return fmt.Sprintf("%s-%d", group.clusterName, replicaIndex)
+			}
+			return fmt.Sprintf("%s-%d-%d", group.clusterName, replicaIndex, workerID)
+		},
+		sliceName: func(group workerGroup, replicaIndex int) string {
+			return fmt.Sprintf("%s-%d", group.clusterName, replicaIndex)
+		},
+		headlessService: func(group workerGroup) string {
+			return group.clusterName
+		},
+	}
+
+	// statefulSetFramework reads the Pod index of StatefulSets that opt in with the
+	// kuberay-tpu-webhook/statefulset label set to the StatefulSet name, every StatefulSet is a slice.
+	// Host names are of the form {STATEFULSET_NAME}-{POD_INDEX}.{SERVICE_NAME}
+	statefulSetFramework = &multiHostFramework{
+		name:             "StatefulSet",
+		instanceLabel:    "kuberay-tpu-webhook/statefulset",
+		workerIndexLabel: "apps.kubernetes.io/pod-index",
+		hostname: func(group workerGroup, replicaIndex int, workerID int) string {
+			return fmt.Sprintf("%s-%d", group.clusterName, workerID)
+		},
+		sliceName: func(group workerGroup, replicaIndex int) string {
+			return group.clusterName
+		},
+		headlessService: func(group workerGroup) string {
+			return group.clusterName
+		},
+	}
+
+	// frameworks that can be enabled with --frameworks, KubeRay is always enabled
+	multiHostFrameworks = map[string]*multiHostFramework{
+		"leaderworkerset": leaderWorkerSetFramework,
+		"statefulset":     statefulSetFramework,
+	}
+)
+
+// parseFrameworks returns the frameworks of a comma-separated list of framework names
+func parseFrameworks(names string) ([]*multiHostFramework, error) {
+	var frameworks []*multiHostFramework
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		framework, ok := multiHostFrameworks[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown multi-host framework %q, expected leaderworkerset or statefulset", name)
+		}
+		frameworks = append(frameworks, framework)
+	}
+	return frameworks, nil
+}
+
+// assignsWorkers returns whether the webhook assigns replica indices and TPU_WORKER_IDs to the Pods
+// of the framework
+func (f *multiHostFramework) assignsWorkers() bool {
+	return f.workerIndexLabel == ""
+}
+
+// podGroup returns the worker group of a Pod, or false if the Pod is missing the framework's labels
+func (f *multiHostFramework) podGroup(pod *corev1.Pod) (workerGroup, bool) {
+	group := workerGroup{namespace: pod.Namespace, clusterName: pod.Labels[f.instanceLabel]}
+	if f.groupLabel != "" {
+		group.groupName = pod.Labels[f.groupLabel]
+	}
+	return group, group.clusterName != "" && (f.groupLabel == "" || group.groupName != "")
+}
+
+// groupSelector returns the label selector of the Pods in a worker group
+func (f *multiHostFramework) groupSelector(group workerGroup) labels.Selector {
+	set := labels.Set{f.instanceLabel: group.clusterName}
+	if f.groupLabel != "" {
+		set[f.groupLabel] = group.groupName
+	}
+	return labels.SelectorFromSet(set)
+}
+
+// podAssignment returns the replica index and TPU_WORKER_ID the framework set in a Pod's labels
+func (f *multiHostFramework) podAssignment(pod *corev1.Pod) (workerAssignment, error) {
+	if f.assignsWorkers() {
+		return workerAssignment{}, fmt.Errorf("%s Pods are assigned TPU_WORKER_IDs by the webhook", f.name)
+	}
+	var a workerAssignment
+	var err error
+	if f.replicaIndexLabel != "" {
+		if a.replicaIndex, err = strconv.Atoi(pod.Labels[f.replicaIndexLabel]); err != nil {
+			return a, fmt.Errorf("%s Pod has invalid %s label %q", f.name, f.replicaIndexLabel, pod.Labels[f.replicaIndexLabel])
+		}
+	}
+	if a.workerID, err = strconv.Atoi(pod.Labels[f.workerIndexLabel]); err != nil {
+		return a, fmt.Errorf("%s Pod has invalid %s label %q", f.name, f.workerIndexLabel, pod.Labels[f.workerIndexLabel])
+	}
+	return a, nil
+}
+
+// hostnames returns the DNS hostnames of the TPU VM hosts of a slice as a comma-separated string
+func (f *multiHostFramework) hostnames(group workerGroup, numOfHosts int32, replicaIndex int, subdomain string) (string, error) {
+	if numOfHosts == 0 {
+		return "", errors.New("workerGroupSpec NumOfHosts not set")
+	}
+	hostNames := make([]string, numOfHosts)
+	for j := 0; j < int(numOfHosts); j++ {
+		hostNames[j] = fmt.Sprintf("%s.%s", f.hostname(group, replicaIndex, j), subdomain)
+	}
+	klog.V(1).InfoS("hostnames", f.name, group.namespace+"/"+group.clusterName, "NumOfHosts", numOfHosts, "Replica Index", replicaIndex)
+	return strings.Join(hostNames, ","), nil
+}
+
+// podFramework returns the enabled framework whose labels a Pod carries, KubeRay if none does
+func (t *TPUWebhookServer) podFramework(pod *corev1.Pod) *multiHostFramework {
+	for _, framework := range t.frameworks {
+		if _, ok := pod.Labels[framework.instanceLabel]; ok {
+			return framework
+		}
+	}
+	return kubeRayFramework
+}
+
+// mutateFrameworkPod injects TPU environment variables into a Pod of a framework that assigns the
+// replica index and TPU_WORKER_ID itself. Only the hostname and subdomain missing from the Pod are
+// injected, LeaderWorkerSet and StatefulSet controllers already set them. The replica label and pod
+// affinity of the injection policy only apply to KubeRay.
+func mutateFrameworkPod(pod *corev1.Pod, framework *multiHostFramework, policy *injectionPolicy, admissionResponse *admissionv1.AdmissionResponse) error {
+	group, ok := framework.podGroup(pod)
+	if !ok {
+		return fmt.Errorf("%s Pod missing %s label", framework.name, framework.instanceLabel)
+	}
+	topology := pod.Spec.NodeSelector["cloud.google.com/gke-tpu-topology"]
+	if topology == "" {
+		return fmt.Errorf("%s Pod missing TPU topology nodeSelector", framework.name)
+	}
+	containers := pod.Spec.Containers
+	accelerator := pod.Spec.NodeSelector["cloud.google.com/gke-tpu-accelerator"]
+	admissionResponse.Warnings = getTPUResourceWarnings(accelerator, topology, containers...)
+	chipsPerHost := getNumTPUChipsRequested(containers...)
+	numOfHosts, err := getNumTPUHostsFromTopology(group.clusterName, group.groupName, group.namespace, accelerator, topology, chipsPerHost)
+	if err != nil {
+		return err
+	}
+	assignment, err := framework.podAssignment(pod)
+	if err != nil {
+		return err
+	}
+	if assignment.workerID < 0 || assignment.workerID >= int(numOfHosts) {
+		return fmt.Errorf("%s Pod %s %d out of range for %d TPU VM hosts in topology %s", framework.name, framework.workerIndexLabel, assignment.workerID, numOfHosts, topology)
+	}
+	if hostname := framework.hostname(group, assignment.replicaIndex, assignment.workerID); pod.Spec.Hostname != "" && pod.Spec.Hostname != hostname {
+		return fmt.Errorf("%s Pod hostname %s doesn't match the expected hostname %s", framework.name, pod.Spec.Hostname, hostname)
+	}
+	if size, ok := pod.Annotations[framework.sizeAnnotation]; ok && size != fmt.Sprint(numOfHosts) {
+		admissionResponse.Warnings = append(admissionResponse.Warnings,
+			fmt.Sprintf("%s of %s doesn't match the %d TPU VM hosts of topology %s", framework.sizeAnnotation, size, numOfHosts, topology))
+	}
+
+	var patches []patch
+	envVars := []corev1.EnvVar{{Name: policy.Env.WorkerID, Value: fmt.Sprint(assignment.workerID)}}
+	if !policy.skips(patchTPUName) {
+		envVars = append(envVars, corev1.EnvVar{Name: policy.Env.Name, Value: framework.sliceName(group, assignment.replicaIndex)})
+	}
+	if numOfHosts > 1 {
+		if pod.Spec.Hostname == "" && !policy.skips(patchHostname) {
+			patches = append(patches, patch{"op": "add", "path": "/spec/hostname", "value": framework.hostname(group, assignment.replicaIndex, assignment.workerID)})
+		}
+		subdomain := pod.Spec.Subdomain
+		if subdomain == "" {
+			subdomain = framework.headlessService(group)
+			if !policy.skips(patchSubdomain) {
+				patches = append(patches, patch{"op": "add", "path": "/spec/subdomain", "value": subdomain})
+			}
+		}
+		if !policy.skips(patchWorkerHostnames) {
+			hostnames, err := framework.hostnames(group, numOfHosts, assignment.replicaIndex, subdomain)
+			if err != nil {
+				return err
+			}
+			klog.V(1).InfoS("mutateFrameworkPod", framework.name, group.namespace+"/"+group.clusterName, policy.Env.WorkerHostnames, hostnames)
+			envVars = append(envVars, corev1.EnvVar{Name: policy.Env.WorkerHostnames, Value: hostnames})
+		}
+	}
+	envVars = append(envVars, policy.extraEnv(accelerator, topology, chipsPerHost, numOfHosts, assignment.replicaIndex)...)
+	klog.V(1).InfoS("mutateFrameworkPod", framework.name, group.namespace+"/"+group.clusterName, "TPU_WORKER_ID", assignment.workerID, "Replica Index", assignment.replicaIndex)
+	for i, container := range containers {
+		if containerRequestingTPUs(container) {
+			injectEnv(fmt.Sprintf("/spec/containers/%d/env", i), container, &patches, envVars...)
+		}
+	}
+	return setPatches(admissionResponse, patches)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// getTestLeaderWorkerSetPod returns a TPU Pod of a LeaderWorkerSet group as created by the LeaderWorkerSet controller
+func getTestLeaderWorkerSetPod(name string, groupIndex string, workerIndex string, size string, topology string) *corev1.Pod {
+	pod := getTestTPUWorker("", "", "test-namespace", "tpu-v5-lite-podslice", topology, "4")
+	pod.Labels = map[string]string{
+		"leaderworkerset.sigs.k8s.io/name":         name,
+		"leaderworkerset.sigs.k8s.io/group-index":  groupIndex,
+		"leaderworkerset.sigs.k8s.io/worker-index": workerIndex,
+	}
+	pod.Annotations = map[string]string{"leaderworkerset.sigs.k8s.io/size": size}
+	pod.Spec.Subdomain = name
+	return pod
+}
+
+// getTestStatefulSetPod returns a TPU Pod of a StatefulSet that opted in to the webhook
+func getTestStatefulSetPod(name string, podIndex string, topology string) *corev1.Pod {
+	pod := getTestTPUWorker("", "", "test-namespace", "tpu-v5-lite-podslice", topology, "4")
+	pod.Labels = map[string]string{
+		"kuberay-tpu-webhook/statefulset": name,
+		"apps.kubernetes.io/pod-index":    podIndex,
+	}
+	pod.Spec.Hostname = name + "-" + podIndex
+	pod.Spec.Subdomain = name + "-svc"
+	return pod
+}
+
+func Test_ParseFrameworks(t *testing.T) {
+	frameworks, err := parseFrameworks("LeaderWorkerSet, statefulset,")
+	assert.Nil(t, err)
+	assert.Equal(t, []*multiHostFramework{leaderWorkerSetFramework, statefulSetFramework}, frameworks)
+
+	frameworks, err = parseFrameworks("")
+	assert.Nil(t, err)
+	assert.Empty(t, frameworks)
+
+	_, err = parseFrameworks("jobset")
+	assert.NotNil(t, err)
+}
+
+func Test_MutateFrameworkPod(t *testing.T) {
+	tests := map[string]struct {
+		testPod          *corev1.Pod
+		frameworks       []*multiHostFramework
+		expectedPatches  []patch
+		expectedWarnings []string
+		expectedError    error
+	}{
+		"LeaderWorkerSet leader": {
+			testPod: getTestLeaderWorkerSetPod("test-lws", "1", "0", "4", "4x4"),
+			expectedPatches: []patch{
+				{"op": "add", "path": "/spec/hostname", "value": "test-lws-1"},
+				{"op": "add", "path": "/spec/containers/0/env", "value": []corev1.EnvVar{
+					{Name: "TPU_WORKER_ID", Value: "0"},
+					{Name: "TPU_NAME", Value: "test-lws-1"},
+					{Name: "TPU_WORKER_HOSTNAMES", Value: "test-lws-1.test-lws,test-lws-1-1.test-lws,test-lws-1-2.test-lws,test-lws-1-3.test-lws"},
+				}},
+			},
+		},
+		"LeaderWorkerSet worker with TPU_NAME set": {
+			testPod: func() *corev1.Pod {
+				pod := getTestLeaderWorkerSetPod("test-lws", "0", "2", "4", "4x4")
+				pod.Spec.Hostname = "test-lws-0-2"
+				pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "TPU_NAME", Value: "my-slice"}}
+				return pod
+			}(),
+			expectedPatches: []patch{
+				{"op": "add", "path": "/spec/containers/0/env/-", "value": corev1.EnvVar{Name: "TPU_WORKER_ID", Value: "2"}},
+				{"op": "add", "path": "/spec/containers/0/env/-", "value": corev1.EnvVar{Name: "TPU_WORKER_HOSTNAMES", Value: "test-lws-0.test-lws,test-lws-0-1.test-lws,test-lws-0-2.test-lws,test-lws-0-3.test-lws"}},
+			},
+		},
+		"LeaderWorkerSet size doesn't match the topology": {
+			testPod: getTestLeaderWorkerSetPod("test-lws", "0", "0", "2", "2x2"),
+			expectedPatches: []patch{
+				{"op": "add", "path": "/spec/containers/0/env", "value": []corev1.EnvVar{
+					{Name: "TPU_WORKER_ID", Value: "0"},
+					{Name: "TPU_NAME", Value: "test-lws-0"},
+				}},
+			},
+			expectedWarnings: []string{"leaderworkerset.sigs.k8s.io/size of 2 doesn't match the 1 TPU VM hosts of topology 2x2"},
+		},
+		"LeaderWorkerSet worker index out of range": {
+			testPod:       getTestLeaderWorkerSetPod("test-lws", "0", "4", "4", "4x4"),
+			expectedError: errors.New("LeaderWorkerSet Pod leaderworkerset.sigs.k8s.io/worker-index 4 out of range for 4 TPU VM hosts in topology 4x4"),
+		},
+		"StatefulSet Pod": {
+			testPod: getTestStatefulSetPod("test-sts", "3", "4x4"),
+			expectedPatches: []patch{
+				{"op": "add", "path": "/spec/containers/0/env", "value": []corev1.EnvVar{
+					{Name: "TPU_WORKER_ID", Value: "3"},
+					{Name: "TPU_NAME", Value: "test-sts"},
+					{Name: "TPU_WORKER_HOSTNAMES", Value: "test-sts-0.test-sts-svc,test-sts-1.test-sts-svc,test-sts-2.test-sts-svc,test-sts-3.test-sts-svc"},
+				}},
+			},
+		},
+		"StatefulSet label doesn't match the StatefulSet name": {
+			testPod: func() *corev1.Pod {
+				pod := getTestStatefulSetPod("test-sts", "1", "4x4")
+				pod.Labels["kuberay-tpu-webhook/statefulset"] = "other-sts"
+				return pod
+			}(),
+			expectedError: errors.New("StatefulSet Pod hostname test-sts-1 doesn't match the expected hostname other-sts-1"),
+		},
+		"StatefulSet Pod missing the Pod index": {
+			testPod: func() *corev1.Pod {
+				pod := getTestStatefulSetPod("test-sts", "1", "4x4")
+				delete(pod.Labels, "apps.kubernetes.io/pod-index")
+				return pod
+			}(),
+			expectedError: errors.New("StatefulSet Pod has invalid apps.kubernetes.io/pod-index label \"\""),
+		},
+		"disabled framework falls back to KubeRay": {
+			testPod:       getTestStatefulSetPod("test-sts", "1", "4x4"),
+			frameworks:    []*multiHostFramework{leaderWorkerSetFramework},
+			expectedError: errors.New("Ray Pod created by KubeRay missing RayCluster label"),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tpuWebhookServer := NewTPUWebhookServer(setupInformer(), nil)
+			if tc.frameworks != nil {
+				tpuWebhookServer.frameworks = tc.frameworks
+			}
+			admissionReview := getTestAdmissionReview("Pod", "CREATE")
+			admissionReview.Request.Object.Raw, _ = json.Marshal(tc.testPod)
+
+			admissionResponse, err := tpuWebhookServer.mutatePod(admissionReview, nil)
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+				return
+			}
+			assert.Nil(t, err)
+			expectedPatches, _ := json.Marshal(tc.expectedPatches)
+			assert.JSONEq(t, string(expectedPatches), string(admissionResponse.Patch))
+			assert.Equal(t, tc.expectedWarnings, admissionResponse.Warnings)
+		})
+	}
+}
+
+func Test_FrameworkHostnames(t *testing.T) {
+	group := workerGroup{"test-namespace", "test-cluster", "test-group"}
+	hostnames, err := kubeRayFramework.hostnames(group, 2, 1, kubeRayFramework.headlessService(group))
+	assert.Nil(t, err)
+	assert.Equal(t, "test-group-1-0.test-cluster-headless-worker-svc,test-group-1-1.test-cluster-headless-worker-svc", hostnames)
+
+	_, err = leaderWorkerSetFramework.hostnames(workerGroup{namespace: "test-namespace", clusterName: "test-lws"}, 0, 0, "test-lws")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,385 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	ray "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	utils "github.com/ray-project/kuberay/ray-operator/controllers/ray/utils"
+	raylisters "github.com/ray-project/kuberay/ray-operator/pkg/client/listers/ray/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// headless Service modes
+const (
+	headlessServiceOff    = "off"
+	headlessServiceVerify = "verify"
+	headlessServiceCreate = "create"
+)
+
+// event reasons of the headlessServiceController
+const (
+	reasonHeadlessServiceCreated       = "HeadlessServiceCreated"
+	reasonHeadlessServiceMissing       = "HeadlessServiceMissing"
+	reasonHeadlessServiceMisconfigured = "HeadlessServiceMisconfigured"
+)
+
+// headlessServiceLease is the Lease in the webhook namespace held by the replica running the
+// headlessServiceController
+const headlessServiceLease = "kuberay-tpu-webhook-headless-services"
+
+// headlessServiceController verifies that the headless Service resolving TPU_WORKER_HOSTNAMES exists
+// for every RayCluster with multi-host or multislice TPU worker groups, and creates it, owned by the
+// RayCluster, if it is missing. Older KubeRay versions don't create the Service, and without it the
+// TPU workers can't resolve each other and JAX initialization hangs. Missing or misconfigured Services
+// are reported as events on the RayCluster.
+//
+// RayClusters are reconciled when they or a Service in their namespace change, not on informer
+// resyncs, and Services are read from the ServiceInformer cache. RayClusters younger than the grace
+// period are reconciled once it has passed, so that KubeRay can create the Service first. With more
+// than one webhook replica, only the replica holding the headlessServiceLease reconciles.
+type headlessServiceController struct {
+	client           kubernetes.Interface
+	rayClusterLister raylisters.RayClusterLister
+	serviceLister    listersv1.ServiceLister
+	policies         *policyStore
+	recorder         record.EventRecorder
+	queue            workqueue.RateLimitingInterface
+	// create is whether missing Services are created, or only reported
+	create bool
+	// gracePeriod is how long after its creation a RayCluster's Services are left to KubeRay
+	gracePeriod time.Duration
+	// leading is whether this replica reconciles, set by runLeaderElection
+	leading atomic.Bool
+}
+
+func newHeadlessServiceController(client kubernetes.Interface, rayClusterLister raylisters.RayClusterLister, serviceLister listersv1.ServiceLister, policies *policyStore, recorder record.EventRecorder, create bool, gracePeriod time.Duration) *headlessServiceController {
+	c := &headlessServiceController{
+		client:           client,
+		rayClusterLister: rayClusterLister,
+		serviceLister:    serviceLister,
+		policies:         policies,
+		recorder:         recorder,
+		queue:            workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		create:           create,
+		gracePeriod:      gracePeriod,
+	}
+	// a controller reconciles unless it takes part in a leader election
+	c.leading.Store(true)
+	return c
+}
+
+// newEventRecorder returns an EventRecorder for events on RayClusters
+func newEventRecorder(client kubernetes.Interface) record.EventRecorder {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(ray.AddToScheme(scheme))
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme, corev1.EventSource{Component: "kuberay-tpu-webhook"})
+}
+
+// enqueue adds a RayCluster to the queue, for RayClusterInformer event handlers
+func (c *headlessServiceController) enqueue(obj any) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.ErrorS(err, "headlessServiceController")
+		return
+	}
+	c.queue.Add(key)
+}
+
+// rayClusterEventHandler returns the event handler of the RayClusterInformer, which ignores resyncs
+func (c *headlessServiceController) rayClusterEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(oldObj any, newObj any) {
+			if oldObj.(*ray.RayCluster).ResourceVersion != newObj.(*ray.RayCluster).ResourceVersion {
+				c.enqueue(newObj)
+			}
+		},
+	}
+}
+
+// serviceEventHandler returns the event handler of the ServiceInformer, which ignores resyncs
+func (c *headlessServiceController) serviceEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueService,
+		UpdateFunc: func(oldObj any, newObj any) {
+			if oldObj.(*corev1.Service).ResourceVersion != newObj.(*corev1.Service).ResourceVersion {
+				c.enqueueService(newObj)
+			}
+		},
+		DeleteFunc: c.enqueueService,
+	}
+}
+
+// enqueueService adds the RayClusters whose headless Service a Service may be to the queue
+func (c *headlessServiceController) enqueueService(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	service, ok := obj.(*corev1.Service)
+	if !ok {
+		return
+	}
+	rayClusters, err := c.rayClusterLister.RayClusters(service.Namespace).List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "headlessServiceController", "Service", service.Namespace+"/"+service.Name)
+		return
+	}
+	for _, rayCluster := range rayClusters {
+		// the name of the Service may be overridden by the injection policy of a worker group
+		names, err := c.headlessServiceNames(rayCluster)
+		if _, ok := names[service.Name]; ok && err == nil {
+			c.enqueue(rayCluster)
+		}
+	}
+}
+
+// run reconciles RayClusters once the informer caches have synced, until stopCh is closed
+func (c *headlessServiceController) run(stopCh <-chan struct{}, cachesSynced ...cache.InformerSynced) {
+	defer c.queue.ShutDown()
+	if !cache.WaitForCacheSync(stopCh, cachesSynced...) {
+		return
+	}
+	go wait.Until(func() {
+		for c.processNextItem() {
+		}
+	}, time.Second, stopCh)
+	<-stopCh
+}
+
+// runLeaderElection reconciles RayClusters only while this replica, identity, holds the
+// headlessServiceLease in namespace, until ctx is done
+func (c *headlessServiceController) runLeaderElection(ctx context.Context, namespace string, identity string) {
+	c.leading.Store(false)
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: headlessServiceLease, Namespace: namespace},
+		Client:     c.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	// a replica that lost the lease campaigns again
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   15 * time.Second,
+			RenewDeadline:   10 * time.Second,
+			RetryPeriod:     2 * time.Second,
+			ReleaseOnCancel: true,
+			Name:            headlessServiceLease,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					klog.V(0).InfoS("headlessServiceController", "identity", identity, "message", "started leading")
+					c.startLeading()
+				},
+				OnStoppedLeading: func() {
+					klog.V(0).InfoS("headlessServiceController", "identity", identity, "message", "stopped leading")
+					c.leading.Store(false)
+				},
+			},
+		})
+	}, time.Second)
+}
+
+// startLeading reconciles all RayClusters, the events observed while another replica was leading
+// have been dropped
+func (c *headlessServiceController) startLeading() {
+	c.leading.Store(true)
+	rayClusters, err := c.rayClusterLister.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "headlessServiceController")
+		return
+	}
+	for _, rayCluster := range rayClusters {
+		c.enqueue(rayCluster)
+	}
+}
+
+func (c *headlessServiceController) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+	if !c.leading.Load() {
+		// reconciled by the leader
+		c.queue.Forget(key)
+		return true
+	}
+	if err := c.reconcile(context.TODO(), key.(string)); err != nil {
+		klog.ErrorS(err, "headlessServiceController", "RayCluster", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// headlessServiceNames returns the names of the headless Services a RayCluster's TPU worker groups
+// need for their hostnames, by worker group
+func (c *headlessServiceController) headlessServiceNames(rayCluster *ray.RayCluster) (map[string][]string, error) {
+	names := make(map[string][]string)
+	for _, workerGroupSpec := range rayCluster.Spec.WorkerGroupSpecs {
+		if len(workerGroupSpec.Template.Spec.Containers) == 0 || !containerRequestingTPUs(workerGroupSpec.Template.Spec.Containers...) {
+			continue
+		}
+		policy := c.policies.get()
+		if data, ok := getAnnotation(injectionPolicyAnnotation, workerGroupSpec.Template.Annotations, rayCluster.Annotations); ok {
+			var err error
+			if policy, err = policy.override(data); err != nil {
+				return nil, fmt.Errorf("worker group %s: %w", workerGroupSpec.GroupName, err)
+			}
+		}
+		multislice := false
+		if value, ok := getAnnotation(multisliceAnnotation, workerGroupSpec.Template.Annotations, rayCluster.Annotations); ok {
+			multislice, _ = strconv.ParseBool(value)
+			multislice = multislice && !policy.skips(patchMultislice)
+		}
+		if (workerGroupSpec.NumOfHosts > 1 || multislice) && !policy.skips(patchSubdomain) {
+			name := policy.headlessServiceName(rayCluster.Name)
+			names[name] = append(names[name], workerGroupSpec.GroupName)
+		}
+	}
+	return names, nil
+}
+
+// newHeadlessService returns the headless Service of a RayCluster's TPU workers, like the one created
+// by KubeRay
+func newHeadlessService(rayCluster *ray.RayCluster, name string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       rayCluster.Namespace,
+			Labels:          map[string]string{utils.RayClusterHeadlessServiceLabelKey: rayCluster.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(rayCluster, ray.GroupVersion.WithKind("RayCluster"))},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector: map[string]string{
+				utils.RayClusterLabelKey:  rayCluster.Name,
+				utils.RayNodeTypeLabelKey: string(ray.WorkerNode),
+			},
+			Type: corev1.ServiceTypeClusterIP,
+			// TPU workers resolve each other before they are ready
+			PublishNotReadyAddresses: true,
+		},
+	}
+}
+
+// checkHeadlessService returns why a Service can't resolve the hostnames of a RayCluster's TPU
+// workers, or an empty string if it can
+func checkHeadlessService(service *corev1.Service, rayCluster *ray.RayCluster) string {
+	if service.Spec.ClusterIP != corev1.ClusterIPNone {
+		return fmt.Sprintf("Service %s is not headless (clusterIP %q)", service.Name, service.Spec.ClusterIP)
+	}
+	workerLabels := labels.Set{utils.RayClusterLabelKey: rayCluster.Name, utils.RayNodeTypeLabelKey: string(ray.WorkerNode)}
+	if len(service.Spec.Selector) == 0 || !labels.SelectorFromSet(service.Spec.Selector).Matches(workerLabels) {
+		return fmt.Sprintf("Service %s selector %v doesn't select the workers of RayCluster %s", service.Name, service.Spec.Selector, rayCluster.Name)
+	}
+	return ""
+}
+
+// reconcile verifies the headless Services of a RayCluster, and creates the missing ones
+func (c *headlessServiceController) reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	rayCluster, err := c.rayClusterLister.RayClusters(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rayCluster.DeletionTimestamp != nil {
+		return nil
+	}
+	if age := time.Since(rayCluster.CreationTimestamp.Time); age < c.gracePeriod {
+		// KubeRay creates the Service after the RayCluster, check it once the grace period has passed
+		c.queue.AddAfter(key, c.gracePeriod-age)
+		return nil
+	}
+	serviceNames, err := c.headlessServiceNames(rayCluster)
+	if err != nil {
+		// the webhook rejects the Pods of the worker group with the same error
+		c.recorder.Event(rayCluster, corev1.EventTypeWarning, reasonHeadlessServiceMisconfigured, err.Error())
+		return nil
+	}
+
+	names := make([]string, 0, len(serviceNames))
+	for serviceName := range serviceNames {
+		names = append(names, serviceName)
+	}
+	sort.Strings(names)
+	for _, serviceName := range names {
+		groups := serviceNames[serviceName]
+		service, err := c.serviceLister.Services(namespace).Get(serviceName)
+		if err == nil {
+			if reason := checkHeadlessService(service, rayCluster); reason != "" {
+				c.recorder.Eventf(rayCluster, corev1.EventTypeWarning, reasonHeadlessServiceMisconfigured,
+					"%s, TPU_WORKER_HOSTNAMES of worker groups %v won't resolve", reason, groups)
+			}
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		if !c.create {
+			c.recorder.Eventf(rayCluster, corev1.EventTypeWarning, reasonHeadlessServiceMissing,
+				"headless Service %s is missing, TPU_WORKER_HOSTNAMES of worker groups %v won't resolve", serviceName, groups)
+			continue
+		}
+		_, err = c.client.CoreV1().Services(namespace).Create(ctx, newHeadlessService(rayCluster, serviceName), metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// created by KubeRay in the meantime, verified once the ServiceInformer observes it
+			continue
+		}
+		if err != nil {
+			c.recorder.Eventf(rayCluster, corev1.EventTypeWarning, reasonHeadlessServiceMissing,
+				"headless Service %s is missing and can't be created: %v", serviceName, err)
+			return err
+		}
+		klog.V(0).InfoS("headlessServiceController", "RayCluster", key, "Service", serviceName, "message", "created headless Service")
+		c.recorder.Eventf(rayCluster, corev1.EventTypeNormal, reasonHeadlessServiceCreated,
+			"created headless Service %s for TPU_WORKER_HOSTNAMES of worker groups %v", serviceName, groups)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// setupServiceLister returns a ServiceLister of a cache holding services
+func setupServiceLister(services ...*corev1.Service) listersv1.ServiceLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, service := range services {
+		indexer.Add(service)
+	}
+	return listersv1.NewServiceLister(indexer)
+}
+
+// recordedEvents returns the events recorded by a FakeRecorder so far
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func Test_HeadlessServiceController(t *testing.T) {
+	serviceName := generateHeadlessServiceName("test-cluster")
+	tests := map[string]struct {
+		numOfHosts         int32
+		clusterAnnotations map[string]string
+		existingService    *corev1.Service
+		create             bool
+		expectedService    bool
+		expectedEvents     []string
+	}{
+		"missing Service is created": {
+			numOfHosts:      4,
+			create:          true,
+			expectedService: true,
+			expectedEvents:  []string{"Normal HeadlessServiceCreated created headless Service test-cluster-headless-worker-svc for TPU_WORKER_HOSTNAMES of worker groups [test-group]"},
+		},
+		"missing Service is reported": {
+			numOfHosts:     4,
+			expectedEvents: []string{"Warning HeadlessServiceMissing headless Service test-cluster-headless-worker-svc is missing, TPU_WORKER_HOSTNAMES of worker groups [test-group] won't resolve"},
+		},
+		"existing Service": {
+			numOfHosts: 4,
+			existingService: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: serviceName, Namespace: "test-namespace"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: corev1.ClusterIPNone,
+					Selector:  map[string]string{"ray.io/cluster": "test-cluster", "ray.io/node-type": "worker"},
+				},
+			},
+			create:          true,
+			expectedService: true,
+		},
+		"existing Service is not headless": {
+			numOfHosts: 4,
+			existingService: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: serviceName, Namespace: "test-namespace"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: "10.0.0.1",
+					Selector:  map[string]string{"ray.io/cluster": "test-cluster"},
+				},
+			},
+			create:          true,
+			expectedService: true,
+			expectedEvents:  []string{`Warning HeadlessServiceMisconfigured Service test-cluster-headless-worker-svc is not headless (clusterIP "10.0.0.1"), TPU_WORKER_HOSTNAMES of worker groups [test-group] won't resolve`},
+		},
+		"single-host worker group": {
+			numOfHosts: 1,
+			create:     true,
+		},
+		"single-host multislice worker group": {
+			numOfHosts:         1,
+			clusterAnnotations: map[string]string{multisliceAnnotation: "true"},
+			create:             true,
+			expectedService:    true,
+			expectedEvents:     []string{"Normal HeadlessServiceCreated created headless Service test-cluster-headless-worker-svc for TPU_WORKER_HOSTNAMES of worker groups [test-group]"},
+		},
+		"subdomain skipped by the injection policy": {
+			numOfHosts:         4,
+			clusterAnnotations: map[string]string{injectionPolicyAnnotation: "skip: [subdomain]"},
+			create:             true,
+		},
+		"invalid injection policy": {
+			numOfHosts:         4,
+			clusterAnnotations: map[string]string{injectionPolicyAnnotation: "podAffinity: sometimes"},
+			create:             true,
+			expectedEvents:     []string{`Warning HeadlessServiceMisconfigured worker group test-group: invalid kuberay-tpu-webhook/injection-policy annotation: invalid podAffinity "sometimes", expected required or preferred`},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rayCluster := getTestRayCluster("test-cluster", "test-group", "test-namespace", tc.numOfHosts, 1, "4", "tpu-v4-podslice", "2x2x4", false)
+			rayCluster.UID = "test-uid"
+			rayCluster.Annotations = tc.clusterAnnotations
+			var objects []runtime.Object
+			var services []*corev1.Service
+			if tc.existingService != nil {
+				objects = append(objects, tc.existingService)
+				services = append(services, tc.existingService)
+			}
+			client := fake.NewSimpleClientset(objects...)
+			recorder := record.NewFakeRecorder(10)
+			controller := newHeadlessServiceController(client, setupRayClusterLister(rayCluster), setupServiceLister(services...), newPolicyStore(defaultInjectionPolicy()), recorder, tc.create, 0)
+
+			assert.Nil(t, controller.reconcile(context.Background(), "test-namespace/test-cluster"))
+			assert.Equal(t, tc.expectedEvents, recordedEvents(recorder))
+			service, err := client.CoreV1().Services("test-namespace").Get(context.Background(), serviceName, metav1.GetOptions{})
+			if !tc.expectedService {
+				assert.True(t, apierrors.IsNotFound(err))
+				return
+			}
+			assert.Nil(t, err)
+			if tc.existingService == nil {
+				assert.Equal(t, "", checkHeadlessService(service, rayCluster))
+				assert.Equal(t, rayv1.GroupVersion.String(), service.OwnerReferences[0].APIVersion)
+				assert.Equal(t, "RayCluster", service.OwnerReferences[0].Kind)
+				assert.Equal(t, rayCluster.UID, service.OwnerReferences[0].UID)
+			}
+		})
+	}
+
+	// deleted RayClusters are ignored
+	controller := newHeadlessServiceController(fake.NewSimpleClientset(), setupRayClusterLister(), setupServiceLister(), newPolicyStore(defaultInjectionPolicy()), record.NewFakeRecorder(10), true, 0)
+	assert.Nil(t, controller.reconcile(context.Background(), "test-namespace/test-cluster"))
+}
+
+func Test_HeadlessServiceControllerEventHandlers(t *testing.T) {
+	rayCluster := getTestRayCluster("test-cluster", "test-group", "test-namespace", 4, 1, "4", "tpu-v4-podslice", "2x2x4", false)
+	rayCluster.ResourceVersion = "1"
+	singleHost := getTestRayCluster("single-host-cluster", "test-group", "test-namespace", 1, 1, "4", "tpu-v4-podslice", "2x2x1", false)
+	controller := newHeadlessServiceController(fake.NewSimpleClientset(), setupRayClusterLister(rayCluster, singleHost), setupServiceLister(), newPolicyStore(defaultInjectionPolicy()), record.NewFakeRecorder(10), true, 0)
+	defer controller.queue.ShutDown()
+
+	// resyncs of RayClusters and Services aren't reconciled
+	controller.rayClusterEventHandler().OnUpdate(rayCluster, rayCluster)
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: generateHeadlessServiceName("test-cluster"), Namespace: "test-namespace", ResourceVersion: "1"}}
+	controller.serviceEventHandler().OnUpdate(service, service)
+	assert.Equal(t, 0, controller.queue.Len())
+
+	// deleting the headless Service of a RayCluster reconciles it
+	controller.serviceEventHandler().OnDelete(cache.DeletedFinalStateUnknown{Key: "test-namespace/" + service.Name, Obj: service})
+	assert.Equal(t, 1, controller.queue.Len())
+	key, _ := controller.queue.Get()
+	assert.Equal(t, "test-namespace/test-cluster", key)
+	controller.queue.Done(key)
+
+	// other Services don't reconcile any RayCluster
+	controller.serviceEventHandler().OnAdd(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "other-svc", Namespace: "test-namespace"}}, false)
+	assert.Equal(t, 0, controller.queue.Len())
+
+	// updated RayClusters are reconciled
+	updated := rayCluster.DeepCopy()
+	updated.ResourceVersion = "2"
+	controller.rayClusterEventHandler().OnUpdate(rayCluster, updated)
+	assert.Equal(t, 1, controller.queue.Len())
+}
+
+func Test_HeadlessServiceControllerGracePeriod(t *testing.T) {
+	serviceName := generateHeadlessServiceName("test-cluster")
+	rayCluster := getTestRayCluster("test-cluster", "test-group", "test-namespace", 4, 1, "4", "tpu-v4-podslice", "2x2x4", false)
+	rayCluster.CreationTimestamp = metav1.Now()
+	client := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+	controller := newHeadlessServiceController(client, setupRayClusterLister(rayCluster), setupServiceLister(), newPolicyStore(defaultInjectionPolicy()), recorder, false, 200*time.Millisecond)
+	defer controller.queue.ShutDown()
+
+	// a new RayCluster's Service is left to KubeRay, no HeadlessServiceMissing warning
+	assert.Nil(t, controller.reconcile(context.Background(), "test-namespace/test-cluster"))
+	assert.Empty(t, recordedEvents(recorder))
+	assert.Equal(t, 0, controller.queue.Len())
+
+	// the RayCluster is requeued once the grace period has passed
+	assert.Eventually(t, func() bool { return controller.queue.Len() == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, controller.processNextItem())
+	assert.Equal(t, []string{"Warning HeadlessServiceMissing headless Service " + serviceName + " is missing, TPU_WORKER_HOSTNAMES of worker groups [test-group] won't resolve"}, recordedEvents(recorder))
+}
+
+func Test_HeadlessServiceControllerLeaderElection(t *testing.T) {
+	serviceName := generateHeadlessServiceName("test-cluster")
+	rayCluster := getTestRayCluster("test-cluster", "test-group", "test-namespace", 4, 1, "4", "tpu-v4-podslice", "2x2x4", false)
+	client := fake.NewSimpleClientset()
+	controller := newHeadlessServiceController(client, setupRayClusterLister(rayCluster), setupServiceLister(), newPolicyStore(defaultInjectionPolicy()), record.NewFakeRecorder(10), true, 0)
+	defer controller.queue.ShutDown()
+
+	// replicas that don't hold the Lease drop RayClusters without creating Services
+	controller.leading.Store(false)
+	controller.enqueue(rayCluster)
+	assert.True(t, controller.processNextItem())
+	assert.Equal(t, 0, controller.queue.Len())
+	_, err := client.CoreV1().Services("test-namespace").Get(context.Background(), serviceName, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// the new leader reconciles every RayCluster
+	controller.startLeading()
+	assert.Equal(t, 1, controller.queue.Len())
+	assert.True(t, controller.processNextItem())
+	_, err = client.CoreV1().Services("test-namespace").Get(context.Background(), serviceName, metav1.GetOptions{})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,99 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// healthChecker reports the liveness and readiness of the webhook server. The webhook is ready once
+// the informer caches have synced, so that TPU_WORKER_IDs are never assigned from a partial cache,
+// and until it starts draining on shutdown.
+type healthChecker struct {
+	informersSynced map[string]cache.InformerSynced
+	draining        atomic.Bool
+}
+
+func newHealthChecker(informersSynced map[string]cache.InformerSynced) *healthChecker {
+	return &healthChecker{informersSynced: informersSynced}
+}
+
+// Healthz handles liveness probes, the webhook is live as long as it serves requests
+func (h *healthChecker) Healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok")
+}
+
+// Readyz handles readiness probes
+func (h *healthChecker) Readyz(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	for name, hasSynced := range h.informersSynced {
+		if !hasSynced() {
+			http.Error(w, fmt.Sprintf("%s not synced", name), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprint(w, "ok")
+}
+
+// serveUntilTerminated runs the TLS server until SIGTERM or SIGINT. On termination the webhook
+// reports not ready for shutdownDelay, so that it is removed from the Service endpoints while still
+// serving requests, and then stops accepting connections and drains in-flight admissions for up to
+// shutdownTimeout.
+func serveUntilTerminated(srv *http.Server, health *healthChecker, shutdownDelay time.Duration, shutdownTimeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		// the certificate is served by the TLSConfig
+		serveErr <- srv.ListenAndServeTLS("", "")
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	klog.V(0).InfoS("serveUntilTerminated", "message", "received termination signal, draining", "shutdownDelay", shutdownDelay)
+	health.draining.Store(true)
+	time.Sleep(shutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("drain in-flight requests: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
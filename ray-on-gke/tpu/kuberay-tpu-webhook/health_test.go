@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_Readyz(t *testing.T) {
+	podInformerSynced := false
+	health := newHealthChecker(map[string]cache.InformerSynced{
+		"PodInformer":        func() bool { return podInformerSynced },
+		"RayClusterInformer": func() bool { return true },
+	})
+	readyz := func() (int, string) {
+		recorder := httptest.NewRecorder()
+		health.Readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	// not ready until every informer cache has synced
+	code, body := readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "PodInformer not synced")
+
+	podInformerSynced = true
+	code, _ = readyz()
+	assert.Equal(t, http.StatusOK, code)
+
+	// not ready while draining on shutdown, but still live
+	health.draining.Store(true)
+	code, body = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "shutting down")
+
+	recorder := httptest.NewRecorder()
+	health.Healthz(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -29,15 +30,18 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	ray "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
-	utils "github.com/ray-project/kuberay/ray-operator/controllers/ray/utils"
+	rayclient "github.com/ray-project/kuberay/ray-operator/pkg/client/clientset/versioned"
+	rayinformers "github.com/ray-project/kuberay/ray-operator/pkg/client/informers/externalversions"
+	raylisters "github.com/ray-project/kuberay/ray-operator/pkg/client/listers/ray/v1"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	listersv1 "k8s.io/client-go/listers/core/v1"
@@ -59,62 +63,87 @@ type slice struct {
 // TPUWebhookServer is a KubeRay TPU webhook server instance.
 type TPUWebhookServer struct {
 	// podLister is used to query Pods from an informer cache.
-	podLister    listersv1.PodLister
-	cacheMutex   sync.Mutex
-	wg           sync.WaitGroup
-	waiting      int
-	lastAdmitted string
+	podLister listersv1.PodLister
+	// rayClusterLister is used to query RayClusters from an informer cache.
+	rayClusterLister raylisters.RayClusterLister
+	// ledger reserves TPU_WORKER_IDs of admitted Pods until they are in the informer cache.
+	ledger workerAllocator
+	// frameworks are the multi-host frameworks served besides KubeRay.
+	frameworks []*multiHostFramework
+	// policies holds the injection policy of TPU Pods.
+	policies *policyStore
 }
 
 // patch is a JSON patch describing mutate operation(s) for an incoming object.
 type patch map[string]any
 
 var (
-	certPath        = "/etc/kuberay-tpu-webhook/tls/tls.crt"
-	keyPath         = "/etc/kuberay-tpu-webhook/tls/tls.key"
 	tpuResourceName = corev1.ResourceName("google.com/tpu")
 
 	// headless svc will be of the form: {kuberay-cluster-name}-headless-worker-svc
 	headlessServiceSuffix = "headless-worker-svc"
 
 	// Flag arguments.
-	BindAddr       string
-	CACert         string
-	KubeConfigPath string
-	ServerCert     string
-	ServerKey      string
+	BindAddr                   string
+	CACert                     string
+	KubeConfigPath             string
+	ServerCert                 string
+	ServerKey                  string
+	ReservationTTL             time.Duration
+	CertDir                    string
+	CertReloadInterval         time.Duration
+	TLSMinVersion              string
+	TLSCipherSuites            string
+	SelfSignedCerts            bool
+	SelfSignedSecret           string
+	InjectCABundle             bool
+	WebhookNamespace           string
+	WebhookService             string
+	MutatingWebhookConfig      string
+	ValidatingWebhookConfig    string
+	ShutdownDelay              time.Duration
+	ShutdownTimeout            time.Duration
+	SharedLedger               bool
+	Frameworks                 string
+	InjectionPolicyFile        string
+	InjectionPolicyConfigMap   string
+	HeadlessServices           string
+	HeadlessServiceGracePeriod time.Duration
 )
 
-func NewTPUWebhookServer(podLister listersv1.PodLister) *TPUWebhookServer {
+func NewTPUWebhookServer(podLister listersv1.PodLister, rayClusterLister raylisters.RayClusterLister) *TPUWebhookServer {
 	return &TPUWebhookServer{
-		podLister: podLister,
+		podLister:        podLister,
+		rayClusterLister: rayClusterLister,
+		ledger:           newWorkerLedger(ReservationTTL),
+		frameworks:       []*multiHostFramework{leaderWorkerSetFramework, statefulSetFramework},
+		policies:         newPolicyStore(defaultInjectionPolicy()),
 	}
 }
 
 // Mutate handles http Request for Pod creation and writes a response
 func (t *TPUWebhookServer) Mutate(w http.ResponseWriter, r *http.Request) {
-	t.cacheMutex.Lock()
-	defer t.cacheMutex.Unlock()
-
+	start := time.Now()
 	admissionReview := &admissionv1.AdmissionReview{}
 	if err := json.NewDecoder(r.Body).Decode(admissionReview); err != nil {
+		observeAdmission("Pod", "", outcomeInvalid, start)
 		http.Error(w, "Error decoding request body", http.StatusBadRequest)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if admissionReview.Request == nil || admissionReview.Request.Kind.Kind != "Pod" {
+		observeAdmission("Pod", "", outcomeInvalid, start)
 		http.Error(w, "Invalid Kind", http.StatusBadRequest)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	klog.V(0).InfoS("Mutate", "Received review for Pod creation: %s", admissionReview.Request.Name)
-	response, err := t.mutatePod(admissionReview)
+	response, err := t.mutatePod(admissionReview, nil)
+	defer observeAdmission("Pod", admissionReview.Request.Operation, admissionOutcome(response, err), start)
 	if err != nil {
 		klog.Errorf("Failed to mutate Pod: %s", err)
-		http.Error(w, "Failed to mutate Pod", http.StatusForbidden)
-		w.WriteHeader(http.StatusForbidden)
-		return
+		response = deniedResponse(admissionReview, fmt.Sprintf("Failed to mutate Pod: %s", err))
 	}
 	admissionReview.Response = response
 	responseBytes, err := json.Marshal(admissionReview)
@@ -129,25 +158,27 @@ func (t *TPUWebhookServer) Mutate(w http.ResponseWriter, r *http.Request) {
 
 // Validate handles http Request for RayCluster creation and writes a response
 func (t *TPUWebhookServer) Validate(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	admissionReview := &admissionv1.AdmissionReview{}
 	if err := json.NewDecoder(r.Body).Decode(admissionReview); err != nil {
+		observeAdmission("RayCluster", "", outcomeInvalid, start)
 		http.Error(w, "Error decoding request body", http.StatusBadRequest)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if admissionReview.Request == nil || admissionReview.Request.Kind.Kind != "RayCluster" {
+		observeAdmission("RayCluster", "", outcomeInvalid, start)
 		http.Error(w, "Invalid Kind", http.StatusBadRequest)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	klog.V(0).InfoS("Validate", "Received review for RayCluster creation: %s", admissionReview.Request.Name)
 	response, err := validateRayCluster(admissionReview)
+	defer observeAdmission("RayCluster", admissionReview.Request.Operation, admissionOutcome(response, err), start)
 	if err != nil {
 		klog.Errorf("Failed to validate RayCluster: %s", err)
-		http.Error(w, "Failed to validate RayCluster", http.StatusForbidden)
-		w.WriteHeader(http.StatusForbidden)
-		return
+		response = deniedResponse(admissionReview, fmt.Sprintf("Failed to validate RayCluster: %s", err))
 	}
 	admissionReview.Response = response
 	responseBytes, err := json.Marshal(admissionReview)
@@ -160,6 +191,20 @@ func (t *TPUWebhookServer) Validate(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, string(responseBytes))
 }
 
+// deniedResponse returns an Admission Response rejecting a request with a message for the user
+func deniedResponse(admissionReview *admissionv1.AdmissionReview, message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:     admissionReview.Request.UID,
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  "Failure",
+			Message: message,
+			Reason:  metav1.StatusReasonForbidden,
+			Code:    http.StatusForbidden,
+		},
+	}
+}
+
 // printSliceToWorkerIds logs sliceToWorkerIDs contents for debugging
 func printSliceToWorkerIds(sliceToWorkerIDs map[slice][]int) {
 	for slice, workerList := range sliceToWorkerIDs {
@@ -210,24 +255,47 @@ func getNumTPUChipsRequested(containers ...corev1.Container) int64 {
 	return min(tpuLimit, tpuRequest)
 }
 
-// getNumTPUHostsFromTopology returns number of TPU VM hosts in Pod Slice specified by gke-tpu-topology Pod nodeSelector
-func getNumTPUHostsFromTopology(clusterName string, groupName string, namespace string, topology string, chipsPerHost int64) (int32, error) {
-	if topology == "" {
-		return 0, errors.New("TPU topology not specified")
+// getTPUResourceWarnings returns warnings for TPU resources that are legal but likely unintended, because
+// the containers don't use full TPU VM hosts of the topology
+func getTPUResourceWarnings(accelerator string, topology string, containers ...corev1.Container) []string {
+	var warnings []string
+	for _, container := range containers {
+		limit, request := container.Resources.Limits[tpuResourceName], container.Resources.Requests[tpuResourceName]
+		if !limit.IsZero() && !request.IsZero() && limit.Cmp(request) != 0 {
+			warnings = append(warnings, fmt.Sprintf("container %s requests %s google.com/tpu but is limited to %s", container.Name, request.String(), limit.String()))
+		}
 	}
-	topologyVals := strings.Split(topology, "x")
-	chips := 1
-	for i := 0; i < len(topologyVals); i++ {
-		dim, err := strconv.Atoi(topologyVals[i])
-		if err != nil {
-			klog.ErrorS(err, "getNumTPUHostsFromTopology", "RayCluster", namespace+"/"+clusterName, "Worker Group", groupName, "gke-tpu-topology", topology)
-			return 0, err
+
+	chipsPerHost := getNumTPUChipsRequested(containers...)
+	if chipsPerHost == 0 || topology == "" {
+		return warnings
+	}
+	hosts, hostChips, err := getTPUSliceShape(accelerator, topology, chipsPerHost)
+	if err != nil {
+		// impossible combinations are rejected elsewhere
+		return warnings
+	}
+	if _, ok := tpuGenerations[accelerator]; ok {
+		if chipsPerHost < hostChips {
+			warnings = append(warnings, fmt.Sprintf("google.com/tpu limit of %d chips doesn't use all %d chips of a %s TPU VM host", chipsPerHost, hostChips, accelerator))
 		}
-		chips *= dim
+		return warnings
+	}
+	if hosts > 1 && chipsPerHost != unknownMultiHostChipsPerHost {
+		warnings = append(warnings, fmt.Sprintf("multi-host topology %s has %d chips per TPU VM host, google.com/tpu limit of %d chips doesn't match a full host", topology, unknownMultiHostChipsPerHost, chipsPerHost))
 	}
-	// calculate the # of VMs using # of chips per host
-	hosts := max(int32(chips)/int32(chipsPerHost), 1)
-	klog.V(1).InfoS("getNumTPUHostsFromTopology", "RayCluster", namespace+"/"+clusterName, "Worker Group", groupName, "topology", topology, "chips", chips, "hosts", hosts)
+	return warnings
+}
+
+// getNumTPUHostsFromTopology returns number of TPU VM hosts in Pod Slice specified by gke-tpu-accelerator and
+// gke-tpu-topology Pod nodeSelectors
+func getNumTPUHostsFromTopology(clusterName string, groupName string, namespace string, accelerator string, topology string, chipsPerHost int64) (int32, error) {
+	hosts, hostChips, err := getTPUSliceShape(accelerator, topology, chipsPerHost)
+	if err != nil {
+		klog.ErrorS(err, "getNumTPUHostsFromTopology", "RayCluster", namespace+"/"+clusterName, "Worker Group", groupName, "gke-tpu-accelerator", accelerator, "gke-tpu-topology", topology)
+		return 0, err
+	}
+	klog.V(1).InfoS("getNumTPUHostsFromTopology", "RayCluster", namespace+"/"+clusterName, "Worker Group", groupName, "accelerator", accelerator, "topology", topology, "chips per host", hostChips, "hosts", hosts)
 	return hosts, nil
 }
 
@@ -245,56 +313,57 @@ func extractRayCluster(admissionReview *admissionv1.AdmissionReview) (*ray.RayCl
 	return &rayCluster, nil
 }
 
+// extractOldRayCluster returns the existing RayCluster unmarshalled from an UPDATE admission request
+func extractOldRayCluster(admissionReview *admissionv1.AdmissionReview) (*ray.RayCluster, error) {
+	if admissionReview.Request.Kind.Kind != "RayCluster" {
+		return nil, fmt.Errorf("Expected RayCluster but got %s", admissionReview.Request.Kind.Kind)
+	}
+
+	rayCluster := ray.RayCluster{}
+	if err := json.Unmarshal(admissionReview.Request.OldObject.Raw, &rayCluster); err != nil {
+		return nil, err
+	}
+
+	return &rayCluster, nil
+}
+
 // generateHeadlessServiceName returns the expected TPU headless service name for a RayCluster
 func generateHeadlessServiceName(clusterName string) string {
-	serviceName := fmt.Sprintf("%s-%s", clusterName, headlessServiceSuffix)
-
-	// Apply the same truncation as in the RayCluster controller when generating the headless service
-	// name. This is to maintain the up-to 63 char compatibility guarantee for hostnames (RFC 1123).
-	return utils.CheckName(serviceName)
+	return defaultInjectionPolicy().headlessServiceName(clusterName)
 }
 
 // genDNSHostnames returns list of DNS hostnames for TPU VM hosts as a string
 func genDNSHostnames(numOfHosts int32, groupName string, clusterName string, namespace string, replicaIndex int) (string, error) {
-	if numOfHosts == 0 {
-		err := errors.New("workerGroupSpec NumOfHosts not set")
-		return "", err
-	}
-	headlessServiceName := generateHeadlessServiceName(clusterName)
-	hostNames := make([]string, numOfHosts)
-	// Host names will be of the form {WORKER_GROUP_NAME}-{REPLICA_INDEX}-{HOST_INDEX}.{CLUSTER_NAME}-headless-worker-svc
-	for j := 0; j < int(numOfHosts); j++ {
-		hostNames[j] = fmt.Sprintf("%s-%d-%d.%s", groupName, replicaIndex, j, headlessServiceName)
-	}
-	klog.V(1).InfoS("genDNSHostnames", "RayCluster", namespace+"/"+clusterName, "NumOfHosts", numOfHosts, "Replica Index", replicaIndex)
-	return strings.Join(hostNames, ","), nil
-}
-
-// injectHostnames injects subdomain and TPU_WORKER_HOSTNAMES into a Pod for TPU multi-host initialization
-func injectHostnames(clusterName string, hostNames string, envPath string, container corev1.Container, patches *[]patch) {
-	subdomainPatch, hostNamesPatch := patch{"op": "add"}, patch{"op": "add"}
-	subdomainPath := "/spec/subdomain"
-	tpuWorkerHostNames := corev1.EnvVar{
-		Name:  "TPU_WORKER_HOSTNAMES",
-		Value: hostNames,
-	}
-	subdomainPatch["path"] = subdomainPath
-	subdomainPatch["value"] = generateHeadlessServiceName(clusterName)
-	// create new EnvVar array if container.Env is empty, and append hostnames if not
+	group := workerGroup{namespace, clusterName, groupName}
+	return kubeRayFramework.hostnames(group, numOfHosts, replicaIndex, kubeRayFramework.headlessService(group))
+}
+
+// injectEnv injects the environment variables that are not set yet into a container
+func injectEnv(envPath string, container corev1.Container, patches *[]patch, envVars ...corev1.EnvVar) {
+	var missing []corev1.EnvVar
+	for _, envVar := range envVars {
+		if getEnvironmentVariable(envVar.Name, container) == "" {
+			missing = append(missing, envVar)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	// create new EnvVar array if container.Env is empty, and append new EnvVars if not
 	if len(container.Env) == 0 {
-		hostNamesPatch["path"] = envPath
-		hostNamesPatch["value"] = []corev1.EnvVar{tpuWorkerHostNames}
-	} else {
-		hostNamesPatch["path"] = fmt.Sprintf("%s/-", envPath)
-		hostNamesPatch["value"] = tpuWorkerHostNames
+		*patches = append(*patches, patch{"op": "add", "path": envPath, "value": missing})
+		return
+	}
+	for _, envVar := range missing {
+		*patches = append(*patches, patch{"op": "add", "path": envPath + "/-", "value": envVar})
 	}
-	*patches = append(*patches, subdomainPatch, hostNamesPatch)
 }
 
 // injectReplicaLabel injects replicaIndex label into a Pod for TPU Pod scheduling and Ray multi-host autoscaling
-func injectReplicaLabel(clusterName string, namespace string, replicaIndex int, workerGroupName string, patches *[]patch) {
+func injectReplicaLabel(clusterName string, namespace string, replicaIndex int, workerGroupName string, labelKey string, patches *[]patch) {
 	labelPatch := patch{"op": "replace"}
-	labelPath := "/metadata/labels/replicaIndex"
+	// escape the label key as a JSON pointer token
+	labelPath := "/metadata/labels/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(labelKey)
 	replicaLabelValue := workerGroupName + "-" + strconv.Itoa(replicaIndex)
 
 	klog.V(1).InfoS("injectReplicaLabel", "RayCluster", namespace+"/"+clusterName, "replicaIndex", replicaLabelValue)
@@ -305,15 +374,13 @@ func injectReplicaLabel(clusterName string, namespace string, replicaIndex int,
 	*patches = append(*patches, labelPatch)
 }
 
-// injectPodAffinity injects pod affinity and anti-affinity scheduling constraints using replicaIndex label
-func injectPodAffinity(pod *corev1.Pod, replicaIndex int, workerGroupName string, patches *[]patch) {
-	key := "replicaIndex"
+// injectPodAffinity injects pod affinity scheduling constraints using the replica label. The affinity
+// term is added to the Pod's existing affinity.
+func injectPodAffinity(pod *corev1.Pod, replicaIndex int, workerGroupName string, policy *injectionPolicy, patches *[]patch) {
+	key := policy.ReplicaLabel
 	value := workerGroupName + "-" + strconv.Itoa(replicaIndex)
-	topologyKey := "cloud.google.com/gke-nodepool"
-	clusterName := pod.Labels["ray.io/cluster"]
-	namespace := pod.Namespace
-
-	klog.V(1).InfoS("injectPodAffinity", "RayCluster", namespace+"/"+clusterName, "podAffinity match label", value)
+	topologyKey := policy.AffinityTopologyKey
+	klog.V(1).InfoS("injectPodAffinity", "Pod", pod.Namespace+"/"+pod.GenerateName, "podAffinity match label", value, "podAffinity", policy.PodAffinity)
 
 	// construct affinity value to inject - schedule pods with the same replicaIndex together
 	podAffinityPatch := patch{"op": "add"}
@@ -321,17 +388,26 @@ func injectPodAffinity(pod *corev1.Pod, replicaIndex int, workerGroupName string
 	affinitySelectorRequirement := metav1.LabelSelectorRequirement{Key: key, Operator: metav1.LabelSelectorOpIn, Values: []string{value}}
 	affinityMatchExpressions := []metav1.LabelSelectorRequirement{affinitySelectorRequirement}
 	affinityLabelSelector := metav1.LabelSelector{MatchExpressions: affinityMatchExpressions}
-	podAffinityTerms := []corev1.PodAffinityTerm{corev1.PodAffinityTerm{LabelSelector: &affinityLabelSelector, TopologyKey: topologyKey}}
-	podAffinity := corev1.PodAffinity{RequiredDuringSchedulingIgnoredDuringExecution: podAffinityTerms}
+	podAffinityTerm := corev1.PodAffinityTerm{LabelSelector: &affinityLabelSelector, TopologyKey: topologyKey}
 
+	affinity := &corev1.Affinity{}
 	if pod.Spec.Affinity != nil {
-		podAffinityPatch["path"] = "/spec/affinity/podAffinity"
-		podAffinityPatch["value"] = podAffinity
+		affinity = pod.Spec.Affinity.DeepCopy()
+	}
+	if affinity.PodAffinity == nil {
+		affinity.PodAffinity = &corev1.PodAffinity{}
+	}
+	if policy.PodAffinity == affinityPreferred {
+		weightedTerm := corev1.WeightedPodAffinityTerm{Weight: 100, PodAffinityTerm: podAffinityTerm}
+		affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution, weightedTerm)
 	} else {
-		podAffinityPatch["path"] = "/spec/affinity"
-		podAffinityPatch["value"] = corev1.Affinity{PodAffinity: &podAffinity}
+		affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution, podAffinityTerm)
 	}
 
+	// add replaces the existing affinity with the merged one
+	podAffinityPatch["path"] = "/spec/affinity"
+	podAffinityPatch["value"] = *affinity
+
 	*patches = append(*patches, podAffinityPatch)
 }
 
@@ -361,7 +437,8 @@ func checkWorkersMatchTopology(clusterName string, namespace string, workerGroup
 			klog.ErrorS(err, "checkWorkersMatchTopology", "RayCluster", namespace+"/"+clusterName, "gke-tpu-topology", topology)
 			return false, err
 		}
-		expectedHosts, err := getNumTPUHostsFromTopology(clusterName, groupName, namespace, topology, chipsPerHost)
+		accelerator := workerGroupSpec.Template.Spec.NodeSelector["cloud.google.com/gke-tpu-accelerator"]
+		expectedHosts, err := getNumTPUHostsFromTopology(clusterName, groupName, namespace, accelerator, topology, chipsPerHost)
 		if err != nil {
 			return false, err
 		}
@@ -373,53 +450,181 @@ func checkWorkersMatchTopology(clusterName string, namespace string, workerGroup
 	return true, nil
 }
 
-// validateRayCluster returns an Admission Response after checking Ray worker groups match TPU scheduling constraints
+// workerGroupCause returns a StatusCause describing a violation in a worker group
+func workerGroupCause(groupName string, field string, message string) metav1.StatusCause {
+	return metav1.StatusCause{
+		Type:    metav1.CauseTypeFieldValueInvalid,
+		Message: fmt.Sprintf("worker group %s: %s", groupName, message),
+		Field:   field,
+	}
+}
+
+// validateWorkerGroup returns all violations of TPU scheduling constraints in a TPU worker group
+func validateWorkerGroup(clusterName string, namespace string, index int, workerGroupSpec ray.WorkerGroupSpec) []metav1.StatusCause {
+	var causes []metav1.StatusCause
+	groupName := workerGroupSpec.GroupName
+	field := fmt.Sprintf("spec.workerGroupSpecs[%d]", index)
+
+	// validate NumOfHosts for worker group matches topology nodeSelector
+	workersMatchTopology, err := checkWorkersMatchTopology(clusterName, namespace, workerGroupSpec)
+	if err != nil {
+		causes = append(causes, workerGroupCause(groupName, field, err.Error()))
+	} else if !workersMatchTopology {
+		causes = append(causes, workerGroupCause(groupName, field+".numOfHosts", "Number of workers in worker group not equal to specified topology"))
+	}
+
+	// every replica is a slice of NumOfHosts workers, replicas are scaled between MinReplicas and MaxReplicas
+	replicas, minReplicas, maxReplicas := workerGroupSpec.Replicas, workerGroupSpec.MinReplicas, workerGroupSpec.MaxReplicas
+	for name, value := range map[string]*int32{"replicas": replicas, "minReplicas": minReplicas, "maxReplicas": maxReplicas} {
+		if value != nil && *value < 0 {
+			causes = append(causes, workerGroupCause(groupName, field+"."+name, fmt.Sprintf("%s %d must not be negative", name, *value)))
+		}
+	}
+	if minReplicas != nil && maxReplicas != nil && *minReplicas > *maxReplicas {
+		causes = append(causes, workerGroupCause(groupName, field+".minReplicas", fmt.Sprintf("minReplicas %d greater than maxReplicas %d", *minReplicas, *maxReplicas)))
+	}
+	if replicas != nil && minReplicas != nil && *replicas < *minReplicas {
+		causes = append(causes, workerGroupCause(groupName, field+".replicas", fmt.Sprintf("replicas %d less than minReplicas %d", *replicas, *minReplicas)))
+	}
+	if replicas != nil && maxReplicas != nil && *replicas > *maxReplicas {
+		causes = append(causes, workerGroupCause(groupName, field+".replicas", fmt.Sprintf("replicas %d greater than maxReplicas %d", *replicas, *maxReplicas)))
+	}
+
+	sort.Slice(causes, func(i, j int) bool { return causes[i].Field < causes[j].Field })
+	return causes
+}
+
+// validateWorkerGroupUpdate returns the violations of changes to a live TPU worker group, the slices of
+// existing replicas would no longer match the worker group if its topology or NumOfHosts changed
+func validateWorkerGroupUpdate(index int, oldWorkerGroupSpec ray.WorkerGroupSpec, workerGroupSpec ray.WorkerGroupSpec) []metav1.StatusCause {
+	var causes []metav1.StatusCause
+	groupName := workerGroupSpec.GroupName
+	field := fmt.Sprintf("spec.workerGroupSpecs[%d]", index)
+
+	oldTopology := oldWorkerGroupSpec.Template.Spec.NodeSelector["cloud.google.com/gke-tpu-topology"]
+	topology := workerGroupSpec.Template.Spec.NodeSelector["cloud.google.com/gke-tpu-topology"]
+	if oldTopology != topology {
+		causes = append(causes, workerGroupCause(groupName, field+".template.spec.nodeSelector", fmt.Sprintf("gke-tpu-topology can't be changed from %q to %q", oldTopology, topology)))
+	}
+	if oldWorkerGroupSpec.NumOfHosts != workerGroupSpec.NumOfHosts {
+		causes = append(causes, workerGroupCause(groupName, field+".numOfHosts", fmt.Sprintf("NumOfHosts can't be changed from %d to %d", oldWorkerGroupSpec.NumOfHosts, workerGroupSpec.NumOfHosts)))
+	}
+	return causes
+}
+
+// workerGroupSpecChanged returns whether a live worker group changed in more than its replicas, replicas are
+// patched by the Ray autoscaler and KubeRay and aren't validated again for worker groups admitted before
+func workerGroupSpecChanged(oldWorkerGroupSpec ray.WorkerGroupSpec, workerGroupSpec ray.WorkerGroupSpec) bool {
+	workerGroupSpec.Replicas = oldWorkerGroupSpec.Replicas
+	workerGroupSpec.ScaleStrategy = oldWorkerGroupSpec.ScaleStrategy
+	return !apiequality.Semantic.DeepEqual(oldWorkerGroupSpec, workerGroupSpec)
+}
+
+// requestingTPUs returns whether a worker group requests TPU resources, worker groups without containers
+// are validated as TPU worker groups
+func requestingTPUs(workerGroupSpec ray.WorkerGroupSpec) bool {
+	containers := workerGroupSpec.Template.Spec.Containers
+	return len(containers) == 0 || containerRequestingTPUs(containers...)
+}
+
+// validateRayCluster returns an Admission Response after checking Ray worker groups match TPU scheduling constraints,
+// for updates it only validates worker groups that changed and checks that live TPU worker groups keep their slice shape
 func validateRayCluster(admissionReview *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
 	raycluster, err := extractRayCluster(admissionReview)
 	if err != nil {
 		return nil, err
 	}
+	if raycluster.DeletionTimestamp != nil {
+		// finalizers of a RayCluster that is being deleted are removed regardless of its worker groups
+		return &admissionv1.AdmissionResponse{
+			UID:     admissionReview.Request.UID,
+			Allowed: true,
+			Result: &metav1.Status{
+				Status:  "Success",
+				Message: "",
+			},
+		}, nil
+	}
+	// worker groups of the existing RayCluster by name
+	oldWorkerGroupSpecs := make(map[string]ray.WorkerGroupSpec)
+	if admissionReview.Request.Operation == admissionv1.Update {
+		oldRayCluster, err := extractOldRayCluster(admissionReview)
+		if err != nil {
+			return nil, err
+		}
+		for _, workerGroupSpec := range oldRayCluster.Spec.WorkerGroupSpecs {
+			oldWorkerGroupSpecs[workerGroupSpec.GroupName] = workerGroupSpec
+		}
+	}
 
-	admit := true
-	status := "Success"
-	message := ""
 	clusterName := raycluster.Name
 	namespace := raycluster.Namespace
-	klog.V(1).InfoS("validateRayCluster", "RayCluster", namespace+"/"+clusterName)
+	klog.V(1).InfoS("validateRayCluster", "RayCluster", namespace+"/"+clusterName, "Operation", admissionReview.Request.Operation)
+	var causes []metav1.StatusCause
+	var warnings []string
 	workerGroupSpecs := raycluster.Spec.WorkerGroupSpecs
 	for i := 0; i < len(workerGroupSpecs); i++ {
 		workerGroupSpec := workerGroupSpecs[i]
-		workerGroupContainers := workerGroupSpec.Template.Spec.Containers
-		if len(workerGroupContainers) != 0 && !containerRequestingTPUs(workerGroupContainers...) {
+		oldWorkerGroupSpec, live := oldWorkerGroupSpecs[workerGroupSpec.GroupName]
+		if !requestingTPUs(workerGroupSpec) && !(live && requestingTPUs(oldWorkerGroupSpec)) {
 			// pass through if no TPUs are requested
 			continue
 		}
-		// validate NumOfHosts for worker group matches topology nodeSelector
-		workersMatchTopology, err := checkWorkersMatchTopology(clusterName, namespace, workerGroupSpec)
-		if err != nil {
-			return nil, err
+		if live {
+			causes = append(causes, validateWorkerGroupUpdate(i, oldWorkerGroupSpec, workerGroupSpec)...)
+			if !workerGroupSpecChanged(oldWorkerGroupSpec, workerGroupSpec) {
+				// metadata-only updates and scaling don't revalidate admitted worker groups
+				continue
+			}
 		}
-
-		if !workersMatchTopology {
-			admit = false
-			status = "Failure"
-			message = "Number of workers in worker group not equal to specified topology"
-			break
+		if requestingTPUs(workerGroupSpec) {
+			causes = append(causes, validateWorkerGroup(clusterName, namespace, i, workerGroupSpec)...)
+			accelerator := workerGroupSpec.Template.Spec.NodeSelector["cloud.google.com/gke-tpu-accelerator"]
+			topology := workerGroupSpec.Template.Spec.NodeSelector["cloud.google.com/gke-tpu-topology"]
+			for _, warning := range getTPUResourceWarnings(accelerator, topology, workerGroupSpec.Template.Spec.Containers...) {
+				warnings = append(warnings, fmt.Sprintf("worker group %s: %s", workerGroupSpec.GroupName, warning))
+			}
 		}
 	}
 
 	// Create AdmissionResponse
 	admissionResponse := &admissionv1.AdmissionResponse{
 		UID:     admissionReview.Request.UID,
-		Allowed: admit,
+		Allowed: true,
 		Result: &metav1.Status{
-			Status:  status,
-			Message: message,
+			Status:  "Success",
+			Message: "",
 		},
+		Warnings: warnings,
+	}
+	if len(causes) > 0 {
+		messages := make([]string, len(causes))
+		for i, cause := range causes {
+			messages[i] = cause.Message
+		}
+		klog.V(0).InfoS("validateRayCluster", "RayCluster", namespace+"/"+clusterName, "violations", messages)
+		admissionResponse.Allowed = false
+		admissionResponse.Result = &metav1.Status{
+			Status:  "Failure",
+			Message: strings.Join(messages, "; "),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Details: &metav1.StatusDetails{
+				Name:   clusterName,
+				Kind:   "RayCluster",
+				Causes: causes,
+			},
+		}
 	}
 	return admissionResponse, nil
 }
 
+// isPodActive returns whether a Pod holds on to its TPU_WORKER_ID, Pods that are being deleted or
+// have terminated are replaced by KubeRay
+func isPodActive(pod *corev1.Pod) bool {
+	return pod.DeletionTimestamp == nil && pod.Status.Phase != corev1.PodFailed && pod.Status.Phase != corev1.PodSucceeded
+}
+
 // getEnvironmentVariable returns value associated with a given Container environment variable
 func getEnvironmentVariable(varName string, container corev1.Container) string {
 	if container.Env != nil && len(container.Env) > 0 {
@@ -441,18 +646,19 @@ func getEnvironmentVariable(varName string, container corev1.Container) string {
 //     pods to the same replica
 //  3. sliceToWorkerIDs isn't empty, but all slices have # workers == NumOfHosts
 //     - this occurs when the pod we intercept is the first pod of a different slice in the cluster
-//     - we keep track of how many replicas of the same worker group have been added to sliceToWorkerIDs
-//     so far, and assign this pod to the next integer replicaIndex
-func getReplicaIndex(sliceToWorkerIDs map[slice][]int, clusterName string, groupName string, namespace string) int {
+//     - we keep track of which replicas of the same worker group have been added to sliceToWorkerIDs
+//     so far, and assign this pod to the lowest unused replicaIndex. Replicas whose Pods were all
+//     deleted leave a gap, which is filled before a new replicaIndex is used.
+func getReplicaIndex(sliceToWorkerIDs map[slice][]int, group workerGroup) int {
 	// first pod created in cluster
 	if len(sliceToWorkerIDs) == 0 {
 		return 0
 	}
 	nextLowestId := math.MaxInt32
-	numReplicas := 0 // tracks # of replicas in worker group created so far
+	replicas := make(map[int]bool) // tracks replicas in worker group created so far
 	for slice, workerList := range sliceToWorkerIDs {
-		if slice.clusterName == clusterName && slice.groupName == groupName && slice.namespace == namespace {
-			numReplicas++
+		if slice.clusterName == group.clusterName && slice.groupName == group.groupName && slice.namespace == group.namespace {
+			replicas[slice.replicaIndex] = true
 			createdPods := len(workerList)
 			if createdPods < int(slice.numOfHosts) {
 				if slice.replicaIndex < nextLowestId {
@@ -463,9 +669,12 @@ func getReplicaIndex(sliceToWorkerIDs map[slice][]int, clusterName string, group
 	}
 	// first pod of new slice in cluster
 	if nextLowestId == math.MaxInt32 {
-		nextLowestId = numReplicas
+		nextLowestId = 0
+		for replicas[nextLowestId] {
+			nextLowestId++
+		}
 	}
-	klog.V(1).InfoS("getReplicaIndex", "RayCluster", namespace+"/"+clusterName, "Worker Group", groupName, "Replica Index", nextLowestId)
+	klog.V(1).InfoS("getReplicaIndex", "RayCluster", group.namespace+"/"+group.clusterName, "Worker Group", group.groupName, "Replica Index", nextLowestId)
 	return nextLowestId
 }
 
@@ -483,6 +692,7 @@ func getNextWorkerID(sliceToWorkerIDs map[slice][]int, podSlice slice, namespace
 		if index == 0 {
 			lastID = workerID
 		} else if workerID == lastID {
+			workerIDConflicts.Inc()
 			return 0, errors.New("Identical TPU_WORKER_ID assigned to multiple TPU workers in slice")
 		}
 		// get the next lowest, valid TPU_WORKER_ID
@@ -496,84 +706,78 @@ func getNextWorkerID(sliceToWorkerIDs map[slice][]int, podSlice slice, namespace
 	return tpuWorkerID, nil
 }
 
-// getSliceToWorkerIDs returns a mapping representing the current RayCluster state of TPU pods using a PodLister
-func (t *TPUWebhookServer) getSliceToWorkerIDs(clusterName string, groupName string, namespace string, numOfHosts int32) (map[slice][]int, error) {
+// getSliceToWorkerIDs returns a mapping representing the current state of the TPU Pods of a worker group using
+// a PodLister, the Pods are selected and read through the KubeRay framework's labels
+func (t *TPUWebhookServer) getSliceToWorkerIDs(group workerGroup, numOfHosts int32) (map[slice][]int, error) {
 	sliceToWorkerIDs := make(map[slice][]int)
+	policy := t.policies.get()
 
 	// we only care about workers in the same RayCluster and worker group when assigning IDs
-	podsInGroup, err := t.podLister.Pods(namespace).List(labels.SelectorFromSet(labels.Set{"ray.io/cluster": clusterName, "ray.io/group": groupName}))
+	podsInGroup, err := t.podLister.Pods(group.namespace).List(kubeRayFramework.groupSelector(group))
 	if err != nil {
 		return nil, err
 	}
 
 	if podsInGroup == nil {
-		// return an empty mapping if no Pods with 'ray.io/group' label found
+		// return an empty mapping if no Pods of the worker group are found
 		return sliceToWorkerIDs, nil
 	}
-	klog.V(1).InfoS("getSliceToWorkerIDs", "RayCluster", namespace+"/"+clusterName, "# Pods in Group", len(podsInGroup))
+	klog.V(1).InfoS("getSliceToWorkerIDs", "RayCluster", group.namespace+"/"+group.clusterName, "# Pods in Group", len(podsInGroup))
 	for _, existingPod := range podsInGroup {
-		if existingPod.DeletionTimestamp != nil {
+		if !isPodActive(existingPod) {
+			// Pod is being replaced, its replacement rejoins the slice with the same TPU_WORKER_ID
 			continue
 		}
-		existingNamespace := existingPod.Namespace
-		// check that Pods are in the same namespace
-		if namespace != existingNamespace {
+		// check that Pods are in the same worker group
+		if existingGroup, ok := kubeRayFramework.podGroup(existingPod); !ok || existingGroup != group {
 			continue
 		}
 
 		if !containerRequestingTPUs(existingPod.Spec.Containers...) {
-			// Pod does not request TPUs, 'ray.io/group' is not a TPU worker group
+			// Pod does not request TPUs, the worker group is not a TPU worker group
 			return sliceToWorkerIDs, nil
 		}
-		replicaIndexLabel := existingPod.Labels["replicaIndex"]
-		if replicaIndexLabel == "" {
+		existingReplicaIndex, err := podReplicaIndex(existingPod, policy)
+		if errors.Is(err, errNotAssigned) {
 			// Pod has not been intercepted by the KubeRay TPU webhook yet
 			continue
 		}
-		replicaIndexLabelValues := strings.Split(replicaIndexLabel, "-")
-		existingReplicaIndex, _ := strconv.Atoi(replicaIndexLabelValues[len(replicaIndexLabelValues)-1])
-		existingWorkerID := -1
-		for _, container := range existingPod.Spec.Containers {
-			if !containerRequestingTPUs(container) {
-				continue
-			}
-
-			tpuWorkerIDEnvVar := getEnvironmentVariable("TPU_WORKER_ID", container)
-			tempVar, err := strconv.Atoi(tpuWorkerIDEnvVar)
-			if err != nil {
-				klog.ErrorS(err, "getSliceToWorkerIDs", "RayCluster", namespace+"/"+clusterName, "TPU_WORKER_ID", tpuWorkerIDEnvVar)
-				continue
-			}
-			existingWorkerID = tempVar
-			break
-		}
-		if existingPod.Status.Phase == "Running" && existingWorkerID == -1 {
-			return nil, errors.New("existing TPU worker missing TPU_WORKER_ID")
+		if err != nil {
+			klog.ErrorS(err, "getSliceToWorkerIDs", "RayCluster", group.namespace+"/"+group.clusterName, "replicaIndex", existingPod.Labels[policy.ReplicaLabel])
+			continue
 		}
-		if existingWorkerID != -1 {
-			// Pod has been intercepted by the webhook
-			podSlice := slice{clusterName, groupName, namespace, existingReplicaIndex, numOfHosts}
-			if sliceToWorkerIDs[podSlice] == nil {
-				sliceToWorkerIDs[podSlice] = []int{existingWorkerID}
-			} else {
-				sliceToWorkerIDs[podSlice] = append(sliceToWorkerIDs[podSlice], existingWorkerID)
+		existingWorkerID, err := podWorkerID(existingPod, policy)
+		if err != nil {
+			if existingPod.Status.Phase == corev1.PodRunning {
+				return nil, errors.New("existing TPU worker missing TPU_WORKER_ID")
 			}
-			klog.V(1).InfoS("getSliceToWorkerIDs", "RayCluster", namespace+"/"+clusterName, "ReplicaIndex", existingReplicaIndex, "TPU_WORKER_ID", existingWorkerID)
-
+			if !errors.Is(err, errNotAssigned) {
+				klog.ErrorS(err, "getSliceToWorkerIDs", "RayCluster", group.namespace+"/"+group.clusterName, "Pod", existingPod.Name)
+			}
+			continue
 		}
+		// Pod has been intercepted by the webhook
+		podSlice := slice{group.clusterName, group.groupName, group.namespace, existingReplicaIndex, numOfHosts}
+		sliceToWorkerIDs[podSlice] = append(sliceToWorkerIDs[podSlice], existingWorkerID)
+		klog.V(1).InfoS("getSliceToWorkerIDs", "RayCluster", group.namespace+"/"+group.clusterName, "ReplicaIndex", existingReplicaIndex, "TPU_WORKER_ID", existingWorkerID)
 	}
 	return sliceToWorkerIDs, nil
 }
 
-// extractPod returns a Pod unmarshalled from an Admission Request
+// extractPod returns a Pod unmarshalled from an Admission Request, for DELETE requests this is the
+// Pod being deleted
 func extractPod(admissionReview *admissionv1.AdmissionReview) (*corev1.Pod, error) {
 	if admissionReview.Request.Kind.Kind != "Pod" {
 		return nil, fmt.Errorf("Expected Pod but got %s", admissionReview.Request.Kind.Kind)
 	}
 
+	raw := admissionReview.Request.Object.Raw
+	if admissionReview.Request.Operation == admissionv1.Delete {
+		raw = admissionReview.Request.OldObject.Raw
+	}
 	pod := corev1.Pod{}
-	if admissionReview.Request.Operation == "CREATE" {
-		if err := json.Unmarshal(admissionReview.Request.Object.Raw, &pod); err != nil {
+	if len(raw) != 0 {
+		if err := json.Unmarshal(raw, &pod); err != nil {
 			return nil, err
 		}
 	}
@@ -581,27 +785,15 @@ func extractPod(admissionReview *admissionv1.AdmissionReview) (*corev1.Pod, erro
 	return &pod, nil
 }
 
-// waitTimeout helper function to sync.WaitGroup Wait() or timeout
-func waitTimeout(wait *sync.WaitGroup, timeout time.Duration) bool {
-	waitChan := make(chan struct{})
-	go func() {
-		defer close(waitChan)
-		wait.Wait()
-	}()
-	select {
-	case <-waitChan:
-		return true // Wait() returned
-	case <-time.After(timeout):
-		return false // Request timed out
-	}
-}
-
-// mutatePod returns an Admission Response after injecting TPU related fields to a given Pod
-func (t *TPUWebhookServer) mutatePod(admissionReview *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+// mutatePod returns an Admission Response after injecting TPU related fields to a given Pod. If explanation
+// is set, the reasoning behind the assignment is recorded in it and the assignment is not reserved.
+func (t *TPUWebhookServer) mutatePod(admissionReview *admissionv1.AdmissionReview, explanation *podExplanation) (*admissionv1.AdmissionResponse, error) {
 	pod, err := extractPod(admissionReview)
 	if err != nil {
 		return nil, err
 	}
+	// dry-run requests must not have side effects, the assigned TPU_WORKER_ID isn't reserved for them
+	dryRun := explanation != nil || (admissionReview.Request.DryRun != nil && *admissionReview.Request.DryRun)
 
 	var patches []patch
 	admissionResponse := &admissionv1.AdmissionResponse{
@@ -609,6 +801,12 @@ func (t *TPUWebhookServer) mutatePod(admissionReview *admissionv1.AdmissionRevie
 		Allowed: true,
 	}
 
+	if admissionReview.Request.Operation != admissionv1.Create {
+		// TPU_WORKER_ID and hostname are assigned once at creation and can't change afterwards
+		klog.V(1).InfoS("mutatePod", "Pod", pod.Namespace+"/"+pod.Name, "Operation", admissionReview.Request.Operation, "message", "admitting without changes")
+		return admissionResponse, nil
+	}
+
 	containers := pod.Spec.Containers
 	if containers == nil {
 		return nil, errors.New("Container path not specified")
@@ -618,123 +816,139 @@ func (t *TPUWebhookServer) mutatePod(admissionReview *admissionv1.AdmissionRevie
 		return admissionResponse, nil
 	}
 
+	if framework := t.podFramework(pod); !framework.assignsWorkers() {
+		if err := mutateFrameworkPod(pod, framework, t.policies.get(), admissionResponse); err != nil {
+			return nil, err
+		}
+		return admissionResponse, nil
+	}
+
 	// ray operator only sets GenerateName field - doesn't include random suffix until after admission request
 	// use mapping of {cluster name, group name, replicaIndex} -> workers to extract next TPU_WORKER_ID
-	clusterName := pod.Labels["ray.io/cluster"]
+	group, ok := kubeRayFramework.podGroup(pod)
+	clusterName, groupName := group.clusterName, group.groupName
 	if clusterName == "" {
 		return nil, errors.New("Ray Pod created by KubeRay missing RayCluster label")
 	}
-	groupName := pod.Labels["ray.io/group"]
-	if groupName == "" {
-		return nil, errors.New("Ray Pod created by KubeRay missing Group label")
+	if !ok {
+		// TPU_WORKER_IDs are assigned per worker group, admit the Pod without TPU environment
+		admissionResponse.Warnings = []string{"Ray Pod missing ray.io/group label, TPU environment variables are not injected"}
+		return admissionResponse, nil
 	}
 	namespace := pod.Namespace
 	topology := pod.Spec.NodeSelector["cloud.google.com/gke-tpu-topology"]
 	if topology == "" {
 		return nil, errors.New("Ray Pod created by KubeRay missing TPU topology nodeSelector")
 	}
+	policy, err := t.podPolicy(pod, clusterName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	subdomain := policy.headlessServiceName(clusterName)
+	accelerator := pod.Spec.NodeSelector["cloud.google.com/gke-tpu-accelerator"]
+	admissionResponse.Warnings = getTPUResourceWarnings(accelerator, topology, containers...)
 	// assign worker to the next unique ID in the Pod Slice and update map
 	chipsPerHost := getNumTPUChipsRequested(containers...)
-	numOfHosts, _ := getNumTPUHostsFromTopology(clusterName, groupName, namespace, topology, chipsPerHost) // ignore error here because topology may not be set yet
+	numOfHosts, err := getNumTPUHostsFromTopology(clusterName, groupName, namespace, accelerator, topology, chipsPerHost)
+	if err != nil {
+		return nil, err
+	}
 
-	// Wait for PodInformer cache to update from previous requests or timeout
-	if waitTimeout(&t.wg, time.Second*1) {
-		klog.V(1).Info("MutatePod", "PodInformer AddFunc called for prior admission request")
-	} else {
-		klog.V(1).Info("MutatePod", "Timed out waiting for PodInformer AddFunc")
+	// check whether every replica of the worker group is a slice of a multislice workload
+	var multislice *multisliceConfig
+	if !policy.skips(patchMultislice) {
+		multislice, err = t.getMultisliceConfig(pod, clusterName, groupName, namespace, numOfHosts, subdomain)
+		if err != nil {
+			return nil, err
+		}
 	}
-	// Add 1 to the WaitGroup to represent the pending Pod to the cache
-	defer t.wg.Add(1)
-	t.waiting += 1
 
-	// query k8s client to populate sliceToWorkerIDs to then calculate the next TPU_WORKER_ID and replicaIndex
-	sliceToWorkerIDs, err := t.getSliceToWorkerIDs(clusterName, groupName, namespace, numOfHosts)
+	// query k8s client to populate sliceToWorkerIDs to then calculate the next TPU_WORKER_ID and replicaIndex,
+	// the ledger adds IDs that were handed out but are not in the PodInformer cache yet
+	var sliceToWorkerIDs map[slice][]int
+	replicaIndex, tpuWorkerID, err := t.ledger.assign(group, numOfHosts, func() (map[slice][]int, error) {
+		var err error
+		sliceToWorkerIDs, err = t.getSliceToWorkerIDs(group, numOfHosts)
+		return sliceToWorkerIDs, err
+	}, dryRun) // TPU_WORKER_ID defaults to 0 for single-host
 	if err != nil {
 		return nil, err
 	}
-	replicaIndex := getReplicaIndex(sliceToWorkerIDs, clusterName, groupName, namespace)
-	podSlice := slice{clusterName, groupName, namespace, replicaIndex, numOfHosts}
-	tpuWorkerID, err := getNextWorkerID(sliceToWorkerIDs, podSlice, namespace, replicaIndex) // defaults to 0 for single-host
-	if err != nil {
-		return nil, err
+	if explanation != nil {
+		explanation.recordAssignment(sliceToWorkerIDs, group, numOfHosts, replicaIndex, tpuWorkerID)
 	}
-	// set the unique identifier for the last admitted Pod by this TPUWebhookServer
-	t.lastAdmitted = fmt.Sprintf("%s-%s-%d-%d", namespace, clusterName, replicaIndex, tpuWorkerID)
 
 	// inject replica index label
-	injectReplicaLabel(clusterName, namespace, replicaIndex, groupName, &patches)
+	injectReplicaLabel(clusterName, namespace, replicaIndex, groupName, policy.ReplicaLabel, &patches)
 
-	if numOfHosts > 1 {
+	if (numOfHosts > 1 || multislice != nil) && !policy.skips(patchHostname) {
 		// inject hostname into pod spec for DNS records
-		hostname := fmt.Sprintf(groupName+"-%d-%d", replicaIndex, tpuWorkerID)
+		hostname := kubeRayFramework.hostname(group, replicaIndex, tpuWorkerID)
 		klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, "hostname", hostname)
 		hostnamePatch := patch{"op": "add"}
 		hostnamePatch["path"] = "/spec/hostname"
 		hostnamePatch["value"] = hostname
 		patches = append(patches, hostnamePatch)
-
-		// inject pod affinity/anti-affinity for scheduling
-		injectPodAffinity(pod, replicaIndex, groupName, &patches)
+	}
+	if numOfHosts > 1 && !policy.skips(patchPodAffinity) {
+		// inject pod affinity for scheduling
+		injectPodAffinity(pod, replicaIndex, groupName, policy, &patches)
+	}
+	if (numOfHosts > 1 || multislice != nil) && !policy.skips(patchSubdomain) {
+		// multi-host Pods need the subdomain for TPU_WORKER_HOSTNAMES, single-host Pods need it
+		// for the DNS record of the multislice coordinator
+		klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, "subdomain", subdomain)
+		subdomainPatch := patch{"op": "add"}
+		subdomainPatch["path"] = "/spec/subdomain"
+		subdomainPatch["value"] = subdomain
+		patches = append(patches, subdomainPatch)
 	}
 
 	// inject all environment variables into the container requesting TPUs
 	for i := 0; i < len(containers); i++ {
 		container := containers[i]
 		if containerRequestingTPUs(container) {
-			path := fmt.Sprintf("/spec/containers/%d/env", i)
-			if numOfHosts > 1 {
+			var envVars []corev1.EnvVar
+			if numOfHosts > 1 && !policy.skips(patchWorkerHostnames) && getEnvironmentVariable(policy.Env.WorkerHostnames, container) == "" {
 				// inject TPU_WORKER_HOSTNAMES
-				hostnames, err := genDNSHostnames(numOfHosts, groupName, clusterName, namespace, replicaIndex)
+				hostnames, err := kubeRayFramework.hostnames(group, numOfHosts, replicaIndex, subdomain)
 				if err != nil {
 					return nil, err
 				}
-				klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, "TPU_WORKER_HOSTNAMES", hostnames)
-				klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, "subdomain", generateHeadlessServiceName(clusterName))
-				injectHostnames(clusterName, hostnames, path, container, &patches)
+				klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, policy.Env.WorkerHostnames, hostnames)
+				envVars = append(envVars, corev1.EnvVar{Name: policy.Env.WorkerHostnames, Value: hostnames})
 			}
 			// inject TPU_WORKER_ID
-			if getEnvironmentVariable("TPU_WORKER_ID", container) == "" {
-				klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, "TPU_WORKER_ID", tpuWorkerID, "Replica Index", replicaIndex)
-				workerID := corev1.EnvVar{
-					Name:  "TPU_WORKER_ID",
-					Value: fmt.Sprint(tpuWorkerID),
-				}
-				idPatch := patch{"op": "add"}
-				// create new EnvVar array if container.Env is empty, and append new EnvVars if not
-				if len(container.Env) == 0 {
-					idPatch["path"] = path
-					idPatch["value"] = []corev1.EnvVar{workerID}
-				} else {
-					idPatch["path"] = fmt.Sprintf("%s/-", path)
-					idPatch["value"] = workerID
-				}
-				patches = append(patches, idPatch)
-			}
+			klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, policy.Env.WorkerID, tpuWorkerID, "Replica Index", replicaIndex)
+			envVars = append(envVars, corev1.EnvVar{Name: policy.Env.WorkerID, Value: fmt.Sprint(tpuWorkerID)})
 			// inject TPU_NAME
-			if getEnvironmentVariable("TPU_NAME", container) == "" {
-				tpuNameValue := fmt.Sprintf("%s-%d", groupName, replicaIndex)
-				klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, "TPU_NAME", tpuNameValue, "Replica Index", replicaIndex)
-				tpuName := corev1.EnvVar{
-					Name:  "TPU_NAME",
-					Value: tpuNameValue,
-				}
-				namePatch := patch{"op": "add"}
-				// create new EnvVar array if container.Env is empty, and append new EnvVars if not
-				if len(container.Env) == 0 {
-					namePatch["path"] = path
-					namePatch["value"] = []corev1.EnvVar{tpuName}
-				} else {
-					namePatch["path"] = fmt.Sprintf("%s/-", path)
-					namePatch["value"] = tpuName
-				}
-				patches = append(patches, namePatch)
+			if !policy.skips(patchTPUName) {
+				tpuName := kubeRayFramework.sliceName(group, replicaIndex)
+				klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, policy.Env.Name, tpuName, "Replica Index", replicaIndex)
+				envVars = append(envVars, corev1.EnvVar{Name: policy.Env.Name, Value: tpuName})
 			}
+			// inject the extra environment variables of the policy
+			envVars = append(envVars, policy.extraEnv(accelerator, topology, chipsPerHost, numOfHosts, replicaIndex)...)
+			// inject MEGASCALE_* for multislice
+			if multislice != nil {
+				klog.V(1).InfoS("mutatePod", "RayCluster", namespace+"/"+clusterName, "MEGASCALE_SLICE_ID", replicaIndex, "MEGASCALE_NUM_SLICES", multislice.numSlices)
+				envVars = append(envVars, multislice.env(replicaIndex)...)
+			}
+			injectEnv(fmt.Sprintf("/spec/containers/%d/env", i), container, &patches, envVars...)
 		}
 	}
 
+	if err := setPatches(admissionResponse, patches); err != nil {
+		return nil, err
+	}
+	return admissionResponse, nil
+}
+
+// setPatches sets the JSON patches of an Admission Response
+func setPatches(admissionResponse *admissionv1.AdmissionResponse, patches []patch) error {
 	patchBytes, err := json.Marshal(patches)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	admissionResponse.Patch = patchBytes
@@ -742,7 +956,7 @@ func (t *TPUWebhookServer) mutatePod(admissionReview *admissionv1.AdmissionRevie
 		pt := admissionv1.PatchTypeJSONPatch
 		return &pt
 	}()
-	return admissionResponse, nil
+	return nil
 }
 
 func writeCertfile(filename string, encodedData string) error {
@@ -756,114 +970,130 @@ func writeCertfile(filename string, encodedData string) error {
 
 func init() {
 	flag.StringVar(&BindAddr, "bind-address", ":443", "Address to bind HTTPS service to")
-	flag.StringVar(&CACert, "ca-cert", "", "base64-encoded root certificate for TLS")
+	flag.StringVar(&CACert, "ca-cert", "", "base64-encoded root certificate for TLS, injected into the webhook configurations with --inject-ca-bundle")
 	flag.StringVar(&ServerCert, "server-cert", "", "base64-encoded server certificate for TLS")
 	flag.StringVar(&ServerKey, "server-key", "", "base64-encoded server key for TLS")
 	flag.StringVar(&KubeConfigPath, "kube-config-path", "", "Kubernetes config path for k8s client")
+	flag.DurationVar(&ReservationTTL, "reservation-ttl", time.Minute, "How long a TPU_WORKER_ID stays reserved for an admitted Pod that is not in the PodInformer cache")
+	flag.StringVar(&CertDir, "cert-dir", "/etc/kuberay-tpu-webhook/tls", "Directory of the tls.crt and tls.key serving certificate files")
+	flag.DurationVar(&CertReloadInterval, "cert-reload-interval", 10*time.Second, "How often the serving certificate files are checked for changes")
+	flag.StringVar(&TLSMinVersion, "tls-min-version", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVar(&TLSCipherSuites, "tls-cipher-suites", "", "Comma-separated TLS 1.2 cipher suites, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. Go defaults if empty")
+	flag.BoolVar(&SelfSignedCerts, "self-signed-certs", false, "Issue the serving certificate from a self-signed CA stored in --self-signed-secret, and inject the CA into the webhook configurations")
+	flag.StringVar(&SelfSignedSecret, "self-signed-secret", "kuberay-tpu-webhook-self-signed-certs", "Secret storing the self-signed CA and serving certificate")
+	flag.BoolVar(&InjectCABundle, "inject-ca-bundle", false, "Inject --ca-cert into the caBundle of the webhook configurations")
+	flag.StringVar(&WebhookNamespace, "webhook-namespace", "ray-system", "Namespace of the webhook Service and self-signed certificate Secret")
+	flag.StringVar(&WebhookService, "webhook-service", "kuberay-tpu-webhook", "Name of the webhook Service the serving certificate is issued for")
+	flag.StringVar(&MutatingWebhookConfig, "mutating-webhook-config", "kuberay-tpu-mutating-webhook-cfg", "MutatingWebhookConfiguration to inject the caBundle into")
+	flag.StringVar(&ValidatingWebhookConfig, "validating-webhook-config", "kuberay-tpu-validating-webhook-cfg", "ValidatingWebhookConfiguration to inject the caBundle into")
+	flag.DurationVar(&ShutdownDelay, "shutdown-delay", 5*time.Second, "How long the webhook reports not ready on SIGTERM before it stops accepting connections")
+	flag.DurationVar(&ShutdownTimeout, "shutdown-timeout", 20*time.Second, "How long in-flight admissions are drained on SIGTERM, shutdown-delay and shutdown-timeout should add up to less than the Pod's terminationGracePeriodSeconds")
+	flag.BoolVar(&SharedLedger, "shared-ledger", false, "Reserve TPU_WORKER_IDs in ConfigMaps in --webhook-namespace, required to run more than one webhook replica")
+	flag.StringVar(&InjectionPolicyFile, "injection-policy", "", "YAML or JSON file of the injection policy, overlaid on the default policy")
+	flag.StringVar(&InjectionPolicyConfigMap, "injection-policy-configmap", "", "ConfigMap in --webhook-namespace whose policy.yaml is overlaid on the injection policy, watched for changes")
+	flag.StringVar(&HeadlessServices, "headless-services", headlessServiceOff, "Whether the headless Services of RayClusters with multi-host TPU worker groups are verified, with events on the RayCluster, or created if missing: off, verify or create")
+	flag.DurationVar(&HeadlessServiceGracePeriod, "headless-service-grace-period", 30*time.Second, "How long after a RayCluster's creation its headless Services are left to KubeRay before they are verified or created")
+	flag.StringVar(&Frameworks, "frameworks", "leaderworkerset,statefulset", "Comma-separated built-in multi-host framework presets served besides KubeRay: leaderworkerset, statefulset")
 
 	// set klog verbosity level
 	klog.InitFlags(nil)
 }
 
-// isLastAdmittedPod returns True if Pod matches the last Pod admitted by the webhook server
-func (t *TPUWebhookServer) isLastAdmittedPod(pod *corev1.Pod) (bool, error) {
-	if pod.Spec.Containers == nil || !containerRequestingTPUs(pod.Spec.Containers...) {
-		// Pod does not use TPUs
-		return false, nil
-	}
-	replicaIndex := pod.Labels["replicaIndex"]
-	if replicaIndex == "" {
-		// Pod was not mutated by the webhook
-		return false, nil
-	}
-	clusterName := pod.Labels["ray.io/cluster"]
-	if clusterName == "" {
-		return false, errors.New("Ray Pod created by KubeRay missing RayCluster label")
-	}
-	namespace := pod.Namespace
-	for _, container := range pod.Spec.Containers {
-		if !containerRequestingTPUs(container) {
-			// Skip to the next container
-			continue
-		}
-		tpuWorkerID := getEnvironmentVariable("TPU_WORKER_ID", container)
-		if tpuWorkerID == "" {
-			// TPU pod was not intercepted by the webhook
-			return false, nil
-		}
-		uniquePodID := fmt.Sprintf("%s-%s-%s-%s", namespace, clusterName, replicaIndex, tpuWorkerID)
-		if uniquePodID == t.lastAdmitted {
-			// Pod matches the last TPU worker Pod intercepted by the webhook server
-			return true, nil
-		}
-	}
-	return false, nil
-}
-
-// addPod allows next goroutine to start once the webhook PodInformer cache updates
+// addPod releases the TPU_WORKER_ID reserved for a Pod once it is in the PodInformer cache
 func (t *TPUWebhookServer) addPod(obj interface{}) {
-	pod := obj.(*corev1.Pod)
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
 	klog.V(1).InfoS("addPod", "Pod", pod.Namespace+"/"+pod.Name, "Time", time.Now())
+	t.observePod(pod)
+}
 
-	if t.lastAdmitted == "" {
-		// There is not a pending TPU worker Pod to the informer cache, unblock if waiting and return
-		for t.waiting > 0 {
-			t.wg.Done()
-			t.waiting -= 1
-		}
+// updatePod releases the TPU_WORKER_ID reserved for a Pod that is first observed through an update,
+// for example after the PodInformer relisted
+func (t *TPUWebhookServer) updatePod(oldObj interface{}, newObj interface{}) {
+	pod, ok := newObj.(*corev1.Pod)
+	if !ok {
 		return
 	}
-	if t.waiting == 0 {
-		// Webhook is not waiting, no-op
+	klog.V(1).InfoS("updatePod", "Pod", pod.Namespace+"/"+pod.Name, "Time", time.Now())
+	t.observePod(pod)
+}
+
+// deletePod logs the TPU_WORKER_ID freed by a deleted Pod. It does not release any reservation in
+// the ledger: a reservation for the same TPU_WORKER_ID belongs to the Pod replacing it.
+func (t *TPUWebhookServer) deletePod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
 		return
 	}
-	// Check if Pod in cache is the last admitted TPU Pod
-	isLastAdmitted, err := t.isLastAdmittedPod(pod)
-	if err != nil {
-		klog.Errorf("Invalid addPod: %s", err)
+	klog.V(1).InfoS("deletePod", "Pod", pod.Namespace+"/"+pod.Name, "Time", time.Now())
+
+	if group, assignment, ok := podWorkerAssignment(pod, t.policies.get()); ok {
+		klog.V(1).InfoS("deletePod", "RayCluster", group.namespace+"/"+group.clusterName, "Worker Group", group.groupName,
+			"Replica Index", assignment.replicaIndex, "TPU_WORKER_ID", assignment.workerID, "message", "TPU_WORKER_ID freed for a replacement Pod")
+	}
+}
+
+// observePod releases the reservation of an active Pod's TPU_WORKER_ID, which is now part of the
+// PodInformer cache. Nothing is released for Pods that are being deleted, since their TPU_WORKER_ID
+// may already be reserved for the replacement Pod.
+func (t *TPUWebhookServer) observePod(pod *corev1.Pod) {
+	if !isPodActive(pod) {
 		return
 	}
-	if isLastAdmitted {
-		// Informer cache has been updated, unblock the next Mutate call
-		t.wg.Done()
-		t.waiting -= 1
+	if group, assignment, ok := podWorkerAssignment(pod, t.policies.get()); ok {
+		t.ledger.release(group, assignment, pod.UID)
 	}
 }
 
 func main() {
 	flag.Parse()
+	certPath := filepath.Join(CertDir, corev1.TLSCertKey)
+	keyPath := filepath.Join(CertDir, corev1.TLSPrivateKeyKey)
+	// fail fast on invalid TLS flags, the certificate is set once it is loaded
+	if _, err := newTLSConfig(TLSMinVersion, TLSCipherSuites, nil); err != nil {
+		klog.Fatalf("TLS configuration: %v", err)
+	}
+	frameworks, err := parseFrameworks(Frameworks)
+	if err != nil {
+		klog.Fatalf("Multi-host frameworks: %v", err)
+	}
+	basePolicy, err := loadPolicyFile(InjectionPolicyFile)
+	if err != nil {
+		klog.Fatalf("Injection policy %s: %v", InjectionPolicyFile, err)
+	}
+	policies := newPolicyStore(basePolicy)
+	if HeadlessServices != headlessServiceOff && HeadlessServices != headlessServiceVerify && HeadlessServices != headlessServiceCreate {
+		klog.Fatalf("Invalid --headless-services %q, expected off, verify or create", HeadlessServices)
+	}
 
 	// use in-cluster config if kubeConfig path is not passed as a flag
-	var client *kubernetes.Clientset
+	var config *rest.Config
 	if KubeConfigPath == "" {
-		config, err := rest.InClusterConfig()
-		if err != nil {
-			panic(err)
-		}
-		client = kubernetes.NewForConfigOrDie(config)
+		config, err = rest.InClusterConfig()
 	} else {
-		config, err := clientcmd.BuildConfigFromFlags("", KubeConfigPath)
-		if err != nil {
-			panic(err)
-		}
-		client = kubernetes.NewForConfigOrDie(config)
+		config, err = clientcmd.BuildConfigFromFlags("", KubeConfigPath)
+	}
+	if err != nil {
+		panic(err)
 	}
+	client := kubernetes.NewForConfigOrDie(config)
 
-	// instantiate PodInformer for Ray worker pods in the GKE cluster
+	// instantiate PodInformer for Ray worker pods in the GKE cluster, the Pods of other multi-host
+	// frameworks don't need to be cached since their TPU_WORKER_IDs are set by the framework
 	tweakListOptionsFunc := func(options *metav1.ListOptions) {
 		options.LabelSelector = "ray.io/node-type=worker,app.kubernetes.io/created-by=kuberay-operator"
 	}
 	factory := informers.NewFilteredSharedInformerFactory(client, 1*time.Minute, metav1.NamespaceAll, tweakListOptionsFunc)
 	podInformer := factory.Core().V1().Pods().Informer()
 
-	// start the PodInformer and wait for cache sync
+	// start the PodInformer, the webhook reports ready once its cache has synced
 	stopCh := make(chan struct{})
 	factory.Start(stopCh)
-	factory.WaitForCacheSync(stopCh)
-
-	if !cache.WaitForCacheSync(stopCh, podInformer.HasSynced) {
-		klog.Fatal("Timed out waiting for PodInformer to sync")
-	}
 
 	podLister := factory.Core().V1().Pods().Lister()
 
@@ -871,15 +1101,57 @@ func main() {
 		klog.Fatal("Failed to initialize Pod Lister")
 	}
 
+	// instantiate RayClusterInformer for multislice configuration of worker groups
+	rayFactory := rayinformers.NewSharedInformerFactory(rayclient.NewForConfigOrDie(config), 1*time.Minute)
+	rayClusterInformer := rayFactory.Ray().V1().RayClusters().Informer()
+	rayFactory.Start(stopCh)
+	rayClusterLister := rayFactory.Ray().V1().RayClusters().Lister()
+
 	// close the PodInformer on exit
 	defer close(stopCh)
 
-	tpuWebhookServer := NewTPUWebhookServer(podLister)
+	tpuWebhookServer := NewTPUWebhookServer(podLister, rayClusterLister)
+	tpuWebhookServer.frameworks = frameworks
+	tpuWebhookServer.policies = policies
+	if SharedLedger {
+		sharedLedger := newSharedWorkerLedger(client, WebhookNamespace, podLister, ReservationTTL)
+		sharedLedger.policies = policies
+		tpuWebhookServer.ledger = sharedLedger
+		// delete the ledgers of deleted worker groups
+		go wait.Until(func() {
+			if err := sharedLedger.collectGarbage(context.TODO()); err != nil {
+				klog.ErrorS(err, "sharedWorkerLedger")
+			}
+		}, 10*time.Minute, stopCh)
+	}
+
+	var serviceInformer cache.SharedIndexInformer
+	if HeadlessServices != headlessServiceOff {
+		// verify the headless Services resolving TPU_WORKER_HOSTNAMES whenever a RayCluster or a
+		// Service changes
+		serviceFactory := informers.NewSharedInformerFactory(client, 0)
+		serviceInformer = serviceFactory.Core().V1().Services().Informer()
+		serviceFactory.Start(stopCh)
+		controller := newHeadlessServiceController(client, rayClusterLister, serviceFactory.Core().V1().Services().Lister(), policies, newEventRecorder(client), HeadlessServices == headlessServiceCreate, HeadlessServiceGracePeriod)
+		rayClusterInformer.AddEventHandler(controller.rayClusterEventHandler())
+		serviceInformer.AddEventHandler(controller.serviceEventHandler())
+		// only one replica verifies and creates the Services, the Pod name identifies the replica
+		identity, err := os.Hostname()
+		if err != nil {
+			klog.Fatalf("Failed to get hostname for leader election: %v", err)
+		}
+		leaderCtx, cancelLeaderElection := context.WithCancel(context.Background())
+		defer cancelLeaderElection()
+		go controller.runLeaderElection(leaderCtx, WebhookNamespace, identity)
+		go controller.run(stopCh, rayClusterInformer.HasSynced, serviceInformer.HasSynced)
+	}
 
-	// Add custom event handler for Pod creation
+	// Add custom event handlers for the Pod lifecycle
 	podInformer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
-			AddFunc: tpuWebhookServer.addPod,
+			AddFunc:    tpuWebhookServer.addPod,
+			UpdateFunc: tpuWebhookServer.updatePod,
+			DeleteFunc: tpuWebhookServer.deletePod,
 		},
 	)
 
@@ -893,12 +1165,49 @@ func main() {
 
 	mux.HandleFunc("/validate", tpuWebhookServer.Validate)
 
-	srv := &http.Server{
-		Addr:    BindAddr,
-		Handler: mux,
-	}
-
-	if ServerCert != "" && ServerKey != "" {
+	mux.HandleFunc("/explain", tpuWebhookServer.Explain)
+
+	informersSynced := map[string]cache.InformerSynced{
+		"PodInformer":        podInformer.HasSynced,
+		"RayClusterInformer": rayClusterInformer.HasSynced,
+	}
+	if serviceInformer != nil {
+		informersSynced["ServiceInformer"] = serviceInformer.HasSynced
+	}
+	if InjectionPolicyConfigMap != "" {
+		// the webhook reports ready once the policy ConfigMap, if it exists, has been applied
+		informersSynced["PolicyInformer"] = policies.watch(client, WebhookNamespace, InjectionPolicyConfigMap, stopCh)
+	}
+	health := newHealthChecker(informersSynced)
+	mux.HandleFunc("/healthz", health.Healthz)
+	mux.HandleFunc("/readyz", health.Readyz)
+
+	mux.Handle("/metrics", promhttp.HandlerFor(newMetricsRegistry(podLister, policies), promhttp.HandlerOpts{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	switch {
+	case SelfSignedCerts:
+		bootstrapper := &certBootstrapper{
+			client:                  client,
+			namespace:               WebhookNamespace,
+			secretName:              SelfSignedSecret,
+			serviceName:             WebhookService,
+			certDir:                 CertDir,
+			mutatingWebhookConfig:   MutatingWebhookConfig,
+			validatingWebhookConfig: ValidatingWebhookConfig,
+			now:                     time.Now,
+		}
+		if err := bootstrapper.reconcile(ctx); err != nil {
+			klog.Fatalf("bootstrap self-signed certificates: %v", err)
+		}
+		// renew the certificates before they expire, and pick up renewals of other replicas
+		go wait.Until(func() {
+			if err := bootstrapper.reconcile(ctx); err != nil {
+				klog.ErrorS(err, "certBootstrapper")
+			}
+		}, time.Hour, stopCh)
+	case ServerCert != "" && ServerKey != "":
 		if err := writeCertfile(certPath, ServerCert); err != nil {
 			klog.Fatalf("write server cert: %v", err)
 		}
@@ -906,12 +1215,35 @@ func main() {
 			klog.Fatalf("write server key: %v", err)
 		}
 	}
-
-	if err := srv.ListenAndServeTLS(certPath, keyPath); err != nil {
-		if err == http.ErrServerClosed {
-			klog.V(0).Info("Server closed")
-			return
+	if InjectCABundle && !SelfSignedCerts {
+		caBundle, err := base64.StdEncoding.DecodeString(CACert)
+		if err != nil || len(caBundle) == 0 {
+			klog.Fatalf("--inject-ca-bundle requires a base64-encoded --ca-cert")
+		}
+		if err := injectCABundle(ctx, client, MutatingWebhookConfig, ValidatingWebhookConfig, caBundle); err != nil {
+			klog.Fatalf("%v", err)
 		}
-		klog.Error("Failed to start server")
 	}
+
+	reloader, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		klog.Fatalf("load serving certificate: %v", err)
+	}
+	go reloader.watch(CertReloadInterval, stopCh)
+	tlsConfig, err := newTLSConfig(TLSMinVersion, TLSCipherSuites, reloader.GetCertificate)
+	if err != nil {
+		klog.Fatalf("TLS configuration: %v", err)
+	}
+
+	srv := &http.Server{
+		Addr:      BindAddr,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	if err := serveUntilTerminated(srv, health, ShutdownDelay, ShutdownTimeout); err != nil {
+		klog.ErrorS(err, "Failed to serve")
+		return
+	}
+	klog.V(0).Info("Server closed")
 }
@@ -0,0 +1,145 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const metricsNamespace = "kuberay_tpu_webhook"
+
+// admission outcomes
+const (
+	outcomeAllowed = "allowed"
+	// the request was rejected, e.g. a RayCluster with invalid worker groups
+	outcomeDenied = "denied"
+	// the webhook failed to process the request and rejected it
+	outcomeError = "error"
+	// the AdmissionReview could not be decoded or is of the wrong kind
+	outcomeInvalid = "invalid"
+)
+
+var (
+	admissionRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_requests_total",
+		Help:      "Admission requests handled by the webhook, by kind, operation and outcome.",
+	}, []string{"kind", "operation", "outcome"})
+	admissionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_duration_seconds",
+		Help:      "Latency of admission requests handled by the webhook, by kind and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"kind", "operation"})
+	reservationsExpired = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "worker_reservations_expired_total",
+		Help:      "TPU_WORKER_ID reservations that expired before their Pod showed up in the PodInformer cache.",
+	})
+	workerIDConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "worker_id_conflicts_total",
+		Help:      "TPU_WORKER_ID assignments that failed because multiple workers of a slice have the same TPU_WORKER_ID.",
+	})
+)
+
+// admissionOutcome returns the outcome of an admission request handled by the webhook
+func admissionOutcome(response *admissionv1.AdmissionResponse, err error) string {
+	switch {
+	case err != nil:
+		return outcomeError
+	case response == nil || !response.Allowed:
+		return outcomeDenied
+	}
+	return outcomeAllowed
+}
+
+// observeAdmission records the outcome and latency of an admission request
+func observeAdmission(kind string, operation admissionv1.Operation, outcome string, start time.Time) {
+	admissionRequests.WithLabelValues(kind, string(operation), outcome).Inc()
+	admissionDuration.WithLabelValues(kind, string(operation)).Observe(time.Since(start).Seconds())
+}
+
+// sliceCollector reports the number of TPU slices (worker group replicas) of every RayCluster,
+// computed from the PodInformer cache at scrape time.
+type sliceCollector struct {
+	podLister listersv1.PodLister
+	policies  *policyStore
+	slices    *prometheus.Desc
+}
+
+func newSliceCollector(podLister listersv1.PodLister, policies *policyStore) *sliceCollector {
+	return &sliceCollector{
+		podLister: podLister,
+		policies:  policies,
+		slices: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "slices"),
+			"TPU slices (worker group replicas) with at least one active TPU worker, by RayCluster and worker group.",
+			[]string{"namespace", "raycluster", "worker_group"}, nil),
+	}
+}
+
+func (c *sliceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.slices
+}
+
+func (c *sliceCollector) Collect(ch chan<- prometheus.Metric) {
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "sliceCollector")
+		return
+	}
+	replicas := make(map[workerGroup]map[int]bool)
+	policy := c.policies.get()
+	for _, pod := range pods {
+		if !isPodActive(pod) {
+			continue
+		}
+		group, assignment, ok := podWorkerAssignment(pod, policy)
+		if !ok {
+			continue
+		}
+		if replicas[group] == nil {
+			replicas[group] = make(map[int]bool)
+		}
+		replicas[group][assignment.replicaIndex] = true
+	}
+	for group, replicaIndices := range replicas {
+		ch <- prometheus.MustNewConstMetric(c.slices, prometheus.GaugeValue, float64(len(replicaIndices)), group.namespace, group.clusterName, group.groupName)
+	}
+}
+
+// newMetricsRegistry returns the registry of the webhook's /metrics endpoint
+func newMetricsRegistry(podLister listersv1.PodLister, policies *policyStore) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		admissionRequests,
+		admissionDuration,
+		reservationsExpired,
+		workerIDConflicts,
+		newSliceCollector(podLister, policies),
+	)
+	return registry
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_AdmissionMetrics(t *testing.T) {
+	tests := map[string]struct {
+		handler         func(*TPUWebhookServer) http.HandlerFunc
+		kind            string
+		operation       string
+		object          any
+		body            string
+		expectedKind    string
+		expectedOp      string
+		expectedOutcome string
+	}{
+		"mutated Pod": {
+			handler:         func(t *TPUWebhookServer) http.HandlerFunc { return t.Mutate },
+			kind:            "Pod",
+			operation:       "CREATE",
+			object:          getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x1", "4"),
+			expectedKind:    "Pod",
+			expectedOp:      "CREATE",
+			expectedOutcome: outcomeAllowed,
+		},
+		"Pod that can't be mutated": {
+			// 8 chips exceed the 4-chip tpu-v4-podslice hosts
+			handler:         func(t *TPUWebhookServer) http.HandlerFunc { return t.Mutate },
+			kind:            "Pod",
+			operation:       "CREATE",
+			object:          getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x2", "8"),
+			expectedKind:    "Pod",
+			expectedOp:      "CREATE",
+			expectedOutcome: outcomeError,
+		},
+		"rejected RayCluster": {
+			handler:         func(t *TPUWebhookServer) http.HandlerFunc { return t.Validate },
+			kind:            "RayCluster",
+			operation:       "CREATE",
+			object:          getTestRayCluster("test-cluster", "test-group", "test-namespace", 1, 1, "4", "tpu-v4-podslice", "2x2x4", false),
+			expectedKind:    "RayCluster",
+			expectedOp:      "CREATE",
+			expectedOutcome: outcomeDenied,
+		},
+		"malformed AdmissionReview": {
+			handler:         func(t *TPUWebhookServer) http.HandlerFunc { return t.Validate },
+			body:            "{",
+			expectedKind:    "RayCluster",
+			expectedOutcome: outcomeInvalid,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tpuWebhookServer := NewTPUWebhookServer(setupInformer(), nil)
+			server := httptest.NewServer(tc.handler(tpuWebhookServer))
+			defer server.Close()
+
+			body := []byte(tc.body)
+			if tc.object != nil {
+				admissionReview := getTestAdmissionReview(tc.kind, tc.operation)
+				admissionReview.Request.Object.Raw, _ = json.Marshal(tc.object)
+				body, _ = json.Marshal(admissionReview)
+			}
+			requests := admissionRequests.WithLabelValues(tc.expectedKind, tc.expectedOp, tc.expectedOutcome)
+			before := testutil.ToFloat64(requests)
+			res, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+			assert.Nil(t, err)
+			res.Body.Close()
+
+			assert.Equal(t, before+1, testutil.ToFloat64(requests))
+		})
+	}
+}
+
+func Test_WorkerReservationMetrics(t *testing.T) {
+	group := workerGroup{"test-namespace", "test-cluster", "test-group"}
+	now := time.Now()
+	ledger := newWorkerLedger(time.Minute)
+	ledger.now = func() time.Time { return now }
+	listSlices := func() (map[slice][]int, error) { return make(map[slice][]int), nil }
+
+	_, _, err := ledger.assign(group, 4, listSlices, false)
+	assert.Nil(t, err)
+	before := testutil.ToFloat64(reservationsExpired)
+	now = now.Add(2 * time.Minute)
+	_, _, err = ledger.assign(group, 4, listSlices, false)
+	assert.Nil(t, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(reservationsExpired))
+
+	before = testutil.ToFloat64(workerIDConflicts)
+	podSlice := slice{"test-cluster", "test-group", "test-namespace", 0, 4}
+	_, err = getNextWorkerID(map[slice][]int{podSlice: {0, 1, 1}}, podSlice, "test-namespace", 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(workerIDConflicts))
+}
+
+func Test_SliceCollector(t *testing.T) {
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+	pods := getTestInterceptedTPUPods(testPod, 9, 3, 4)
+	// replica 2 only has a terminating worker
+	pods[8].DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	otherPod := getTestTPUWorker("other-cluster", "other-group", "test-namespace", "tpu-v4-podslice", "2x2x1", "4")
+	otherPod = getTestInterceptedTPUPods(otherPod, 1, 1, 1)[0]
+	otherPod.Name = "other-tpu-pod"
+	pods = append(pods, otherPod)
+	// Pods that were not mutated by the webhook are ignored
+	pods = append(pods, getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4"))
+	pods[len(pods)-1].Name = "pending-tpu-pod"
+
+	expected := `
+# HELP kuberay_tpu_webhook_slices TPU slices (worker group replicas) with at least one active TPU worker, by RayCluster and worker group.
+# TYPE kuberay_tpu_webhook_slices gauge
+kuberay_tpu_webhook_slices{namespace="test-namespace",raycluster="other-cluster",worker_group="other-group"} 1
+kuberay_tpu_webhook_slices{namespace="test-namespace",raycluster="test-cluster",worker_group="test-group"} 2
+`
+	err := testutil.CollectAndCompare(newSliceCollector(setupInformer(pods...), newPolicyStore(defaultInjectionPolicy())), strings.NewReader(expected))
+	assert.Nil(t, err)
+}
+
+func Test_AdmissionOutcome(t *testing.T) {
+	assert.Equal(t, outcomeAllowed, admissionOutcome(&admissionv1.AdmissionResponse{Allowed: true}, nil))
+	assert.Equal(t, outcomeDenied, admissionOutcome(&admissionv1.AdmissionResponse{Allowed: false}, nil))
+	assert.Equal(t, outcomeError, admissionOutcome(nil, assert.AnError))
+}
@@ -0,0 +1,141 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// multisliceAnnotation enables MEGASCALE_* environment injection when set to "true" on a RayCluster
+	// or on a worker group's Pod template, which takes precedence.
+	multisliceAnnotation = "kuberay-tpu-webhook/multislice"
+	// multislicePortAnnotation overrides the MEGASCALE_PORT, on a RayCluster or worker group's Pod template.
+	multislicePortAnnotation = "kuberay-tpu-webhook/megascale-port"
+
+	defaultMegascalePort = "8080"
+)
+
+// multisliceConfig is the multislice (DCN) configuration of a worker group, where every replica
+// of the worker group is one slice.
+type multisliceConfig struct {
+	coordinatorAddress string
+	numSlices          int32
+	port               string
+}
+
+// getAnnotation returns the value of a worker group annotation, which KubeRay copies from the
+// worker group's Pod template to the Pod, falling back to the RayCluster annotation.
+func getAnnotation(key string, podAnnotations map[string]string, clusterAnnotations map[string]string) (string, bool) {
+	if value, ok := podAnnotations[key]; ok {
+		return value, true
+	}
+	value, ok := clusterAnnotations[key]
+	return value, ok
+}
+
+// getMultisliceConfig returns the multislice configuration for a TPU worker Pod, or nil if
+// MEGASCALE_* environment injection is not enabled for its worker group
+func (t *TPUWebhookServer) getMultisliceConfig(pod *corev1.Pod, clusterName string, groupName string, namespace string, numOfHosts int32, subdomain string) (*multisliceConfig, error) {
+	// the worker group annotation takes precedence, so the RayCluster isn't needed if it disables
+	// multislice, and a RayCluster that isn't cached (yet) only fails Pods that enable it
+	podEnabled, podAnnotated := pod.Annotations[multisliceAnnotation]
+	if podAnnotated {
+		isEnabled, err := strconv.ParseBool(podEnabled)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", multisliceAnnotation, podEnabled, err)
+		}
+		if !isEnabled {
+			return nil, nil
+		}
+	}
+	if t.rayClusterLister == nil {
+		if podAnnotated {
+			return nil, errors.New("RayCluster lister required for multislice environment injection")
+		}
+		return nil, nil
+	}
+	rayCluster, err := t.rayClusterLister.RayClusters(namespace).Get(clusterName)
+	if apierrors.IsNotFound(err) && !podAnnotated {
+		klog.V(1).InfoS("getMultisliceConfig", "RayCluster", namespace+"/"+clusterName, "message", "RayCluster not found, multislice disabled")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, ok := getAnnotation(multisliceAnnotation, pod.Annotations, rayCluster.Annotations)
+	if !ok {
+		return nil, nil
+	}
+	isEnabled, err := strconv.ParseBool(enabled)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", multisliceAnnotation, enabled, err)
+	}
+	if !isEnabled {
+		return nil, nil
+	}
+
+	port := defaultMegascalePort
+	if value, ok := getAnnotation(multislicePortAnnotation, pod.Annotations, rayCluster.Annotations); ok {
+		if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", multislicePortAnnotation, value, err)
+		}
+		port = value
+	}
+
+	numSlices := int32(-1)
+	for _, workerGroupSpec := range rayCluster.Spec.WorkerGroupSpecs {
+		if workerGroupSpec.GroupName == groupName && workerGroupSpec.Replicas != nil {
+			numSlices = *workerGroupSpec.Replicas
+		}
+	}
+	if numSlices < 0 {
+		return nil, fmt.Errorf("worker group %s missing replicas in RayCluster %s", groupName, namespace+"/"+clusterName)
+	}
+
+	// the coordinator is worker 0 of replica 0
+	hostnames, err := kubeRayFramework.hostnames(workerGroup{namespace, clusterName, groupName}, numOfHosts, 0, subdomain)
+	if err != nil {
+		return nil, err
+	}
+	coordinatorAddress := strings.Split(hostnames, ",")[0]
+
+	klog.V(1).InfoS("getMultisliceConfig", "RayCluster", namespace+"/"+clusterName, "Worker Group", groupName, "MEGASCALE_COORDINATOR_ADDRESS", coordinatorAddress, "MEGASCALE_NUM_SLICES", numSlices, "MEGASCALE_PORT", port)
+	return &multisliceConfig{
+		coordinatorAddress: coordinatorAddress,
+		numSlices:          numSlices,
+		port:               port,
+	}, nil
+}
+
+// env returns the MEGASCALE_* environment variables of the workers of a slice
+func (config *multisliceConfig) env(replicaIndex int) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{Name: "MEGASCALE_COORDINATOR_ADDRESS", Value: config.coordinatorAddress},
+		{Name: "MEGASCALE_NUM_SLICES", Value: fmt.Sprint(config.numSlices)},
+		{Name: "MEGASCALE_SLICE_ID", Value: fmt.Sprint(replicaIndex)},
+		{Name: "MEGASCALE_PORT", Value: config.port},
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	raylisters "github.com/ray-project/kuberay/ray-operator/pkg/client/listers/ray/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// setupRayClusterLister returns a RayClusterLister serving the given RayClusters
+func setupRayClusterLister(rayClusters ...*rayv1.RayCluster) raylisters.RayClusterLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, rayCluster := range rayClusters {
+		indexer.Add(rayCluster)
+	}
+	return raylisters.NewRayClusterLister(indexer)
+}
+
+func Test_GetMultisliceConfig(t *testing.T) {
+	coordinator := fmt.Sprintf("%s-%d-%d.%s-%s", "test-group", 0, 0, "test-cluster", headlessServiceSuffix)
+
+	tests := map[string]struct {
+		clusterAnnotations map[string]string
+		podAnnotations     map[string]string
+		missingRayCluster  bool
+		expectedConfig     *multisliceConfig
+		expectedError      bool
+	}{
+		"no multislice annotation": {
+			// MEGASCALE_* injection is opt-in
+			expectedConfig: nil,
+		},
+		"RayCluster annotation": {
+			// applies to every worker group of the RayCluster
+			clusterAnnotations: map[string]string{multisliceAnnotation: "true"},
+			expectedConfig:     &multisliceConfig{coordinator, 3, defaultMegascalePort},
+		},
+		"worker group annotation": {
+			// worker group annotation takes precedence over the RayCluster annotation
+			clusterAnnotations: map[string]string{multisliceAnnotation: "true", multislicePortAnnotation: "9000"},
+			podAnnotations:     map[string]string{multisliceAnnotation: "false"},
+			expectedConfig:     nil,
+		},
+		"worker group port annotation": {
+			clusterAnnotations: map[string]string{multisliceAnnotation: "true", multislicePortAnnotation: "9000"},
+			podAnnotations:     map[string]string{multislicePortAnnotation: "9001"},
+			expectedConfig:     &multisliceConfig{coordinator, 3, "9001"},
+		},
+		"invalid multislice annotation": {
+			podAnnotations: map[string]string{multisliceAnnotation: "yes please"},
+			expectedError:  true,
+		},
+		"invalid port annotation": {
+			podAnnotations: map[string]string{multisliceAnnotation: "true", multislicePortAnnotation: "80800"},
+			expectedError:  true,
+		},
+		"RayCluster not found": {
+			podAnnotations:    map[string]string{multisliceAnnotation: "true"},
+			missingRayCluster: true,
+			expectedError:     true,
+		},
+		"RayCluster not found without multislice annotation": {
+			// a lagging RayCluster cache doesn't deny single-slice Pods
+			missingRayCluster: true,
+			expectedConfig:    nil,
+		},
+		"RayCluster not found with multislice disabled": {
+			podAnnotations:    map[string]string{multisliceAnnotation: "false"},
+			missingRayCluster: true,
+			expectedConfig:    nil,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rayCluster := getTestRayCluster("test-cluster", "test-group", "test-namespace", 4, 3, "4", "tpu-v4-podslice", "2x2x4", false)
+			rayCluster.Annotations = tc.clusterAnnotations
+			rayClusterLister := setupRayClusterLister(rayCluster)
+			if tc.missingRayCluster {
+				rayClusterLister = setupRayClusterLister()
+			}
+			testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+			testPod.Annotations = tc.podAnnotations
+
+			tpuWebhookServer := NewTPUWebhookServer(setupInformer(), rayClusterLister)
+			config, err := tpuWebhookServer.getMultisliceConfig(testPod, "test-cluster", "test-group", "test-namespace", 4, generateHeadlessServiceName("test-cluster"))
+			if tc.expectedError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectedConfig, config)
+		})
+	}
+}
+
+func Test_MutatePodMultislice(t *testing.T) {
+	tests := map[string]struct {
+		topology            string
+		numOfHosts          int32
+		existingPods        int
+		existingReplicas    int
+		expectedCoordinator string
+		expectedSliceID     string
+	}{
+		"multi-host worker group": {
+			// every worker of every slice uses worker 0 of replica 0 as the coordinator
+			topology:            "2x2x4",
+			numOfHosts:          4,
+			existingPods:        5,
+			existingReplicas:    2,
+			expectedCoordinator: fmt.Sprintf("%s-%d-%d.%s-%s", "test-group", 0, 0, "test-cluster", headlessServiceSuffix),
+			expectedSliceID:     "1",
+		},
+		"single-host worker group": {
+			// single-host Pods get a hostname and subdomain so that the coordinator can be resolved
+			topology:            "2x2x1",
+			numOfHosts:          1,
+			existingPods:        2,
+			existingReplicas:    2,
+			expectedCoordinator: fmt.Sprintf("%s-%d-%d.%s-%s", "test-group", 0, 0, "test-cluster", headlessServiceSuffix),
+			expectedSliceID:     "2",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rayCluster := getTestRayCluster("test-cluster", "test-group", "test-namespace", tc.numOfHosts, 4, "4", "tpu-v4-podslice", tc.topology, false)
+			rayCluster.Annotations = map[string]string{multisliceAnnotation: "true"}
+			testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", tc.topology, "4")
+			testPod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "MEGASCALE_PORT", Value: "9000"}}
+
+			testPodLister := setupInformer(getTestInterceptedTPUPods(testPod, tc.existingPods, tc.existingReplicas, int(tc.numOfHosts))...)
+			tpuWebhookServer := NewTPUWebhookServer(testPodLister, setupRayClusterLister(rayCluster))
+
+			admissionReview := getTestAdmissionReview("Pod", "CREATE")
+			admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+			admissionResponse, err := tpuWebhookServer.mutatePod(admissionReview, nil)
+			assert.Nil(t, err)
+
+			var patches []patch
+			assert.Nil(t, json.Unmarshal(admissionResponse.Patch, &patches))
+			env := make(map[string]string)
+			paths := make(map[string]any)
+			for _, p := range patches {
+				paths[p["path"].(string)] = p["value"]
+				if value, ok := p["value"].(map[string]any); ok && p["path"] == "/spec/containers/0/env/-" {
+					env[value["name"].(string)] = value["value"].(string)
+				}
+			}
+			assert.Equal(t, tc.expectedCoordinator, env["MEGASCALE_COORDINATOR_ADDRESS"])
+			assert.Equal(t, "4", env["MEGASCALE_NUM_SLICES"])
+			assert.Equal(t, tc.expectedSliceID, env["MEGASCALE_SLICE_ID"])
+			// MEGASCALE_PORT set by the user is not overwritten
+			assert.NotContains(t, env, "MEGASCALE_PORT")
+			assert.Equal(t, fmt.Sprintf("%s-%s", "test-cluster", headlessServiceSuffix), paths["/spec/subdomain"])
+			assert.Contains(t, paths, "/spec/hostname")
+		})
+	}
+}
+
+func Test_MutatePodRayClusterNotCached(t *testing.T) {
+	// the RayCluster informer lags behind, or can't list RayClusters, single-slice Pods are admitted
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+	tpuWebhookServer := NewTPUWebhookServer(setupInformer(), setupRayClusterLister())
+
+	admissionReview := getTestAdmissionReview("Pod", "CREATE")
+	admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+	admissionResponse, err := tpuWebhookServer.mutatePod(admissionReview, nil)
+	assert.Nil(t, err)
+	assert.True(t, admissionResponse.Allowed)
+	assert.NotContains(t, string(admissionResponse.Patch), "MEGASCALE_")
+}
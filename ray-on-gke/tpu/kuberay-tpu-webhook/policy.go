@@ -0,0 +1,304 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	utils "github.com/ray-project/kuberay/ray-operator/controllers/ray/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// injectionPolicyAnnotation overrides the injection policy with a YAML or JSON policy, on a
+	// RayCluster or on a worker group's Pod template, which takes precedence
+	injectionPolicyAnnotation = "kuberay-tpu-webhook/injection-policy"
+	// injectionPolicyKey is the key of the injection policy in the policy ConfigMap
+	injectionPolicyKey = "policy.yaml"
+)
+
+// patches that can be skipped by an injection policy
+const (
+	patchHostname        = "hostname"
+	patchSubdomain       = "subdomain"
+	patchPodAffinity     = "podAffinity"
+	patchWorkerHostnames = "workerHostnames"
+	patchTPUName         = "tpuName"
+	patchMultislice      = "multislice"
+)
+
+// pod affinity modes
+const (
+	affinityRequired  = "required"
+	affinityPreferred = "preferred"
+)
+
+// sources of extra environment variables
+var extraEnvSources = []string{"accelerator", "topology", "chipsPerHost", "numOfHosts", "replicaIndex"}
+
+// injectionEnvNames are the names of the environment variables injected into TPU containers.
+type injectionEnvNames struct {
+	WorkerID        string `json:"workerID,omitempty"`
+	WorkerHostnames string `json:"workerHostnames,omitempty"`
+	Name            string `json:"name,omitempty"`
+}
+
+// injectionPolicy configures the patches the webhook injects into TPU Pods.
+type injectionPolicy struct {
+	// ReplicaLabel is the Pod label holding the slice of a Ray TPU worker, {WORKER_GROUP_NAME}-{REPLICA_INDEX}
+	ReplicaLabel string `json:"replicaLabel,omitempty"`
+	// AffinityTopologyKey is the topology key of the pod affinity between the Ray TPU workers of a slice
+	AffinityTopologyKey string `json:"affinityTopologyKey,omitempty"`
+	// PodAffinity is whether the pod affinity is required or preferred during scheduling
+	PodAffinity string `json:"podAffinity,omitempty"`
+	// HeadlessServiceSuffix is the suffix of the headless Service KubeRay creates for multi-host RayClusters
+	HeadlessServiceSuffix string            `json:"headlessServiceSuffix,omitempty"`
+	Env                   injectionEnvNames `json:"env,omitempty"`
+	// ExtraEnv maps the names of additional environment variables to their source: accelerator,
+	// topology, chipsPerHost, numOfHosts or replicaIndex
+	ExtraEnv map[string]string `json:"extraEnv,omitempty"`
+	// Skip lists the patches that aren't injected: hostname, subdomain, podAffinity, workerHostnames,
+	// tpuName or multislice
+	Skip []string `json:"skip,omitempty"`
+}
+
+func defaultInjectionPolicy() *injectionPolicy {
+	return &injectionPolicy{
+		ReplicaLabel:          "replicaIndex",
+		AffinityTopologyKey:   "cloud.google.com/gke-nodepool",
+		PodAffinity:           affinityRequired,
+		HeadlessServiceSuffix: headlessServiceSuffix,
+		Env: injectionEnvNames{
+			WorkerID:        "TPU_WORKER_ID",
+			WorkerHostnames: "TPU_WORKER_HOSTNAMES",
+			Name:            "TPU_NAME",
+		},
+	}
+}
+
+// overlay returns a copy of the policy with the fields set in a YAML or JSON policy replaced. Extra
+// environment variables are merged, skipped patches are replaced.
+func (p *injectionPolicy) overlay(data string) (*injectionPolicy, error) {
+	policy := *p
+	policy.ExtraEnv = maps.Clone(p.ExtraEnv)
+	policy.Skip = slices.Clone(p.Skip)
+	if err := yaml.UnmarshalStrict([]byte(data), &policy); err != nil {
+		return nil, err
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// validate returns an error if the policy can't be injected
+func (p *injectionPolicy) validate() error {
+	if errs := validation.IsQualifiedName(p.ReplicaLabel); len(errs) > 0 {
+		return fmt.Errorf("invalid replicaLabel %q: %s", p.ReplicaLabel, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsQualifiedName(p.AffinityTopologyKey); len(errs) > 0 {
+		return fmt.Errorf("invalid affinityTopologyKey %q: %s", p.AffinityTopologyKey, strings.Join(errs, ", "))
+	}
+	if p.PodAffinity != affinityRequired && p.PodAffinity != affinityPreferred {
+		return fmt.Errorf("invalid podAffinity %q, expected %s or %s", p.PodAffinity, affinityRequired, affinityPreferred)
+	}
+	if p.HeadlessServiceSuffix == "" {
+		return fmt.Errorf("headlessServiceSuffix must be set")
+	}
+	names := map[string]bool{}
+	for _, name := range []string{p.Env.WorkerID, p.Env.WorkerHostnames, p.Env.Name} {
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return fmt.Errorf("invalid environment variable name %q: %s", name, strings.Join(errs, ", "))
+		}
+		names[name] = true
+	}
+	for name, source := range p.ExtraEnv {
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return fmt.Errorf("invalid environment variable name %q: %s", name, strings.Join(errs, ", "))
+		}
+		if names[name] {
+			return fmt.Errorf("extraEnv %s is already injected", name)
+		}
+		if !slices.Contains(extraEnvSources, source) {
+			return fmt.Errorf("invalid extraEnv source %q for %s, expected one of %s", source, name, strings.Join(extraEnvSources, ", "))
+		}
+	}
+	for _, skip := range p.Skip {
+		switch skip {
+		case patchHostname, patchSubdomain, patchPodAffinity, patchWorkerHostnames, patchTPUName, patchMultislice:
+		default:
+			return fmt.Errorf("invalid skip %q, TPU_WORKER_ID and the replica label can't be skipped", skip)
+		}
+	}
+	return nil
+}
+
+// skips returns whether a patch is skipped by the policy
+func (p *injectionPolicy) skips(name string) bool {
+	return slices.Contains(p.Skip, name)
+}
+
+// headlessServiceName returns the expected TPU headless service name for a RayCluster
+func (p *injectionPolicy) headlessServiceName(clusterName string) string {
+	// Apply the same truncation as in the RayCluster controller when generating the headless service
+	// name. This is to maintain the up-to 63 char compatibility guarantee for hostnames (RFC 1123).
+	return utils.CheckName(fmt.Sprintf("%s-%s", clusterName, p.HeadlessServiceSuffix))
+}
+
+// extraEnv returns the extra environment variables of a TPU worker, sorted by name
+func (p *injectionPolicy) extraEnv(accelerator string, topology string, chipsPerHost int64, numOfHosts int32, replicaIndex int) []corev1.EnvVar {
+	values := map[string]string{
+		"accelerator":  accelerator,
+		"topology":     topology,
+		"chipsPerHost": fmt.Sprint(chipsPerHost),
+		"numOfHosts":   fmt.Sprint(numOfHosts),
+		"replicaIndex": fmt.Sprint(replicaIndex),
+	}
+	var envVars []corev1.EnvVar
+	for name, source := range p.ExtraEnv {
+		envVars = append(envVars, corev1.EnvVar{Name: name, Value: values[source]})
+	}
+	sort.Slice(envVars, func(i, j int) bool { return envVars[i].Name < envVars[j].Name })
+	return envVars
+}
+
+// checkOverride returns an error if an overriding policy changes how the webhook reads the
+// assignments of admitted Pods back, which must be the same for every RayCluster and every Pod
+// admitted since the webhook started
+func (p *injectionPolicy) checkOverride(override *injectionPolicy) error {
+	if override.ReplicaLabel != p.ReplicaLabel || override.Env.WorkerID != p.Env.WorkerID {
+		return fmt.Errorf("replicaLabel and env.workerID can't be overridden")
+	}
+	return nil
+}
+
+// policyStore holds the injection policy of the webhook, which is replaced when the policy
+// ConfigMap changes.
+type policyStore struct {
+	// base is the policy of the policy file, or the default policy
+	base    *injectionPolicy
+	current atomic.Pointer[injectionPolicy]
+}
+
+func newPolicyStore(base *injectionPolicy) *policyStore {
+	s := &policyStore{base: base}
+	s.current.Store(base)
+	return s
+}
+
+// loadPolicyFile returns the default policy overlaid with a policy file, or the default policy if
+// path is empty
+func loadPolicyFile(path string) (*injectionPolicy, error) {
+	if path == "" {
+		return defaultInjectionPolicy(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return defaultInjectionPolicy().overlay(string(data))
+}
+
+func (s *policyStore) get() *injectionPolicy {
+	return s.current.Load()
+}
+
+// update replaces the policy with the base policy overlaid with the policy of a ConfigMap. An
+// invalid policy, or one changing replicaLabel or env.workerID, is ignored and the previous
+// policy is kept.
+func (s *policyStore) update(configMap *corev1.ConfigMap) {
+	if configMap == nil {
+		klog.V(0).InfoS("policyStore", "message", "policy ConfigMap deleted, using the base injection policy")
+		s.current.Store(s.base)
+		return
+	}
+	policy, err := s.base.overlay(configMap.Data[injectionPolicyKey])
+	if err == nil {
+		err = s.base.checkOverride(policy)
+	}
+	if err != nil {
+		klog.ErrorS(err, "policyStore", "ConfigMap", configMap.Namespace+"/"+configMap.Name, "message", "keeping the previous injection policy")
+		return
+	}
+	klog.V(0).InfoS("policyStore", "ConfigMap", configMap.Namespace+"/"+configMap.Name, "message", "injection policy updated")
+	s.current.Store(policy)
+}
+
+// watch updates the policy whenever a ConfigMap changes, until stopCh is closed
+func (s *policyStore) watch(client kubernetes.Interface, namespace string, name string, stopCh <-chan struct{}) cache.InformerSynced {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			s.update(obj.(*corev1.ConfigMap))
+		},
+		UpdateFunc: func(oldObj any, newObj any) {
+			s.update(newObj.(*corev1.ConfigMap))
+		},
+		DeleteFunc: func(obj any) {
+			s.update(nil)
+		},
+	})
+	factory.Start(stopCh)
+	return informer.HasSynced
+}
+
+// override returns the policy overridden by the YAML or JSON policy of an injectionPolicyAnnotation
+func (p *injectionPolicy) override(data string) (*injectionPolicy, error) {
+	override, err := p.overlay(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", injectionPolicyAnnotation, err)
+	}
+	if err := p.checkOverride(override); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", injectionPolicyAnnotation, err)
+	}
+	return override, nil
+}
+
+// podPolicy returns the injection policy of a Ray Pod, overridden by the injectionPolicyAnnotation
+// of its worker group or RayCluster
+func (t *TPUWebhookServer) podPolicy(pod *corev1.Pod, clusterName string, namespace string) (*injectionPolicy, error) {
+	policy := t.policies.get()
+	clusterAnnotations := map[string]string{}
+	if t.rayClusterLister != nil {
+		if rayCluster, err := t.rayClusterLister.RayClusters(namespace).Get(clusterName); err == nil {
+			clusterAnnotations = rayCluster.Annotations
+		}
+	}
+	data, ok := getAnnotation(injectionPolicyAnnotation, pod.Annotations, clusterAnnotations)
+	if !ok {
+		return policy, nil
+	}
+	return policy.override(data)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_InjectionPolicyOverlay(t *testing.T) {
+	base := defaultInjectionPolicy()
+	base.ExtraEnv = map[string]string{"TPU_ACCELERATOR_TYPE": "accelerator"}
+
+	tests := map[string]struct {
+		data          string
+		expected      func(*injectionPolicy)
+		expectedError bool
+	}{
+		"empty policy": {
+			data:     "",
+			expected: func(p *injectionPolicy) {},
+		},
+		"YAML policy": {
+			data: `
+affinityTopologyKey: cloud.google.com/gke-placement-group
+podAffinity: preferred
+env:
+  name: MY_TPU_NAME
+extraEnv:
+  TPU_TOPOLOGY: topology
+skip: [tpuName, multislice]
+`,
+			expected: func(p *injectionPolicy) {
+				p.AffinityTopologyKey = "cloud.google.com/gke-placement-group"
+				p.PodAffinity = affinityPreferred
+				p.Env.Name = "MY_TPU_NAME"
+				p.ExtraEnv = map[string]string{"TPU_ACCELERATOR_TYPE": "accelerator", "TPU_TOPOLOGY": "topology"}
+				p.Skip = []string{patchTPUName, patchMultislice}
+			},
+		},
+		"JSON policy": {
+			data: `{"replicaLabel": "example.com/replica"}`,
+			expected: func(p *injectionPolicy) {
+				p.ReplicaLabel = "example.com/replica"
+			},
+		},
+		"unknown field": {
+			data:          "podAfinity: preferred",
+			expectedError: true,
+		},
+		"invalid pod affinity": {
+			data:          "podAffinity: sometimes",
+			expectedError: true,
+		},
+		"TPU_WORKER_ID can't be skipped": {
+			data:          "skip: [workerID]",
+			expectedError: true,
+		},
+		"extra env var is already injected": {
+			data:          "extraEnv: {TPU_WORKER_ID: replicaIndex}",
+			expectedError: true,
+		},
+		"invalid extra env source": {
+			data:          "extraEnv: {TPU_ZONE: zone}",
+			expectedError: true,
+		},
+		"invalid replica label": {
+			data:          "replicaLabel: replica index",
+			expectedError: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			policy, err := base.overlay(tc.data)
+			if tc.expectedError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			expected := defaultInjectionPolicy()
+			expected.ExtraEnv = map[string]string{"TPU_ACCELERATOR_TYPE": "accelerator"}
+			tc.expected(expected)
+			assert.Equal(t, expected, policy)
+			// the base policy is unchanged
+			assert.Equal(t, map[string]string{"TPU_ACCELERATOR_TYPE": "accelerator"}, base.ExtraEnv)
+		})
+	}
+}
+
+func Test_PolicyStore(t *testing.T) {
+	store := newPolicyStore(defaultInjectionPolicy())
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "tpu-injection-policy", Namespace: "ray-system"},
+		Data:       map[string]string{injectionPolicyKey: "podAffinity: preferred"},
+	}
+	store.update(configMap)
+	assert.Equal(t, affinityPreferred, store.get().PodAffinity)
+
+	// an invalid policy keeps the previous policy
+	configMap.Data[injectionPolicyKey] = "podAffinity: sometimes"
+	store.update(configMap)
+	assert.Equal(t, affinityPreferred, store.get().PodAffinity)
+
+	// the assignments of admitted Pods are read back with the replica label and TPU_WORKER_ID
+	// of the base policy, which can't change at runtime
+	configMap.Data[injectionPolicyKey] = "replicaLabel: example.com/replica"
+	store.update(configMap)
+	assert.Equal(t, "replicaIndex", store.get().ReplicaLabel)
+	assert.Equal(t, affinityPreferred, store.get().PodAffinity)
+	configMap.Data[injectionPolicyKey] = "env: {workerID: WORKER_ID}"
+	store.update(configMap)
+	assert.Equal(t, "TPU_WORKER_ID", store.get().Env.WorkerID)
+
+	// deleting the ConfigMap restores the base policy
+	store.update(nil)
+	assert.Equal(t, defaultInjectionPolicy(), store.get())
+}
+
+func Test_MutatePodPolicy(t *testing.T) {
+	policy, err := defaultInjectionPolicy().overlay(`
+replicaLabel: example.com/replica
+affinityTopologyKey: cloud.google.com/gke-placement-group
+podAffinity: preferred
+headlessServiceSuffix: workers
+env:
+  workerID: WORKER_ID
+  workerHostnames: WORKER_HOSTNAMES
+extraEnv:
+  TPU_ACCELERATOR_TYPE: accelerator
+  TPU_TOPOLOGY: topology
+skip: [tpuName, hostname]
+`)
+	assert.Nil(t, err)
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x2", "4")
+	testPod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "RAY_PORT", Value: "6379"}}
+	// existing affinity is kept
+	nodeAffinity := &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: "cloud.google.com/gke-spot", Operator: corev1.NodeSelectorOpDoesNotExist},
+			}}},
+		},
+	}
+	existingTerm := corev1.WeightedPodAffinityTerm{Weight: 10, PodAffinityTerm: corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cache"}},
+		TopologyKey:   "kubernetes.io/hostname",
+	}}
+	testPod.Spec.Affinity = &corev1.Affinity{
+		NodeAffinity: nodeAffinity,
+		PodAffinity:  &corev1.PodAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{existingTerm}},
+	}
+
+	tpuWebhookServer := NewTPUWebhookServer(setupInformer(), nil)
+	tpuWebhookServer.policies = newPolicyStore(policy)
+	admissionReview := getTestAdmissionReview("Pod", "CREATE")
+	admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+	admissionResponse, err := tpuWebhookServer.mutatePod(admissionReview, nil)
+	assert.Nil(t, err)
+
+	expectedPatches := []patch{
+		{"op": "replace", "path": "/metadata/labels/example.com~1replica", "value": "test-group-0"},
+		{"op": "add", "path": "/spec/affinity", "value": corev1.Affinity{
+			NodeAffinity: nodeAffinity,
+			PodAffinity: &corev1.PodAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				existingTerm,
+				{Weight: 100, PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "example.com/replica", Operator: metav1.LabelSelectorOpIn, Values: []string{"test-group-0"}},
+					}},
+					TopologyKey: "cloud.google.com/gke-placement-group",
+				}},
+			}},
+		}},
+		{"op": "add", "path": "/spec/subdomain", "value": "test-cluster-workers"},
+		{"op": "add", "path": "/spec/containers/0/env/-", "value": corev1.EnvVar{Name: "WORKER_HOSTNAMES", Value: "test-group-0-0.test-cluster-workers,test-group-0-1.test-cluster-workers"}},
+		{"op": "add", "path": "/spec/containers/0/env/-", "value": corev1.EnvVar{Name: "WORKER_ID", Value: "0"}},
+		{"op": "add", "path": "/spec/containers/0/env/-", "value": corev1.EnvVar{Name: "TPU_ACCELERATOR_TYPE", Value: "tpu-v4-podslice"}},
+		{"op": "add", "path": "/spec/containers/0/env/-", "value": corev1.EnvVar{Name: "TPU_TOPOLOGY", Value: "2x2x2"}},
+	}
+	expected, _ := json.Marshal(expectedPatches)
+	assert.JSONEq(t, string(expected), string(admissionResponse.Patch))
+
+	// the assignment is read back with the policy's replica label and TPU_WORKER_ID variable
+	admittedPod := testPod.DeepCopy()
+	admittedPod.Labels["example.com/replica"] = "test-group-0"
+	admittedPod.Spec.Containers[0].Env = append(admittedPod.Spec.Containers[0].Env, corev1.EnvVar{Name: "WORKER_ID", Value: "0"})
+	_, assignment, ok := podWorkerAssignment(admittedPod, policy)
+	assert.True(t, ok)
+	assert.Equal(t, workerAssignment{0, 0}, assignment)
+}
+
+func Test_PodPolicyAnnotation(t *testing.T) {
+	tests := map[string]struct {
+		clusterAnnotations  map[string]string
+		podAnnotations      map[string]string
+		expectedPodAffinity string
+		expectedError       bool
+	}{
+		"no override": {
+			expectedPodAffinity: affinityRequired,
+		},
+		"RayCluster override": {
+			clusterAnnotations:  map[string]string{injectionPolicyAnnotation: "podAffinity: preferred"},
+			expectedPodAffinity: affinityPreferred,
+		},
+		"worker group override takes precedence": {
+			clusterAnnotations:  map[string]string{injectionPolicyAnnotation: "podAffinity: preferred"},
+			podAnnotations:      map[string]string{injectionPolicyAnnotation: "podAffinity: required"},
+			expectedPodAffinity: affinityRequired,
+		},
+		"invalid override": {
+			clusterAnnotations: map[string]string{injectionPolicyAnnotation: "podAffinity: ["},
+			expectedError:      true,
+		},
+		"replica label can't be overridden": {
+			clusterAnnotations: map[string]string{injectionPolicyAnnotation: "replicaLabel: slice"},
+			expectedError:      true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rayCluster := getTestRayCluster("test-cluster", "test-group", "test-namespace", 4, 1, "4", "tpu-v4-podslice", "2x2x4", false)
+			rayCluster.Annotations = tc.clusterAnnotations
+			testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+			testPod.Annotations = tc.podAnnotations
+
+			tpuWebhookServer := NewTPUWebhookServer(setupInformer(), setupRayClusterLister(rayCluster))
+			policy, err := tpuWebhookServer.podPolicy(testPod, "test-cluster", "test-namespace")
+			if tc.expectedError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectedPodAffinity, policy.PodAffinity)
+		})
+	}
+}
@@ -0,0 +1,382 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// sharedLedgerLabel marks the ConfigMaps holding the reservations of the sharedWorkerLedger
+	sharedLedgerLabel = "kuberay-tpu-webhook/worker-ledger"
+)
+
+// sharedLedgerBackoff retries reservations that lost a resourceVersion conflict to another webhook
+// replica. Every TPU worker of a worker group is reserved in the same ConfigMap, so conflicts are
+// expected while a RayCluster scales up.
+var sharedLedgerBackoff = wait.Backoff{
+	Steps:    20,
+	Duration: 5 * time.Millisecond,
+	Factor:   1.5,
+	Jitter:   0.5,
+	Cap:      time.Second,
+}
+
+// sharedWorkerLedger reserves TPU_WORKER_IDs in a ConfigMap per worker group, so that several webhook
+// replicas can admit Pods of the same worker group. Every reservation is a compare-and-swap on the
+// ConfigMap's resourceVersion: a replica that assigned from a stale view of the ConfigMap gets a
+// conflict and assigns again.
+//
+// The PodInformer caches of the replicas don't sync at the same time, so a reservation can't be
+// released when one replica observes its Pod. Reservations are instead kept until they expire, and a
+// replica only ignores a reservation once its own cache contains a Pod with that assignment that the
+// reserving replica didn't observe. Those Pods, recorded by UID with the reservation, are Pods being
+// replaced. Pods are told apart by UID rather than by comparing their creationTimestamp with the
+// reservation time, which would compare the API server's clock with the webhook's.
+//
+// A replica also remembers the Pods it observed for reserved assignments, so that a reservation whose
+// Pod was deleted before the reservation expired doesn't block the Pod replacing it. A replica that
+// never observed that Pod can't tell it apart from a Pod it hasn't observed yet, and keeps the
+// reservation until it expires.
+type sharedWorkerLedger struct {
+	mu sync.Mutex
+	// groupLocks serialize the assignments of a worker group within this replica, assignments of
+	// different worker groups don't conflict
+	groupLocks map[workerGroup]*sync.Mutex
+	// consumed are the UIDs of the Pods this replica observed by assignment, guarded by mu
+	consumed  map[workerGroup]map[workerAssignment][]types.UID
+	client    kubernetes.Interface
+	namespace string
+	podLister listersv1.PodLister
+	// policies holds the replica label and TPU_WORKER_ID variable of admitted Pods
+	policies *policyStore
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+func newSharedWorkerLedger(client kubernetes.Interface, namespace string, podLister listersv1.PodLister, ttl time.Duration) *sharedWorkerLedger {
+	return &sharedWorkerLedger{
+		groupLocks: make(map[workerGroup]*sync.Mutex),
+		consumed:   make(map[workerGroup]map[workerAssignment][]types.UID),
+		client:     client,
+		namespace:  namespace,
+		podLister:  podLister,
+		policies:   newPolicyStore(defaultInjectionPolicy()),
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+// groupLock returns the lock of the assignments of a worker group
+func (l *sharedWorkerLedger) groupLock(group workerGroup) *sync.Mutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock, ok := l.groupLocks[group]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.groupLocks[group] = lock
+	}
+	return lock
+}
+
+// configMapName returns the name of the ConfigMap holding the reservations of a worker group
+func (l *sharedWorkerLedger) configMapName(group workerGroup) string {
+	h := fnv.New64a()
+	h.Write([]byte(group.namespace + "/" + group.clusterName + "/" + group.groupName))
+	return fmt.Sprintf("tpu-worker-ledger-%x", h.Sum64())
+}
+
+// reservationKey returns the ConfigMap key of an assignment
+func reservationKey(a workerAssignment) string {
+	return fmt.Sprintf("%d.%d", a.replicaIndex, a.workerID)
+}
+
+// reservation is a reserved assignment, stored as the reservation time followed by the UIDs of the
+// Pods with the assignment that the reserving replica observed, separated by spaces
+type reservation struct {
+	reservedAt time.Time
+	replacing  []types.UID
+}
+
+func (r reservation) String() string {
+	fields := []string{r.reservedAt.UTC().Format(time.RFC3339Nano)}
+	for _, uid := range r.replacing {
+		fields = append(fields, string(uid))
+	}
+	return strings.Join(fields, " ")
+}
+
+// replaces returns whether every Pod of uids was observed when the assignment was reserved
+func (r reservation) replaces(uids []types.UID) bool {
+	for _, uid := range uids {
+		if !slices.Contains(r.replacing, uid) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseReservations returns the reservations of the assignments in a ConfigMap. Reservations that
+// can't be parsed are dropped.
+func parseReservations(data map[string]string) map[workerAssignment]reservation {
+	reservations := make(map[workerAssignment]reservation)
+	for key, value := range data {
+		replicaIndex, workerID, ok := strings.Cut(key, ".")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			klog.V(0).InfoS("parseReservations", "key", key, "value", value, "message", "dropping invalid reservation")
+			continue
+		}
+		r, errReplica := strconv.Atoi(replicaIndex)
+		w, errWorker := strconv.Atoi(workerID)
+		reservedAt, errTime := time.Parse(time.RFC3339Nano, fields[0])
+		if errReplica != nil || errWorker != nil || errTime != nil {
+			klog.V(0).InfoS("parseReservations", "key", key, "value", value, "message", "dropping invalid reservation")
+			continue
+		}
+		res := reservation{reservedAt: reservedAt}
+		for _, uid := range fields[1:] {
+			res.replacing = append(res.replacing, types.UID(uid))
+		}
+		reservations[workerAssignment{r, w}] = res
+	}
+	return reservations
+}
+
+// observedAssignments returns the UIDs of the Pods in the PodInformer cache for every assignment of a
+// worker group, including Pods that are being deleted
+func (l *sharedWorkerLedger) observedAssignments(group workerGroup) (map[workerAssignment][]types.UID, error) {
+	pods, err := l.podLister.Pods(group.namespace).List(kubeRayFramework.groupSelector(group))
+	if err != nil {
+		return nil, err
+	}
+	observed := make(map[workerAssignment][]types.UID)
+	policy := l.policies.get()
+	for _, pod := range pods {
+		if _, a, ok := podWorkerAssignment(pod, policy); ok {
+			observed[a] = append(observed[a], pod.UID)
+		}
+	}
+	return observed, nil
+}
+
+// assign returns the next free replica index and TPU_WORKER_ID in a worker group and reserves them in
+// the worker group's ConfigMap, unless the assignment is for a dry-run request. listSlices returns the
+// assignments of the existing Pods in the worker group and is called again for every retry. The
+// returned map is updated in place with the reserved assignments.
+func (l *sharedWorkerLedger) assign(group workerGroup, numOfHosts int32, listSlices func() (map[slice][]int, error), dryRun bool) (int, int, error) {
+	// assignments within this replica don't need to conflict with each other
+	lock := l.groupLock(group)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx := context.TODO()
+	configMaps := l.client.CoreV1().ConfigMaps(l.namespace)
+	name := l.configMapName(group)
+	var replicaIndex, workerID int
+	err := retry.OnError(sharedLedgerBackoff, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		configMap, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		notFound := apierrors.IsNotFound(err)
+		if err != nil && !notFound {
+			return err
+		}
+		if notFound {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: l.namespace,
+					Labels:    map[string]string{sharedLedgerLabel: "true"},
+					Annotations: map[string]string{
+						"ray.io/cluster":   group.clusterName,
+						"ray.io/group":     group.groupName,
+						"ray.io/namespace": group.namespace,
+					},
+				},
+			}
+		}
+		if configMap.Annotations["ray.io/namespace"] != group.namespace || configMap.Annotations["ray.io/cluster"] != group.clusterName || configMap.Annotations["ray.io/group"] != group.groupName {
+			return fmt.Errorf("ConfigMap %s/%s holds the reservations of another worker group", l.namespace, name)
+		}
+
+		sliceToWorkerIDs, err := listSlices()
+		if err != nil {
+			return err
+		}
+		observed, err := l.observedAssignments(group)
+		if err != nil {
+			return err
+		}
+		now := l.now()
+		reservations := parseReservations(configMap.Data)
+		consumed := l.consumedAssignments(group, reservations)
+		for a, r := range reservations {
+			if now.After(r.reservedAt.Add(l.ttl)) {
+				delete(reservations, a)
+				continue
+			}
+			// a Pod the reserving replica didn't observe is the Pod of the reservation
+			if !r.replaces(observed[a]) {
+				continue
+			}
+			// the Pod of the reservation was observed by this replica and has been deleted since
+			if !r.replaces(consumed[a]) {
+				klog.V(1).InfoS("sharedWorkerLedger", "RayCluster", group.namespace+"/"+group.clusterName, "Worker Group", group.groupName,
+					"Replica Index", a.replicaIndex, "TPU_WORKER_ID", a.workerID, "message", "dropping reservation of a deleted Pod")
+				delete(reservations, a)
+				continue
+			}
+			podSlice := slice{group.clusterName, group.groupName, group.namespace, a.replicaIndex, numOfHosts}
+			if !slices.Contains(sliceToWorkerIDs[podSlice], a.workerID) {
+				sliceToWorkerIDs[podSlice] = append(sliceToWorkerIDs[podSlice], a.workerID)
+			}
+		}
+		printSliceToWorkerIds(sliceToWorkerIDs)
+
+		replicaIndex = getReplicaIndex(sliceToWorkerIDs, group)
+		podSlice := slice{group.clusterName, group.groupName, group.namespace, replicaIndex, numOfHosts}
+		workerID, err = getNextWorkerID(sliceToWorkerIDs, podSlice, group.namespace, replicaIndex)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return nil
+		}
+
+		assignment := workerAssignment{replicaIndex, workerID}
+		reservations[assignment] = reservation{reservedAt: now, replacing: observed[assignment]}
+		configMap.Data = make(map[string]string, len(reservations))
+		for a, r := range reservations {
+			configMap.Data[reservationKey(a)] = r.String()
+		}
+		if notFound {
+			_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+		} else {
+			_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		}
+		if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+			klog.V(1).InfoS("sharedWorkerLedger", "RayCluster", group.namespace+"/"+group.clusterName, "Worker Group", group.groupName,
+				"Replica Index", replicaIndex, "TPU_WORKER_ID", workerID, "message", "reservation conflicted with another webhook replica, retrying")
+		}
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return replicaIndex, workerID, nil
+}
+
+// release records that this replica observed the Pod with uid for an assignment. Shared reservations
+// are kept until they expire since other webhook replicas may not have observed the Pod yet, but this
+// replica drops a reservation once the Pod it observed for it is gone.
+func (l *sharedWorkerLedger) release(group workerGroup, a workerAssignment, uid types.UID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.consumed[group] == nil {
+		l.consumed[group] = make(map[workerAssignment][]types.UID)
+	}
+	if !slices.Contains(l.consumed[group][a], uid) {
+		l.consumed[group][a] = append(l.consumed[group][a], uid)
+	}
+}
+
+// consumedAssignments returns the UIDs of the Pods this replica observed for the reserved assignments
+// of a worker group, and forgets the Pods of assignments that are no longer reserved
+func (l *sharedWorkerLedger) consumedAssignments(group workerGroup, reservations map[workerAssignment]reservation) map[workerAssignment][]types.UID {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	consumed := make(map[workerAssignment][]types.UID)
+	for a, uids := range l.consumed[group] {
+		if _, ok := reservations[a]; !ok {
+			delete(l.consumed[group], a)
+			continue
+		}
+		consumed[a] = slices.Clone(uids)
+	}
+	if len(l.consumed[group]) == 0 {
+		delete(l.consumed, group)
+	}
+	return consumed
+}
+
+// collectGarbage deletes the ConfigMaps whose reservations have all expired, e.g. of deleted RayClusters.
+// A ConfigMap that was updated in the meantime is kept.
+func (l *sharedWorkerLedger) collectGarbage(ctx context.Context) error {
+	configMaps := l.client.CoreV1().ConfigMaps(l.namespace)
+	list, err := configMaps.List(ctx, metav1.ListOptions{LabelSelector: sharedLedgerLabel + "=true"})
+	if err != nil {
+		return err
+	}
+	now := l.now()
+	kept := make(map[workerGroup]bool)
+	for _, configMap := range list.Items {
+		group := workerGroup{configMap.Annotations["ray.io/namespace"], configMap.Annotations["ray.io/cluster"], configMap.Annotations["ray.io/group"]}
+		expired := true
+		for _, r := range parseReservations(configMap.Data) {
+			if !now.After(r.reservedAt.Add(l.ttl)) {
+				expired = false
+			}
+		}
+		if !expired {
+			kept[group] = true
+			continue
+		}
+		resourceVersion := configMap.ResourceVersion
+		err := configMaps.Delete(ctx, configMap.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &resourceVersion}})
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			return err
+		}
+		if err == nil {
+			// a replica racing the deletion still updates the ConfigMap with a resourceVersion check
+			l.mu.Lock()
+			delete(l.groupLocks, group)
+			l.mu.Unlock()
+		} else {
+			kept[group] = true
+		}
+	}
+	// the Pods observed for worker groups without reservations are forgotten
+	l.mu.Lock()
+	for group := range l.consumed {
+		if !kept[group] {
+			delete(l.consumed, group)
+		}
+	}
+	l.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// reserved returns the number of reservations of a worker group that haven't expired.
+func (l *sharedWorkerLedger) reserved(group workerGroup) int {
+	configMap, err := l.client.CoreV1().ConfigMaps(l.namespace).Get(context.TODO(), l.configMapName(group), metav1.GetOptions{})
+	if err != nil {
+		return 0
+	}
+	count := 0
+	now := l.now()
+	for _, r := range parseReservations(configMap.Data) {
+		if !now.After(r.reservedAt.Add(l.ttl)) {
+			count++
+		}
+	}
+	return count
+}
+
+// setupConflictingClientSet returns a fake Clientset that rejects ConfigMap updates with a stale
+// resourceVersion, like the API server
+func setupConflictingClientSet() *fake.Clientset {
+	clientSet := fake.NewSimpleClientset()
+	var mu sync.Mutex
+	resourceVersion := 0
+	tracker := clientSet.Tracker()
+	// widen the window between reading and updating a ConfigMap, so that replicas race
+	clientSet.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		time.Sleep(time.Millisecond)
+		return false, nil, nil
+	})
+	clientSet.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		configMap := action.(k8stesting.CreateAction).GetObject().(*corev1.ConfigMap).DeepCopy()
+		resourceVersion++
+		configMap.ResourceVersion = strconv.Itoa(resourceVersion)
+		if err := tracker.Create(action.GetResource(), configMap, action.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		return true, configMap, nil
+	})
+	clientSet.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		configMap := action.(k8stesting.UpdateAction).GetObject().(*corev1.ConfigMap).DeepCopy()
+		current, err := tracker.Get(action.GetResource(), action.GetNamespace(), configMap.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		if current.(*corev1.ConfigMap).ResourceVersion != configMap.ResourceVersion {
+			return true, nil, apierrors.NewConflict(action.GetResource().GroupResource(), configMap.Name, nil)
+		}
+		resourceVersion++
+		configMap.ResourceVersion = strconv.Itoa(resourceVersion)
+		if err := tracker.Update(action.GetResource(), configMap, action.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		return true, configMap, nil
+	})
+	return clientSet
+}
+
+// Test_SharedWorkerLedgerReplicas admits all Pods of a multi-host worker group through three webhook
+// replicas at once, none of which has any of the Pods in its PodInformer cache yet.
+func Test_SharedWorkerLedgerReplicas(t *testing.T) {
+	const (
+		numOfHosts = 4
+		numSlices  = 4
+	)
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+	clientSet := setupConflictingClientSet()
+	var replicas []*TPUWebhookServer
+	for i := 0; i < 3; i++ {
+		podLister := setupInformer()
+		tpuWebhookServer := NewTPUWebhookServer(podLister, nil)
+		tpuWebhookServer.ledger = newSharedWorkerLedger(clientSet, "ray-system", podLister, time.Minute)
+		replicas = append(replicas, tpuWebhookServer)
+	}
+
+	var mu sync.Mutex
+	assignments := make(map[workerAssignment]int)
+	var wg sync.WaitGroup
+	for i := 0; i < numOfHosts*numSlices; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			admissionReview := getTestAdmissionReview("Pod", "CREATE")
+			admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+			response, err := replicas[i%len(replicas)].mutatePod(admissionReview, nil)
+			assert.Nil(t, err)
+			mu.Lock()
+			assignments[mutateAssignment(t, response)]++
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	// every TPU_WORKER_ID of every replica is assigned exactly once
+	for replicaIndex := 0; replicaIndex < numSlices; replicaIndex++ {
+		for workerID := 0; workerID < numOfHosts; workerID++ {
+			assert.Equal(t, 1, assignments[workerAssignment{replicaIndex, workerID}], "replica %d TPU_WORKER_ID %d", replicaIndex, workerID)
+		}
+	}
+	assert.Equal(t, numOfHosts*numSlices, replicas[0].ledger.(*sharedWorkerLedger).reserved(workerGroup{"test-namespace", "test-cluster", "test-group"}))
+}
+
+func Test_SharedWorkerLedgerObservedPods(t *testing.T) {
+	group := workerGroup{"test-namespace", "test-cluster", "test-group"}
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+	now := time.Now()
+	pods := getTestInterceptedTPUPods(testPod, 4, 1, 4)
+	for i, pod := range pods {
+		pod.UID = types.UID(fmt.Sprintf("pod-%d", i))
+		pod.CreationTimestamp = metav1.Time{Time: now.Add(-time.Hour)}
+	}
+	// TPU_WORKER_ID 1 is terminating
+	pods[1].DeletionTimestamp = &metav1.Time{Time: now}
+
+	clientSet := setupConflictingClientSet()
+	podLister := setupInformer(pods...)
+	ledger := newSharedWorkerLedger(clientSet, "ray-system", podLister, time.Minute)
+	ledger.now = func() time.Time { return now }
+	tpuWebhookServer := NewTPUWebhookServer(podLister, nil)
+	listSlices := func() (map[slice][]int, error) {
+		return tpuWebhookServer.getSliceToWorkerIDs(workerGroup{"test-namespace", "test-cluster", "test-group"}, 4)
+	}
+
+	// the replacement of the terminating Pod rejoins its slice
+	replicaIndex, workerID, err := ledger.assign(group, 4, listSlices, false)
+	assert.Nil(t, err)
+	assert.Equal(t, workerAssignment{0, 1}, workerAssignment{replicaIndex, workerID})
+
+	// another replica whose cache still has the terminating Pod doesn't reuse its TPU_WORKER_ID,
+	// the reserving replica observed that Pod
+	otherLedger := newSharedWorkerLedger(clientSet, "ray-system", podLister, time.Minute)
+	otherLedger.now = func() time.Time { return now.Add(time.Second) }
+	replicaIndex, workerID, err = otherLedger.assign(group, 4, listSlices, true)
+	assert.Nil(t, err)
+	assert.Equal(t, workerAssignment{1, 0}, workerAssignment{replicaIndex, workerID})
+
+	// once the replacement is in the cache the reservation is its Pod, even if the API server's
+	// clock, which set its creationTimestamp, is behind the webhook's
+	replacement := pods[1].DeepCopy()
+	replacement.UID = "pod-replacement"
+	replacement.Name += "-replacement"
+	replacement.DeletionTimestamp = nil
+	replacement.CreationTimestamp = metav1.Time{Time: now.Add(-time.Minute)}
+	observedLister := setupInformer(append(pods, replacement)...)
+	observedLedger := newSharedWorkerLedger(clientSet, "ray-system", observedLister, time.Minute)
+	observedLedger.now = func() time.Time { return now.Add(time.Second) }
+	observedServer := NewTPUWebhookServer(observedLister, nil)
+	replicaIndex, workerID, err = observedLedger.assign(group, 4, func() (map[slice][]int, error) {
+		sliceToWorkerIDs, err := observedServer.getSliceToWorkerIDs(workerGroup{"test-namespace", "test-cluster", "test-group"}, 4)
+		// the replacement is dropped from the slice, only the reservation can hold its TPU_WORKER_ID
+		podSlice := slice{"test-cluster", "test-group", "test-namespace", 0, 4}
+		sliceToWorkerIDs[podSlice] = slices.DeleteFunc(sliceToWorkerIDs[podSlice], func(id int) bool { return id == 1 })
+		return sliceToWorkerIDs, err
+	}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, workerAssignment{0, 1}, workerAssignment{replicaIndex, workerID})
+
+	// reservations expire, and the ledgers of worker groups without reservations are deleted
+	ledger.now = func() time.Time { return now.Add(2 * time.Minute) }
+	assert.Equal(t, 0, ledger.reserved(group))
+	assert.Nil(t, ledger.collectGarbage(context.Background()))
+	_, err = clientSet.CoreV1().ConfigMaps("ray-system").Get(context.Background(), ledger.configMapName(group), metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+// Test_SharedWorkerLedgerGroupLocks checks that an assignment in flight for one worker group doesn't
+// block the assignments of other worker groups.
+func Test_SharedWorkerLedgerGroupLocks(t *testing.T) {
+	ledger := newSharedWorkerLedger(setupConflictingClientSet(), "ray-system", setupInformer(), time.Minute)
+	busy := workerGroup{"test-namespace", "test-cluster", "busy-group"}
+	lock := ledger.groupLock(busy)
+	lock.Lock()
+	defer lock.Unlock()
+
+	done := make(chan error)
+	go func() {
+		_, _, err := ledger.assign(workerGroup{"test-namespace", "test-cluster", "test-group"}, 1, func() (map[slice][]int, error) {
+			return map[slice][]int{}, nil
+		}, false)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("assignment blocked by another worker group")
+	}
+	assert.Same(t, lock, ledger.groupLock(busy))
+}
+
+// Test_SharedWorkerLedgerDeletedPod deletes an admitted Pod before its reservation expires, the Pod
+// replacing it gets the same TPU_WORKER_ID from the replica that observed the deleted Pod.
+func Test_SharedWorkerLedgerDeletedPod(t *testing.T) {
+	group := workerGroup{"test-namespace", "test-cluster", "test-group"}
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+	clientSet := setupConflictingClientSet()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	podLister := listersv1.NewPodLister(indexer)
+	tpuWebhookServer := NewTPUWebhookServer(podLister, nil)
+	tpuWebhookServer.ledger = newSharedWorkerLedger(clientSet, "ray-system", podLister, time.Minute)
+
+	admissionReview := getTestAdmissionReview("Pod", "CREATE")
+	admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+	response, err := tpuWebhookServer.mutatePod(admissionReview, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, workerAssignment{0, 0}, mutateAssignment(t, response))
+
+	// the admitted Pod is observed, then deleted within the reservation's TTL
+	admitted := getTestInterceptedTPUPods(testPod, 1, 1, 4)[0]
+	admitted.UID = "pod-admitted"
+	assert.Nil(t, indexer.Add(admitted))
+	tpuWebhookServer.addPod(admitted)
+	assert.Nil(t, indexer.Delete(admitted))
+	tpuWebhookServer.deletePod(admitted)
+
+	// another replica that never observed the Pod keeps the reservation
+	otherLedger := newSharedWorkerLedger(clientSet, "ray-system", setupInformer(), time.Minute)
+	replicaIndex, workerID, err := otherLedger.assign(group, 4, func() (map[slice][]int, error) {
+		return map[slice][]int{}, nil
+	}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, workerAssignment{0, 1}, workerAssignment{replicaIndex, workerID})
+
+	// the replacement rejoins the slice of the deleted Pod
+	response, err = tpuWebhookServer.mutatePod(admissionReview, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, workerAssignment{0, 0}, mutateAssignment(t, response))
+}
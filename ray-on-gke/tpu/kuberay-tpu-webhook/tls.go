@@ -0,0 +1,484 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+
+	// validity of the self-signed CA and serving certificate, which are renewed once they
+	// expire within their renewal period
+	caValidity             = 10 * 365 * 24 * time.Hour
+	caRenewBefore          = 365 * 24 * time.Hour
+	servingCertValidity    = 365 * 24 * time.Hour
+	servingCertRenewBefore = 30 * 24 * time.Hour
+)
+
+// tlsVersions are the supported values of --tls-min-version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion returns the TLS version for a --tls-min-version value
+func parseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q, must be one of 1.0, 1.1, 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites returns the IDs of a comma-separated list of cipher suite names, as named by
+// crypto/tls. Cipher suites with known security issues are rejected.
+func parseCipherSuites(names string) ([]uint16, error) {
+	if names == "" {
+		return nil, nil
+	}
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if i := slices.IndexFunc(tls.CipherSuites(), func(c *tls.CipherSuite) bool { return c.Name == name }); i >= 0 {
+			ids = append(ids, tls.CipherSuites()[i].ID)
+			continue
+		}
+		if slices.ContainsFunc(tls.InsecureCipherSuites(), func(c *tls.CipherSuite) bool { return c.Name == name }) {
+			return nil, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+		return nil, fmt.Errorf("unknown cipher suite %s", name)
+	}
+	return ids, nil
+}
+
+// newTLSConfig returns the TLS configuration of the webhook server. Cipher suites only apply to
+// TLS 1.2 and earlier, TLS 1.3 cipher suites are not configurable.
+func newTLSConfig(minVersion string, cipherSuites string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+	version, err := parseTLSVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := parseCipherSuites(cipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     version,
+		CipherSuites:   suites,
+		GetCertificate: getCertificate,
+	}, nil
+}
+
+// certReloader serves the certificate and key in certFile and keyFile, and reloads them when the
+// files change, e.g. when cert-manager renews the mounted Secret.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newCertReloader returns a certReloader serving the current contents of certFile and keyFile
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the certificate and key if the files changed, and returns whether they did. The
+// previous certificate is kept if the files can't be loaded, for example while a Secret volume is
+// only partially updated.
+func (c *certReloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(c.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.RLock()
+	unchanged := bytes.Equal(certPEM, c.certPEM) && bytes.Equal(keyPEM, c.keyPEM)
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	c.cert, c.certPEM, c.keyPEM = &cert, certPEM, keyPEM
+	c.mu.Unlock()
+	return true, nil
+}
+
+// GetCertificate returns the current certificate, for use as tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// watch polls the certificate files for changes every interval until stopCh is closed. Polling
+// works for Secret volumes, which are updated by swapping a symlink.
+func (c *certReloader) watch(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			reloaded, err := c.reload()
+			if err != nil {
+				klog.ErrorS(err, "certReloader", "certFile", c.certFile, "keyFile", c.keyFile, "message", "keeping previous certificate")
+			} else if reloaded {
+				klog.V(0).InfoS("certReloader", "certFile", c.certFile, "message", "reloaded certificate")
+			}
+		}
+	}
+}
+
+// serviceDNSNames returns the DNS names the API server may use to reach the webhook Service
+func serviceDNSNames(service string, namespace string) []string {
+	return []string{
+		service,
+		fmt.Sprintf("%s.%s", service, namespace),
+		fmt.Sprintf("%s.%s.svc", service, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service, namespace),
+	}
+}
+
+// newCertificate creates a certificate from template, signed by the parent certificate and key, or
+// self-signed if parent is nil, and returns the PEM-encoded certificate and key
+func newCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// newCA returns a PEM-encoded self-signed CA certificate and key
+func newCA(now time.Time) ([]byte, []byte, error) {
+	return newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "kuberay-tpu-webhook-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+}
+
+// newServingCert returns a PEM-encoded serving certificate and key for dnsNames, signed by the CA
+func newServingCert(caCertPEM []byte, caKeyPEM []byte, dnsNames []string, now time.Time) ([]byte, []byte, error) {
+	caCert, err := parseCertificate(caCertPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(caKeyPEM)
+	if block == nil {
+		return nil, nil, errors.New("CA key is not PEM-encoded")
+	}
+	caKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:    dnsNames,
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(servingCertValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, caKey)
+}
+
+// parseCertificate parses the first certificate of a PEM bundle
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate is not PEM-encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// caNeedsRenewal returns whether the CA is missing, invalid or close to expiry
+func caNeedsRenewal(caCertPEM []byte, caKeyPEM []byte, now time.Time) bool {
+	caCert, err := parseCertificate(caCertPEM)
+	if err != nil || len(caKeyPEM) == 0 {
+		return true
+	}
+	return now.Add(caRenewBefore).After(caCert.NotAfter)
+}
+
+// servingCertNeedsRenewal returns whether the serving certificate is missing, close to expiry, not
+// valid for all dnsNames or not signed by the CA
+func servingCertNeedsRenewal(certPEM []byte, keyPEM []byte, caCertPEM []byte, dnsNames []string, now time.Time) bool {
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return true
+	}
+	cert, err := parseCertificate(certPEM)
+	if err != nil || now.Add(servingCertRenewBefore).After(cert.NotAfter) {
+		return true
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caCertPEM) {
+		return true
+	}
+	for _, dnsName := range dnsNames {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: dnsName, Roots: roots, CurrentTime: now}); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// certBootstrapper issues the webhook's serving certificate from a self-signed CA. The CA and
+// certificate are stored in a Secret shared by all webhook replicas, written to the certificate
+// directory and the CA is patched into the caBundle of the webhook configurations.
+type certBootstrapper struct {
+	client                  kubernetes.Interface
+	namespace               string
+	secretName              string
+	serviceName             string
+	certDir                 string
+	mutatingWebhookConfig   string
+	validatingWebhookConfig string
+	now                     func() time.Time
+}
+
+// reconcile creates or renews the certificates in the Secret, writes them to the certificate
+// directory and injects the CA into the webhook configurations
+func (b *certBootstrapper) reconcile(ctx context.Context) error {
+	var secret *corev1.Secret
+	err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		var err error
+		secret, err = b.ensureSecret(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("reconcile Secret %s/%s: %w", b.namespace, b.secretName, err)
+	}
+
+	files := map[string][]byte{
+		corev1.TLSCertKey:       secret.Data[corev1.TLSCertKey],
+		corev1.TLSPrivateKeyKey: secret.Data[corev1.TLSPrivateKeyKey],
+		caCertFile:              secret.Data[caCertFile],
+	}
+	for name, data := range files {
+		if err := writeFileAtomic(filepath.Join(b.certDir, name), data); err != nil {
+			return err
+		}
+	}
+	return injectCABundle(ctx, b.client, b.mutatingWebhookConfig, b.validatingWebhookConfig, secret.Data[caCertFile])
+}
+
+// ensureSecret returns the Secret holding valid certificates, after creating or renewing them if needed.
+// The serving certificate is renewed with the existing CA, so that the caBundle stays the same.
+func (b *certBootstrapper) ensureSecret(ctx context.Context) (*corev1.Secret, error) {
+	now := b.now()
+	dnsNames := serviceDNSNames(b.serviceName, b.namespace)
+	secret, err := b.client.CoreV1().Secrets(b.namespace).Get(ctx, b.secretName, metav1.GetOptions{})
+	notFound := apierrors.IsNotFound(err)
+	if err != nil && !notFound {
+		return nil, err
+	}
+	if notFound {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: b.secretName, Namespace: b.namespace},
+			Type:       corev1.SecretTypeTLS,
+		}
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+
+	data := secret.Data
+	renewed := false
+	if caNeedsRenewal(data[caCertFile], data[caKeyFile], now) {
+		klog.V(0).InfoS("certBootstrapper", "Secret", b.namespace+"/"+b.secretName, "message", "creating self-signed CA")
+		if data[caCertFile], data[caKeyFile], err = newCA(now); err != nil {
+			return nil, err
+		}
+		renewed = true
+	}
+	if renewed || servingCertNeedsRenewal(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey], data[caCertFile], dnsNames, now) {
+		klog.V(0).InfoS("certBootstrapper", "Secret", b.namespace+"/"+b.secretName, "DNS names", dnsNames, "message", "issuing serving certificate")
+		if data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey], err = newServingCert(data[caCertFile], data[caKeyFile], dnsNames, now); err != nil {
+			return nil, err
+		}
+		renewed = true
+	}
+
+	switch {
+	case notFound:
+		return b.client.CoreV1().Secrets(b.namespace).Create(ctx, secret, metav1.CreateOptions{})
+	case renewed:
+		return b.client.CoreV1().Secrets(b.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	return secret, nil
+}
+
+// setCABundle sets the caBundle of webhook client configs, and returns whether any of them changed
+func setCABundle(caBundle []byte, clientConfigs ...*admissionregistrationv1.WebhookClientConfig) bool {
+	changed := false
+	for _, clientConfig := range clientConfigs {
+		if !bytes.Equal(clientConfig.CABundle, caBundle) {
+			clientConfig.CABundle = caBundle
+			changed = true
+		}
+	}
+	return changed
+}
+
+// injectCABundle sets the caBundle of every webhook in the Mutating and Validating WebhookConfigurations.
+// Configurations that don't exist are skipped.
+func injectCABundle(ctx context.Context, client kubernetes.Interface, mutatingWebhookConfig string, validatingWebhookConfig string, caBundle []byte) error {
+	mutatingConfigs := client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	validatingConfigs := client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	updates := []struct {
+		kind   string
+		name   string
+		update func() error
+	}{
+		{"MutatingWebhookConfiguration", mutatingWebhookConfig, func() error {
+			config, err := mutatingConfigs.Get(ctx, mutatingWebhookConfig, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			var clientConfigs []*admissionregistrationv1.WebhookClientConfig
+			for i := range config.Webhooks {
+				clientConfigs = append(clientConfigs, &config.Webhooks[i].ClientConfig)
+			}
+			if !setCABundle(caBundle, clientConfigs...) {
+				return nil
+			}
+			_, err = mutatingConfigs.Update(ctx, config, metav1.UpdateOptions{})
+			return err
+		}},
+		{"ValidatingWebhookConfiguration", validatingWebhookConfig, func() error {
+			config, err := validatingConfigs.Get(ctx, validatingWebhookConfig, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			var clientConfigs []*admissionregistrationv1.WebhookClientConfig
+			for i := range config.Webhooks {
+				clientConfigs = append(clientConfigs, &config.Webhooks[i].ClientConfig)
+			}
+			if !setCABundle(caBundle, clientConfigs...) {
+				return nil
+			}
+			_, err = validatingConfigs.Update(ctx, config, metav1.UpdateOptions{})
+			return err
+		}},
+	}
+
+	for _, u := range updates {
+		if u.name == "" {
+			continue
+		}
+		err := retry.RetryOnConflict(retry.DefaultRetry, u.update)
+		if apierrors.IsNotFound(err) {
+			klog.V(0).InfoS("injectCABundle", u.kind, u.name, "message", "not found, skipping")
+		} else if err != nil {
+			return fmt.Errorf("inject caBundle into %s %s: %w", u.kind, u.name, err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces filename with data, so that the certReloader never reads a partially
+// written file. Files with unchanged contents are left alone.
+func writeFileAtomic(filename string, data []byte) error {
+	if current, err := os.ReadFile(filename); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
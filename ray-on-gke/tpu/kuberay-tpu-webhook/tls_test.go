@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_NewTLSConfig(t *testing.T) {
+	tests := map[string]struct {
+		minVersion      string
+		cipherSuites    string
+		expectedVersion uint16
+		expectedSuites  []uint16
+		expectedError   bool
+	}{
+		"default": {
+			minVersion:      "1.2",
+			expectedVersion: tls.VersionTLS12,
+		},
+		"TLS 1.3": {
+			minVersion:      "1.3",
+			expectedVersion: tls.VersionTLS13,
+		},
+		"cipher suites": {
+			minVersion:      "1.2",
+			cipherSuites:    "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			expectedVersion: tls.VersionTLS12,
+			expectedSuites:  []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		},
+		"unsupported version": {
+			minVersion:    "1.4",
+			expectedError: true,
+		},
+		"insecure cipher suite": {
+			minVersion:    "1.2",
+			cipherSuites:  "TLS_RSA_WITH_RC4_128_SHA",
+			expectedError: true,
+		},
+		"unknown cipher suite": {
+			minVersion:    "1.2",
+			cipherSuites:  "TLS_NOT_A_CIPHER",
+			expectedError: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config, err := newTLSConfig(tc.minVersion, tc.cipherSuites, nil)
+			if tc.expectedError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectedVersion, config.MinVersion)
+			assert.Equal(t, tc.expectedSuites, config.CipherSuites)
+		})
+	}
+}
+
+func Test_CertReloader(t *testing.T) {
+	now := time.Now()
+	caCert, caKey, err := newCA(now)
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	// the reloader serves the initial certificate
+	cert, key, err := newServingCert(caCert, caKey, []string{"first.ray-system.svc"}, now)
+	assert.Nil(t, err)
+	assert.Nil(t, writeFileAtomic(certFile, cert))
+	assert.Nil(t, writeFileAtomic(keyFile, key))
+	reloader, err := newCertReloader(certFile, keyFile)
+	assert.Nil(t, err)
+	served, _ := reloader.GetCertificate(nil)
+	leaf, _ := x509.ParseCertificate(served.Certificate[0])
+	assert.Equal(t, []string{"first.ray-system.svc"}, leaf.DNSNames)
+
+	// unchanged files are not reloaded
+	reloaded, err := reloader.reload()
+	assert.Nil(t, err)
+	assert.False(t, reloaded)
+
+	// a certificate that doesn't match the key keeps the previous certificate
+	cert, key, err = newServingCert(caCert, caKey, []string{"second.ray-system.svc"}, now)
+	assert.Nil(t, err)
+	assert.Nil(t, writeFileAtomic(certFile, cert))
+	reloaded, err = reloader.reload()
+	assert.NotNil(t, err)
+	assert.False(t, reloaded)
+	served, _ = reloader.GetCertificate(nil)
+	leaf, _ = x509.ParseCertificate(served.Certificate[0])
+	assert.Equal(t, []string{"first.ray-system.svc"}, leaf.DNSNames)
+
+	// the renewed certificate is served once both files are updated
+	assert.Nil(t, writeFileAtomic(keyFile, key))
+	reloaded, err = reloader.reload()
+	assert.Nil(t, err)
+	assert.True(t, reloaded)
+	served, _ = reloader.GetCertificate(nil)
+	leaf, _ = x509.ParseCertificate(served.Certificate[0])
+	assert.Equal(t, []string{"second.ray-system.svc"}, leaf.DNSNames)
+}
+
+func Test_CertBootstrapper(t *testing.T) {
+	failurePolicy := admissionregistrationv1.Fail
+	mutatingConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "kuberay-tpu-mutating-webhook-cfg"},
+		Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "kuberay-tpu-webhook.ray-system.svc", FailurePolicy: &failurePolicy}},
+	}
+	client := fake.NewSimpleClientset(mutatingConfig)
+
+	now := time.Now()
+	dir := t.TempDir()
+	bootstrapper := &certBootstrapper{
+		client:                  client,
+		namespace:               "ray-system",
+		secretName:              "kuberay-tpu-webhook-self-signed-certs",
+		serviceName:             "kuberay-tpu-webhook",
+		certDir:                 dir,
+		mutatingWebhookConfig:   "kuberay-tpu-mutating-webhook-cfg",
+		validatingWebhookConfig: "kuberay-tpu-validating-webhook-cfg", // missing configurations are skipped
+		now:                     func() time.Time { return now },
+	}
+	ctx := context.Background()
+
+	// the CA and serving certificate are created and written to the certificate directory
+	assert.Nil(t, bootstrapper.reconcile(ctx))
+	secret, err := client.CoreV1().Secrets("ray-system").Get(ctx, "kuberay-tpu-webhook-self-signed-certs", metav1.GetOptions{})
+	assert.Nil(t, err)
+	caCert := secret.Data[caCertFile]
+	assert.False(t, servingCertNeedsRenewal(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey], caCert, serviceDNSNames("kuberay-tpu-webhook", "ray-system"), now))
+	for _, name := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, caCertFile} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		assert.Equal(t, secret.Data[name], data)
+	}
+	_, err = os.Stat(filepath.Join(dir, caKeyFile))
+	assert.True(t, os.IsNotExist(err))
+
+	// the CA is injected into every webhook
+	config, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "kuberay-tpu-mutating-webhook-cfg", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, caCert, config.Webhooks[0].ClientConfig.CABundle)
+
+	// valid certificates are reused, e.g. by other replicas
+	assert.Nil(t, bootstrapper.reconcile(ctx))
+	renewedSecret, _ := client.CoreV1().Secrets("ray-system").Get(ctx, "kuberay-tpu-webhook-self-signed-certs", metav1.GetOptions{})
+	assert.Equal(t, secret.Data, renewedSecret.Data)
+
+	// the serving certificate is renewed close to expiry, with the same CA
+	now = now.Add(servingCertValidity - servingCertRenewBefore + time.Hour)
+	assert.Nil(t, bootstrapper.reconcile(ctx))
+	renewedSecret, _ = client.CoreV1().Secrets("ray-system").Get(ctx, "kuberay-tpu-webhook-self-signed-certs", metav1.GetOptions{})
+	assert.Equal(t, caCert, renewedSecret.Data[caCertFile])
+	assert.NotEqual(t, secret.Data[corev1.TLSCertKey], renewedSecret.Data[corev1.TLSCertKey])
+	data, _ := os.ReadFile(filepath.Join(dir, corev1.TLSCertKey))
+	assert.Equal(t, renewedSecret.Data[corev1.TLSCertKey], data)
+}
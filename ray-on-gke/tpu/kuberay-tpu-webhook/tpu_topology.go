@@ -0,0 +1,149 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// tpuGeneration describes the TPU VM shapes available for a gke-tpu-accelerator.
+type tpuGeneration struct {
+	// dimensions of the gke-tpu-topology
+	dimensions int
+	// singleHostTopologies maps topologies served by a single TPU VM to its number of chips
+	singleHostTopologies map[string]int64
+	// multiHostChipsPerHost is the number of chips of every TPU VM in a multi-host slice, 0 if the
+	// generation has no multi-host slices
+	multiHostChipsPerHost int64
+	// multiHostTopologies are the allowed multi-host topologies, if nil every topology of the right
+	// dimensions with a multiple of multiHostChipsPerHost chips is allowed
+	multiHostTopologies []string
+}
+
+// unknownMultiHostChipsPerHost is the number of chips assumed for every TPU VM in a multi-host slice of
+// an unknown gke-tpu-accelerator, which every multi-host TPU generation has
+const unknownMultiHostChipsPerHost = 4
+
+// tpuGenerations are the TPU generations by gke-tpu-accelerator, see
+// https://cloud.google.com/kubernetes-engine/docs/concepts/tpus#plan-tpu-configuration
+var tpuGenerations = map[string]tpuGeneration{
+	"tpu-v4-podslice": {
+		dimensions:            3,
+		singleHostTopologies:  map[string]int64{"2x2x1": 4},
+		multiHostChipsPerHost: 4,
+	},
+	"tpu-v5p-slice": {
+		dimensions:            3,
+		singleHostTopologies:  map[string]int64{"2x2x1": 4},
+		multiHostChipsPerHost: 4,
+	},
+	"tpu-v5-lite-device": {
+		dimensions:           2,
+		singleHostTopologies: map[string]int64{"1x1": 1, "2x2": 4, "2x4": 8},
+	},
+	"tpu-v5-lite-podslice": {
+		dimensions:            2,
+		singleHostTopologies:  map[string]int64{"1x1": 1, "2x2": 4, "2x4": 8},
+		multiHostChipsPerHost: 4,
+		multiHostTopologies:   []string{"2x4", "4x4", "4x8", "8x8", "8x16", "16x16"},
+	},
+	"tpu-v6e-slice": {
+		dimensions:            2,
+		singleHostTopologies:  map[string]int64{"1x1": 1, "2x2": 4, "2x4": 8},
+		multiHostChipsPerHost: 4,
+		multiHostTopologies:   []string{"2x4", "4x4", "4x8", "8x8", "8x16", "16x16"},
+	},
+}
+
+// parseTopology returns the dimensions of a gke-tpu-topology
+func parseTopology(topology string) ([]int64, error) {
+	if topology == "" {
+		return nil, errors.New("TPU topology not specified")
+	}
+	var dims []int64
+	for _, value := range strings.Split(topology, "x") {
+		dim, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if dim < 1 {
+			return nil, fmt.Errorf("invalid TPU topology %s", topology)
+		}
+		dims = append(dims, dim)
+	}
+	return dims, nil
+}
+
+// getTPUSliceShape returns the number of TPU VM hosts of a slice and the number of chips of each host
+// for an accelerator and topology. Where a topology is served by different TPU VM shapes, such as a
+// v5e 2x4 by a single 8-chip host or two 4-chip hosts, the smallest host that fits the chips requested
+// per Pod is used. For unknown accelerators every Pod is assumed to use one host, so the chips requested
+// per Pod must evenly divide the topology.
+func getTPUSliceShape(accelerator string, topology string, chipsRequested int64) (int32, int64, error) {
+	dims, err := parseTopology(topology)
+	if err != nil {
+		return 0, 0, err
+	}
+	chips := int64(1)
+	for _, dim := range dims {
+		chips *= dim
+	}
+
+	generation, ok := tpuGenerations[accelerator]
+	if !ok {
+		switch {
+		case chipsRequested <= 0:
+			return 1, chips, nil
+		case chipsRequested > chips:
+			return 0, 0, fmt.Errorf("google.com/tpu request of %d chips exceeds the %d chips of topology %s", chipsRequested, chips, topology)
+		case chips%chipsRequested != 0:
+			return 0, 0, fmt.Errorf("google.com/tpu request of %d chips doesn't evenly divide the %d chips of topology %s", chipsRequested, chips, topology)
+		}
+		return int32(chips / chipsRequested), chipsRequested, nil
+	}
+	if len(dims) != generation.dimensions {
+		return 0, 0, fmt.Errorf("topology %s is not a %d-dimensional %s topology", topology, generation.dimensions, accelerator)
+	}
+
+	// chips per host of the TPU VM shapes serving the topology, in increasing order
+	var hostSizes []int64
+	if hostChips, ok := generation.singleHostTopologies[topology]; ok {
+		hostSizes = append(hostSizes, hostChips)
+	}
+	multiHost := generation.multiHostChipsPerHost > 0 && chips > generation.multiHostChipsPerHost && chips%generation.multiHostChipsPerHost == 0
+	if multiHost && generation.multiHostTopologies != nil {
+		multiHost = slices.Contains(generation.multiHostTopologies, topology)
+	}
+	if multiHost {
+		hostSizes = append(hostSizes, generation.multiHostChipsPerHost)
+	}
+	if len(hostSizes) == 0 {
+		return 0, 0, fmt.Errorf("topology %s is not supported by %s", topology, accelerator)
+	}
+	slices.Sort(hostSizes)
+
+	for _, hostChips := range hostSizes {
+		if chipsRequested <= hostChips {
+			return int32(chips / hostChips), hostChips, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("google.com/tpu request of %d chips exceeds the %d chips per TPU VM host of %s topology %s", chipsRequested, hostSizes[len(hostSizes)-1], accelerator, topology)
+}
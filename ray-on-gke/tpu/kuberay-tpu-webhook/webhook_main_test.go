@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
 	"github.com/ray-project/kuberay/ray-operator/controllers/ray/utils"
@@ -439,12 +440,20 @@ func Test_GetReplicaIndex(t *testing.T) {
 			},
 			expectedReplicaIndex: 3,
 		},
+		"multi-slice worker group with deleted slice": {
+			// should assign Pod to replica 0 since all Pods of that slice were deleted
+			sliceToWorkerIDs: map[slice][]int{
+				slice{"test-cluster", "test-group", "test-namespace", 1, int32(4)}: []int{0, 1, 2, 3},
+				slice{"test-cluster", "test-group", "test-namespace", 2, int32(4)}: []int{0, 1, 2, 3},
+			},
+			expectedReplicaIndex: 0,
+		},
 	}
 
 	// validate getReplicaIndex() returns the expected Replica ID for TPU pods in varying pod slices
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			replicaIndex := getReplicaIndex(tc.sliceToWorkerIDs, "test-cluster", "test-group", "test-namespace")
+			replicaIndex := getReplicaIndex(tc.sliceToWorkerIDs, workerGroup{"test-namespace", "test-cluster", "test-group"})
 			assert.Equal(t, tc.expectedReplicaIndex, replicaIndex)
 		})
 	}
@@ -570,6 +579,7 @@ func Test_ContainerRequestingTPUs(t *testing.T) {
 
 func Test_GetNumTPUHostsFromTopology(t *testing.T) {
 	tests := map[string]struct {
+		accelerator   string
 		topology      string
 		chipsPerHost  int64
 		expectedHosts int32
@@ -611,18 +621,80 @@ func Test_GetNumTPUHostsFromTopology(t *testing.T) {
 			expectedHosts: int32(4),
 			chipsPerHost:  int64(4),
 		},
+		"getNumTPUHostsFromTopology with v5p 2x4x4 topology": {
+			// v5p - 2x4x4 has 4 chips per VM, should return 8 TPU VMs
+			accelerator:   "tpu-v5p-slice",
+			topology:      "2x4x4",
+			expectedHosts: int32(8),
+			chipsPerHost:  int64(4),
+		},
+		"getNumTPUHostsFromTopology with v4 partial host": {
+			// v4 - 2x2x2 with 2 chips per Pod still uses 4-chip VMs, should return 2 TPU VMs
+			accelerator:   "tpu-v4-podslice",
+			topology:      "2x2x2",
+			expectedHosts: int32(2),
+			chipsPerHost:  int64(2),
+		},
+		"getNumTPUHostsFromTopology with v5e ct5lp-hightpu-8t 2x4 topology": {
+			// v5e - 2x4 with 8 chips per Pod is served by a single 8-chip VM
+			accelerator:   "tpu-v5-lite-podslice",
+			topology:      "2x4",
+			expectedHosts: int32(1),
+			chipsPerHost:  int64(8),
+		},
+		"getNumTPUHostsFromTopology with v6e 4x8 topology": {
+			// v6e - 4x8 has 4 chips per VM, should return 8 TPU VMs
+			accelerator:   "tpu-v6e-slice",
+			topology:      "4x8",
+			expectedHosts: int32(8),
+			chipsPerHost:  int64(4),
+		},
+		"getNumTPUHostsFromTopology with v5e device multi-host topology": {
+			// tpu-v5-lite-device is single-host only - returns error
+			accelerator:   "tpu-v5-lite-device",
+			topology:      "4x4",
+			chipsPerHost:  int64(4),
+			expectedError: errors.New("topology 4x4 is not supported by tpu-v5-lite-device"),
+		},
+		"getNumTPUHostsFromTopology with v6e unsupported topology": {
+			// v6e - 2x8 is not an allowed topology - returns error
+			accelerator:   "tpu-v6e-slice",
+			topology:      "2x8",
+			chipsPerHost:  int64(4),
+			expectedError: errors.New("topology 2x8 is not supported by tpu-v6e-slice"),
+		},
+		"getNumTPUHostsFromTopology with v4 2-dimensional topology": {
+			// v4 topologies are 3-dimensional - returns error
+			accelerator:   "tpu-v4-podslice",
+			topology:      "2x2",
+			chipsPerHost:  int64(4),
+			expectedError: errors.New("topology 2x2 is not a 3-dimensional tpu-v4-podslice topology"),
+		},
+		"getNumTPUHostsFromTopology with unknown accelerator and request not dividing topology": {
+			topology:      "2x2x2",
+			chipsPerHost:  3,
+			expectedError: errors.New("google.com/tpu request of 3 chips doesn't evenly divide the 8 chips of topology 2x2x2"),
+		},
+		"getNumTPUHostsFromTopology with unknown accelerator and request exceeding topology": {
+			topology:      "2x2x1",
+			chipsPerHost:  8,
+			expectedError: errors.New("google.com/tpu request of 8 chips exceeds the 4 chips of topology 2x2x1"),
+		},
+		"getNumTPUHostsFromTopology with request exceeding host": {
+			// v4 VMs have 4 chips - returns error
+			accelerator:   "tpu-v4-podslice",
+			topology:      "2x2x2",
+			chipsPerHost:  int64(8),
+			expectedError: errors.New("google.com/tpu request of 8 chips exceeds the 4 chips per TPU VM host of tpu-v4-podslice topology 2x2x2"),
+		},
 	}
 
 	// validate that getNumTPUHostsFromTopology returns the expected # TPU VM Hosts for varying TPU podslice types
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			vms, err := getNumTPUHostsFromTopology("test-cluster", "test-group", "test-namespace", tc.topology, tc.chipsPerHost)
-			if err == nil {
-				assert.Equal(t, tc.expectedHosts, vms)
-			}
-			if tc.topology == "" {
-				assert.Equal(t, tc.expectedError, err)
-			}
+			vms, err := getNumTPUHostsFromTopology("test-cluster", "test-group", "test-namespace", tc.accelerator, tc.topology, tc.chipsPerHost)
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedHosts, vms)
 		})
 	}
 }
@@ -694,18 +766,33 @@ func Test_ExtractPod(t *testing.T) {
 	tests := map[string]struct {
 		testPod       *corev1.Pod
 		expectedKind  string
+		operation     string
 		expectedError error
 	}{
 		"extractPod with wrong admissionRequest Kind": {
 			// should return an error since Kind != Pod
 			testPod:       getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x1", "4"),
 			expectedKind:  "RayCluster",
+			operation:     "CREATE",
 			expectedError: errors.New("Expected Pod but got RayCluster"),
 		},
 		"extractPod with admissionRequest Kind == Pod": {
 			// should successfully unmarshal the Pod object
 			testPod:      getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x1", "4"),
 			expectedKind: "Pod",
+			operation:    "CREATE",
+		},
+		"extractPod with UPDATE admissionRequest": {
+			// should successfully unmarshal the updated Pod object
+			testPod:      getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x1", "4"),
+			expectedKind: "Pod",
+			operation:    "UPDATE",
+		},
+		"extractPod with DELETE admissionRequest": {
+			// should successfully unmarshal the deleted Pod object from OldObject
+			testPod:      getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x1", "4"),
+			expectedKind: "Pod",
+			operation:    "DELETE",
 		},
 	}
 
@@ -713,10 +800,15 @@ func Test_ExtractPod(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			// set up admissionReview object
-			admissionReview := getTestAdmissionReview(tc.expectedKind, "CREATE")
+			admissionReview := getTestAdmissionReview(tc.expectedKind, tc.operation)
 			jsonPod, _ := json.Marshal(tc.testPod)
-			admissionReview.Request.Object.Raw = jsonPod
-			admissionReview.Request.Object.Object = tc.testPod
+			if tc.operation == "DELETE" {
+				admissionReview.Request.OldObject.Raw = jsonPod
+				admissionReview.Request.OldObject.Object = tc.testPod
+			} else {
+				admissionReview.Request.Object.Raw = jsonPod
+				admissionReview.Request.Object.Object = tc.testPod
+			}
 
 			// set Request Kind
 			admissionReview.Request.Kind.Kind = tc.expectedKind
@@ -873,13 +965,12 @@ func Test_InjectHostnames(t *testing.T) {
 			testPod := getTestTPUWorker(tc.clusterName, tc.groupName, "test-namespace", "tpu-v4-podslice", "2x2x2", "4")
 			expectedEnv := []corev1.EnvVar{corev1.EnvVar{Name: "TPU_WORKER_HOSTNAMES", Value: tc.expectedHostnames}}
 			patches := []patch{}
-			injectHostnames(tc.clusterName, tc.expectedHostnames, "/spec/containers/0/env", testPod.Spec.Containers[0], &patches)
-			// check subdomain patch
-			assert.Equal(t, "/spec/subdomain", patches[0]["path"])
-			assert.Equal(t, tc.expectedSubdomain, patches[0]["value"])
+			injectEnv("/spec/containers/0/env", testPod.Spec.Containers[0], &patches, expectedEnv...)
+			// check subdomain, which mutatePod injects with the hostnames
+			assert.Equal(t, tc.expectedSubdomain, generateHeadlessServiceName(tc.clusterName))
 			// check hostnames patch
-			assert.Equal(t, "/spec/containers/0/env", patches[1]["path"])
-			assert.Equal(t, expectedEnv, patches[1]["value"])
+			assert.Equal(t, "/spec/containers/0/env", patches[0]["path"])
+			assert.Equal(t, expectedEnv, patches[0]["value"])
 		})
 	}
 }
@@ -902,7 +993,7 @@ func Test_InjectReplicaLabel(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			expectedPatches := []patch{}
-			injectReplicaLabel("test-cluster", "test-namespace", tc.replicaIndex, tc.groupName, &expectedPatches)
+			injectReplicaLabel("test-cluster", "test-namespace", tc.replicaIndex, tc.groupName, "replicaIndex", &expectedPatches)
 			assert.Equal(t, "/metadata/labels/replicaIndex", expectedPatches[0]["path"])
 			assert.Equal(t, tc.expectedReplicaLabel, expectedPatches[0]["value"])
 		})
@@ -929,7 +1020,7 @@ func Test_InjectPodAffinity(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			expectedPatches := []patch{}
-			injectPodAffinity(tc.testPod, tc.replicaIndex, tc.groupName, &expectedPatches)
+			injectPodAffinity(tc.testPod, tc.replicaIndex, tc.groupName, defaultInjectionPolicy(), &expectedPatches)
 			patchValue := expectedPatches[0]["value"]
 			affinity := patchValue.(corev1.Affinity)
 			assert.Equal(t, "/spec/affinity", expectedPatches[0]["path"])
@@ -1007,11 +1098,28 @@ func Test_CheckWorkersMatchTopology(t *testing.T) {
 		"checkWorkersMatchTopology v5 multi-host NumOfHosts equal to specified topology": {
 			// topology matches NumOfHosts, returns true
 			expectedNumOfHosts:  2,
-			expectedAccelerator: "tpu-v5-lite-device",
+			expectedAccelerator: "tpu-v5-lite-podslice",
 			expectedTopology:    "2x4",
 			expectedTPUChips:    "4",
 			workersMatch:        true,
 		},
+		"checkWorkersMatchTopology v5 single-host accelerator with multi-host NumOfHosts": {
+			// tpu-v5-lite-device 2x4 is a single 8-chip VM, returns false
+			expectedNumOfHosts:  2,
+			expectedAccelerator: "tpu-v5-lite-device",
+			expectedTopology:    "2x4",
+			expectedTPUChips:    "4",
+			workersMatch:        false,
+		},
+		"checkWorkersMatchTopology unsupported topology": {
+			// tpu-v6e-slice has no 2x8 topology, returns false and an error
+			expectedNumOfHosts:  4,
+			expectedAccelerator: "tpu-v6e-slice",
+			expectedTopology:    "2x8",
+			expectedTPUChips:    "4",
+			expectedError:       errors.New("topology 2x8 is not supported by tpu-v6e-slice"),
+			workersMatch:        false,
+		},
 	}
 
 	// validate checkWorkersMatchTopology returns true only when NumOfHosts == # TPU VMs specified by topology
@@ -1025,9 +1133,7 @@ func Test_CheckWorkersMatchTopology(t *testing.T) {
 
 			workersMatchTopology, err := checkWorkersMatchTopology("test-cluster", "test-namespace", *workerGroupSpec)
 
-			if tc.expectedNumOfHosts == 0 || tc.missingContainers == true || tc.expectedTopology == "" {
-				assert.Equal(t, tc.expectedError, err)
-			}
+			assert.Equal(t, tc.expectedError, err)
 			assert.Equal(t, tc.workersMatch, workersMatchTopology)
 		})
 	}
@@ -1066,7 +1172,7 @@ func Test_ValidateRayCluster(t *testing.T) {
 			expectedAllowed: false,
 			expectedResult: &metav1.Status{
 				Status:  "Failure",
-				Message: "Number of workers in worker group not equal to specified topology",
+				Message: "worker group test-group: Number of workers in worker group not equal to specified topology",
 			},
 		},
 		"validateRayCluster RayCluster with single-slice, single-host TPU worker group": {
@@ -1140,6 +1246,148 @@ func Test_ValidateRayCluster(t *testing.T) {
 	}
 }
 
+func Test_ValidateRayClusterUpdate(t *testing.T) {
+	getTestRayClusterWith := func(modify func(workerGroupSpec *rayv1.WorkerGroupSpec)) *rayv1.RayCluster {
+		rayCluster := getTestRayCluster("test-cluster", "test-group", "test-namespace", int32(4), 2, "4", "tpu-v4-podslice", "2x2x4", false)
+		modify(&rayCluster.Spec.WorkerGroupSpecs[0])
+		return rayCluster
+	}
+	unchanged := func(workerGroupSpec *rayv1.WorkerGroupSpec) {}
+	// RayCluster admitted before the replicas rules, or before the webhook was installed
+	getInvalidRayClusterWith := func(modify func(rayCluster *rayv1.RayCluster)) *rayv1.RayCluster {
+		rayCluster := getTestRayClusterWith(func(workerGroupSpec *rayv1.WorkerGroupSpec) {
+			workerGroupSpec.NumOfHosts = 2
+			workerGroupSpec.MinReplicas = pointer.Int32(4)
+		})
+		modify(rayCluster)
+		return rayCluster
+	}
+
+	tests := map[string]struct {
+		oldRayCluster    *rayv1.RayCluster
+		rayCluster       *rayv1.RayCluster
+		expectedAllowed  bool
+		expectedMessages []string
+	}{
+		"scale up multi-host worker group": {
+			// replicas within minReplicas and maxReplicas, admit
+			oldRayCluster: getTestRayClusterWith(unchanged),
+			rayCluster: getTestRayClusterWith(func(workerGroupSpec *rayv1.WorkerGroupSpec) {
+				workerGroupSpec.Replicas = pointer.Int32(8)
+			}),
+			expectedAllowed: true,
+		},
+		"scale above maxReplicas": {
+			// replicas greater than maxReplicas, deny
+			oldRayCluster: getTestRayClusterWith(unchanged),
+			rayCluster: getTestRayClusterWith(func(workerGroupSpec *rayv1.WorkerGroupSpec) {
+				workerGroupSpec.MaxReplicas = pointer.Int32(1)
+			}),
+			expectedAllowed:  false,
+			expectedMessages: []string{"worker group test-group: replicas 2 greater than maxReplicas 1"},
+		},
+		"change topology of live worker group": {
+			// topology and NumOfHosts match, but existing slices would no longer match the worker group, deny
+			oldRayCluster: getTestRayClusterWith(unchanged),
+			rayCluster: getTestRayClusterWith(func(workerGroupSpec *rayv1.WorkerGroupSpec) {
+				workerGroupSpec.NumOfHosts = 2
+				workerGroupSpec.Template.Spec.NodeSelector["cloud.google.com/gke-tpu-topology"] = "2x2x2"
+			}),
+			expectedAllowed: false,
+			expectedMessages: []string{
+				`worker group test-group: gke-tpu-topology can't be changed from "2x2x4" to "2x2x2"`,
+				"worker group test-group: NumOfHosts can't be changed from 4 to 2",
+			},
+		},
+		"add worker group": {
+			// added worker groups are validated like on creation, all violations are returned
+			oldRayCluster: getTestRayClusterWith(unchanged),
+			rayCluster: func() *rayv1.RayCluster {
+				rayCluster := getTestRayClusterWith(unchanged)
+				workerGroupSpec := getTestTPUWorkerGroup("new-group", 2, 3, "tpu-v4-podslice", "2x2x4", "4")
+				workerGroupSpec.MinReplicas = pointer.Int32(4)
+				rayCluster.Spec.WorkerGroupSpecs = append(rayCluster.Spec.WorkerGroupSpecs, *workerGroupSpec)
+				return rayCluster
+			}(),
+			expectedAllowed: false,
+			expectedMessages: []string{
+				"worker group new-group: Number of workers in worker group not equal to specified topology",
+				"worker group new-group: replicas 3 less than minReplicas 4",
+			},
+		},
+		"finalizer-only update of invalid RayCluster": {
+			// metadata-only updates don't revalidate worker groups, admit
+			oldRayCluster: getInvalidRayClusterWith(func(rayCluster *rayv1.RayCluster) {
+				rayCluster.Finalizers = []string{"ray.io/gcs-ft-redis-cleanup-finalizer"}
+			}),
+			rayCluster:      getInvalidRayClusterWith(func(rayCluster *rayv1.RayCluster) {}),
+			expectedAllowed: true,
+		},
+		"autoscaler replicas patch of invalid RayCluster": {
+			// replicas changes don't revalidate worker groups, admit
+			oldRayCluster: getInvalidRayClusterWith(func(rayCluster *rayv1.RayCluster) {}),
+			rayCluster: getInvalidRayClusterWith(func(rayCluster *rayv1.RayCluster) {
+				rayCluster.Spec.WorkerGroupSpecs[0].Replicas = pointer.Int32(1)
+				rayCluster.Spec.WorkerGroupSpecs[0].ScaleStrategy.WorkersToDelete = []string{"test-group-worker-0"}
+			}),
+			expectedAllowed: true,
+		},
+		"change invalid RayCluster": {
+			// changed worker groups are validated again, all violations are returned
+			oldRayCluster: getInvalidRayClusterWith(func(rayCluster *rayv1.RayCluster) {}),
+			rayCluster: getInvalidRayClusterWith(func(rayCluster *rayv1.RayCluster) {
+				rayCluster.Spec.WorkerGroupSpecs[0].Template.Spec.Containers[0].Image = "rayproject/ray:latest"
+			}),
+			expectedAllowed: false,
+			expectedMessages: []string{
+				"worker group test-group: Number of workers in worker group not equal to specified topology",
+				"worker group test-group: replicas 2 less than minReplicas 4",
+			},
+		},
+		"delete invalid RayCluster": {
+			// RayClusters that are being deleted aren't validated, admit
+			oldRayCluster: getInvalidRayClusterWith(func(rayCluster *rayv1.RayCluster) {}),
+			rayCluster: getInvalidRayClusterWith(func(rayCluster *rayv1.RayCluster) {
+				rayCluster.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+				rayCluster.Spec.WorkerGroupSpecs[0].MaxReplicas = pointer.Int32(1)
+			}),
+			expectedAllowed: true,
+		},
+		"remove worker group": {
+			// removing a worker group, admit
+			oldRayCluster: getTestRayClusterWith(unchanged),
+			rayCluster: getTestRayClusterWith(func(workerGroupSpec *rayv1.WorkerGroupSpec) {
+				workerGroupSpec.GroupName = "other-group"
+				workerGroupSpec.Template.Spec.Containers[0].Resources = corev1.ResourceRequirements{}
+			}),
+			expectedAllowed: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// set up admissionReview object
+			admissionReview := getTestAdmissionReview("RayCluster", "UPDATE")
+			admissionReview.Request.Object.Raw, _ = json.Marshal(tc.rayCluster)
+			admissionReview.Request.OldObject.Raw, _ = json.Marshal(tc.oldRayCluster)
+
+			admissionResponse, err := validateRayCluster(admissionReview)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectedAllowed, admissionResponse.Allowed)
+			if tc.expectedAllowed {
+				assert.Nil(t, admissionResponse.Result.Details)
+				return
+			}
+			var messages []string
+			for _, cause := range admissionResponse.Result.Details.Causes {
+				messages = append(messages, cause.Message)
+			}
+			assert.Equal(t, tc.expectedMessages, messages)
+			assert.Equal(t, strings.Join(tc.expectedMessages, "; "), admissionResponse.Result.Message)
+		})
+	}
+}
+
 func Test_GetEnvironmentVariable(t *testing.T) {
 	// initialize test container object
 	testTPUWorker := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x1", "4")
@@ -1234,8 +1482,8 @@ func Test_GetSliceToWorkerIDs(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			podLister := setupInformer(tc.podsInGroup...)
-			tpuWebhook := NewTPUWebhookServer(podLister)
-			sliceToWorkerIDs, err := tpuWebhook.getSliceToWorkerIDs("test-cluster", "test-group", "test-namespace", tc.numOfHosts)
+			tpuWebhook := NewTPUWebhookServer(podLister, nil)
+			sliceToWorkerIDs, err := tpuWebhook.getSliceToWorkerIDs(workerGroup{"test-namespace", "test-cluster", "test-group"}, tc.numOfHosts)
 
 			// sliceToWorkerIDs should be populated with slices and unique TPU_WORKER_IDs for each Pod
 			assert.Equal(t, err, nil)
@@ -1251,72 +1499,6 @@ func Test_GetSliceToWorkerIDs(t *testing.T) {
 	}
 }
 
-func Test_IsLastAdmittedPod(t *testing.T) {
-	tests := map[string]struct {
-		testPod        *corev1.Pod
-		testWorkerID   string
-		testReplicaID  string
-		lastAdmitted   string
-		isLastAdmitted bool
-		expectedError  error
-	}{
-		"isLastAdmittedPod Pod missing RayCluster label": {
-			// missing Ray cluster label - returns error
-			testPod:        getTestCPUWorker("", "test-group", "test-namespace"),
-			expectedError:  errors.New("Ray Pod created by KubeRay missing RayCluster label"),
-			isLastAdmitted: false,
-		},
-		"isLastAdmittedPod Pod does not request TPUs": {
-			// pod is not a TPU pod, should return false
-			testPod:        getTestCPUWorker("test-cluster", "test-group", "test-namespace"),
-			isLastAdmitted: false,
-		},
-		"isLastAdmittedPod TPU Pod does not match lastAdmitted": {
-			// TPU pod does not match lastAdmitted, return false
-			testPod:        getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v6e-slice", "4x4", "4"),
-			testWorkerID:   "4",
-			testReplicaID:  "test-group-0",
-			lastAdmitted:   "test-namespace-test-cluster-test-group-0-3",
-			isLastAdmitted: false,
-		},
-		"isLastAdmittedPod TPU Pod matches lastAdmitted": {
-			// TPU pod matches lastAdmitted, return true
-			testPod:        getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v6e-slice", "4x4", "4"),
-			testWorkerID:   "0",
-			testReplicaID:  "test-group-0",
-			lastAdmitted:   "test-namespace-test-cluster-test-group-0-0",
-			isLastAdmitted: true,
-		},
-	}
-	for name, tc := range tests {
-		t.Run(name, func(t *testing.T) {
-			// set TPU_WORKER_ID for testPod
-			if containerRequestingTPUs(tc.testPod.Spec.Containers...) {
-				if tc.testReplicaID != "" {
-					tc.testPod.Labels["replicaIndex"] = tc.testReplicaID
-					tc.testPod.Spec.Containers[0].Env = []corev1.EnvVar{
-						{
-							Name:  "TPU_WORKER_ID",
-							Value: tc.testWorkerID,
-						},
-					}
-				}
-			}
-			// set up TPUWebhookServer
-			testPodLister := setupInformer(tc.testPod)
-			tpuWebhookServer := NewTPUWebhookServer(testPodLister)
-			tpuWebhookServer.lastAdmitted = tc.lastAdmitted
-
-			isLastAdmitted, err := tpuWebhookServer.isLastAdmittedPod(tc.testPod)
-			if err != nil {
-				assert.Equal(t, tc.expectedError, err)
-			} else {
-				assert.Equal(t, tc.isLastAdmitted, isLastAdmitted)
-			}
-		})
-	}
-}
-
 func Test_MutatePod(t *testing.T) {
 	tests := map[string]struct {
 		testPod              *corev1.Pod
@@ -1447,9 +1629,9 @@ func Test_MutatePod(t *testing.T) {
 			testPodLister := setupInformer(testTPUPods...)
 
 			// set up TPUWebhookServer
-			tpuWebhookServer := NewTPUWebhookServer(testPodLister)
+			tpuWebhookServer := NewTPUWebhookServer(testPodLister, nil)
 
-			admissionResponse, err := tpuWebhookServer.mutatePod(admissionReview)
+			admissionResponse, err := tpuWebhookServer.mutatePod(admissionReview, nil)
 			if err != nil {
 				assert.Equal(t, tc.expectedError, err)
 			} else {
@@ -1457,33 +1639,135 @@ func Test_MutatePod(t *testing.T) {
 				json.Unmarshal(admissionResponse.Patch, &patches)
 
 				if tc.numOfHosts == 1 {
-					// single-host - patches should add replicaIndex label, TPU_WORKER_ID, and TPU_NAME
-					workerID := patches[1]
-					workerName := patches[2]
-					expectedIDPatch := []interface{}([]interface{}{map[string]interface{}{"name": "TPU_WORKER_ID", "value": tc.expectedWorkerID}})
-					expectedNamePatch := []interface{}([]interface{}{map[string]interface{}{"name": "TPU_NAME", "value": tc.expectedWorkerName}})
+					// single-host - patches should add replicaIndex label, and the env array with
+					// TPU_WORKER_ID and TPU_NAME
+					expectedEnvPatch := []interface{}{
+						map[string]interface{}{"name": "TPU_WORKER_ID", "value": tc.expectedWorkerID},
+						map[string]interface{}{"name": "TPU_NAME", "value": tc.expectedWorkerName},
+					}
+					assert.Len(t, patches, 2)
 					assert.Equal(t, tc.expectedReplicaLabel, patches[0]["value"])
-					assert.Equal(t, expectedIDPatch, workerID["value"])
-					assert.Equal(t, expectedNamePatch, workerName["value"])
+					assert.Equal(t, "/spec/containers/0/env", patches[1]["path"])
+					assert.Equal(t, expectedEnvPatch, patches[1]["value"])
 				}
 				if tc.numOfHosts > 1 {
 					// multi-host - patches should add replicaIndex, hostname, podAffinity, subdomain,
-					// TPU_WORKER_HOSTNAMES, TPU_WORKER_ID, and TPU_NAME
-					expectedIDPatch := []interface{}([]interface{}{map[string]interface{}{"name": "TPU_WORKER_ID", "value": tc.expectedWorkerID}})
-					expectedNamePatch := []interface{}([]interface{}{map[string]interface{}{"name": "TPU_NAME", "value": tc.expectedWorkerName}})
-					expectedHostnamesPatch := []interface{}([]interface{}{map[string]interface{}{"name": "TPU_WORKER_HOSTNAMES", "value": tc.expectedHostnames}})
+					// and the env array with TPU_WORKER_HOSTNAMES, TPU_WORKER_ID, and TPU_NAME
+					expectedEnvPatch := []interface{}{
+						map[string]interface{}{"name": "TPU_WORKER_HOSTNAMES", "value": tc.expectedHostnames},
+						map[string]interface{}{"name": "TPU_WORKER_ID", "value": tc.expectedWorkerID},
+						map[string]interface{}{"name": "TPU_NAME", "value": tc.expectedWorkerName},
+					}
+					assert.Len(t, patches, 5)
 					assert.Equal(t, tc.expectedReplicaLabel, patches[0]["value"])
 					assert.Equal(t, fmt.Sprintf("%s-%s", tc.expectedReplicaLabel, tc.expectedWorkerID), patches[1]["value"])
 					assert.Equal(t, fmt.Sprintf("%s-%s", "test-cluster", headlessServiceSuffix), patches[3]["value"])
-					assert.Equal(t, expectedHostnamesPatch, patches[4]["value"])
-					assert.Equal(t, expectedIDPatch, patches[5]["value"])
-					assert.Equal(t, expectedNamePatch, patches[6]["value"])
+					assert.Equal(t, "/spec/containers/0/env", patches[4]["path"])
+					assert.Equal(t, expectedEnvPatch, patches[4]["value"])
 				}
 			}
 		})
 	}
 }
 
+func Test_GetTPUResourceWarnings(t *testing.T) {
+	tests := map[string]struct {
+		accelerator      string
+		topology         string
+		tpuResource      string
+		requestOverride  string
+		expectedWarnings []string
+	}{
+		"full multi-host TPU VM hosts": {
+			topology:    "2x2x4",
+			tpuResource: "4",
+		},
+		"full single-host TPU VM host": {
+			topology:    "2x4",
+			tpuResource: "8",
+		},
+		"partial multi-host TPU VM hosts": {
+			topology:         "4x4",
+			tpuResource:      "8",
+			expectedWarnings: []string{"multi-host topology 4x4 has 4 chips per TPU VM host, google.com/tpu limit of 8 chips doesn't match a full host"},
+		},
+		"limit not dividing topology": {
+			// rejected by getNumTPUHostsFromTopology
+			topology:    "2x2x2",
+			tpuResource: "3",
+		},
+		"limit exceeding topology": {
+			// rejected by getNumTPUHostsFromTopology
+			topology:    "2x2x1",
+			tpuResource: "8",
+		},
+		"request different from limit": {
+			topology:        "2x2x1",
+			tpuResource:     "4",
+			requestOverride: "2",
+			expectedWarnings: []string{
+				"container ray-worker requests 2 google.com/tpu but is limited to 4",
+				"multi-host topology 2x2x1 has 4 chips per TPU VM host, google.com/tpu limit of 2 chips doesn't match a full host",
+			},
+		},
+		"partial host of a known accelerator": {
+			accelerator:      "tpu-v4-podslice",
+			topology:         "2x2x2",
+			tpuResource:      "2",
+			expectedWarnings: []string{"google.com/tpu limit of 2 chips doesn't use all 4 chips of a tpu-v4-podslice TPU VM host"},
+		},
+		"full hosts of a known accelerator": {
+			// v5e 2x4 with 4 chips per Pod is served by two 4-chip VMs
+			accelerator: "tpu-v5-lite-podslice",
+			topology:    "2x4",
+			tpuResource: "4",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", tc.topology, tc.tpuResource)
+			if tc.requestOverride != "" {
+				testPod.Spec.Containers[0].Resources.Requests["google.com/tpu"] = resource.MustParse(tc.requestOverride)
+			}
+			warnings := getTPUResourceWarnings(tc.accelerator, tc.topology, testPod.Spec.Containers...)
+			assert.Equal(t, tc.expectedWarnings, warnings)
+		})
+	}
+}
+
+func Test_AdmissionWarnings(t *testing.T) {
+	tpuWebhookServer := NewTPUWebhookServer(setupInformer(), nil)
+
+	// Pod missing ray.io/group is admitted without TPU environment variables
+	testPod := getTestTPUWorker("test-cluster", "", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+	admissionReview := getTestAdmissionReview("Pod", "CREATE")
+	admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+	admissionResponse, err := tpuWebhookServer.mutatePod(admissionReview, nil)
+	assert.Nil(t, err)
+	assert.True(t, admissionResponse.Allowed)
+	assert.Nil(t, admissionResponse.Patch)
+	assert.Equal(t, []string{"Ray Pod missing ray.io/group label, TPU environment variables are not injected"}, admissionResponse.Warnings)
+
+	// Pod with partial TPU VM hosts is mutated with a warning
+	testPod = getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x2", "2")
+	admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+	admissionResponse, err = tpuWebhookServer.mutatePod(admissionReview, nil)
+	assert.Nil(t, err)
+	assert.True(t, admissionResponse.Allowed)
+	assert.NotNil(t, admissionResponse.Patch)
+	assert.Equal(t, []string{"google.com/tpu limit of 2 chips doesn't use all 4 chips of a tpu-v4-podslice TPU VM host"}, admissionResponse.Warnings)
+
+	// RayCluster with partial TPU VM hosts is admitted with a warning
+	rayCluster := getTestRayCluster("test-cluster", "test-group", "test-namespace", int32(2), 1, "2", "tpu-v4-podslice", "2x2x2", false)
+	admissionReview = getTestAdmissionReview("RayCluster", "CREATE")
+	admissionReview.Request.Object.Raw, _ = json.Marshal(rayCluster)
+	admissionResponse, err = validateRayCluster(admissionReview)
+	assert.Nil(t, err)
+	assert.True(t, admissionResponse.Allowed)
+	assert.Equal(t, []string{"worker group test-group: google.com/tpu limit of 2 chips doesn't use all 4 chips of a tpu-v4-podslice TPU VM host"}, admissionResponse.Warnings)
+}
+
 func Test_GenerateHeadlessServiceName(t *testing.T) {
 	tests := map[string]struct {
 		testRayClusterName  string
@@ -0,0 +1,184 @@
+/**
+ * Copyright 2024 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// workerGroup identifies a Ray worker group.
+type workerGroup struct {
+	namespace   string
+	clusterName string
+	groupName   string
+}
+
+// workerAssignment is a TPU_WORKER_ID in a Pod Slice (worker group replica).
+type workerAssignment struct {
+	replicaIndex int
+	workerID     int
+}
+
+// workerAllocator hands out replica indices and TPU_WORKER_IDs for TPU worker groups and reserves
+// them until the admitted Pods are in the PodInformer cache.
+type workerAllocator interface {
+	assign(group workerGroup, numOfHosts int32, listSlices func() (map[slice][]int, error), dryRun bool) (int, int, error)
+	release(group workerGroup, a workerAssignment, uid types.UID)
+}
+
+// workerLedger hands out replica indices and TPU_WORKER_IDs for TPU worker groups. Every assignment
+// is reserved until the admitted Pod shows up in the PodInformer cache, so that concurrent admissions
+// never see the same free ID. Reservations expire after ttl in case the Pod is never created, for
+// example when the API server rejects it after admission. Reservations are held in memory, so only
+// a single webhook replica may assign IDs, see sharedWorkerLedger for multiple replicas.
+type workerLedger struct {
+	mu           sync.Mutex
+	ttl          time.Duration
+	now          func() time.Time
+	reservations map[workerGroup]map[workerAssignment]time.Time
+}
+
+func newWorkerLedger(ttl time.Duration) *workerLedger {
+	return &workerLedger{
+		ttl:          ttl,
+		now:          time.Now,
+		reservations: make(map[workerGroup]map[workerAssignment]time.Time),
+	}
+}
+
+// assign returns the next free replica index and TPU_WORKER_ID in a worker group and reserves them,
+// unless the assignment is for a dry-run request. listSlices returns the assignments of the existing
+// Pods in the worker group, it is called with the ledger locked so that no other assignment can happen
+// in between. The returned map is updated in place with the reserved assignments.
+func (l *workerLedger) assign(group workerGroup, numOfHosts int32, listSlices func() (map[slice][]int, error), dryRun bool) (int, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sliceToWorkerIDs, err := listSlices()
+	if err != nil {
+		return 0, 0, err
+	}
+	l.mergeReservations(group, numOfHosts, sliceToWorkerIDs)
+	printSliceToWorkerIds(sliceToWorkerIDs)
+
+	replicaIndex := getReplicaIndex(sliceToWorkerIDs, group)
+	podSlice := slice{group.clusterName, group.groupName, group.namespace, replicaIndex, numOfHosts}
+	workerID, err := getNextWorkerID(sliceToWorkerIDs, podSlice, group.namespace, replicaIndex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if dryRun {
+		return replicaIndex, workerID, nil
+	}
+	if l.reservations[group] == nil {
+		l.reservations[group] = make(map[workerAssignment]time.Time)
+	}
+	l.reservations[group][workerAssignment{replicaIndex, workerID}] = l.now().Add(l.ttl)
+	return replicaIndex, workerID, nil
+}
+
+// mergeReservations adds the reserved assignments of a worker group to sliceToWorkerIDs. Reservations
+// of Pods that are already in the PodInformer cache and expired reservations are dropped.
+func (l *workerLedger) mergeReservations(group workerGroup, numOfHosts int32, sliceToWorkerIDs map[slice][]int) {
+	now := l.now()
+	for a, expiry := range l.reservations[group] {
+		podSlice := slice{group.clusterName, group.groupName, group.namespace, a.replicaIndex, numOfHosts}
+		switch {
+		case slices.Contains(sliceToWorkerIDs[podSlice], a.workerID):
+			delete(l.reservations[group], a)
+		case now.After(expiry):
+			klog.V(0).InfoS("mergeReservations", "RayCluster", group.namespace+"/"+group.clusterName, "Worker Group", group.groupName,
+				"Replica Index", a.replicaIndex, "TPU_WORKER_ID", a.workerID, "message", "reservation expired before the Pod was observed")
+			reservationsExpired.Inc()
+			delete(l.reservations[group], a)
+		default:
+			sliceToWorkerIDs[podSlice] = append(sliceToWorkerIDs[podSlice], a.workerID)
+		}
+	}
+	if len(l.reservations[group]) == 0 {
+		delete(l.reservations, group)
+	}
+}
+
+// release drops the reservation of an assignment, once its Pod with uid is in the PodInformer cache.
+func (l *workerLedger) release(group workerGroup, a workerAssignment, uid types.UID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.reservations[group], a)
+	if len(l.reservations[group]) == 0 {
+		delete(l.reservations, group)
+	}
+}
+
+// podWorkerAssignment returns the worker group and assignment of a TPU worker Pod that was mutated by
+// the webhook, or false if the Pod was not.
+func podWorkerAssignment(pod *corev1.Pod, policy *injectionPolicy) (workerGroup, workerAssignment, bool) {
+	group, ok := kubeRayFramework.podGroup(pod)
+	if !ok {
+		return group, workerAssignment{}, false
+	}
+	replicaIndex, err := podReplicaIndex(pod, policy)
+	if err != nil {
+		return group, workerAssignment{}, false
+	}
+	workerID, err := podWorkerID(pod, policy)
+	if err != nil {
+		return group, workerAssignment{}, false
+	}
+	return group, workerAssignment{replicaIndex, workerID}, true
+}
+
+// errNotAssigned is returned for Pods the webhook hasn't assigned a replica index or TPU_WORKER_ID yet
+var errNotAssigned = errors.New("not assigned by the webhook")
+
+// podReplicaIndex returns the replica index the webhook set in the replica label of a Pod, which is
+// suffixed with the index
+func podReplicaIndex(pod *corev1.Pod, policy *injectionPolicy) (int, error) {
+	replicaIndexLabel := pod.Labels[policy.ReplicaLabel]
+	if replicaIndexLabel == "" {
+		return 0, errNotAssigned
+	}
+	replicaIndexLabelValues := strings.Split(replicaIndexLabel, "-")
+	return strconv.Atoi(replicaIndexLabelValues[len(replicaIndexLabelValues)-1])
+}
+
+// podWorkerID returns the TPU_WORKER_ID the webhook set in the first TPU container of a Pod that has
+// a valid one
+func podWorkerID(pod *corev1.Pod, policy *injectionPolicy) (int, error) {
+	err := errNotAssigned
+	for _, container := range pod.Spec.Containers {
+		if !containerRequestingTPUs(container) {
+			continue
+		}
+		var workerID int
+		if workerID, err = strconv.Atoi(getEnvironmentVariable(policy.Env.WorkerID, container)); err == nil {
+			return workerID, nil
+		}
+	}
+	return 0, err
+}
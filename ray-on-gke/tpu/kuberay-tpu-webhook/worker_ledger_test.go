@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// reserved returns the number of reservations that are held for a worker group.
+func (l *workerLedger) reserved(group workerGroup) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.reservations[group])
+}
+
+func Test_WorkerLedgerAssign(t *testing.T) {
+	group := workerGroup{"test-namespace", "test-cluster", "test-group"}
+	emptyCache := func() (map[slice][]int, error) { return make(map[slice][]int), nil }
+
+	tests := map[string]struct {
+		numOfHosts          int32
+		numAssignments      int
+		expectedAssignments []workerAssignment
+	}{
+		"single-host worker group": {
+			// every Pod gets its own replica
+			numOfHosts:          1,
+			numAssignments:      3,
+			expectedAssignments: []workerAssignment{{0, 0}, {1, 0}, {2, 0}},
+		},
+		"multi-host worker group": {
+			// slices are filled up before the next replica is started
+			numOfHosts:          2,
+			numAssignments:      5,
+			expectedAssignments: []workerAssignment{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {2, 0}},
+		},
+	}
+
+	// validate assign() does not hand out reserved IDs before the Pods are in the cache
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ledger := newWorkerLedger(time.Minute)
+			var assignments []workerAssignment
+			for i := 0; i < tc.numAssignments; i++ {
+				replicaIndex, workerID, err := ledger.assign(group, tc.numOfHosts, emptyCache, false)
+				assert.Nil(t, err)
+				assignments = append(assignments, workerAssignment{replicaIndex, workerID})
+			}
+			assert.Equal(t, tc.expectedAssignments, assignments)
+			assert.Equal(t, tc.numAssignments, ledger.reserved(group))
+		})
+	}
+}
+
+func Test_WorkerLedgerReservations(t *testing.T) {
+	group := workerGroup{"test-namespace", "test-cluster", "test-group"}
+	now := time.Now()
+	ledger := newWorkerLedger(time.Minute)
+	ledger.now = func() time.Time { return now }
+	cached := make(map[slice][]int)
+	listCache := func() (map[slice][]int, error) {
+		sliceToWorkerIDs := make(map[slice][]int)
+		for k, v := range cached {
+			sliceToWorkerIDs[k] = append([]int{}, v...)
+		}
+		return sliceToWorkerIDs, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		_, _, err := ledger.assign(group, 4, listCache, false)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 3, ledger.reserved(group))
+
+	// TPU_WORKER_ID 0 shows up in the cache, its reservation is dropped on the next assignment
+	cached[slice{"test-cluster", "test-group", "test-namespace", 0, 4}] = []int{0}
+	replicaIndex, workerID, err := ledger.assign(group, 4, listCache, false)
+	assert.Nil(t, err)
+	assert.Equal(t, workerAssignment{0, 3}, workerAssignment{replicaIndex, workerID})
+	assert.Equal(t, 3, ledger.reserved(group))
+
+	// the informer releases TPU_WORKER_ID 1
+	ledger.release(group, workerAssignment{0, 1}, "")
+	assert.Equal(t, 2, ledger.reserved(group))
+
+	// TPU_WORKER_ID 3 shows up in the cache, 1 was released and 2 expires without showing up, so
+	// both are handed out again
+	ledger.release(group, workerAssignment{0, 3}, "")
+	cached[slice{"test-cluster", "test-group", "test-namespace", 0, 4}] = []int{0, 3}
+	now = now.Add(2 * time.Minute)
+	replicaIndex, workerID, err = ledger.assign(group, 4, listCache, false)
+	assert.Nil(t, err)
+	assert.Equal(t, workerAssignment{0, 1}, workerAssignment{replicaIndex, workerID})
+	replicaIndex, workerID, err = ledger.assign(group, 4, listCache, false)
+	assert.Nil(t, err)
+	assert.Equal(t, workerAssignment{0, 2}, workerAssignment{replicaIndex, workerID})
+}
+
+func Test_PodWorkerAssignment(t *testing.T) {
+	interceptedPods := getTestInterceptedTPUPods(getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x2", "4"), 4, 2, 2)
+
+	tests := map[string]struct {
+		pod                *corev1.Pod
+		expectedAssignment workerAssignment
+		expectedOk         bool
+	}{
+		"Pod mutated by the webhook": {
+			pod:                interceptedPods[3],
+			expectedAssignment: workerAssignment{1, 1},
+			expectedOk:         true,
+		},
+		"Pod not mutated by the webhook": {
+			pod:        getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x2", "4"),
+			expectedOk: false,
+		},
+		"CPU Pod": {
+			pod:        getTestCPUWorker("test-cluster", "test-group", "test-namespace"),
+			expectedOk: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			group, assignment, ok := podWorkerAssignment(tc.pod, defaultInjectionPolicy())
+			assert.Equal(t, tc.expectedOk, ok)
+			if ok {
+				assert.Equal(t, workerGroup{"test-namespace", "test-cluster", "test-group"}, group)
+				assert.Equal(t, tc.expectedAssignment, assignment)
+			}
+		})
+	}
+}
+
+// mutateAssignment returns the replica index and TPU_WORKER_ID from the patches of a mutated Pod.
+func mutateAssignment(t *testing.T, response *admissionv1.AdmissionResponse) workerAssignment {
+	var patches []struct {
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	assert.Nil(t, json.Unmarshal(response.Patch, &patches))
+
+	assignment := workerAssignment{-1, -1}
+	for _, p := range patches {
+		var label string
+		var env []corev1.EnvVar
+		var envVar corev1.EnvVar
+		switch {
+		case p.Path == "/metadata/labels/replicaIndex":
+			assert.Nil(t, json.Unmarshal(p.Value, &label))
+			assignment.replicaIndex, _ = strconv.Atoi(label[strings.LastIndex(label, "-")+1:])
+		case json.Unmarshal(p.Value, &env) == nil:
+			for _, envVar := range env {
+				if envVar.Name == "TPU_WORKER_ID" {
+					assignment.workerID, _ = strconv.Atoi(envVar.Value)
+				}
+			}
+		case json.Unmarshal(p.Value, &envVar) == nil && envVar.Name == "TPU_WORKER_ID":
+			assignment.workerID, _ = strconv.Atoi(envVar.Value)
+		}
+	}
+	return assignment
+}
+
+// Test_MutateConcurrent sends admission requests for all Pods of a multi-slice, multi-host worker
+// group at once, like a burst of scale-up, and creates the Pods while requests are still coming in.
+func Test_MutateConcurrent(t *testing.T) {
+	const (
+		numOfHosts = 4
+		numSlices  = 16
+	)
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+
+	fakeClientSet := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(fakeClientSet, 0)
+	podInformer := factory.Core().V1().Pods().Informer()
+	tpuWebhookServer := NewTPUWebhookServer(factory.Core().V1().Pods().Lister(), nil)
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{AddFunc: tpuWebhookServer.addPod})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	cache.WaitForCacheSync(stopCh, podInformer.HasSynced)
+
+	server := httptest.NewServer(http.HandlerFunc(tpuWebhookServer.Mutate))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	assigned := make(map[workerAssignment]int)
+	for i := 0; i < numOfHosts*numSlices; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			admissionReview := getTestAdmissionReview("Pod", "CREATE")
+			admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+			body, _ := json.Marshal(admissionReview)
+			res, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer res.Body.Close()
+			if !assert.Equal(t, http.StatusOK, res.StatusCode) {
+				return
+			}
+			review := &admissionv1.AdmissionReview{}
+			if !assert.Nil(t, json.NewDecoder(res.Body).Decode(review)) {
+				return
+			}
+			assignment := mutateAssignment(t, review.Response)
+
+			mu.Lock()
+			assigned[assignment]++
+			mu.Unlock()
+
+			// create the mutated Pod, so that the informer observes it and releases the reservation
+			pod := testPod.DeepCopy()
+			pod.Name = fmt.Sprintf("tpu-pod-%d", i)
+			pod.Labels["replicaIndex"] = fmt.Sprintf("test-group-%d", assignment.replicaIndex)
+			pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "TPU_WORKER_ID", Value: fmt.Sprint(assignment.workerID)}}
+			_, err = fakeClientSet.CoreV1().Pods("test-namespace").Create(context.Background(), pod, metav1.CreateOptions{})
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	// every TPU_WORKER_ID of every slice is handed out exactly once
+	assert.Equal(t, numOfHosts*numSlices, len(assigned))
+	for replicaIndex := 0; replicaIndex < numSlices; replicaIndex++ {
+		for workerID := 0; workerID < numOfHosts; workerID++ {
+			assert.Equal(t, 1, assigned[workerAssignment{replicaIndex, workerID}], "replica %d, TPU_WORKER_ID %d", replicaIndex, workerID)
+		}
+	}
+
+	// all reservations are released once the informer observed the Pods
+	group := workerGroup{"test-namespace", "test-cluster", "test-group"}
+	assert.Eventually(t, func() bool { return tpuWebhookServer.ledger.(*workerLedger).reserved(group) == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+// Test_WorkerReplacement replaces single workers of a multi-host worker group and validates that the
+// replacement Pods rejoin their original slice with the same TPU_WORKER_ID and hostname.
+func Test_WorkerReplacement(t *testing.T) {
+	testPod := getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4")
+	group := workerGroup{"test-namespace", "test-cluster", "test-group"}
+
+	fakeClientSet := fake.NewSimpleClientset()
+	for _, pod := range getTestInterceptedTPUPods(testPod, 8, 2, 4) {
+		_, err := fakeClientSet.CoreV1().Pods("test-namespace").Create(context.Background(), pod, metav1.CreateOptions{})
+		assert.Nil(t, err)
+	}
+	factory := informers.NewSharedInformerFactory(fakeClientSet, 0)
+	podInformer := factory.Core().V1().Pods().Informer()
+	podLister := factory.Core().V1().Pods().Lister()
+	tpuWebhookServer := NewTPUWebhookServer(podLister, nil)
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    tpuWebhookServer.addPod,
+		UpdateFunc: tpuWebhookServer.updatePod,
+		DeleteFunc: tpuWebhookServer.deletePod,
+	})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	cache.WaitForCacheSync(stopCh, podInformer.HasSynced)
+
+	mutate := func() (workerAssignment, string) {
+		admissionReview := getTestAdmissionReview("Pod", "CREATE")
+		admissionReview.Request.Object.Raw, _ = json.Marshal(testPod)
+		response, err := tpuWebhookServer.mutatePod(admissionReview, nil)
+		assert.Nil(t, err)
+		var patches []patch
+		assert.Nil(t, json.Unmarshal(response.Patch, &patches))
+		hostname := ""
+		for _, p := range patches {
+			if p["path"] == "/spec/hostname" {
+				hostname = p["value"].(string)
+			}
+		}
+		return mutateAssignment(t, response), hostname
+	}
+
+	// TPU_WORKER_ID 2 of replica 0 is deleted
+	assert.Nil(t, fakeClientSet.CoreV1().Pods("test-namespace").Delete(context.Background(), "intercepted-tpu-pod-2", metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		_, err := podLister.Pods("test-namespace").Get("intercepted-tpu-pod-2")
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assignment, hostname := mutate()
+	assert.Equal(t, workerAssignment{0, 2}, assignment)
+	assert.Equal(t, "test-group-0-2", hostname)
+
+	// TPU_WORKER_ID 1 of replica 1 is terminating
+	terminating, err := fakeClientSet.CoreV1().Pods("test-namespace").Get(context.Background(), "intercepted-tpu-pod-5", metav1.GetOptions{})
+	assert.Nil(t, err)
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	_, err = fakeClientSet.CoreV1().Pods("test-namespace").Update(context.Background(), terminating, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		pod, err := podLister.Pods("test-namespace").Get("intercepted-tpu-pod-5")
+		return err == nil && pod.DeletionTimestamp != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assignment, hostname = mutate()
+	assert.Equal(t, workerAssignment{1, 1}, assignment)
+	assert.Equal(t, "test-group-1-1", hostname)
+	assert.Equal(t, 2, tpuWebhookServer.ledger.(*workerLedger).reserved(group))
+
+	// neither updates nor the tombstone of the terminating Pod release the replacement's reservation
+	tpuWebhookServer.updatePod(terminating, terminating)
+	tpuWebhookServer.deletePod(cache.DeletedFinalStateUnknown{Key: "test-namespace/intercepted-tpu-pod-5", Obj: terminating})
+	assert.Equal(t, 2, tpuWebhookServer.ledger.(*workerLedger).reserved(group))
+
+	// all slices are full again, the next Pod starts a new replica
+	assignment, hostname = mutate()
+	assert.Equal(t, workerAssignment{2, 0}, assignment)
+	assert.Equal(t, "test-group-2-0", hostname)
+}
+
+func Test_MutatePodUpdate(t *testing.T) {
+	testPod := getTestInterceptedTPUPods(getTestTPUWorker("test-cluster", "test-group", "test-namespace", "tpu-v4-podslice", "2x2x4", "4"), 1, 1, 4)[0]
+	tpuWebhookServer := NewTPUWebhookServer(setupInformer(testPod), nil)
+
+	// validate mutatePod admits Pod updates without patches or reservations
+	for _, operation := range []string{"UPDATE", "DELETE"} {
+		t.Run(operation, func(t *testing.T) {
+			admissionReview := getTestAdmissionReview("Pod", operation)
+			jsonPod, _ := json.Marshal(testPod)
+			if operation == "DELETE" {
+				admissionReview.Request.OldObject.Raw = jsonPod
+			} else {
+				admissionReview.Request.Object.Raw = jsonPod
+			}
+			response, err := tpuWebhookServer.mutatePod(admissionReview, nil)
+			assert.Nil(t, err)
+			assert.True(t, response.Allowed)
+			assert.Nil(t, response.Patch)
+			assert.Equal(t, 0, tpuWebhookServer.ledger.(*workerLedger).reserved(workerGroup{"test-namespace", "test-cluster", "test-group"}))
+		})
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// gcp-auth-plugin is a client-go exec credential plugin that provides GCP
+// access tokens, configured with the same options as the legacy "gcp" auth
+// provider. Example kubeconfig user:
+//
+//	users:
+//	- name: gke
+//	  user:
+//	    exec:
+//	      apiVersion: client.authentication.k8s.io/v1
+//	      command: gcp-auth-plugin
+//	      interactiveMode: Never
+//	      args:
+//	      - --cmd-path=gcloud
+//	      - --cmd-args=config config-helper --format=json
+//	      - --token-key={.credential.access_token}
+//	      - --expiry-key={.credential.token_expiry}
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/auth/gcp"
+)
+
+func main() {
+	defaultCacheFile := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		defaultCacheFile = filepath.Join(dir, "gcp-auth-plugin", "token.json")
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	// Flags have the same names as the gcp auth provider config keys, only
+	// flags that are set are passed on, as some options depend on presence.
+	fs.String("auth-type", "", "Token source to use: adc, cmd, external-account, file or metadata (default derived from the other flags).")
+	fs.String("scopes", "", "Comma-separated list of GCP API scopes, only used with application default credentials.")
+	fs.String("cmd-path", "", "Command to execute for an access token, instead of using application default credentials.")
+	fs.String("cmd-args", "", "Arguments to pass to cmd-path.")
+	fs.String("token-key", "", "JSONPath to the access token in the command output (default \"{.access_token}\").")
+	fs.String("expiry-key", "", "JSONPath to the token expiry in the command output (default \"{.token_expiry}\").")
+	fs.String("time-fmt", "", "Go reference time layout of the token expiry (default RFC3339Nano).")
+	fs.String("token-file", "", "File to read the access token from with auth-type file, in the same format as the cmd-path output.")
+	fs.String("metadata-host", "", "Metadata server host for auth-type metadata (default $GCE_METADATA_HOST or 169.254.169.254).")
+	fs.String("credential-file", "", "External account credential file to use for Workload Identity Federation, instead of application default credentials.")
+	fs.String("audience", "", "Workload identity pool provider audience, overrides the audience of the credential-file.")
+	fs.String("impersonate-service-account", "", "Service account to impersonate, or a comma-separated delegation chain ending with it.")
+	fs.String("quota-project", "", "Project to bill and use quota of for impersonation, application default and external account credentials.")
+	fs.String("refresh-skew", "", "How long before expiry a cached token is refreshed (default 5m).")
+	cacheFile := fs.String("cache-file", defaultCacheFile, "File to cache tokens in until they expire, empty to disable caching.")
+	fs.Parse(os.Args[1:])
+
+	gcpConfig := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "cache-file" {
+			gcpConfig[f.Name] = f.Value.String()
+		}
+	})
+
+	if err := gcp.WriteExecCredential(gcpConfig, *cacheFile, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "gcp-auth-plugin: %v\n", err)
+		os.Exit(1)
+	}
+}
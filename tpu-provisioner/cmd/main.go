@@ -17,11 +17,8 @@ limitations under the License.
 package main
 
 import (
-	"context"
 	"flag"
-	"net/http"
 	"os"
-	"strings"
 	"sync"
 	"time"
 
@@ -35,11 +32,11 @@ import (
 	// https://cloud.google.com/blog/products/containers-kubernetes/kubectl-auth-changes-in-gke
 	_ "github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/auth/gcp"
 
-	"cloud.google.com/go/compute/metadata"
 	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/cloud"
 	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/controller"
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/usage"
+	provisionerwebhook "github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/webhook"
 
-	containerv1beta1 "google.golang.org/api/container/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -70,25 +67,11 @@ func init() {
 
 func main() {
 	var cfg struct {
-		// Provider can be "gke" or "mock".
+		// Provider is the name of a registered cloud provider: "gke", "kwok" or "mock".
+		// Provider specific configuration (for example GCP_* for "gke") is read
+		// by the provider itself.
 		Provider string `envconfig:"PROVIDER" default:"gke"`
 
-		GCPProjectID          string `envconfig:"GCP_PROJECT_ID"`
-		GCPClusterLocation    string `envconfig:"GCP_CLUSTER_LOCATION"`
-		GCPZone               string `envconfig:"GCP_ZONE"`
-		GCPCluster            string `envconfig:"GCP_CLUSTER"`
-		GCPNodeServiceAccount string `envconfig:"GCP_NODE_SERVICE_ACCOUNT"`
-
-		GCPNodeTags               []string `envconfig:"GCP_NODE_TAGS"`
-		GCPPodToNodeLabels        []string `envconfig:"GCP_POD_TO_NODE_LABELS"`
-		GCPNodeSecondaryDisk      string   `envconfig:"GCP_NODE_SECONDARY_DISK" default:""`
-		GCPNodeSecureBoot         bool     `envconfig:"GCP_NODE_SECURE_BOOT" default:"true"`
-		GCPNodeAdditionalNetworks string   `envconfig:"GCP_NODE_ADDITIONAL_NETWORKS" default:""`
-
-		// GCPForceOnDemand forces the controller to create nodes on demand, even if
-		// the Pod requests a reservation or spot.
-		GCPForceOnDemand bool `envconfig:"GCP_FORCE_ON_DEMAND" default:"false"`
-
 		// NodeMinLifespan is the amount of time that should pass between a Node object
 		// creation and a cleanup of that Node. This needs to be long enough to allow
 		// the node to become Ready and for a pending Pod to be scheduled on it.
@@ -99,6 +82,18 @@ func main() {
 		PodResourceType string `envconfig:"POD_RESOURCE_TYPE" default:"google.com/tpu"`
 
 		Concurrency int `envconfig:"CONCURRENCY" default:"3"`
+
+		// UsageLedgerPath is an optional JSONL file that node pool usage
+		// (chip-hours per namespace, JobSet and tier) is appended to.
+		UsageLedgerPath string `envconfig:"USAGE_LEDGER_PATH" default:""`
+		// UsagePriceTablePath is an optional YAML file with per chip-hour prices
+		// by machine type (or family) and tier.
+		UsagePriceTablePath  string        `envconfig:"USAGE_PRICE_TABLE_PATH" default:""`
+		UsageAccrualInterval time.Duration `envconfig:"USAGE_ACCRUAL_INTERVAL" default:"1m"`
+
+		// EnableWebhooks registers validating webhooks for Pods and JobSets on the
+		// webhook server. Requires serving certificates to be mounted.
+		EnableWebhooks bool `envconfig:"ENABLE_WEBHOOKS" default:"false"`
 	}
 	envconfig.MustProcess("", &cfg)
 
@@ -146,80 +141,27 @@ func main() {
 		os.Exit(1)
 	}
 
-	var provider cloud.Provider
-	switch p := strings.ToLower(cfg.Provider); p {
-	case "gke":
-		if metadata.OnGCE() {
-			// Attempt to infer cluster information from GKE metadata server.
-			md := metadata.NewClient(&http.Client{})
-			var err error
-
-			if cfg.GCPProjectID == "" {
-				cfg.GCPProjectID, err = md.ProjectID()
-				if err != nil {
-					setupLog.Error(err, "fetching project id from metadata server")
-					os.Exit(1)
-				}
-			}
-			if cfg.GCPCluster == "" {
-				cfg.GCPCluster, err = md.InstanceAttributeValue("cluster-name")
-				if err != nil {
-					setupLog.Error(err, "fetching cluster name from metadata server")
-					os.Exit(1)
-				}
-			}
-			if cfg.GCPClusterLocation == "" {
-				cfg.GCPClusterLocation, err = md.InstanceAttributeValue("cluster-location")
-				if err != nil {
-					setupLog.Error(err, "fetching cluster location from metadata server")
-					os.Exit(1)
-				}
-			}
-			if cfg.GCPZone == "" {
-				cfg.GCPZone, err = md.Zone()
-				if err != nil {
-					setupLog.Error(err, "fetching zone from metadata server")
-					os.Exit(1)
-				}
-			}
-		}
-
-		setupLog.Info("creating gke client",
-			"project", cfg.GCPProjectID,
-			"clusterLocation", cfg.GCPClusterLocation,
-			"cluster", cfg.GCPCluster,
-			"zone", cfg.GCPZone,
-			"nodeServiceAccount", cfg.GCPNodeServiceAccount,
-			"nodeTags", cfg.GCPNodeTags,
-			"podToNodeLabels", cfg.GCPPodToNodeLabels,
-		)
-
-		containers, err := containerv1beta1.NewService(context.Background() /*, option.WithCredentials(creds)*/)
+	var prices usage.PriceTable
+	if cfg.UsagePriceTablePath != "" {
+		prices, err = usage.LoadPriceTable(cfg.UsagePriceTablePath)
 		if err != nil {
-			setupLog.Error(err, "unable to create gke client")
+			setupLog.Error(err, "unable to load usage price table")
 			os.Exit(1)
 		}
-		provider = &cloud.GKE{
-			Service: containers,
-			ClusterContext: cloud.GKEContext{
-				ProjectID:              cfg.GCPProjectID,
-				ClusterLocation:        cfg.GCPClusterLocation,
-				Cluster:                cfg.GCPCluster,
-				NodeZone:               cfg.GCPZone,
-				NodeServiceAccount:     cfg.GCPNodeServiceAccount,
-				NodeAdditionalNetworks: cfg.GCPNodeAdditionalNetworks,
-				NodeSecondaryDisk:      cfg.GCPNodeSecondaryDisk,
-				NodeTags:               cfg.GCPNodeTags,
-				PodToNodeLabels:        cfg.GCPPodToNodeLabels,
-				NodeSecureBoot:         cfg.GCPNodeSecureBoot,
-				ForceOnDemand:          cfg.GCPForceOnDemand,
-			},
-			Recorder: mgr.GetEventRecorderFor("tpu-provisioner"),
-		}
-	case "mock":
-		provider = &cloud.Mock{}
-	default:
-		setupLog.Error(err, "unrecognized provider", "provider", p)
+	}
+	ledger, err := usage.NewLedger(cfg.UsageLedgerPath, prices)
+	if err != nil {
+		setupLog.Error(err, "unable to load usage ledger")
+		os.Exit(1)
+	}
+
+	provider, err := cloud.NewProvider(cfg.Provider, cloud.ProviderOptions{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("tpu-provisioner"),
+		Usage:    ledger,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create provider", "provider", cfg.Provider)
 		os.Exit(1)
 	}
 
@@ -251,6 +193,16 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "DeletionReconciler")
 		os.Exit(1)
 	}
+	if cfg.EnableWebhooks {
+		if err := (&provisionerwebhook.PodValidator{}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Pod")
+			os.Exit(1)
+		}
+		if err := (&provisionerwebhook.JobSetValidator{}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "JobSet")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -267,13 +219,18 @@ func main() {
 		Interval: time.Minute,
 		Client:   mgr.GetClient(),
 		Provider: provider,
+		Usage:    ledger,
 	}
 	var wg sync.WaitGroup
-	wg.Add(1)
+	wg.Add(2)
 	go func() {
 		gc.Run(ctx)
 		wg.Done()
 	}()
+	go func() {
+		ledger.Run(ctx, cfg.UsageAccrualInterval)
+		wg.Done()
+	}()
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctx); err != nil {
@@ -0,0 +1,161 @@
+package gcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+const execCredentialAPIVersion = "client.authentication.k8s.io/v1"
+
+// WriteExecCredential gets a token using the same config options as the "gcp"
+// auth provider (see gcpAuthProvider) and writes it to out as a
+// client.authentication.k8s.io/v1 ExecCredential, which is what client-go
+// expects from an exec credential plugin.
+//
+// If cacheFile is not empty, the token is cached there and reused by later
+// invocations with the same config until it expires.
+func WriteExecCredential(gcpConfig map[string]string, cacheFile string, out io.Writer) error {
+	if err := checkExecInfo(os.Getenv("KUBERNETES_EXEC_INFO")); err != nil {
+		return err
+	}
+
+	var persister restclient.AuthProviderConfigPersister
+	var cached map[string]string
+	if cacheFile != "" {
+		fp := &filePersister{path: cacheFile}
+		c, err := fp.load()
+		if err != nil {
+			klog.V(4).Infof("Ignoring token cache: %v", err)
+		} else if sameConfig(c, gcpConfig) {
+			cached = c
+		}
+		persister = fp
+	}
+
+	if quotaProjectIgnored(gcpConfig) {
+		klog.Warningf("quota-project %q is ignored, it only applies to impersonation, application default and external account credentials", gcpConfig["quota-project"])
+	}
+	ts, err := tokenSource(gcpConfig)
+	if err != nil {
+		return err
+	}
+	cts, err := newCachedTokenSource(cached["access-token"], cached["expiry"], persister, ts, gcpConfig)
+	if err != nil {
+		return err
+	}
+	tok, err := cts.Token()
+	if err != nil {
+		return fmt.Errorf("getting token: %w", err)
+	}
+	// The process exits right away, so a token that is about to expire is
+	// refreshed now instead of in the background.
+	if !tok.Expiry.IsZero() && time.Until(tok.Expiry) <= cts.refreshSkew {
+		if err := cts.forceRefresh(tok.AccessToken); err != nil {
+			return fmt.Errorf("refreshing token: %w", err)
+		}
+		tok = cts.cachedToken()
+	}
+
+	cred := &clientauthv1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: execCredentialAPIVersion,
+			Kind:       "ExecCredential",
+		},
+		Status: &clientauthv1.ExecCredentialStatus{
+			Token: tok.AccessToken,
+		},
+	}
+	if !tok.Expiry.IsZero() {
+		expiry := metav1.NewTime(tok.Expiry)
+		cred.Status.ExpirationTimestamp = &expiry
+	}
+	return json.NewEncoder(out).Encode(cred)
+}
+
+// checkExecInfo verifies that client-go asked for an ExecCredential version
+// that is supported. The KUBERNETES_EXEC_INFO environment variable is only set
+// when the kubeconfig requests it (provideClusterInfo) or interactive mode is known.
+func checkExecInfo(execInfo string) error {
+	if execInfo == "" {
+		return nil
+	}
+	var info metav1.TypeMeta
+	if err := json.Unmarshal([]byte(execInfo), &info); err != nil {
+		return fmt.Errorf("parsing KUBERNETES_EXEC_INFO: %w", err)
+	}
+	if info.APIVersion != execCredentialAPIVersion {
+		return fmt.Errorf("unsupported ExecCredential apiVersion %q, only %q is supported", info.APIVersion, execCredentialAPIVersion)
+	}
+	return nil
+}
+
+// sameConfig returns true if a persisted cache was written for the given config,
+// so that a token is never reused after the config (for example cmd-path) changed.
+func sameConfig(cache, gcpConfig map[string]string) bool {
+	n := 0
+	for k, v := range cache {
+		if k == "access-token" || k == "expiry" {
+			continue
+		}
+		if gcpConfig[k] != v {
+			return false
+		}
+		n++
+	}
+	return n == len(gcpConfig)
+}
+
+// filePersister persists the token cache of a cachedTokenSource to a file.
+type filePersister struct {
+	path string
+}
+
+func (f *filePersister) load() (map[string]string, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cache map[string]string
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", f.path, err)
+	}
+	return cache, nil
+}
+
+// Persist writes the cache atomically, readable only by the current user.
+func (f *filePersister) Persist(cache map[string]string) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
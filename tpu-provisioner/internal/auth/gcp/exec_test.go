@@ -0,0 +1,120 @@
+package gcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteExecCredential(t *testing.T) {
+	execCommand = fakeExec
+	defer func() { execCommand = exec.Command }()
+	t.Setenv("KUBERNETES_EXEC_INFO", "")
+
+	cacheFile := filepath.Join(t.TempDir(), "cache", "token.json")
+	gcpConfig := map[string]string{"cmd-path": "/default/no/args"}
+
+	var out bytes.Buffer
+	if err := WriteExecCredential(gcpConfig, cacheFile, &out); err != nil {
+		t.Fatalf("WriteExecCredential: %v", err)
+	}
+	want := `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false},"status":{"expirationTimestamp":"2016-10-31T22:31:09Z","token":"faketoken"}}` + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	var cache map[string]string
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		t.Fatalf("reading cache: %v", err)
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatalf("parsing cache: %v", err)
+	}
+	if cache["access-token"] != "faketoken" || cache["cmd-path"] != "/default/no/args" {
+		t.Errorf("unexpected cache: %v", cache)
+	}
+}
+
+func TestWriteExecCredential_cached(t *testing.T) {
+	// The command is unknown to TestHelperProcess, so it fails if it is executed.
+	execCommand = fakeExec
+	defer func() { execCommand = exec.Command }()
+	t.Setenv("KUBERNETES_EXEC_INFO", `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","spec":{"interactive":false}}`)
+
+	gcpConfig := map[string]string{"cmd-path": "/not/executed"}
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	cacheFile := filepath.Join(t.TempDir(), "token.json")
+	persister := &filePersister{path: cacheFile}
+	if err := persister.Persist(map[string]string{
+		"cmd-path":     "/not/executed",
+		"access-token": "cachedtoken",
+		"expiry":       expiry.Format(time.RFC3339Nano),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := WriteExecCredential(gcpConfig, cacheFile, &out); err != nil {
+		t.Fatalf("WriteExecCredential: %v", err)
+	}
+	var cred struct {
+		Status struct {
+			Token               string    `json:"token"`
+			ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &cred); err != nil {
+		t.Fatal(err)
+	}
+	if cred.Status.Token != "cachedtoken" || !cred.Status.ExpirationTimestamp.Equal(expiry) {
+		t.Errorf("expected cached token expiring at %v, got: %+v", expiry, cred.Status)
+	}
+
+	// The cached token must not be used once the config changes.
+	gcpConfig["cmd-args"] = "--changed"
+	if err := WriteExecCredential(gcpConfig, cacheFile, &out); err == nil {
+		t.Errorf("expected cached token to be ignored after a config change")
+	}
+}
+
+func Test_checkExecInfo(t *testing.T) {
+	tests := []struct {
+		execInfo string
+		wantErr  bool
+	}{
+		{execInfo: ""},
+		{execInfo: `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential"}`},
+		{execInfo: `{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential"}`, wantErr: true},
+		{execInfo: `not json`, wantErr: true},
+	}
+	for _, tc := range tests {
+		if err := checkExecInfo(tc.execInfo); (err != nil) != tc.wantErr {
+			t.Errorf("checkExecInfo(%q) = %v, wantErr %v", tc.execInfo, err, tc.wantErr)
+		}
+	}
+}
+
+func Test_sameConfig(t *testing.T) {
+	gcpConfig := map[string]string{"cmd-path": "gcloud", "cmd-args": "config config-helper"}
+	tests := []struct {
+		name  string
+		cache map[string]string
+		want  bool
+	}{
+		{"same", map[string]string{"cmd-path": "gcloud", "cmd-args": "config config-helper", "access-token": "tok", "expiry": "x"}, true},
+		{"different value", map[string]string{"cmd-path": "gcloud", "cmd-args": "other", "access-token": "tok"}, false},
+		{"missing key", map[string]string{"cmd-path": "gcloud", "access-token": "tok"}, false},
+		{"extra key", map[string]string{"cmd-path": "gcloud", "cmd-args": "config config-helper", "scopes": ""}, false},
+		{"no cache", nil, false},
+	}
+	for _, tc := range tests {
+		if got := sameConfig(tc.cache, gcpConfig); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package gcp
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	registerTokenSource("file", newFileTokenSource)
+}
+
+// fileTokenSource reads an access token from a file that is rotated by another
+// process, such as a sidecar writing to a shared or projected volume. The file
+// has the same format as the output of cmd-path and is only re-read when its
+// modification time or size changed.
+type fileTokenSource struct {
+	path string
+	// format parses the file contents, it is never executed.
+	format *commandTokenSource
+
+	lk      sync.Mutex
+	modTime time.Time
+	size    int64
+	tok     *oauth2.Token
+}
+
+func newFileTokenSource(gcpConfig map[string]string, _ []string) (oauth2.TokenSource, error) {
+	path := gcpConfig["token-file"]
+	if path == "" {
+		return nil, fmt.Errorf("missing token-file")
+	}
+	if err := checkNoScopes(gcpConfig); err != nil {
+		return nil, err
+	}
+	return &fileTokenSource{
+		path:   path,
+		format: newCmdTokenSource("", nil, gcpConfig["token-key"], gcpConfig["expiry-key"], gcpConfig["time-fmt"]),
+	}, nil
+}
+
+func (f *fileTokenSource) Token() (*oauth2.Token, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading token-file: %v", err)
+	}
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if f.tok != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		tok := *f.tok
+		return &tok, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading token-file: %v", err)
+	}
+	tok, err := f.format.parseTokenCmdOutput(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing token-file %q: %v", f.path, err)
+	}
+	if tok.AccessToken == "" {
+		return nil, fmt.Errorf("token-file %q does not contain an access token", f.path)
+	}
+	f.tok, f.modTime, f.size = tok, info.ModTime(), info.Size()
+
+	copied := *tok
+	return &copied, nil
+}
@@ -0,0 +1,75 @@
+package gcp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	writeToken := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeToken(`{"credential": {"access_token": "token-a", "token_expiry": "2016-10-31T22:31:09Z"}}`, modTime)
+
+	ts, err := tokenSource(map[string]string{
+		"auth-type":  "file",
+		"token-file": path,
+		"token-key":  "{.credential.access_token}",
+		"expiry-key": "{.credential.token_expiry}",
+	})
+	if err != nil {
+		t.Fatalf("failed to get a token source: %v", err)
+	}
+	wantToken := func(want string) {
+		t.Helper()
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tok.AccessToken != want {
+			t.Errorf("got token %q, want %q", tok.AccessToken, want)
+		}
+	}
+	wantToken("token-a")
+
+	// Same modification time and size: the file is not read again.
+	writeToken(`{"credential": {"access_token": "token-b", "token_expiry": "2016-10-31T22:31:09Z"}}`, modTime)
+	wantToken("token-a")
+
+	// Rotated by the sidecar.
+	writeToken(`{"credential": {"access_token": "token-c", "token_expiry": "2016-10-31T23:31:09Z"}}`, modTime.Add(time.Minute))
+	wantToken("token-c")
+	tok, _ := ts.Token()
+	if want := time.Date(2016, 10, 31, 23, 31, 9, 0, time.UTC); !tok.Expiry.Equal(want) {
+		t.Errorf("got expiry %v, want %v", tok.Expiry, want)
+	}
+
+	writeToken(`{"credential": {}}`, modTime.Add(2*time.Minute))
+	if _, err := ts.Token(); err == nil {
+		t.Errorf("expected error for a file without a token")
+	}
+}
+
+func TestFileTokenSource_invalidConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		gcpConfig map[string]string
+	}{
+		{"missing token-file", map[string]string{"auth-type": "file"}},
+		{"scopes", map[string]string{"auth-type": "file", "token-file": "token.json", "scopes": "A"}},
+	}
+	for _, tc := range tests {
+		if _, err := tokenSource(tc.gcpConfig); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
@@ -19,8 +19,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"os/exec"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -38,11 +42,15 @@ func init() {
 	if err := restclient.RegisterAuthProviderPlugin("gcp", newGCPAuthProvider); err != nil {
 		klog.Fatalf("Failed to register gcp auth plugin: %v", err)
 	}
+	registerTokenSource("adc", adcTokenSource)
+	registerTokenSource("cmd", cmdTokenSource)
+	registerTokenSource("external-account", externalAccountTokenSourceFromConfig)
 }
 
 var (
 	// Stubbable for testing
-	execCommand = exec.Command
+	execCommand            = exec.Command
+	iamCredentialsEndpoint = "https://iamcredentials.googleapis.com"
 
 	// defaultScopes:
 	// - cloud-platform is the base scope to authenticate to GCP.
@@ -51,6 +59,22 @@ var (
 	defaultScopes = []string{
 		"https://www.googleapis.com/auth/cloud-platform",
 		"https://www.googleapis.com/auth/userinfo.email"}
+
+	// workloadIdentityPoolAudience matches the audience of a workload identity
+	// pool provider, as used in external account credential files.
+	workloadIdentityPoolAudience = regexp.MustCompile(`^//iam\.googleapis\.com/projects/[^/]+/locations/[^/]+/workloadIdentityPools/[^/]+/providers/[^/]+$`)
+)
+
+const (
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	// defaultRefreshSkew is how long before expiry cached tokens are refreshed
+	// in the background, unless configured with "refresh-skew".
+	defaultRefreshSkew = 5 * time.Minute
+
+	// Bounds of the exponential backoff after a failed token refresh.
+	minRefreshBackoff = time.Second
+	maxRefreshBackoff = time.Minute
 )
 
 // gcpAuthProvider is an auth provider plugin that uses GCP credentials to provide
@@ -66,17 +90,43 @@ var (
 //	      # Authentication options
 //	      # These options are used while getting a token.
 //
+//	      # Token source to use, one of "adc" (application default credentials),
+//	      # "cmd", "external-account", "file" or "metadata". If omitted, "cmd" is
+//	      # used if cmd-path is set, "external-account" if credential-file is set
+//	      # and "adc" otherwise.
+//	      "auth-type": "cmd",
+//
 //	      # comma-separated list of GCP API scopes. default value of this field
 //	      # is "https://www.googleapis.com/auth/cloud-platform,https://www.googleapis.com/auth/userinfo.email".
 //			 # to override the API scopes, specify this field explicitly.
 //	      "scopes": "https://www.googleapis.com/auth/cloud-platform"
 //
+//	      # Path to an external account credential configuration, as created by
+//	      # "gcloud iam workload-identity-pools create-cred-config", to use instead of
+//	      # application default credentials. Used for Workload Identity Federation.
+//	      "credential-file": "/etc/gcp/wif.json",
+//
+//	      # Workload identity pool provider audience, overrides the audience of the
+//	      # credential-file.
+//	      "audience": "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/ci/providers/github",
+//
+//	      # Service account to impersonate with the credentials obtained by any of
+//	      # the other options. A comma-separated delegation chain can be given, where
+//	      # each account impersonates the next and the last one is the target.
+//	      "impersonate-service-account": "delegate@proj.iam.gserviceaccount.com,target@proj.iam.gserviceaccount.com",
+//
+//	      # Project to bill and use quota of, sent as X-Goog-User-Project on requests.
+//	      "quota-project": "my-project",
+//
 //	      # Caching options
 //
 //	      # Raw string data representing cached access token.
 //	      "access-token": "REMOVED",
 //	      # RFC3339Nano expiration timestamp for cached access token.
 //	      "expiry": "2016-10-31 22:31:9.123",
+//	      # How long before expiry the cached access token is refreshed in the
+//	      # background, as a Go duration. If omitted, defaults to "5m".
+//	      "refresh-skew": "5m",
 //
 //	      # Command execution options
 //	      # These options direct the plugin to execute a specified command and parse
@@ -101,7 +151,20 @@ var (
 //
 //	      # golang reference time in the format that the expiration timestamp uses.
 //	      # If omitted, defaults to time.RFC3339Nano
-//	      "time-fmt": "2006-01-02 15:04:05.999999999"
+//	      "time-fmt": "2006-01-02 15:04:05.999999999",
+//
+//	      # File options
+//	      # The "file" auth-type reads the token from a file that is rotated by
+//	      # another process, for example a sidecar. The file has the same format as
+//	      # the command output above and is parsed with token-key, expiry-key and
+//	      # time-fmt. It is re-read whenever it changed.
+//	      "token-file": "/var/run/secrets/gcp/token.json",
+//
+//	      # Metadata server options
+//	      # The "metadata" auth-type gets the token of the default service account
+//	      # from the GCE/GKE metadata server. If omitted, the host defaults to
+//	      # $GCE_METADATA_HOST or 169.254.169.254.
+//	      "metadata-host": "169.254.169.254"
 //	    }
 //	  }
 //	}
@@ -111,7 +174,7 @@ type gcpAuthProvider struct {
 }
 
 func newGCPAuthProvider(_ string, gcpConfig map[string]string, persister restclient.AuthProviderConfigPersister) (restclient.AuthProvider, error) {
-	ts, err := tokenSource(isCmdTokenSource(gcpConfig), gcpConfig)
+	ts, err := tokenSource(gcpConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -122,41 +185,195 @@ func newGCPAuthProvider(_ string, gcpConfig map[string]string, persister restcli
 	return &gcpAuthProvider{cts, persister}, nil
 }
 
-func isCmdTokenSource(gcpConfig map[string]string) bool {
-	_, ok := gcpConfig["cmd-path"]
-	return ok
+// tokenSourceFactory returns the token source of an auth-type, which is used to
+// get tokens with the given scopes.
+type tokenSourceFactory func(gcpConfig map[string]string, scopes []string) (oauth2.TokenSource, error)
+
+var tokenSourceFactories = map[string]tokenSourceFactory{}
+
+// registerTokenSource makes a token source available as the given auth-type.
+func registerTokenSource(authType string, f tokenSourceFactory) {
+	if _, ok := tokenSourceFactories[authType]; ok {
+		panic(fmt.Sprintf("token source %q registered twice", authType))
+	}
+	tokenSourceFactories[authType] = f
 }
 
-func tokenSource(isCmd bool, gcpConfig map[string]string) (oauth2.TokenSource, error) {
-	// Command-based token source
-	if isCmd {
-		cmd := gcpConfig["cmd-path"]
-		if len(cmd) == 0 {
-			return nil, fmt.Errorf("missing access token cmd")
-		}
-		if gcpConfig["scopes"] != "" {
-			return nil, fmt.Errorf("scopes can only be used when kubectl is using a gcp service account key")
-		}
-		var args []string
-		if cmdArgs, ok := gcpConfig["cmd-args"]; ok {
-			args = strings.Fields(cmdArgs)
-		} else {
-			fields := strings.Fields(cmd)
-			cmd = fields[0]
-			args = fields[1:]
-		}
-		return newCmdTokenSource(cmd, args, gcpConfig["token-key"], gcpConfig["expiry-key"], gcpConfig["time-fmt"]), nil
+// authTypes returns the sorted names of all registered token sources.
+func authTypes() []string {
+	names := make([]string, 0, len(tokenSourceFactories))
+	for name := range tokenSourceFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// authType returns the configured auth-type. Without one, it is derived from
+// the options that are present, as it was before auth-type existed.
+func authType(gcpConfig map[string]string) string {
+	if t, ok := gcpConfig["auth-type"]; ok {
+		return t
+	}
+	if _, ok := gcpConfig["cmd-path"]; ok {
+		return "cmd"
+	}
+	if _, ok := gcpConfig["credential-file"]; ok {
+		return "external-account"
+	}
+	return "adc"
+}
+
+func tokenSource(gcpConfig map[string]string) (oauth2.TokenSource, error) {
+	t := authType(gcpConfig)
+	newTokenSource, ok := tokenSourceFactories[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown auth-type %q, expected one of: %s", t, strings.Join(authTypes(), ", "))
+	}
+	if _, ok := gcpConfig["audience"]; ok && t != "external-account" {
+		return nil, fmt.Errorf("audience can only be used with a credential-file")
 	}
 
-	// Google Application Credentials-based token source
+	chain, impersonate := gcpConfig["impersonate-service-account"]
 	scopes := parseScopes(gcpConfig)
-	ts, err := google.DefaultTokenSource(context.Background(), scopes...)
+	if impersonate {
+		// The base token is only used to call the IAM Credentials API.
+		scopes = []string{cloudPlatformScope}
+	}
+	ts, err := newTokenSource(gcpConfig, scopes)
+	if err != nil || !impersonate {
+		return ts, err
+	}
+	return newImpersonatedTokenSource(ts, chain, parseScopes(gcpConfig), gcpConfig["quota-project"])
+}
+
+// checkNoScopes returns an error if scopes are configured for a token source
+// that cannot request them, unless the token is only used for impersonation.
+func checkNoScopes(gcpConfig map[string]string) error {
+	if _, impersonate := gcpConfig["impersonate-service-account"]; gcpConfig["scopes"] != "" && !impersonate {
+		return fmt.Errorf("scopes can only be used when kubectl is using a gcp service account key")
+	}
+	return nil
+}
+
+// Google Application Credentials-based token source
+func adcTokenSource(gcpConfig map[string]string, scopes []string) (oauth2.TokenSource, error) {
+	ts, err := google.DefaultTokenSource(quotaProjectContext(gcpConfig["quota-project"]), scopes...)
 	if err != nil {
 		return nil, fmt.Errorf("cannot construct google default token source: %v", err)
 	}
 	return ts, nil
 }
 
+// quotaProjectContext returns the context to create token sources with, its
+// HTTP client bills token requests to quotaProject if set.
+func quotaProjectContext(quotaProject string) context.Context {
+	ctx := context.Background()
+	if quotaProject == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+		Transport: &quotaProjectTransport{quotaProject, http.DefaultTransport},
+	})
+}
+
+// quotaProjectTransport sets X-Goog-User-Project on requests without one.
+type quotaProjectTransport struct {
+	quotaProject string
+	base         http.RoundTripper
+}
+
+func (t *quotaProjectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("X-Goog-User-Project") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("X-Goog-User-Project", t.quotaProject)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// quotaProjectIgnored returns true if quota-project is set but is not used to
+// get the token, so it only takes effect through the X-Goog-User-Project
+// header on API server requests, which is not sent when the token is handed to
+// another client.
+func quotaProjectIgnored(gcpConfig map[string]string) bool {
+	if gcpConfig["quota-project"] == "" {
+		return false
+	}
+	if _, impersonate := gcpConfig["impersonate-service-account"]; impersonate {
+		return false
+	}
+	switch authType(gcpConfig) {
+	case "adc", "external-account":
+		return false
+	}
+	return true
+}
+
+// Command-based token source
+func cmdTokenSource(gcpConfig map[string]string, _ []string) (oauth2.TokenSource, error) {
+	cmd := gcpConfig["cmd-path"]
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("missing access token cmd")
+	}
+	if _, ok := gcpConfig["credential-file"]; ok {
+		return nil, fmt.Errorf("credential-file cannot be used with cmd-path")
+	}
+	if err := checkNoScopes(gcpConfig); err != nil {
+		return nil, err
+	}
+	var args []string
+	if cmdArgs, ok := gcpConfig["cmd-args"]; ok {
+		args = strings.Fields(cmdArgs)
+	} else {
+		fields := strings.Fields(cmd)
+		cmd = fields[0]
+		args = fields[1:]
+	}
+	return newCmdTokenSource(cmd, args, gcpConfig["token-key"], gcpConfig["expiry-key"], gcpConfig["time-fmt"]), nil
+}
+
+// Workload Identity Federation token source
+func externalAccountTokenSourceFromConfig(gcpConfig map[string]string, scopes []string) (oauth2.TokenSource, error) {
+	return externalAccountTokenSource(gcpConfig["credential-file"], gcpConfig["audience"], gcpConfig["quota-project"], scopes)
+}
+
+// externalAccountTokenSource reads an external account credential file and
+// returns a token source that exchanges its subject token through STS.
+func externalAccountTokenSource(path, audience, quotaProject string, scopes []string) (oauth2.TokenSource, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing credential-file")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read credential-file: %v", err)
+	}
+	var f map[string]interface{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("cannot parse credential-file %q: %v", path, err)
+	}
+	if f["type"] != "external_account" {
+		return nil, fmt.Errorf("credential-file %q is of type %q, expected \"external_account\"", path, f["type"])
+	}
+	if audience != "" {
+		f["audience"] = audience
+	}
+	if aud, _ := f["audience"].(string); !workloadIdentityPoolAudience.MatchString(aud) {
+		return nil, fmt.Errorf("audience %q is not a workload identity pool provider, expected \"//iam.googleapis.com/projects/<number>/locations/global/workloadIdentityPools/<pool>/providers/<provider>\"", aud)
+	}
+	if quotaProject != "" {
+		f["quota_project_id"] = quotaProject
+	}
+	if data, err = json.Marshal(f); err != nil {
+		return nil, err
+	}
+
+	creds, err := google.CredentialsFromJSON(quotaProjectContext(quotaProject), data, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot construct external account token source: %v", err)
+	}
+	return creds.TokenSource, nil
+}
+
 // parseScopes constructs a list of scopes that should be included in token source
 // from the config map.
 func parseScopes(gcpConfig map[string]string) []string {
@@ -172,16 +389,22 @@ func parseScopes(gcpConfig map[string]string) []string {
 
 func (g *gcpAuthProvider) WrapTransport(rt http.RoundTripper) http.RoundTripper {
 	var resetCache map[string]string
-	if cts, ok := g.tokenSource.(*cachedTokenSource); ok {
+	cts, ok := g.tokenSource.(*cachedTokenSource)
+	if ok {
 		resetCache = cts.baseCache()
 	} else {
 		resetCache = make(map[string]string)
 	}
-	return &conditionalTransport{&oauth2.Transport{Source: g.tokenSource, Base: rt}, g.persister, resetCache}
+	return &conditionalTransport{&oauth2.Transport{Source: g.tokenSource, Base: rt}, g.persister, resetCache, resetCache["quota-project"], cts}
 }
 
 func (g *gcpAuthProvider) Login() error { return nil }
 
+// cachedTokenSource caches tokens of the underlying source until they expire.
+// Tokens are refreshed in the background once they expire within refreshSkew,
+// either by a timer that is armed when a token is handed out or by the next
+// caller, concurrent callers share a single refresh, and failed refreshes are
+// retried with exponential backoff.
 type cachedTokenSource struct {
 	lk          sync.Mutex
 	source      oauth2.TokenSource
@@ -189,6 +412,27 @@ type cachedTokenSource struct {
 	expiry      time.Time
 	persister   restclient.AuthProviderConfigPersister
 	cache       map[string]string
+	refreshSkew time.Duration
+
+	// inflight is the refresh in progress, if any.
+	inflight *tokenRefresh
+	// timer starts a refresh refreshSkew before the cached token expires. It
+	// is only armed by Token, so a source that is no longer used stops
+	// refreshing.
+	timer *time.Timer
+	// backoff is the current delay after a failed refresh, no refresh is
+	// attempted before retryAfter. lastErr is the error of that refresh.
+	backoff    time.Duration
+	retryAfter time.Time
+	lastErr    error
+}
+
+// tokenRefresh is a call to the underlying token source, done is closed once
+// tok and err are set.
+type tokenRefresh struct {
+	done chan struct{}
+	tok  *oauth2.Token
+	err  error
 }
 
 func newCachedTokenSource(accessToken, expiry string, persister restclient.AuthProviderConfigPersister, ts oauth2.TokenSource, cache map[string]string) (*cachedTokenSource, error) {
@@ -199,36 +443,132 @@ func newCachedTokenSource(accessToken, expiry string, persister restclient.AuthP
 	if cache == nil {
 		cache = make(map[string]string)
 	}
+	refreshSkew := defaultRefreshSkew
+	if skew, ok := cache["refresh-skew"]; ok {
+		d, err := time.ParseDuration(skew)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid refresh-skew %q, expected a non-negative duration such as \"5m\"", skew)
+		}
+		refreshSkew = d
+	}
 	return &cachedTokenSource{
 		source:      ts,
 		accessToken: accessToken,
 		expiry:      expiryTime,
 		persister:   persister,
 		cache:       cache,
+		refreshSkew: refreshSkew,
 	}, nil
 }
 
 func (t *cachedTokenSource) Token() (*oauth2.Token, error) {
-	tok := t.cachedToken()
+	t.lk.Lock()
+	tok := t.cachedTokenLocked()
 	if tok.Valid() && !tok.Expiry.IsZero() {
+		if time.Until(tok.Expiry) <= t.refreshSkew {
+			// Refresh in the background and keep using the current token
+			// until that succeeds.
+			t.refreshLocked()
+		} else {
+			t.scheduleRefreshLocked(tok.Expiry)
+		}
+		t.lk.Unlock()
 		return tok, nil
 	}
-	tok, err := t.source.Token()
+	r, err := t.refreshLocked()
+	t.lk.Unlock()
 	if err != nil {
 		return nil, err
 	}
-	cache := t.update(tok)
+	<-r.done
+	return r.tok, r.err
+}
+
+// forceRefresh refreshes the token unless it already changed from stale, which
+// is the token that was rejected. It returns once the refresh completed.
+func (t *cachedTokenSource) forceRefresh(stale string) error {
+	t.lk.Lock()
+	if t.accessToken != stale {
+		t.lk.Unlock()
+		return nil
+	}
+	r, err := t.refreshLocked()
+	t.lk.Unlock()
+	if err != nil {
+		return err
+	}
+	<-r.done
+	return r.err
+}
+
+// scheduleRefreshLocked arms the timer to refresh the token that expires at
+// expiry, so that callers keep getting a valid token without waiting for the
+// refresh even if they do not call Token within refreshSkew of the expiry.
+func (t *cachedTokenSource) scheduleRefreshLocked(expiry time.Time) {
+	if t.timer != nil {
+		return
+	}
+	t.timer = time.AfterFunc(time.Until(expiry)-t.refreshSkew, func() {
+		t.lk.Lock()
+		defer t.lk.Unlock()
+		t.timer = nil
+		if _, err := t.refreshLocked(); err != nil {
+			klog.V(4).Infof("Skipping scheduled token refresh: %v", err)
+		}
+	})
+}
+
+// refreshLocked returns the refresh in progress or starts a new one, unless
+// the last refresh failed and its backoff did not pass yet.
+func (t *cachedTokenSource) refreshLocked() (*tokenRefresh, error) {
+	if t.inflight != nil {
+		return t.inflight, nil
+	}
+	if time.Now().Before(t.retryAfter) {
+		return nil, fmt.Errorf("not refreshing token before %s after error: %w", t.retryAfter.Format(time.RFC3339), t.lastErr)
+	}
+	r := &tokenRefresh{done: make(chan struct{})}
+	t.inflight = r
+	go t.refresh(r)
+	return r, nil
+}
+
+func (t *cachedTokenSource) refresh(r *tokenRefresh) {
+	defer close(r.done)
+	r.tok, r.err = t.source.Token()
+	if r.err != nil {
+		r.tok = nil
+		t.lk.Lock()
+		t.inflight = nil
+		t.backoff = min(max(2*t.backoff, minRefreshBackoff), maxRefreshBackoff)
+		t.retryAfter = time.Now().Add(t.backoff)
+		t.lastErr = r.err
+		t.lk.Unlock()
+		klog.V(4).Infof("Failed to refresh token, retrying in %v: %v", t.backoff, r.err)
+		return
+	}
+
+	cache := t.update(r.tok)
+	t.lk.Lock()
+	t.inflight = nil
+	t.backoff = 0
+	t.retryAfter = time.Time{}
+	t.lastErr = nil
+	t.lk.Unlock()
 	if t.persister != nil {
 		if err := t.persister.Persist(cache); err != nil {
 			klog.V(4).Infof("Failed to persist token: %v", err)
 		}
 	}
-	return tok, nil
 }
 
 func (t *cachedTokenSource) cachedToken() *oauth2.Token {
 	t.lk.Lock()
 	defer t.lk.Unlock()
+	return t.cachedTokenLocked()
+}
+
+func (t *cachedTokenSource) cachedTokenLocked() *oauth2.Token {
 	return &oauth2.Token{
 		AccessToken: t.accessToken,
 		TokenType:   "Bearer",
@@ -338,6 +678,88 @@ func (c *commandTokenSource) parseTokenCmdOutput(output []byte) (*oauth2.Token,
 	}, nil
 }
 
+// impersonatedTokenSource generates access tokens for a service account with
+// the IAM Credentials API, authenticating with the base token source.
+type impersonatedTokenSource struct {
+	client       *http.Client
+	target       string
+	delegates    []string
+	scopes       []string
+	quotaProject string
+}
+
+func newImpersonatedTokenSource(base oauth2.TokenSource, chain string, scopes []string, quotaProject string) (*impersonatedTokenSource, error) {
+	var accounts []string
+	for _, a := range strings.Split(chain, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			accounts = append(accounts, a)
+		}
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("missing service account to impersonate")
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("scopes cannot be empty when impersonating a service account")
+	}
+	return &impersonatedTokenSource{
+		client:       oauth2.NewClient(context.Background(), base),
+		target:       accounts[len(accounts)-1],
+		delegates:    accounts[:len(accounts)-1],
+		scopes:       scopes,
+		quotaProject: quotaProject,
+	}, nil
+}
+
+func (i *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	delegates := make([]string, 0, len(i.delegates))
+	for _, d := range i.delegates {
+		delegates = append(delegates, "projects/-/serviceAccounts/"+d)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"delegates": delegates,
+		"scope":     i.scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/v1/projects/-/serviceAccounts/%s:generateAccessToken", iamCredentialsEndpoint, i.target)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if i.quotaProject != "" {
+		req.Header.Set("X-Goog-User-Project", i.quotaProject)
+	}
+
+	res, err := i.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error impersonating service account %q: %v", i.target, err)
+	}
+	defer res.Body.Close()
+	output, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading access token for service account %q: %v", i.target, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error impersonating service account %q: status=%d body=%s", i.target, res.StatusCode, output)
+	}
+
+	var data struct {
+		AccessToken string    `json:"accessToken"`
+		ExpireTime  time.Time `json:"expireTime"`
+	}
+	if err := json.Unmarshal(output, &data); err != nil {
+		return nil, fmt.Errorf("error parsing access token for service account %q: %v", i.target, err)
+	}
+	return &oauth2.Token{
+		AccessToken: data.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      data.ExpireTime,
+	}, nil
+}
+
 func parseJSONPath(input interface{}, name, template string) (string, error) {
 	j := jsonpath.New(name)
 	buf := new(bytes.Buffer)
@@ -354,6 +776,10 @@ type conditionalTransport struct {
 	oauthTransport *oauth2.Transport
 	persister      restclient.AuthProviderConfigPersister
 	resetCache     map[string]string
+	quotaProject   string
+	// refresher is used to refresh the token and retry requests that were
+	// rejected as unauthorized, nil if the token source is not cached.
+	refresher *cachedTokenSource
 }
 
 var _ net.RoundTripperWrapper = &conditionalTransport{}
@@ -363,12 +789,37 @@ func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, err
 		return t.oauthTransport.Base.RoundTrip(req)
 	}
 
+	if t.quotaProject != "" && req.Header.Get("X-Goog-User-Project") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("X-Goog-User-Project", t.quotaProject)
+	}
+
+	var stale string
+	if t.refresher != nil {
+		stale = t.refresher.cachedToken().AccessToken
+	}
+
 	res, err := t.oauthTransport.RoundTrip(req)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if res.StatusCode == 401 && t.refresher != nil {
+		if retry, ok := rewindRequest(req); ok {
+			if err := t.refresher.forceRefresh(stale); err != nil {
+				klog.V(4).Infof("Failed to refresh token after unauthorized response: %v", err)
+			} else {
+				if res.Body != nil {
+					res.Body.Close()
+				}
+				if res, err = t.oauthTransport.RoundTrip(retry); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
 	if res.StatusCode == 401 {
 		klog.V(4).Infof("The credentials that were supplied are invalid for the target cluster")
 		t.persister.Persist(t.resetCache)
@@ -377,4 +828,22 @@ func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, err
 	return res, nil
 }
 
+// rewindRequest returns a request that can be sent again, which is only
+// possible if its body is empty or can be recreated.
+func rewindRequest(req *http.Request) (*http.Request, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	retry := req.Clone(req.Context())
+	retry.Body = body
+	return retry, true
+}
+
 func (t *conditionalTransport) WrappedRoundTripper() http.RoundTripper { return t.oauthTransport.Base }
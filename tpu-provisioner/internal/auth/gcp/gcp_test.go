@@ -19,14 +19,18 @@ limitations under the License.
 package gcp
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -119,27 +123,40 @@ func TestHelperProcess(t *testing.T) {
 	os.Exit(0)
 }
 
-func Test_isCmdTokenSource(t *testing.T) {
-	c1 := map[string]string{"cmd-path": "foo"}
-	if v := isCmdTokenSource(c1); !v {
-		t.Fatalf("cmd-path present in config (%+v), but got %v", c1, v)
+func Test_authType(t *testing.T) {
+	cases := []struct {
+		gcpConfig map[string]string
+		want      string
+	}{
+		{map[string]string{}, "adc"},
+		{map[string]string{"cmd-path": "foo"}, "cmd"},
+		{map[string]string{"cmd-args": "foo bar"}, "adc"},
+		{map[string]string{"credential-file": "wif.json"}, "external-account"},
+		{map[string]string{"auth-type": "file", "cmd-path": "foo"}, "file"},
 	}
+	for _, c := range cases {
+		if got := authType(c.gcpConfig); got != c.want {
+			t.Errorf("authType(%v) = %q, want %q", c.gcpConfig, got, c.want)
+		}
+	}
+}
 
-	c2 := map[string]string{"cmd-args": "foo bar"}
-	if v := isCmdTokenSource(c2); v {
-		t.Fatalf("cmd-path not present in config (%+v), but got %v", c2, v)
+func Test_tokenSource_unknownAuthType(t *testing.T) {
+	_, err := tokenSource(map[string]string{"auth-type": "kerberos"})
+	if err == nil || !strings.Contains(err.Error(), "adc, cmd, external-account, file, metadata") {
+		t.Errorf("expected error listing the auth types, got: %v", err)
 	}
 }
 
 func Test_tokenSource_cmd(t *testing.T) {
-	if _, err := tokenSource(true, map[string]string{}); err == nil {
+	if _, err := tokenSource(map[string]string{"auth-type": "cmd"}); err == nil {
 		t.Fatalf("expected error, cmd-args not present in config")
 	}
 
 	c := map[string]string{
 		"cmd-path": "foo",
 		"cmd-args": "bar"}
-	ts, err := tokenSource(true, c)
+	ts, err := tokenSource(c)
 	if err != nil {
 		t.Fatalf("failed to return cmd token source: %+v", err)
 	}
@@ -155,7 +172,7 @@ func Test_tokenSource_cmdCannotBeUsedWithScopes(t *testing.T) {
 	c := map[string]string{
 		"cmd-path": "foo",
 		"scopes":   "A,B"}
-	if _, err := tokenSource(true, c); err == nil {
+	if _, err := tokenSource(c); err == nil {
 		t.Fatal("expected error when scopes is used with cmd-path")
 	}
 }
@@ -171,7 +188,7 @@ func Test_tokenSource_applicationDefaultCredentials_fails(t *testing.T) {
 
 	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", fakeTokenFile.Name())
 	defer os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if _, err := tokenSource(false, map[string]string{}); err == nil {
+	if _, err := tokenSource(map[string]string{}); err == nil {
 		t.Fatalf("expected error because specified ADC token file is not a JSON")
 	}
 }
@@ -189,7 +206,7 @@ func Test_tokenSource_applicationDefaultCredentials(t *testing.T) {
 
 	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", fakeTokenFile.Name())
 	defer os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
-	ts, err := tokenSource(false, map[string]string{})
+	ts, err := tokenSource(map[string]string{})
 	if err != nil {
 		t.Fatalf("failed to get a token source: %+v", err)
 	}
@@ -198,6 +215,223 @@ func Test_tokenSource_applicationDefaultCredentials(t *testing.T) {
 	}
 }
 
+func writeCredentialFile(t *testing.T, tokenURL, audience string) string {
+	dir := t.TempDir()
+	subjectTokenFile := filepath.Join(dir, "oidc-token")
+	if err := os.WriteFile(subjectTokenFile, []byte("fakeoidctoken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"type":               "external_account",
+		"audience":           audience,
+		"subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+		"token_url":          tokenURL,
+		"credential_source":  map[string]string{"file": subjectTokenFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialFile := filepath.Join(dir, "credentials.json")
+	if err := os.WriteFile(credentialFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return credentialFile
+}
+
+func Test_tokenSource_externalAccount(t *testing.T) {
+	const (
+		fileAudience = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/ci/providers/github"
+		audience     = "//iam.googleapis.com/projects/456/locations/global/workloadIdentityPools/ci/providers/gitlab"
+	)
+	var gotAudience, gotSubjectToken string
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing STS request: %v", err)
+		}
+		gotAudience = r.Form.Get("audience")
+		gotSubjectToken = r.Form.Get("subject_token")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"federatedtoken","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer sts.Close()
+	credentialFile := writeCredentialFile(t, sts.URL, fileAudience)
+
+	tests := []struct {
+		name         string
+		gcpConfig    map[string]string
+		wantAudience string
+	}{
+		{"audience from credential-file", map[string]string{"credential-file": credentialFile}, fileAudience},
+		{"audience override", map[string]string{"credential-file": credentialFile, "audience": audience}, audience},
+	}
+	for _, tc := range tests {
+		ts, err := tokenSource(tc.gcpConfig)
+		if err != nil {
+			t.Fatalf("%s: failed to get a token source: %v", tc.name, err)
+		}
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatalf("%s: failed to get a token: %v", tc.name, err)
+		}
+		if tok.AccessToken != "federatedtoken" {
+			t.Errorf("%s: got token %q, want %q", tc.name, tok.AccessToken, "federatedtoken")
+		}
+		if gotAudience != tc.wantAudience || gotSubjectToken != "fakeoidctoken" {
+			t.Errorf("%s: got audience=%q subject_token=%q, want audience=%q subject_token=%q", tc.name, gotAudience, gotSubjectToken, tc.wantAudience, "fakeoidctoken")
+		}
+	}
+}
+
+func Test_tokenSource_quotaProject(t *testing.T) {
+	var gotQuotaProject string
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuotaProject = r.Header.Get("X-Goog-User-Project")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"federatedtoken","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer sts.Close()
+	credentialFile := writeCredentialFile(t, sts.URL, "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/ci/providers/github")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentialFile)
+
+	tests := []struct {
+		name      string
+		gcpConfig map[string]string
+		want      string
+	}{
+		{"adc", map[string]string{"quota-project": "billing"}, "billing"},
+		{"adc without quota-project", map[string]string{}, ""},
+		{"external-account", map[string]string{"credential-file": credentialFile, "quota-project": "billing"}, "billing"},
+	}
+	for _, tc := range tests {
+		gotQuotaProject = ""
+		ts, err := tokenSource(tc.gcpConfig)
+		if err != nil {
+			t.Fatalf("%s: failed to get a token source: %v", tc.name, err)
+		}
+		if _, err := ts.Token(); err != nil {
+			t.Fatalf("%s: failed to get a token: %v", tc.name, err)
+		}
+		if gotQuotaProject != tc.want {
+			t.Errorf("%s: got X-Goog-User-Project %q, want %q", tc.name, gotQuotaProject, tc.want)
+		}
+	}
+}
+
+func Test_quotaProjectIgnored(t *testing.T) {
+	tests := []struct {
+		gcpConfig map[string]string
+		want      bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"quota-project": "billing"}, false},
+		{map[string]string{"quota-project": "billing", "credential-file": "wif.json"}, false},
+		{map[string]string{"quota-project": "billing", "cmd-path": "gcloud"}, true},
+		{map[string]string{"quota-project": "billing", "auth-type": "metadata"}, true},
+		{map[string]string{"quota-project": "billing", "auth-type": "metadata", "impersonate-service-account": "sa@p.iam.gserviceaccount.com"}, false},
+	}
+	for _, tc := range tests {
+		if got := quotaProjectIgnored(tc.gcpConfig); got != tc.want {
+			t.Errorf("quotaProjectIgnored(%v) = %v, want %v", tc.gcpConfig, got, tc.want)
+		}
+	}
+}
+
+func Test_tokenSource_externalAccount_invalid(t *testing.T) {
+	const audience = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/ci/providers/github"
+	credentialFile := writeCredentialFile(t, "https://sts.googleapis.com/v1/token", audience)
+	serviceAccountFile := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(serviceAccountFile, []byte(`{"type":"service_account"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		gcpConfig map[string]string
+	}{
+		{"missing file", map[string]string{"credential-file": filepath.Join(t.TempDir(), "missing.json")}},
+		{"not an external account", map[string]string{"credential-file": serviceAccountFile}},
+		{"not a workload identity pool audience", map[string]string{"credential-file": credentialFile, "audience": "https://example.com"}},
+		{"audience without credential-file", map[string]string{"audience": audience}},
+		{"credential-file with cmd-path", map[string]string{"credential-file": credentialFile, "cmd-path": "foo"}},
+	}
+	for _, tc := range tests {
+		if _, err := tokenSource(tc.gcpConfig); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+func Test_tokenSource_impersonate(t *testing.T) {
+	execCommand = fakeExec
+	defer func() { execCommand = exec.Command }()
+
+	var gotPath, gotAuth, gotQuotaProject string
+	var gotBody map[string][]string
+	iam := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		gotQuotaProject = r.Header.Get("X-Goog-User-Project")
+		if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+			t.Errorf("decoding generateAccessToken request: %v", err)
+		}
+		fmt.Fprint(w, `{"accessToken":"impersonatedtoken","expireTime":"2016-10-31T23:31:09Z"}`)
+	}))
+	defer iam.Close()
+	iamCredentialsEndpoint = iam.URL
+	defer func() { iamCredentialsEndpoint = "https://iamcredentials.googleapis.com" }()
+
+	ts, err := tokenSource(map[string]string{
+		"cmd-path":                    "/default/no/args",
+		"cmd-args":                    "",
+		"scopes":                      "A,B",
+		"impersonate-service-account": "delegate@proj.iam.gserviceaccount.com, target@proj.iam.gserviceaccount.com",
+		"quota-project":               "billing-proj",
+	})
+	if err != nil {
+		t.Fatalf("failed to get a token source: %v", err)
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("failed to get a token: %v", err)
+	}
+
+	wantExpiry := time.Date(2016, 10, 31, 23, 31, 9, 0, time.UTC)
+	if tok.AccessToken != "impersonatedtoken" || !tok.Expiry.Equal(wantExpiry) {
+		t.Errorf("got token %q expiring at %v, want %q expiring at %v", tok.AccessToken, tok.Expiry, "impersonatedtoken", wantExpiry)
+	}
+	if want := "/v1/projects/-/serviceAccounts/target@proj.iam.gserviceaccount.com:generateAccessToken"; gotPath != want {
+		t.Errorf("got path %q, want %q", gotPath, want)
+	}
+	if want := "Bearer faketoken"; gotAuth != want {
+		t.Errorf("got Authorization %q, want %q", gotAuth, want)
+	}
+	if want := "billing-proj"; gotQuotaProject != want {
+		t.Errorf("got X-Goog-User-Project %q, want %q", gotQuotaProject, want)
+	}
+	wantBody := map[string][]string{
+		"delegates": {"projects/-/serviceAccounts/delegate@proj.iam.gserviceaccount.com"},
+		"scope":     {"A", "B"},
+	}
+	if !reflect.DeepEqual(gotBody, wantBody) {
+		t.Errorf("got request %v, want %v", gotBody, wantBody)
+	}
+}
+
+func Test_tokenSource_impersonate_invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		gcpConfig map[string]string
+	}{
+		{"no service account", map[string]string{"cmd-path": "foo", "impersonate-service-account": " , "}},
+		{"empty scopes", map[string]string{"cmd-path": "foo", "impersonate-service-account": "target@proj.iam.gserviceaccount.com", "scopes": ""}},
+	}
+	for _, tc := range tests {
+		if _, err := tokenSource(tc.gcpConfig); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
 func Test_parseScopes(t *testing.T) {
 	cases := []struct {
 		in  map[string]string
@@ -436,6 +670,184 @@ func TestCachedTokenSource(t *testing.T) {
 	}
 }
 
+// countingTokenSource returns a new token "token-<n>" on the n-th call, after
+// waiting for release if set.
+type countingTokenSource struct {
+	calls   atomic.Int32
+	release chan struct{}
+	expiry  time.Duration
+	err     error
+}
+
+func (c *countingTokenSource) Token() (*oauth2.Token, error) {
+	n := c.calls.Add(1)
+	if c.release != nil {
+		<-c.release
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", n), Expiry: time.Now().Add(c.expiry)}, nil
+}
+
+func TestCachedTokenSource_singleflight(t *testing.T) {
+	source := &countingTokenSource{release: make(chan struct{}), expiry: time.Hour}
+	ts, err := newCachedTokenSource("expired", time.Now().Add(-time.Minute).Format(time.RFC3339Nano), nil, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tok, err := ts.Token()
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			tokens[i] = tok.AccessToken
+		}(i)
+	}
+	// Give all callers time to wait on the same refresh.
+	time.Sleep(50 * time.Millisecond)
+	close(source.release)
+	wg.Wait()
+
+	if got := source.calls.Load(); got != 1 {
+		t.Errorf("got %d calls to the token source, want 1", got)
+	}
+	for _, tok := range tokens {
+		if tok != "token-1" {
+			t.Errorf("got token %q, want %q", tok, "token-1")
+		}
+	}
+}
+
+func TestCachedTokenSource_proactiveRefresh(t *testing.T) {
+	source := &countingTokenSource{release: make(chan struct{}), expiry: time.Hour}
+	cache := map[string]string{"refresh-skew": "10m"}
+	ts, err := newCachedTokenSource("cached", time.Now().Add(5*time.Minute).Format(time.RFC3339Nano), nil, source, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cached token is returned while it is refreshed in the background.
+	for i := 0; i < 3; i++ {
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tok.AccessToken != "cached" {
+			t.Errorf("got token %q, want %q", tok.AccessToken, "cached")
+		}
+	}
+	close(source.release)
+
+	if err := waitFor(func() bool { return ts.cachedToken().AccessToken == "token-1" }); err != nil {
+		t.Fatalf("token was not refreshed in the background: %v", err)
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok.AccessToken != "token-1" {
+		t.Errorf("got token %q, want %q", tok.AccessToken, "token-1")
+	}
+	if got := source.calls.Load(); got != 1 {
+		t.Errorf("got %d calls to the token source, want 1", got)
+	}
+}
+
+func TestCachedTokenSource_scheduledRefresh(t *testing.T) {
+	source := &countingTokenSource{expiry: time.Hour}
+	cache := map[string]string{"refresh-skew": "10m"}
+	ts, err := newCachedTokenSource("cached", time.Now().Add(10*time.Minute+100*time.Millisecond).Format(time.RFC3339Nano), nil, source, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Handing out the cached token arms the timer, the token is refreshed
+	// before it expires within refresh-skew without another call to Token.
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok.AccessToken != "cached" {
+		t.Errorf("got token %q, want %q", tok.AccessToken, "cached")
+	}
+	if err := waitFor(func() bool { return ts.cachedToken().AccessToken == "token-1" }); err != nil {
+		t.Fatalf("token was not refreshed ahead of expiry: %v", err)
+	}
+	if got := source.calls.Load(); got != 1 {
+		t.Errorf("got %d calls to the token source, want 1", got)
+	}
+}
+
+func TestCachedTokenSource_backoff(t *testing.T) {
+	source := &countingTokenSource{err: fmt.Errorf("gcloud failed")}
+	ts, err := newCachedTokenSource("", "", nil, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ts.Token(); err == nil || !strings.Contains(err.Error(), "gcloud failed") {
+		t.Fatalf("expected error from token source, got: %v", err)
+	}
+	// Backing off, the token source is not called again.
+	if _, err := ts.Token(); err == nil || !strings.Contains(err.Error(), "gcloud failed") {
+		t.Fatalf("expected error from previous refresh, got: %v", err)
+	}
+	if got := source.calls.Load(); got != 1 {
+		t.Errorf("got %d calls to the token source, want 1", got)
+	}
+	if ts.backoff != minRefreshBackoff {
+		t.Errorf("got backoff %v, want %v", ts.backoff, minRefreshBackoff)
+	}
+
+	// The backoff doubles after every failure.
+	ts.retryAfter = time.Time{}
+	if _, err := ts.Token(); err == nil {
+		t.Fatal("expected error from token source")
+	}
+	if got := source.calls.Load(); got != 2 {
+		t.Errorf("got %d calls to the token source, want 2", got)
+	}
+	if ts.backoff != 2*minRefreshBackoff {
+		t.Errorf("got backoff %v, want %v", ts.backoff, 2*minRefreshBackoff)
+	}
+
+	// A successful refresh resets the backoff.
+	ts.retryAfter = time.Time{}
+	source.err = nil
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok.AccessToken != "token-3" || ts.backoff != 0 {
+		t.Errorf("got token %q and backoff %v, want %q and no backoff", tok.AccessToken, ts.backoff, "token-3")
+	}
+}
+
+func TestCachedTokenSource_invalidRefreshSkew(t *testing.T) {
+	for _, skew := range []string{"5", "-1m"} {
+		if _, err := newCachedTokenSource("", "", nil, &fakeTokenSource{}, map[string]string{"refresh-skew": skew}); err == nil {
+			t.Errorf("expected error for refresh-skew %q", skew)
+		}
+	}
+}
+
+func waitFor(cond func() bool) error {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return nil
+		}
+	}
+	return fmt.Errorf("timed out")
+}
+
 type MockTransport struct {
 	res *http.Response
 }
@@ -527,3 +939,112 @@ func Test_cmdTokenSource_roundTrip(t *testing.T) {
 	}
 
 }
+
+type recordingTransport struct {
+	req *http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req = req
+	return &http.Response{StatusCode: http.StatusOK}, nil
+}
+
+func Test_roundTrip_quotaProject(t *testing.T) {
+	fs := &fakeTokenSource{token: &oauth2.Token{AccessToken: "fakeToken", Expiry: time.Now().Add(time.Hour)}}
+
+	tests := []struct {
+		name             string
+		baseCache        map[string]string
+		header           string
+		wantQuotaProject string
+	}{
+		{"no quota project", map[string]string{}, "", ""},
+		{"quota project", map[string]string{"quota-project": "billing-proj"}, "", "billing-proj"},
+		{"header set by caller", map[string]string{"quota-project": "billing-proj"}, "other-proj", "other-proj"},
+	}
+	for _, tc := range tests {
+		cts, err := newCachedTokenSource("", "", nil, fs, tc.baseCache)
+		if err != nil {
+			t.Fatalf("unexpected error from newCachedTokenSource: %v", err)
+		}
+		authProvider := gcpAuthProvider{cts, &fakePersister{}}
+		rt := &recordingTransport{}
+		req := &http.Request{Header: http.Header{}}
+		if tc.header != "" {
+			req.Header.Set("X-Goog-User-Project", tc.header)
+		}
+		if _, err := authProvider.WrapTransport(rt).RoundTrip(req); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if got := rt.req.Header.Get("X-Goog-User-Project"); got != tc.wantQuotaProject {
+			t.Errorf("%s: got X-Goog-User-Project %q, want %q", tc.name, got, tc.wantQuotaProject)
+		}
+		if got := rt.req.Header.Get("Authorization"); got != "Bearer fakeToken" {
+			t.Errorf("%s: got Authorization %q", tc.name, got)
+		}
+	}
+}
+
+// unauthorizedTransport rejects all requests that do not use the valid token.
+type unauthorizedTransport struct {
+	valid  string
+	bodies []string
+}
+
+func (t *unauthorizedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	t.bodies = append(t.bodies, string(body))
+	if req.Header.Get("Authorization") != "Bearer "+t.valid {
+		return &http.Response{StatusCode: http.StatusUnauthorized, Body: http.NoBody}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func Test_roundTrip_refreshOnUnauthorized(t *testing.T) {
+	source := &countingTokenSource{expiry: time.Hour}
+	persister := &fakePersister{}
+	baseCache := map[string]string{"cmd-path": "/path/to/tokensource/cmd"}
+	cts, err := newCachedTokenSource("revoked", time.Now().Add(time.Hour).Format(time.RFC3339Nano), persister, source, baseCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &unauthorizedTransport{valid: "token-1"}
+	transport := (&gcpAuthProvider{cts, persister}).WrapTransport(rt)
+
+	req, err := http.NewRequest(http.MethodPost, "https://kubernetes/api", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if want := []string{"payload", "payload"}; !reflect.DeepEqual(rt.bodies, want) {
+		t.Errorf("got request bodies %q, want %q", rt.bodies, want)
+	}
+	if got := persister.read()["access-token"]; got != "token-1" {
+		t.Errorf("got persisted token %q, want %q", got, "token-1")
+	}
+
+	// Still unauthorized after the refresh: the cache is reset and the 401 returned.
+	rt.valid = "other"
+	res, err = transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+	if got := source.calls.Load(); got != 2 {
+		t.Errorf("got %d calls to the token source, want 2", got)
+	}
+	if got := persister.read(); !reflect.DeepEqual(got, baseCache) {
+		t.Errorf("got cache %v, want %v", got, baseCache)
+	}
+}
@@ -0,0 +1,93 @@
+package gcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// defaultMetadataHost is the address of the GCE/GKE metadata server.
+const defaultMetadataHost = "169.254.169.254"
+
+func init() {
+	registerTokenSource("metadata", newMetadataTokenSource)
+}
+
+// metadataTokenSource gets tokens of the default service account of the
+// instance, or of the Kubernetes service account on GKE with Workload Identity,
+// from the metadata server.
+type metadataTokenSource struct {
+	client *http.Client
+	url    string
+}
+
+func newMetadataTokenSource(gcpConfig map[string]string, scopes []string) (oauth2.TokenSource, error) {
+	host := gcpConfig["metadata-host"]
+	if host == "" {
+		host = os.Getenv("GCE_METADATA_HOST")
+	}
+	if host == "" {
+		host = defaultMetadataHost
+	}
+
+	u := url.URL{
+		Scheme: "http",
+		Host:   host,
+		Path:   "/computeMetadata/v1/instance/service-accounts/default/token",
+	}
+	// Without scopes, the metadata server returns a token with the scopes of the instance.
+	if _, ok := gcpConfig["scopes"]; ok && len(scopes) > 0 {
+		u.RawQuery = url.Values{"scopes": {strings.Join(scopes, ",")}}.Encode()
+	}
+	return &metadataTokenSource{
+		client: &http.Client{Timeout: 10 * time.Second},
+		url:    u.String(),
+	}, nil
+}
+
+func (m *metadataTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest(http.MethodGet, m.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting token from metadata server: %v", err)
+	}
+	defer res.Body.Close()
+	output, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading token from metadata server: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error requesting token from metadata server: status=%d body=%s", res.StatusCode, output)
+	}
+
+	var data struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(output, &data); err != nil {
+		return nil, fmt.Errorf("error parsing token from metadata server: %v", err)
+	}
+	if data.AccessToken == "" {
+		return nil, fmt.Errorf("metadata server returned an empty access token")
+	}
+	tok := &oauth2.Token{
+		AccessToken: data.AccessToken,
+		TokenType:   "Bearer",
+	}
+	if data.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(data.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
@@ -0,0 +1,72 @@
+package gcp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetadataTokenSource(t *testing.T) {
+	var gotPath, gotFlavor, gotScopes string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotFlavor = r.Header.Get("Metadata-Flavor")
+		gotScopes = r.URL.Query().Get("scopes")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"metadatatoken","expires_in":3599,"token_type":"Bearer"}`)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name       string
+		gcpConfig  map[string]string
+		wantScopes string
+	}{
+		{"instance scopes", map[string]string{"auth-type": "metadata", "metadata-host": host}, ""},
+		{"custom scopes", map[string]string{"auth-type": "metadata", "metadata-host": host, "scopes": "A,B"}, "A,B"},
+	}
+	for _, tc := range tests {
+		ts, err := tokenSource(tc.gcpConfig)
+		if err != nil {
+			t.Fatalf("%s: failed to get a token source: %v", tc.name, err)
+		}
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatalf("%s: failed to get a token: %v", tc.name, err)
+		}
+		if tok.AccessToken != "metadatatoken" {
+			t.Errorf("%s: got token %q, want %q", tc.name, tok.AccessToken, "metadatatoken")
+		}
+		if d := time.Until(tok.Expiry); d < 59*time.Minute || d > time.Hour {
+			t.Errorf("%s: got expiry in %v, want in about an hour", tc.name, d)
+		}
+		if want := "/computeMetadata/v1/instance/service-accounts/default/token"; gotPath != want {
+			t.Errorf("%s: got path %q, want %q", tc.name, gotPath, want)
+		}
+		if gotFlavor != "Google" {
+			t.Errorf("%s: got Metadata-Flavor %q, want %q", tc.name, gotFlavor, "Google")
+		}
+		if gotScopes != tc.wantScopes {
+			t.Errorf("%s: got scopes %q, want %q", tc.name, gotScopes, tc.wantScopes)
+		}
+	}
+}
+
+func TestMetadataTokenSource_error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "service account not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ts, err := tokenSource(map[string]string{"auth-type": "metadata", "metadata-host": strings.TrimPrefix(server.URL, "http://")})
+	if err != nil {
+		t.Fatalf("failed to get a token source: %v", err)
+	}
+	if _, err := ts.Token(); err == nil || !strings.Contains(err.Error(), "status=404") {
+		t.Errorf("expected error with status, got: %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package cloud
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
@@ -21,6 +23,10 @@ const (
 
 	LabelJobSetName      = keyPrefix + "tpu-provisioner-jobset-name"
 	LabelJobSetNamespace = keyPrefix + "tpu-provisioner-jobset-namespace"
+	LabelJobKey          = keyPrefix + "tpu-provisioner-job-key"
+	// LabelNodeCount is the number of nodes a node pool was created or last resized
+	// with, GKE does not update the initial node count of a node pool on resize.
+	LabelNodeCount = keyPrefix + "tpu-provisioner-node-count"
 
 	LabelProvisionerNodepoolID = "provisioner-nodepool-id"
 
@@ -40,7 +46,12 @@ const (
 	EventNodePoolDeletionSucceeded = "NodePoolDeletionSucceeded"
 	EventNodePoolDeletionFailed    = "NodePoolDeletionFailed"
 
-	EventNodePoolNotFound = "NodePoolNotFound"
+	EventNodePoolResizeStarted   = "NodePoolResizeStarted"
+	EventNodePoolResizeSucceeded = "NodePoolResizeSucceeded"
+	EventNodePoolResizeFailed    = "NodePoolResizeFailed"
+
+	EventNodePoolNotFound      = "NodePoolNotFound"
+	EventNodePoolDriftDetected = "NodePoolDriftDetected"
 )
 
 type Provider interface {
@@ -51,8 +62,31 @@ type Provider interface {
 	ListNodePools() ([]NodePoolRef, error)
 }
 
+// NodePoolResizer is implemented by providers that can change the number of
+// nodes of an existing node pool.
+type NodePoolResizer interface {
+	ResizeNodePool(name string, nodeCount int, eventObj client.Object, why string) error
+}
+
 var ErrDuplicateRequest = errors.New("duplicate request")
 
+// NodePoolDriftError is returned by EnsureNodePoolForPod when the node pool for
+// the pod exists, but no longer matches what the pod requires, for example
+// because the JobSet was changed after the node pool was created.
+type NodePoolDriftError struct {
+	NodePool string
+	// Drift describes every difference between the node pool and the pod.
+	Drift []string
+	// Resizable is true if the node pool can be fixed by resizing it to
+	// NodeCount, otherwise it needs to be replaced.
+	Resizable bool
+	NodeCount int
+}
+
+func (e *NodePoolDriftError) Error() string {
+	return fmt.Sprintf("node pool %s does not match pod: %s", e.NodePool, strings.Join(e.Drift, ", "))
+}
+
 type NodePoolRef struct {
 	Name string
 
@@ -0,0 +1,83 @@
+package cloud
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	containerv1beta1 "google.golang.org/api/container/v1beta1"
+)
+
+// nodePoolDrift compares a live node pool with the node pool that would be
+// created for a pod and describes every difference that would prevent the pod
+// from being scheduled on it: machine type, node count, topology and the labels
+// the pod selects on.
+func nodePoolDrift(live, desired *containerv1beta1.NodePool, nodeSelector map[string]string) []string {
+	var drift []string
+
+	if l, d := nodePoolConfig(live).MachineType, desired.Config.MachineType; l != d {
+		drift = append(drift, fmt.Sprintf("machine type %s != %s", l, d))
+	}
+	if l, d := nodePoolNodeCount(live), nodePoolNodeCount(desired); l != d {
+		drift = append(drift, fmt.Sprintf("node count %v != %v", l, d))
+	}
+	if l, d := nodePoolTopology(live), nodePoolTopology(desired); l != d {
+		drift = append(drift, fmt.Sprintf("topology %q != %q", l, d))
+	}
+
+	return append(drift, labelDrift(live, desired, nodeSelector)...)
+}
+
+// labelDrift only considers node selector labels that are set on the node pool,
+// other labels do not affect scheduling of the pod.
+func labelDrift(live, desired *containerv1beta1.NodePool, nodeSelector map[string]string) []string {
+	var keys []string
+	for key := range nodeSelector {
+		if _, ok := desired.Config.Labels[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var drift []string
+	for _, key := range keys {
+		if l, d := nodePoolConfig(live).Labels[key], desired.Config.Labels[key]; l != d {
+			drift = append(drift, fmt.Sprintf("label %s=%q != %q", key, l, d))
+		}
+	}
+	return drift
+}
+
+// nodePoolResizable returns true if the only difference between the live and
+// desired node pool is the node count and the node pool consists of single-host
+// TPU nodes, which can be added or removed without changing any slice.
+func nodePoolResizable(live, desired *containerv1beta1.NodePool, nodeSelector map[string]string) bool {
+	return nodePoolNodeCount(live) != nodePoolNodeCount(desired) &&
+		nodePoolConfig(live).MachineType == desired.Config.MachineType &&
+		nodePoolTopology(live) == "" && nodePoolTopology(desired) == "" &&
+		len(labelDrift(live, desired, nodeSelector)) == 0
+}
+
+// nodePoolNodeCount returns the number of nodes of a node pool from its
+// LabelNodeCount label. Node pools created before the label was introduced
+// fall back to the initial node count.
+func nodePoolNodeCount(np *containerv1beta1.NodePool) int64 {
+	if n, err := strconv.ParseInt(nodePoolConfig(np).Labels[LabelNodeCount], 10, 64); err == nil {
+		return n
+	}
+	return np.InitialNodeCount
+}
+
+func nodePoolConfig(np *containerv1beta1.NodePool) *containerv1beta1.NodeConfig {
+	if np.Config == nil {
+		return &containerv1beta1.NodeConfig{}
+	}
+	return np.Config
+}
+
+func nodePoolTopology(np *containerv1beta1.NodePool) string {
+	if np.PlacementPolicy == nil {
+		return ""
+	}
+	return np.PlacementPolicy.TpuTopology
+}
@@ -0,0 +1,92 @@
+package cloud
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	containerv1beta1 "google.golang.org/api/container/v1beta1"
+)
+
+func Test_nodePoolDrift(t *testing.T) {
+	nodePool := func(machineType string, nodeCount int64, topo string, labels map[string]string) *containerv1beta1.NodePool {
+		np := &containerv1beta1.NodePool{
+			Config: &containerv1beta1.NodeConfig{
+				MachineType: machineType,
+				Labels:      labels,
+			},
+			InitialNodeCount: nodeCount,
+			PlacementPolicy:  &containerv1beta1.PlacementPolicy{},
+		}
+		if topo != "" {
+			np.PlacementPolicy.TpuTopology = topo
+			np.PlacementPolicy.Type = "COMPACT"
+		}
+		return np
+	}
+	selector := map[string]string{
+		GKETPUNodeSelector: "2x2x2",
+		"team":             "a",
+	}
+
+	cases := []struct {
+		desc      string
+		live      *containerv1beta1.NodePool
+		desired   *containerv1beta1.NodePool
+		drift     []string
+		resizable bool
+	}{
+		{
+			desc:    "no drift",
+			live:    nodePool("ct5p-hightpu-4t", 2, "2x2x2", map[string]string{"team": "a", LabelJobKey: "old"}),
+			desired: nodePool("ct5p-hightpu-4t", 2, "2x2x2", map[string]string{"team": "a", LabelJobKey: "new", "copied": "x"}),
+		},
+		{
+			desc:    "topology changed",
+			live:    nodePool("ct5p-hightpu-4t", 1, "2x2x1", map[string]string{"team": "a"}),
+			desired: nodePool("ct5p-hightpu-4t", 2, "2x2x2", map[string]string{"team": "a"}),
+			drift:   []string{"node count 1 != 2", `topology "2x2x1" != "2x2x2"`},
+		},
+		{
+			desc:    "machine type and labels changed",
+			live:    nodePool("ct5lp-hightpu-4t", 1, "", map[string]string{"team": "b"}),
+			desired: nodePool("ct5lp-hightpu-8t", 1, "", map[string]string{"team": "a"}),
+			drift:   []string{"machine type ct5lp-hightpu-4t != ct5lp-hightpu-8t", `label team="b" != "a"`},
+		},
+		{
+			desc:      "single-host node count changed",
+			live:      nodePool("ct5lp-hightpu-1t", 1, "", map[string]string{"team": "a"}),
+			desired:   nodePool("ct5lp-hightpu-1t", 3, "", map[string]string{"team": "a"}),
+			drift:     []string{"node count 1 != 3"},
+			resizable: true,
+		},
+		{
+			// GKE does not update the initial node count on resize.
+			desc:    "resized node pool",
+			live:    nodePool("ct5lp-hightpu-1t", 1, "", map[string]string{"team": "a", LabelNodeCount: "3"}),
+			desired: nodePool("ct5lp-hightpu-1t", 3, "", map[string]string{"team": "a", LabelNodeCount: "3"}),
+		},
+		{
+			desc:      "resized node pool node count changed",
+			live:      nodePool("ct5lp-hightpu-1t", 1, "", map[string]string{"team": "a", LabelNodeCount: "3"}),
+			desired:   nodePool("ct5lp-hightpu-1t", 1, "", map[string]string{"team": "a", LabelNodeCount: "1"}),
+			drift:     []string{"node count 3 != 1"},
+			resizable: true,
+		},
+		{
+			desc:    "live node pool without config",
+			live:    &containerv1beta1.NodePool{InitialNodeCount: 1},
+			desired: nodePool("ct5lp-hightpu-1t", 1, "", map[string]string{"team": "a"}),
+			drift:   []string{"machine type  != ct5lp-hightpu-1t", `label team="" != "a"`},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			if diff := cmp.Diff(c.drift, nodePoolDrift(c.live, c.desired, selector)); diff != "" {
+				t.Errorf("nodePoolDrift() unexpected result, diff (-want +got): \n%s", diff)
+			}
+			if got := nodePoolResizable(c.live, c.desired, selector); got != c.resizable {
+				t.Errorf("nodePoolResizable() expected: %v, got: %v", c.resizable, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package cloud
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
@@ -10,6 +12,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/usage"
+
 	containerv1beta1 "google.golang.org/api/container/v1beta1"
 	"google.golang.org/api/googleapi"
 	corev1 "k8s.io/api/core/v1"
@@ -58,44 +62,63 @@ const (
 	maxPodsPerNode = 15
 
 	// Constants for node pool naming conventions.
-	maxJobSetPrefixLength = 34
-	jobKeySuffixLength    = 5
+	maxJobSetPrefixLength  = 31
+	nodePoolNameHashLength = 8
+	// Constants for the node pool naming convention before the namespace was
+	// included, see legacyNodePoolName.
+	legacyMaxJobSetPrefixLength = 34
+	legacyJobKeySuffixLength    = 5
+	// maxNodePoolNameAttempts is the number of alternative names that are tried
+	// when a node pool name is already taken by a node pool of another JobSet.
+	maxNodePoolNameAttempts = 5
 )
 
-var _ Provider = &GKE{}
+var (
+	_ Provider        = &GKE{}
+	_ NodePoolResizer = &GKE{}
+)
 
 type GKE struct {
 	Service        *containerv1beta1.Service
 	ClusterContext GKEContext
 
 	Recorder record.EventRecorder
+	// Usage is an optional ledger that node pool lifetimes are recorded to.
+	Usage *usage.Ledger
 
 	inProgressDeletesNPName sync.Map
 	inProgressCreatesNPName sync.Map
 	inProgressCreatesJobKey sync.Map
+	inProgressResizesNPName sync.Map
 }
 
 func (g *GKE) NodePoolLabelKey() string { return GKENodePoolNameLabel }
 
 func (g *GKE) EnsureNodePoolForPod(p *corev1.Pod, why string) error {
-	name, err := podToNodePoolName(p)
+	name, existing, err := g.resolveNodePool(p)
 	if err != nil {
 		return err
 	}
 
-	exists, err := g.nodePoolExists(name)
-	if err != nil {
-		return fmt.Errorf("checking if node pool exists: %w", err)
-	}
-	if exists {
-		return nil
-	}
-
 	np, err := g.nodePoolForPod(name, p)
 	if err != nil {
 		return fmt.Errorf("determining node pool for pod: %w", err)
 	}
 
+	if existing != nil {
+		// The pod might still be pending because the JobSet was changed after
+		// the node pool was created, surface this so it can be acted upon.
+		if drift := nodePoolDrift(existing, np, p.Spec.NodeSelector); len(drift) > 0 {
+			return &NodePoolDriftError{
+				NodePool:  name,
+				Drift:     drift,
+				Resizable: nodePoolResizable(existing, np, p.Spec.NodeSelector),
+				NodeCount: int(np.InitialNodeCount),
+			}
+		}
+		return nil
+	}
+
 	req := &containerv1beta1.CreateNodePoolRequest{
 		NodePool: np,
 		Parent:   g.ClusterContext.ClusterName(),
@@ -143,6 +166,12 @@ func (g *GKE) EnsureNodePoolForPod(p *corev1.Pod, why string) error {
 
 	g.Recorder.Eventf(p, corev1.EventTypeNormal, EventNodePoolCreationSucceeded, "Successfully created Node Pool %s.", name)
 
+	if g.Usage != nil {
+		if err := g.Usage.Start(usageRecordForNodePool(np, jobSetName, p.Namespace)); err != nil {
+			log.Error(err, "failed to record node pool usage", "nodepool", name)
+		}
+	}
+
 	return nil
 }
 
@@ -203,6 +232,7 @@ func (g *GKE) DeleteNodePool(name string, eventObj client.Object, why string) er
 	if err != nil {
 		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
 			g.Recorder.Eventf(eventObj, corev1.EventTypeNormal, EventNodePoolNotFound, "Node pool not found - ignoring deletion attempt.", name)
+			g.endUsage(name)
 			return nil
 		}
 		g.Recorder.Eventf(eventObj, corev1.EventTypeWarning, EventNodePoolDeletionFailed, "Request to delete Node Pool %s failed: %v.", name, err)
@@ -215,26 +245,181 @@ func (g *GKE) DeleteNodePool(name string, eventObj client.Object, why string) er
 	}
 
 	g.Recorder.Eventf(eventObj, corev1.EventTypeNormal, EventNodePoolDeletionSucceeded, "Successfully deleted Node Pool %s.", name)
+	g.endUsage(name)
 
 	return nil
 }
 
-var ErrNodePoolStopping = errors.New("node pool stopping")
+func (g *GKE) ResizeNodePool(name string, nodeCount int, eventObj client.Object, why string) error {
+	// Due to concurrent reconciles, multiple resizes of the same Node Pool
+	// will occur at the same time, so we deduplicate here. Once a resize is
+	// done, the node count label of the Node Pool no longer shows drift.
+	if _, inProgress := g.inProgressResizesNPName.Load(name); inProgress {
+		return ErrDuplicateRequest
+	}
+	g.inProgressResizesNPName.Store(name, struct{}{})
+	defer g.inProgressResizesNPName.Delete(name)
 
-func (g *GKE) nodePoolExists(name string) (bool, error) {
-	call := g.Service.Projects.Locations.Clusters.NodePools.Get(g.ClusterContext.NodePoolName(name))
-	np, err := call.Do()
-	if err == nil {
-		return true, nil
+	np, err := g.getNodePool(name)
+	if err != nil {
+		return fmt.Errorf("getting node pool %q: %w", name, err)
 	}
-	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
-		return false, nil
+	if np == nil {
+		return fmt.Errorf("node pool %q not found", name)
 	}
-	if np.Status == "STOPPING" {
-		return false, ErrNodePoolStopping
+
+	g.Recorder.Eventf(eventObj, corev1.EventTypeNormal, EventNodePoolResizeStarted, "Starting resize of Node Pool %s to %v nodes because %s", name, nodeCount, why)
+	req := &containerv1beta1.SetNodePoolSizeRequest{NodeCount: int64(nodeCount)}
+	op, err := g.Service.Projects.Locations.Clusters.NodePools.SetSize(g.ClusterContext.NodePoolName(name), req).Do()
+	if err != nil {
+		g.Recorder.Eventf(eventObj, corev1.EventTypeWarning, EventNodePoolResizeFailed, "Request to resize Node Pool %s failed: %v.", name, err)
+		return fmt.Errorf("resizing node pool %q: %w", name, err)
 	}
 
-	return false, err
+	if err := waitForGkeOp(g.Service, g.ClusterContext, op); err != nil {
+		g.Recorder.Eventf(eventObj, corev1.EventTypeWarning, EventNodePoolResizeFailed, "Operation to resize Node Pool %s failed: %v.", name, err)
+		return err
+	}
+
+	// The label is only updated after the resize, if this fails the drift is
+	// still detected and the resize, which does not change a node pool of the
+	// requested size, is retried.
+	labels := map[string]string{}
+	for k, v := range nodePoolConfig(np).Labels {
+		labels[k] = v
+	}
+	labels[LabelNodeCount] = strconv.Itoa(nodeCount)
+	updateReq := &containerv1beta1.UpdateNodePoolRequest{Labels: &containerv1beta1.NodeLabels{Labels: labels}}
+	op, err = g.Service.Projects.Locations.Clusters.NodePools.Update(g.ClusterContext.NodePoolName(name), updateReq).Do()
+	if err != nil {
+		g.Recorder.Eventf(eventObj, corev1.EventTypeWarning, EventNodePoolResizeFailed, "Request to label resized Node Pool %s failed: %v.", name, err)
+		return fmt.Errorf("labeling node pool %q: %w", name, err)
+	}
+
+	if err := waitForGkeOp(g.Service, g.ClusterContext, op); err != nil {
+		g.Recorder.Eventf(eventObj, corev1.EventTypeWarning, EventNodePoolResizeFailed, "Operation to label resized Node Pool %s failed: %v.", name, err)
+		return err
+	}
+
+	g.Recorder.Eventf(eventObj, corev1.EventTypeNormal, EventNodePoolResizeSucceeded, "Successfully resized Node Pool %s to %v nodes.", name, nodeCount)
+
+	if g.Usage != nil {
+		if err := g.Usage.Resize(name, nodeCount, time.Now()); err != nil {
+			log.Error(err, "failed to record node pool usage", "nodepool", name)
+		}
+	}
+
+	return nil
+}
+
+func (g *GKE) endUsage(name string) {
+	if g.Usage == nil {
+		return
+	}
+	if _, _, err := g.Usage.End(name, time.Now()); err != nil {
+		log.Error(err, "failed to record node pool usage", "nodepool", name)
+	}
+}
+
+var (
+	ErrNodePoolStopping     = errors.New("node pool stopping")
+	ErrNodePoolNameConflict = errors.New("node pool name conflict")
+)
+
+// resolveNodePool returns the name of the node pool for the given pod and
+// the node pool itself if it already exists, see resolveNodePoolName.
+func (g *GKE) resolveNodePool(p *corev1.Pod) (string, *containerv1beta1.NodePool, error) {
+	jobSetName, jobKey, err := podJobSetAndJobKey(p)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name, np, err := resolveNodePoolName(p.Namespace, jobSetName, jobKey, g.getNodePool)
+	if err != nil {
+		return "", nil, fmt.Errorf("resolving node pool name: %w", err)
+	}
+	if np != nil && np.Status == "STOPPING" {
+		return "", nil, ErrNodePoolStopping
+	}
+	return name, np, nil
+}
+
+// resolveNodePoolName returns the name of the node pool for the given job and
+// the node pool itself if it already exists, given a lookup that returns the
+// node pool with a name or nil if it does not exist.
+// A node pool with the legacy name of the job (see legacyNodePoolName) is
+// reused, so that JobSets keep their node pools across provisioner upgrades.
+// Otherwise an existing node pool is only reused when its labels show that it
+// was created for the same JobSet and job, and the next candidate name (see
+// nodePoolName) is tried.
+func resolveNodePoolName(namespace, jobSetName, jobKey string, lookup func(name string) (*containerv1beta1.NodePool, error)) (string, *containerv1beta1.NodePool, error) {
+	legacyName := legacyNodePoolName(jobSetName, jobKey)
+	np, err := lookup(legacyName)
+	if err != nil {
+		return "", nil, fmt.Errorf("checking if node pool exists: %w", err)
+	}
+	if np != nil && legacyNodePoolOwnedBy(np, namespace, jobSetName, jobKey) {
+		return legacyName, np, nil
+	}
+
+	for attempt := 0; attempt < maxNodePoolNameAttempts; attempt++ {
+		name := nodePoolName(namespace, jobSetName, jobKey, attempt)
+		np, err := lookup(name)
+		if err != nil {
+			return "", nil, fmt.Errorf("checking if node pool exists: %w", err)
+		}
+		if np == nil {
+			return name, nil, nil
+		}
+		if !nodePoolOwnedBy(np, namespace, jobSetName, jobKey) {
+			log.Info("node pool name is taken by another owner, trying next name",
+				"nodepool", name, "jobset", jobSetName, "namespace", namespace,
+				"ownerJobSet", np.Config.Labels[LabelJobSetName], "ownerNamespace", np.Config.Labels[LabelJobSetNamespace])
+			continue
+		}
+		return name, np, nil
+	}
+
+	return "", nil, fmt.Errorf("no free node pool name for jobset %s/%s after %v attempts: %w", namespace, jobSetName, maxNodePoolNameAttempts, ErrNodePoolNameConflict)
+}
+
+// getNodePool returns the node pool with the given name, or nil if it does not exist.
+func (g *GKE) getNodePool(name string) (*containerv1beta1.NodePool, error) {
+	np, err := g.Service.Projects.Locations.Clusters.NodePools.Get(g.ClusterContext.NodePoolName(name)).Do()
+	if err != nil {
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return np, nil
+}
+
+// nodePoolOwnedBy returns true if the node pool was created by this provisioner
+// for the given JobSet and job.
+func nodePoolOwnedBy(np *containerv1beta1.NodePool, namespace, jobSetName, jobKey string) bool {
+	return nodePoolCreatedForJobSet(np, namespace, jobSetName) && np.Config.Labels[LabelJobKey] == jobKey
+}
+
+// legacyNodePoolOwnedBy returns true if the node pool with a legacy name was
+// created by this provisioner for the given JobSet and job. These node pools
+// were created before the job-key label was introduced, so they are matched on
+// JobSet name and namespace only when the label is missing.
+func legacyNodePoolOwnedBy(np *containerv1beta1.NodePool, namespace, jobSetName, jobKey string) bool {
+	if !nodePoolCreatedForJobSet(np, namespace, jobSetName) {
+		return false
+	}
+	npJobKey, ok := np.Config.Labels[LabelJobKey]
+	return !ok || npJobKey == jobKey
+}
+
+func nodePoolCreatedForJobSet(np *containerv1beta1.NodePool, namespace, jobSetName string) bool {
+	if np.Config == nil {
+		return false
+	}
+	labels := np.Config.Labels
+	return labels[LabelNodepoolManager] == LabelNodepoolManagerTPUPodinator &&
+		labels[LabelJobSetName] == jobSetName && labels[LabelJobSetNamespace] == namespace
 }
 
 func (g *GKE) nodePoolForPod(name string, p *corev1.Pod) (*containerv1beta1.NodePool, error) {
@@ -263,6 +448,9 @@ func (g *GKE) nodePoolForPod(name string, p *corev1.Pod) (*containerv1beta1.Node
 		LabelJobSetName:      jobSetName,
 		LabelJobSetNamespace: p.Namespace,
 	}
+	if jobKey := p.Labels[jobset.JobKey]; jobKey != "" {
+		labels[LabelJobKey] = jobKey
+	}
 
 	// Copy configured labels from the Pod to the Node.
 	for _, key := range g.ClusterContext.PodToNodeLabels {
@@ -296,28 +484,12 @@ func (g *GKE) nodePoolForPod(name string, p *corev1.Pod) (*containerv1beta1.Node
 		}
 	}
 
-	// Pod should already be filtered for this Node Selector at this point.
-	tpuTopo, ok := p.Spec.NodeSelector[GKETPUNodeSelector]
-	if !ok {
-		return nil, fmt.Errorf("missing node selector key: %v", GKETPUNodeSelector)
-	}
-	accel, ok := p.Spec.NodeSelector[GKEAcceleratorNodeSelector]
-	if !ok {
-		return nil, fmt.Errorf("missing node selector key: %v", GKEAcceleratorNodeSelector)
-	}
-	tpuRequest, err := sumTPURequests(p)
+	slice, err := tpuSliceForPod(p)
 	if err != nil {
-		return nil, fmt.Errorf("summing TPU requests: %w", err)
-	}
-
-	nodeCount, err := tpuTopologyToNodeCount(accel, tpuTopo)
-	if err != nil {
-		return nil, fmt.Errorf("determining node count: %w", err)
-	}
-	machineType, err := tpuMachineType(accel, tpuRequest)
-	if err != nil {
-		return nil, fmt.Errorf("determining node count: %w", err)
+		return nil, err
 	}
+	tpuTopo, nodeCount, machineType := slice.Topology, slice.NodeCount, slice.MachineType
+	labels[LabelNodeCount] = strconv.Itoa(nodeCount)
 
 	var reservation *containerv1beta1.ReservationAffinity
 	var taints []*containerv1beta1.NodeTaint
@@ -365,27 +537,13 @@ func (g *GKE) nodePoolForPod(name string, p *corev1.Pod) (*containerv1beta1.Node
 	}
 
 	var networkConfig *containerv1beta1.NodeNetworkConfig
-	var additionalNodeNetworks []*containerv1beta1.AdditionalNodeNetworkConfig
-	// additional-node-networks: "vpc1:subnet1, vpc2:subnet2"
 	additionalNodeNetworksCSV := g.ClusterContext.NodeAdditionalNetworks
 	if getAnnotation(p, AnnotationAdditionalNodeNetworks) != "" {
 		additionalNodeNetworksCSV = getAnnotation(p, AnnotationAdditionalNodeNetworks)
 	}
-	for _, pair := range strings.Split(additionalNodeNetworksCSV, ",") {
-		pair = strings.TrimSpace(pair)
-		if pair == "" {
-			continue
-		}
-
-		netAndSubnet := strings.SplitN(pair, ":", 2)
-		if len(netAndSubnet) != 2 {
-			return nil, fmt.Errorf("invalid additional network annotation: %v", pair)
-		}
-
-		additionalNodeNetworks = append(additionalNodeNetworks, &containerv1beta1.AdditionalNodeNetworkConfig{
-			Network:    strings.TrimSpace(netAndSubnet[0]),
-			Subnetwork: strings.TrimSpace(netAndSubnet[1]),
-		})
+	additionalNodeNetworks, err := parseAdditionalNodeNetworks(additionalNodeNetworksCSV)
+	if err != nil {
+		return nil, err
 	}
 	if len(additionalNodeNetworks) > 0 {
 		networkConfig = &containerv1beta1.NodeNetworkConfig{
@@ -438,6 +596,106 @@ func (g *GKE) nodePoolForPod(name string, p *corev1.Pod) (*containerv1beta1.Node
 	}, nil
 }
 
+// usageRecordForNodePool returns the usage record for a node pool that was
+// created for the given JobSet.
+func usageRecordForNodePool(np *containerv1beta1.NodePool, jobSetName, namespace string) usage.Record {
+	tier := usage.TierOnDemand
+	if np.Config.Spot {
+		tier = usage.TierSpot
+	} else if np.Config.ReservationAffinity != nil {
+		tier = usage.TierReservation
+	}
+	return usage.Record{
+		NodePool:     np.Name,
+		JobSetName:   jobSetName,
+		Namespace:    namespace,
+		MachineType:  np.Config.MachineType,
+		NodeCount:    int(np.InitialNodeCount),
+		ChipsPerNode: machineTypeChips(np.Config.MachineType),
+		Tier:         tier,
+	}
+}
+
+// machineTypeChips returns the number of TPU chips of a TPU machine type,
+// given that machine type names end in "-{chips}t" (for example "ct5p-hightpu-4t").
+func machineTypeChips(machineType string) int {
+	i := strings.LastIndex(machineType, "-")
+	if i < 0 || !strings.HasSuffix(machineType, "t") {
+		return 0
+	}
+	n, err := strconv.Atoi(machineType[i+1 : len(machineType)-1])
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// tpuSlice describes the nodes that are needed to run a TPU pod, as derived
+// from its node selectors and TPU requests.
+type tpuSlice struct {
+	Accelerator  string
+	Topology     string
+	NodeCount    int
+	ChipsPerNode int
+	MachineType  string
+}
+
+func tpuSliceForPod(p *corev1.Pod) (tpuSlice, error) {
+	// Pod should already be filtered for this Node Selector at this point.
+	tpuTopo, ok := p.Spec.NodeSelector[GKETPUNodeSelector]
+	if !ok {
+		return tpuSlice{}, fmt.Errorf("missing node selector key: %v", GKETPUNodeSelector)
+	}
+	accel, ok := p.Spec.NodeSelector[GKEAcceleratorNodeSelector]
+	if !ok {
+		return tpuSlice{}, fmt.Errorf("missing node selector key: %v", GKEAcceleratorNodeSelector)
+	}
+	tpuRequest, err := sumTPURequests(p)
+	if err != nil {
+		return tpuSlice{}, fmt.Errorf("summing TPU requests: %w", err)
+	}
+
+	nodeCount, err := tpuTopologyToNodeCount(accel, tpuTopo)
+	if err != nil {
+		return tpuSlice{}, fmt.Errorf("determining node count: %w", err)
+	}
+	machineType, err := tpuMachineType(accel, tpuRequest)
+	if err != nil {
+		return tpuSlice{}, fmt.Errorf("determining node count: %w", err)
+	}
+
+	return tpuSlice{
+		Accelerator:  accel,
+		Topology:     tpuTopo,
+		NodeCount:    nodeCount,
+		ChipsPerNode: tpuRequest,
+		MachineType:  machineType,
+	}, nil
+}
+
+// parseAdditionalNodeNetworks parses a comma-separated list of additional
+// networks and subnets, for example: "vpc1:subnet1, vpc2:subnet2".
+func parseAdditionalNodeNetworks(csv string) ([]*containerv1beta1.AdditionalNodeNetworkConfig, error) {
+	var networks []*containerv1beta1.AdditionalNodeNetworkConfig
+	for _, pair := range strings.Split(csv, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		netAndSubnet := strings.SplitN(pair, ":", 2)
+		if len(netAndSubnet) != 2 {
+			return nil, fmt.Errorf("invalid additional network annotation: %v", pair)
+		}
+
+		networks = append(networks, &containerv1beta1.AdditionalNodeNetworkConfig{
+			Network:    strings.TrimSpace(netAndSubnet[0]),
+			Subnetwork: strings.TrimSpace(netAndSubnet[1]),
+		})
+	}
+	return networks, nil
+}
+
 func sumTPURequests(p *corev1.Pod) (int, error) {
 	var n int
 	for _, c := range p.Spec.Containers {
@@ -458,27 +716,57 @@ func sumTPURequests(p *corev1.Pod) (int, error) {
 }
 
 // podToNodePoolName deterministically generates a node pool name for a given pod,
-// by using the JobSet name and job-key (SHA1 hash of namespaced job key), as
-// given in the pod labels.
+// by using the namespace, JobSet name and job-key (SHA1 hash of namespaced job key),
+// as given in the pod labels.
 // These labels are stable through JobSet restarts, so the node pool name
 // generated here will be the same if the JobSet is restarted.
-// Node pool name format is: {first 34 chars of jobset name}-{first 5 chars of job-key}
-// This ensures node pool names are within the 40 char limit on node pool name size.
+// This is the first candidate name, see nodePoolName.
 func podToNodePoolName(p *corev1.Pod) (string, error) {
+	jobSetName, jobKey, err := podJobSetAndJobKey(p)
+	if err != nil {
+		return "", err
+	}
+	return nodePoolName(p.Namespace, jobSetName, jobKey, 0), nil
+}
+
+func podJobSetAndJobKey(p *corev1.Pod) (string, string, error) {
 	jobSetName, exists := p.Labels[jobset.JobSetNameKey]
 	if !exists {
-		return "", fmt.Errorf("%s label not found on pod %s", jobset.JobSetNameKey, p.Name)
+		return "", "", fmt.Errorf("%s label not found on pod %s", jobset.JobSetNameKey, p.Name)
 	}
 	jobKey, exists := p.Labels[jobset.JobKey]
 	if !exists {
-		return "", fmt.Errorf("%s label not found on pod %s", jobset.JobKey, p.Name)
+		return "", "", fmt.Errorf("%s label not found on pod %s", jobset.JobKey, p.Name)
+	}
+	return jobSetName, jobKey, nil
+}
+
+// nodePoolName returns the candidate node pool name for the given attempt.
+// Node pool name format is: {first 31 chars of jobset name}-{first 8 chars of hash}
+// where hash is the SHA1 hash of "{namespace}/{jobset name}/{job-key}", with
+// "/{attempt}" appended for attempts after the first one. Including the
+// namespace and full JobSet name in the hash keeps JobSets with a common prefix
+// apart, further conflicts are resolved by trying the next attempt.
+// This ensures node pool names are within the 40 char limit on node pool name size.
+func nodePoolName(namespace, jobSetName, jobKey string, attempt int) string {
+	key := fmt.Sprintf("%s/%s/%s", namespace, jobSetName, jobKey)
+	if attempt > 0 {
+		key = fmt.Sprintf("%s/%d", key, attempt)
 	}
+	sum := sha1.Sum([]byte(key))
 
-	prefixLength := min(maxJobSetPrefixLength, len(jobSetName))
-	prefix := jobSetName[:prefixLength]
-	suffix := jobKey[:jobKeySuffixLength]
-	nodePoolName := fmt.Sprintf("%s-%s", prefix, suffix)
-	return nodePoolName, nil
+	prefix := jobSetName[:min(maxJobSetPrefixLength, len(jobSetName))]
+	suffix := hex.EncodeToString(sum[:])[:nodePoolNameHashLength]
+	return fmt.Sprintf("%s-%s", prefix, suffix)
+}
+
+// legacyNodePoolName returns the node pool name that was used before the
+// namespace was included in node pool names.
+// Node pool name format is: {first 34 chars of jobset name}-{first 5 chars of job-key}
+func legacyNodePoolName(jobSetName, jobKey string) string {
+	prefix := jobSetName[:min(legacyMaxJobSetPrefixLength, len(jobSetName))]
+	suffix := jobKey[:min(legacyJobKeySuffixLength, len(jobKey))]
+	return fmt.Sprintf("%s-%s", prefix, suffix)
 }
 
 func tpuTopologyToNodeCount(accelerator, topo string) (int, error) {
@@ -509,6 +797,26 @@ func tpuTopologyToNodeCount(accelerator, topo string) (int, error) {
 	return int(math.Ceil(float64(product) / 4)), nil
 }
 
+// tpuChipsPerHost returns the number of TPU chips each node of a node pool with
+// the given accelerator and topology has, which is what every pod needs to request.
+func tpuChipsPerHost(accelerator, topo string) (int, error) {
+	nodeCount, err := tpuTopologyToNodeCount(accelerator, topo)
+	if err != nil {
+		return 0, err
+	}
+
+	chips := 1
+	for _, s := range strings.Split(topo, "x") {
+		x, _ := strconv.Atoi(s)
+		chips *= x
+	}
+	if chips < 1 || chips%nodeCount != 0 {
+		return 0, fmt.Errorf("invalid topology: %v, %v chips cannot be evenly split across %v hosts", topo, chips, nodeCount)
+	}
+
+	return chips / nodeCount, nil
+}
+
 // tpuMachineType takes an accelerator type (from nodeSelector) and a TPU request
 // from container requests and returns the corresponding machine type.
 func tpuMachineType(accel string, tpuRequest int) (string, error) {
@@ -0,0 +1,106 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/kelseyhightower/envconfig"
+	containerv1beta1 "google.golang.org/api/container/v1beta1"
+)
+
+func init() {
+	RegisterProvider("gke", newGKE)
+}
+
+// gkeConfig is read from the environment when the "gke" provider is used.
+type gkeConfig struct {
+	GCPProjectID          string `envconfig:"GCP_PROJECT_ID"`
+	GCPClusterLocation    string `envconfig:"GCP_CLUSTER_LOCATION"`
+	GCPZone               string `envconfig:"GCP_ZONE"`
+	GCPCluster            string `envconfig:"GCP_CLUSTER"`
+	GCPNodeServiceAccount string `envconfig:"GCP_NODE_SERVICE_ACCOUNT"`
+
+	GCPNodeTags               []string `envconfig:"GCP_NODE_TAGS"`
+	GCPPodToNodeLabels        []string `envconfig:"GCP_POD_TO_NODE_LABELS"`
+	GCPNodeSecondaryDisk      string   `envconfig:"GCP_NODE_SECONDARY_DISK" default:""`
+	GCPNodeSecureBoot         bool     `envconfig:"GCP_NODE_SECURE_BOOT" default:"true"`
+	GCPNodeAdditionalNetworks string   `envconfig:"GCP_NODE_ADDITIONAL_NETWORKS" default:""`
+
+	// GCPForceOnDemand forces the controller to create nodes on demand, even if
+	// the Pod requests a reservation or spot.
+	GCPForceOnDemand bool `envconfig:"GCP_FORCE_ON_DEMAND" default:"false"`
+}
+
+func newGKE(opts ProviderOptions) (Provider, error) {
+	var cfg gkeConfig
+	if err := envconfig.Process("", &cfg); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	if metadata.OnGCE() {
+		// Attempt to infer cluster information from GKE metadata server.
+		md := metadata.NewClient(&http.Client{})
+		var err error
+
+		if cfg.GCPProjectID == "" {
+			cfg.GCPProjectID, err = md.ProjectID()
+			if err != nil {
+				return nil, fmt.Errorf("fetching project id from metadata server: %w", err)
+			}
+		}
+		if cfg.GCPCluster == "" {
+			cfg.GCPCluster, err = md.InstanceAttributeValue("cluster-name")
+			if err != nil {
+				return nil, fmt.Errorf("fetching cluster name from metadata server: %w", err)
+			}
+		}
+		if cfg.GCPClusterLocation == "" {
+			cfg.GCPClusterLocation, err = md.InstanceAttributeValue("cluster-location")
+			if err != nil {
+				return nil, fmt.Errorf("fetching cluster location from metadata server: %w", err)
+			}
+		}
+		if cfg.GCPZone == "" {
+			cfg.GCPZone, err = md.Zone()
+			if err != nil {
+				return nil, fmt.Errorf("fetching zone from metadata server: %w", err)
+			}
+		}
+	}
+
+	log.Info("creating gke client",
+		"project", cfg.GCPProjectID,
+		"clusterLocation", cfg.GCPClusterLocation,
+		"cluster", cfg.GCPCluster,
+		"zone", cfg.GCPZone,
+		"nodeServiceAccount", cfg.GCPNodeServiceAccount,
+		"nodeTags", cfg.GCPNodeTags,
+		"podToNodeLabels", cfg.GCPPodToNodeLabels,
+	)
+
+	containers, err := containerv1beta1.NewService(context.Background() /*, option.WithCredentials(creds)*/)
+	if err != nil {
+		return nil, fmt.Errorf("creating gke client: %w", err)
+	}
+
+	return &GKE{
+		Service: containers,
+		ClusterContext: GKEContext{
+			ProjectID:              cfg.GCPProjectID,
+			ClusterLocation:        cfg.GCPClusterLocation,
+			Cluster:                cfg.GCPCluster,
+			NodeZone:               cfg.GCPZone,
+			NodeServiceAccount:     cfg.GCPNodeServiceAccount,
+			NodeAdditionalNetworks: cfg.GCPNodeAdditionalNetworks,
+			NodeSecondaryDisk:      cfg.GCPNodeSecondaryDisk,
+			NodeTags:               cfg.GCPNodeTags,
+			PodToNodeLabels:        cfg.GCPPodToNodeLabels,
+			NodeSecureBoot:         cfg.GCPNodeSecureBoot,
+			ForceOnDemand:          cfg.GCPForceOnDemand,
+		},
+		Recorder: opts.Recorder,
+		Usage:    opts.Usage,
+	}, nil
+}
@@ -1,6 +1,7 @@
 package cloud
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -159,6 +160,24 @@ func Test_tpuMachineType(t *testing.T) {
 	}
 }
 
+func Test_machineTypeChips(t *testing.T) {
+	cases := map[string]int{
+		"ct4p-hightpu-4t":  4,
+		"ct5lp-hightpu-1t": 1,
+		"ct5lp-hightpu-8t": 8,
+		"ct6e-standard-4t": 4,
+		"n2-standard-8":    0,
+		"not-a-machine":    0,
+	}
+	for machineType, chips := range cases {
+		t.Run(machineType, func(t *testing.T) {
+			if got := machineTypeChips(machineType); got != chips {
+				t.Fatalf("chips: expected: %v, got: %v", chips, got)
+			}
+		})
+	}
+}
+
 func TestPodToNodePoolName(t *testing.T) {
 	var jobKey = "759730a97e4373f3a0ee12805db065e3a4a649a5"
 
@@ -203,7 +222,7 @@ func TestPodToNodePoolName(t *testing.T) {
 					},
 				},
 			},
-			expectedName: fmt.Sprintf("myjobset-%s", jobKey[:jobKeySuffixLength]),
+			expectedName: "myjobset-5a416fda",
 		},
 		{
 			name: "jobset name more than 34 chars",
@@ -216,7 +235,7 @@ func TestPodToNodePoolName(t *testing.T) {
 					},
 				},
 			},
-			expectedName: fmt.Sprintf("%s-%s", "myjobset-aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"[:maxJobSetPrefixLength], jobKey[:jobKeySuffixLength]),
+			expectedName: "myjobset-aaaaaaaaaaaaaaaaaaaaaa-74994c63",
 		},
 	}
 
@@ -237,6 +256,186 @@ func TestPodToNodePoolName(t *testing.T) {
 	}
 }
 
+func Test_nodePoolName(t *testing.T) {
+	jobKey := "759730a97e4373f3a0ee12805db065e3a4a649a5"
+	cases := []struct {
+		namespace string
+		jobSet    string
+		attempt   int
+		want      string
+	}{
+		{namespace: "", jobSet: "myjobset", want: "myjobset-5a416fda"},
+		{namespace: "team-b", jobSet: "myjobset", want: "myjobset-db0eeb20"},
+		{namespace: "", jobSet: "myjobset", attempt: 1, want: "myjobset-73326347"},
+		{namespace: "team-b", jobSet: "myjobset-aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", want: "myjobset-aaaaaaaaaaaaaaaaaaaaaa-82fcdae0"},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s/%s/%d", c.namespace, c.jobSet, c.attempt), func(t *testing.T) {
+			if got := nodePoolName(c.namespace, c.jobSet, jobKey, c.attempt); got != c.want {
+				t.Errorf("expected: %v, got: %v", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_nodePoolOwnedBy(t *testing.T) {
+	nodePool := func(labels map[string]string) *containerv1beta1.NodePool {
+		l := map[string]string{
+			LabelNodepoolManager: LabelNodepoolManagerTPUPodinator,
+			LabelJobSetName:      "jobset-test",
+			LabelJobSetNamespace: "default",
+		}
+		for k, v := range labels {
+			if v == "" {
+				delete(l, k)
+				continue
+			}
+			l[k] = v
+		}
+		return &containerv1beta1.NodePool{Config: &containerv1beta1.NodeConfig{Labels: l}}
+	}
+	cases := []struct {
+		desc        string
+		np          *containerv1beta1.NodePool
+		owned       bool
+		legacyOwned bool
+	}{
+		{desc: "same jobset and job", np: nodePool(map[string]string{LabelJobKey: "key-a"}), owned: true, legacyOwned: true},
+		{desc: "created without job-key label", np: nodePool(nil), legacyOwned: true},
+		{desc: "other job", np: nodePool(map[string]string{LabelJobKey: "key-b"})},
+		{desc: "other namespace", np: nodePool(map[string]string{LabelJobSetNamespace: "team-b"})},
+		{desc: "other jobset", np: nodePool(map[string]string{LabelJobSetName: "jobset-other"})},
+		{desc: "not managed by provisioner", np: nodePool(map[string]string{LabelNodepoolManager: ""})},
+		{desc: "no config", np: &containerv1beta1.NodePool{}},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			if got := nodePoolOwnedBy(c.np, "default", "jobset-test", "key-a"); got != c.owned {
+				t.Errorf("nodePoolOwnedBy expected: %v, got: %v", c.owned, got)
+			}
+			if got := legacyNodePoolOwnedBy(c.np, "default", "jobset-test", "key-a"); got != c.legacyOwned {
+				t.Errorf("legacyNodePoolOwnedBy expected: %v, got: %v", c.legacyOwned, got)
+			}
+		})
+	}
+}
+
+func Test_legacyNodePoolName(t *testing.T) {
+	jobKey := "759730a97e4373f3a0ee12805db065e3a4a649a5"
+	cases := []struct {
+		jobSet string
+		want   string
+	}{
+		{jobSet: "myjobset", want: "myjobset-75973"},
+		{jobSet: "myjobset-aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", want: "myjobset-aaaaaaaaaaaaaaaaaaaaaaaaa-75973"},
+	}
+	for _, c := range cases {
+		t.Run(c.jobSet, func(t *testing.T) {
+			if got := legacyNodePoolName(c.jobSet, jobKey); got != c.want {
+				t.Errorf("expected: %v, got: %v", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_resolveNodePoolName(t *testing.T) {
+	const namespace, jobSetName = "default", "train"
+	// Jobs train-tpu-82304 and train-tpu-85379 hash to the same first node pool name.
+	keyA, keyB := jobKey(namespace, "train-tpu-82304"), jobKey(namespace, "train-tpu-85379")
+	if nodePoolName(namespace, jobSetName, keyA, 0) != nodePoolName(namespace, jobSetName, keyB, 0) {
+		t.Fatal("expected the first node pool names of both jobs to collide")
+	}
+
+	nodePool := func(name, jobKey string) *containerv1beta1.NodePool {
+		labels := map[string]string{
+			LabelNodepoolManager: LabelNodepoolManagerTPUPodinator,
+			LabelJobSetName:      jobSetName,
+			LabelJobSetNamespace: namespace,
+		}
+		if jobKey != "" {
+			labels[LabelJobKey] = jobKey
+		}
+		return &containerv1beta1.NodePool{Name: name, Config: &containerv1beta1.NodeConfig{Labels: labels}}
+	}
+	firstName := nodePoolName(namespace, jobSetName, keyB, 0)
+	secondName := nodePoolName(namespace, jobSetName, keyB, 1)
+	legacyName := legacyNodePoolName(jobSetName, keyB)
+
+	cases := []struct {
+		desc         string
+		nodePools    []*containerv1beta1.NodePool
+		wantName     string
+		wantExisting bool
+		wantErr      error
+	}{
+		{
+			desc:     "no node pools",
+			wantName: firstName,
+		},
+		{
+			desc:         "own node pool",
+			nodePools:    []*containerv1beta1.NodePool{nodePool(firstName, keyB)},
+			wantName:     firstName,
+			wantExisting: true,
+		},
+		{
+			desc:      "first name taken by colliding job",
+			nodePools: []*containerv1beta1.NodePool{nodePool(firstName, keyA)},
+			wantName:  secondName,
+		},
+		{
+			desc:         "second name owned after collision",
+			nodePools:    []*containerv1beta1.NodePool{nodePool(firstName, keyA), nodePool(secondName, keyB)},
+			wantName:     secondName,
+			wantExisting: true,
+		},
+		{
+			desc:         "legacy node pool",
+			nodePools:    []*containerv1beta1.NodePool{nodePool(legacyName, "")},
+			wantName:     legacyName,
+			wantExisting: true,
+		},
+		{
+			desc:      "legacy name taken by other job",
+			nodePools: []*containerv1beta1.NodePool{nodePool(legacyName, keyA)},
+			wantName:  firstName,
+		},
+		{
+			desc: "all names taken",
+			nodePools: func() []*containerv1beta1.NodePool {
+				var nps []*containerv1beta1.NodePool
+				for attempt := 0; attempt < maxNodePoolNameAttempts; attempt++ {
+					nps = append(nps, nodePool(nodePoolName(namespace, jobSetName, keyB, attempt), keyA))
+				}
+				return nps
+			}(),
+			wantErr: ErrNodePoolNameConflict,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			lookup := func(name string) (*containerv1beta1.NodePool, error) {
+				for _, np := range c.nodePools {
+					if np.Name == name {
+						return np, nil
+					}
+				}
+				return nil, nil
+			}
+			name, existing, err := resolveNodePoolName(namespace, jobSetName, keyB, lookup)
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("expected error %v, got %v", c.wantErr, err)
+			}
+			if name != c.wantName {
+				t.Errorf("expected name %v, got %v", c.wantName, name)
+			}
+			if (existing != nil) != c.wantExisting {
+				t.Errorf("expected existing node pool: %v, got %v", c.wantExisting, existing)
+			}
+		})
+	}
+}
+
 func TestNodePoolForPod(t *testing.T) {
 	tests := []struct {
 		desc                  string
@@ -255,6 +454,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -297,6 +498,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "1",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -324,6 +527,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -356,6 +561,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -382,6 +589,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -415,6 +624,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -446,6 +657,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -472,6 +685,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -498,6 +713,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -529,6 +746,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -561,6 +780,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -593,6 +814,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -621,6 +844,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -663,6 +888,8 @@ func TestNodePoolForPod(t *testing.T) {
 						"google.com/nodepool-manager":                 "tpu-provisioner",
 						"google.com/tpu-provisioner-jobset-name":      "jobset-test",
 						"google.com/tpu-provisioner-jobset-namespace": "default",
+						"google.com/tpu-provisioner-node-count":       "512",
+						"google.com/tpu-provisioner-job-key":          "random-key",
 						"google.com/tpu-provisioner-parent-kind":      "job",
 						"google.com/tpu-provisioner-parent-name":      "jobset-test-job-1-0",
 						"google.com/tpu-provisioner-parent-namespace": "default",
@@ -0,0 +1,276 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/usage"
+	containerv1beta1 "google.golang.org/api/container/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	jobset "sigs.k8s.io/jobset/api/jobset/v1alpha2"
+)
+
+const (
+	// KwokNodeAnnotation marks Nodes that are managed by KWOK.
+	KwokNodeAnnotation = "kwok.x-k8s.io/node"
+)
+
+var _ Provider = &Kwok{}
+
+func init() {
+	RegisterProvider("kwok", func(opts ProviderOptions) (Provider, error) {
+		if opts.Client == nil {
+			return nil, fmt.Errorf("kwok provider requires a client")
+		}
+		return &Kwok{Client: opts.Client, Recorder: opts.Recorder, Usage: opts.Usage}, nil
+	})
+}
+
+// Kwok "provisions" node pools by creating fake Nodes in the cluster, which are
+// kept Ready by KWOK (https://kwok.sigs.k8s.io). It is useful to exercise the
+// controllers end to end, for example in a kind cluster, without cloud credentials.
+//
+// A node pool is the set of Nodes with the same GKENodePoolNameLabel, so that
+// JobSets using exclusive placement on that label behave like they do on GKE.
+type Kwok struct {
+	Client   client.Client
+	Recorder record.EventRecorder
+	// Usage is an optional ledger that node pool lifetimes are recorded to.
+	Usage *usage.Ledger
+}
+
+func (k *Kwok) NodePoolLabelKey() string { return GKENodePoolNameLabel }
+
+func (k *Kwok) EnsureNodePoolForPod(p *corev1.Pod, why string) error {
+	ctx := context.TODO()
+
+	jobSetName, jobKey, err := podJobSetAndJobKey(p)
+	if err != nil {
+		return err
+	}
+
+	name, existing, err := resolveNodePoolName(p.Namespace, jobSetName, jobKey, func(name string) (*containerv1beta1.NodePool, error) {
+		return k.nodePool(ctx, name)
+	})
+	if err != nil {
+		return fmt.Errorf("resolving node pool name: %w", err)
+	}
+	if existing != nil {
+		return nil
+	}
+
+	nodes, err := kwokNodesForPod(name, p)
+	if err != nil {
+		return fmt.Errorf("determining node pool for pod: %w", err)
+	}
+
+	k.Recorder.Eventf(p, corev1.EventTypeNormal, EventNodePoolCreationStarted, "Starting creation of Node Pool %s (size = %v) for JobSet %s because %s", name, len(nodes), jobSetName, why)
+	log.Info(fmt.Sprintf("creating kwok node pool %s for jobset %s", name, jobSetName))
+
+	for _, n := range nodes {
+		if err := k.Client.Create(ctx, n); err != nil && !apierrors.IsAlreadyExists(err) {
+			k.Recorder.Eventf(p, corev1.EventTypeWarning, EventNodePoolCreationFailed, "Request to create Node Pool %s failed: %v.", name, err)
+			return fmt.Errorf("creating node %s: %w", n.Name, err)
+		}
+	}
+
+	k.Recorder.Eventf(p, corev1.EventTypeNormal, EventNodePoolCreationSucceeded, "Successfully created Node Pool %s.", name)
+
+	if k.Usage != nil {
+		if err := k.Usage.Start(kwokUsageRecord(name, nodes, p, jobSetName)); err != nil {
+			log.Error(err, "failed to record node pool usage", "nodepool", name)
+		}
+	}
+
+	return nil
+}
+
+func (k *Kwok) DeleteNodePoolForNode(node *corev1.Node, why string) error {
+	name, ok := node.GetLabels()[k.NodePoolLabelKey()]
+	if !ok {
+		return fmt.Errorf("node %q does not have node pool label", node.Name)
+	}
+
+	return k.DeleteNodePool(name, node, why)
+}
+
+func (k *Kwok) DeleteNodePool(name string, eventObj client.Object, why string) error {
+	ctx := context.TODO()
+
+	nodes, err := k.nodePoolNodes(ctx, name)
+	if err != nil {
+		return fmt.Errorf("listing nodes of node pool %q: %w", name, err)
+	}
+	if len(nodes) == 0 {
+		k.Recorder.Eventf(eventObj, corev1.EventTypeNormal, EventNodePoolNotFound, "Node pool %s not found - ignoring deletion attempt.", name)
+		k.endUsage(name)
+		return nil
+	}
+
+	k.Recorder.Eventf(eventObj, corev1.EventTypeNormal, EventNodePoolDeletionStarted, "Starting deletion of Node Pool %s because %s", name, why)
+	for i := range nodes {
+		if err := k.Client.Delete(ctx, &nodes[i]); client.IgnoreNotFound(err) != nil {
+			k.Recorder.Eventf(eventObj, corev1.EventTypeWarning, EventNodePoolDeletionFailed, "Request to delete Node Pool %s failed: %v.", name, err)
+			return fmt.Errorf("deleting node %s: %w", nodes[i].Name, err)
+		}
+	}
+	k.Recorder.Eventf(eventObj, corev1.EventTypeNormal, EventNodePoolDeletionSucceeded, "Successfully deleted Node Pool %s.", name)
+	k.endUsage(name)
+
+	return nil
+}
+
+func (k *Kwok) endUsage(name string) {
+	if k.Usage == nil {
+		return
+	}
+	if _, _, err := k.Usage.End(name, time.Now()); err != nil {
+		log.Error(err, "failed to record node pool usage", "nodepool", name)
+	}
+}
+
+func (k *Kwok) ListNodePools() ([]NodePoolRef, error) {
+	var nodes corev1.NodeList
+	if err := k.Client.List(context.TODO(), &nodes, client.MatchingLabels{LabelNodepoolManager: LabelNodepoolManagerTPUPodinator}); err != nil {
+		return nil, fmt.Errorf("listing nodes: %w", err)
+	}
+
+	pools := map[string]*NodePoolRef{}
+	for _, n := range nodes.Items {
+		name, ok := n.Labels[GKENodePoolNameLabel]
+		if !ok || n.Annotations[KwokNodeAnnotation] == "" {
+			continue
+		}
+		ref, ok := pools[name]
+		if !ok {
+			ref = &NodePoolRef{
+				Name:         name,
+				CreationTime: n.CreationTimestamp.Time,
+				CreatedForJobSet: types.NamespacedName{
+					Name:      n.Labels[LabelJobSetName],
+					Namespace: n.Labels[LabelJobSetNamespace],
+				},
+			}
+			pools[name] = ref
+		}
+		if n.CreationTimestamp.Time.Before(ref.CreationTime) {
+			ref.CreationTime = n.CreationTimestamp.Time
+		}
+	}
+
+	refs := make([]NodePoolRef, 0, len(pools))
+	for _, ref := range pools {
+		refs = append(refs, *ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+func (k *Kwok) nodePoolNodes(ctx context.Context, name string) ([]corev1.Node, error) {
+	var nodes corev1.NodeList
+	if err := k.Client.List(ctx, &nodes, client.MatchingLabels{GKENodePoolNameLabel: name}); err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+// nodePool returns the node pool with the given name, or nil if it has no
+// Nodes. Only the name and the labels of its first Node are set, which is
+// enough to check who it was created for.
+func (k *Kwok) nodePool(ctx context.Context, name string) (*containerv1beta1.NodePool, error) {
+	nodes, err := k.nodePoolNodes(ctx, name)
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	return &containerv1beta1.NodePool{
+		Name:   name,
+		Config: &containerv1beta1.NodeConfig{Labels: nodes[0].Labels},
+	}, nil
+}
+
+// kwokUsageRecord returns the usage record for the fake Nodes of a node pool
+// created for the given pod, accounted like the GKE node pool it stands in for.
+func kwokUsageRecord(name string, nodes []*corev1.Node, p *corev1.Pod, jobSetName string) usage.Record {
+	np := &containerv1beta1.NodePool{
+		Name:             name,
+		InitialNodeCount: int64(len(nodes)),
+		Config: &containerv1beta1.NodeConfig{
+			MachineType: nodes[0].Labels[corev1.LabelInstanceTypeStable],
+			Spot:        p.Spec.NodeSelector["cloud.google.com/gke-spot"] == "true",
+		},
+	}
+	if _, ok := p.Spec.NodeSelector["cloud.google.com/reservation-name"]; ok {
+		np.Config.ReservationAffinity = &containerv1beta1.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION"}
+	}
+	return usageRecordForNodePool(np, jobSetName, p.Namespace)
+}
+
+// kwokNodesForPod returns the fake Nodes of a node pool for the given pod. They
+// carry the same labels a GKE node pool would have, so that the pod's node
+// selectors match.
+func kwokNodesForPod(name string, p *corev1.Pod) ([]*corev1.Node, error) {
+	jobSetName := p.Labels[jobset.JobSetNameKey]
+	if jobSetName == "" {
+		return nil, fmt.Errorf("pod %s is not part of a jobset, not constructing node pool config for it", p.Name)
+	}
+
+	slice, err := tpuSliceForPod(p)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := corev1.ResourceList{
+		corev1.ResourceCPU:                     resource.MustParse("32"),
+		corev1.ResourceMemory:                  resource.MustParse("256Gi"),
+		corev1.ResourcePods:                    resource.MustParse(strconv.Itoa(maxPodsPerNode)),
+		corev1.ResourceName(GoogleTPUResource): *resource.NewQuantity(int64(slice.ChipsPerNode), resource.DecimalSI),
+	}
+
+	nodes := make([]*corev1.Node, 0, slice.NodeCount)
+	for i := 0; i < slice.NodeCount; i++ {
+		nodeName := fmt.Sprintf("%s-%d", name, i)
+		labels := map[string]string{
+			LabelNodepoolManager:           LabelNodepoolManagerTPUPodinator,
+			LabelJobSetName:                jobSetName,
+			LabelJobSetNamespace:           p.Namespace,
+			GKENodePoolNameLabel:           name,
+			corev1.LabelHostname:           nodeName,
+			corev1.LabelInstanceTypeStable: slice.MachineType,
+			"type":                         "kwok",
+		}
+		if jobKey := p.Labels[jobset.JobKey]; jobKey != "" {
+			labels[LabelJobKey] = jobKey
+		}
+		// Unlike on GKE, cloud.google.com/ labels (topology, accelerator, spot, ...)
+		// are not set by anything else, so all node selectors are copied.
+		for k, v := range p.Spec.NodeSelector {
+			labels[k] = v
+		}
+
+		nodes = append(nodes, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   nodeName,
+				Labels: labels,
+				Annotations: map[string]string{
+					KwokNodeAnnotation: "fake",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Capacity:    capacity,
+				Allocatable: capacity,
+			},
+		})
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,162 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/usage"
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestKwokNodePoolLifecycle(t *testing.T) {
+	k := &Kwok{
+		Client:   fake.NewClientBuilder().Build(),
+		Recorder: record.NewFakeRecorder(100),
+	}
+	// 2x2x2 v5p with 4 chips per host is a node pool of 2 nodes.
+	pod := buildPod(nil, nil, map[string]string{GKETPUNodeSelector: "2x2x2"}, nil)
+	name, err := podToNodePoolName(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := k.EnsureNodePoolForPod(pod, "test"); err != nil {
+			t.Fatalf("EnsureNodePoolForPod: %v", err)
+		}
+	}
+
+	nodes, err := k.nodePoolNodes(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %v", len(nodes))
+	}
+	node := nodes[0]
+	for key, want := range map[string]string{
+		GKENodePoolNameLabel:       name,
+		GKETPUNodeSelector:         "2x2x2",
+		GKEAcceleratorNodeSelector: V5pPodSliceAccelerator,
+		LabelNodepoolManager:       LabelNodepoolManagerTPUPodinator,
+		LabelJobSetName:            "jobset-test",
+		LabelJobSetNamespace:       "default",
+		LabelJobKey:                "random-key",
+	} {
+		if got := node.Labels[key]; got != want {
+			t.Errorf("label %s: expected %q, got %q", key, want, got)
+		}
+	}
+	if got := node.Status.Allocatable[GoogleTPUResource]; !got.Equal(resource.MustParse("4")) {
+		t.Errorf("expected 4 allocatable TPU chips, got %v", got.String())
+	}
+
+	refs, err := k.ListNodePools()
+	if err != nil {
+		t.Fatalf("ListNodePools: %v", err)
+	}
+	want := []NodePoolRef{{
+		Name:             name,
+		CreationTime:     node.CreationTimestamp.Time,
+		CreatedForJobSet: types.NamespacedName{Name: "jobset-test", Namespace: "default"},
+	}}
+	if diff := cmp.Diff(want, refs); diff != "" {
+		t.Errorf("ListNodePools() unexpected result, diff (-want +got): \n%s", diff)
+	}
+
+	if err := k.DeleteNodePoolForNode(&node, "test"); err != nil {
+		t.Fatalf("DeleteNodePoolForNode: %v", err)
+	}
+	if nodes, _ := k.nodePoolNodes(context.Background(), name); len(nodes) != 0 {
+		t.Errorf("expected all nodes to be deleted, got %v", len(nodes))
+	}
+	if err := k.DeleteNodePool(name, pod, "test"); err != nil {
+		t.Errorf("deleting a node pool that does not exist should not fail: %v", err)
+	}
+}
+
+func TestKwokNodePoolNameTaken(t *testing.T) {
+	pod := buildPod(nil, nil, map[string]string{GKETPUNodeSelector: "2x2x2"}, nil)
+	taken := nodePoolName("default", "jobset-test", "random-key", 0)
+	other := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: taken + "-0",
+			Labels: map[string]string{
+				LabelNodepoolManager: LabelNodepoolManagerTPUPodinator,
+				LabelJobSetName:      "jobset-test",
+				LabelJobSetNamespace: "default",
+				LabelJobKey:          "other-key",
+				GKENodePoolNameLabel: taken,
+			},
+			Annotations: map[string]string{KwokNodeAnnotation: "fake"},
+		},
+	}
+	k := &Kwok{
+		Client:   fake.NewClientBuilder().WithObjects(other).Build(),
+		Recorder: record.NewFakeRecorder(100),
+	}
+
+	if err := k.EnsureNodePoolForPod(pod, "test"); err != nil {
+		t.Fatalf("EnsureNodePoolForPod: %v", err)
+	}
+
+	if nodes, _ := k.nodePoolNodes(context.Background(), taken); len(nodes) != 1 {
+		t.Errorf("expected the node pool of the other job to be left alone, got %v nodes", len(nodes))
+	}
+	name := nodePoolName("default", "jobset-test", "random-key", 1)
+	if nodes, _ := k.nodePoolNodes(context.Background(), name); len(nodes) != 2 {
+		t.Errorf("expected 2 nodes in node pool %s, got %v", name, len(nodes))
+	}
+}
+
+func TestKwokUsage(t *testing.T) {
+	ledger, err := usage.NewLedger("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &Kwok{
+		Client:   fake.NewClientBuilder().Build(),
+		Recorder: record.NewFakeRecorder(100),
+		Usage:    ledger,
+	}
+	pod := buildPod(nil, nil, map[string]string{GKETPUNodeSelector: "2x2x2", "cloud.google.com/gke-spot": "true"}, nil)
+	name, err := podToNodePoolName(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.EnsureNodePoolForPod(pod, "test"); err != nil {
+		t.Fatalf("EnsureNodePoolForPod: %v", err)
+	}
+	open := ledger.Open()
+	if len(open) != 1 {
+		t.Fatalf("expected 1 open usage record, got %v", len(open))
+	}
+	open[0].Start = time.Time{}
+	want := usage.Record{
+		NodePool:     name,
+		JobSetName:   "jobset-test",
+		Namespace:    "default",
+		MachineType:  "ct5p-hightpu-4t",
+		NodeCount:    2,
+		ChipsPerNode: 4,
+		Tier:         usage.TierSpot,
+	}
+	if diff := cmp.Diff(want, open[0]); diff != "" {
+		t.Errorf("usage record unexpected, diff (-want +got): \n%s", diff)
+	}
+
+	if err := k.DeleteNodePool(name, pod, "test"); err != nil {
+		t.Fatalf("DeleteNodePool: %v", err)
+	}
+	if open := ledger.Open(); len(open) != 0 {
+		t.Errorf("expected usage of the deleted node pool to end, got %v open records", len(open))
+	}
+}
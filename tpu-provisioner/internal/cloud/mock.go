@@ -7,6 +7,10 @@ import (
 
 var _ Provider = &Mock{}
 
+func init() {
+	RegisterProvider("mock", func(ProviderOptions) (Provider, error) { return &Mock{}, nil })
+}
+
 // Mock is useful for local development or debugging purposes to understand what
 // the controller would do without it doing anything.
 type Mock struct{}
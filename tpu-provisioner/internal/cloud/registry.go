@@ -0,0 +1,72 @@
+package cloud
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/usage"
+
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ProviderOptions are passed to every ProviderFactory. Provider specific
+// configuration is read by the factory itself (for example from the environment).
+type ProviderOptions struct {
+	Client   client.Client
+	Recorder record.EventRecorder
+	// Usage is an optional ledger that node pool lifetimes are recorded to.
+	Usage *usage.Ledger
+}
+
+// ProviderFactory constructs a Provider.
+type ProviderFactory func(opts ProviderOptions) (Provider, error)
+
+var (
+	providersMtx sync.Mutex
+	providers    = map[string]ProviderFactory{}
+)
+
+// RegisterProvider makes a Provider available by name, it is meant to be
+// called from init functions. Registering the same name twice panics.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMtx.Lock()
+	defer providersMtx.Unlock()
+
+	name = strings.ToLower(name)
+	if _, ok := providers[name]; ok {
+		panic(fmt.Sprintf("provider %q already registered", name))
+	}
+	providers[name] = factory
+}
+
+// NewProvider constructs the Provider registered under the given name.
+func NewProvider(name string, opts ProviderOptions) (Provider, error) {
+	providersMtx.Lock()
+	factory, ok := providers[strings.ToLower(name)]
+	providersMtx.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unrecognized provider %q, registered providers: %s", name, strings.Join(RegisteredProviders(), ", "))
+	}
+
+	p, err := factory(opts)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s provider: %w", name, err)
+	}
+	return p, nil
+}
+
+// RegisteredProviders returns the sorted names of all registered providers.
+func RegisteredProviders() []string {
+	providersMtx.Lock()
+	defer providersMtx.Unlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
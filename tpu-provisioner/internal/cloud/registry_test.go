@@ -0,0 +1,42 @@
+package cloud
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRegisteredProviders(t *testing.T) {
+	if diff := cmp.Diff([]string{"gke", "kwok", "mock"}, RegisteredProviders()); diff != "" {
+		t.Errorf("unexpected registered providers, diff (-want +got): \n%s", diff)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	p, err := NewProvider("MOCK", ProviderOptions{})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	if _, ok := p.(*Mock); !ok {
+		t.Errorf("expected a *Mock, got %T", p)
+	}
+
+	if _, err := NewProvider("kwok", ProviderOptions{}); err == nil {
+		t.Errorf("expected kwok provider without client to fail")
+	}
+
+	_, err = NewProvider("not-a-provider", ProviderOptions{})
+	if err == nil || !strings.Contains(err.Error(), "gke, kwok, mock") {
+		t.Errorf("expected error listing registered providers, got: %v", err)
+	}
+}
+
+func TestRegisterProviderTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a provider twice to panic")
+		}
+	}()
+	RegisterProvider("gke", newGKE)
+}
@@ -0,0 +1,139 @@
+package cloud
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	jobset "sigs.k8s.io/jobset/api/jobset/v1alpha2"
+)
+
+// maxNodePoolNameLength is the maximum length of a GKE node pool name.
+const maxNodePoolNameLength = 40
+
+var nodePoolNameRegexp = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// ValidatePodTemplate runs the same derivations that are done when a node pool
+// is created for a pod (node count, machine type, chips per host, additional
+// networks) and returns everything that would make that fail.
+// Pod specs that do not select a TPU topology are not validated.
+// A nil fldPath can be used when validating a Pod itself.
+func ValidatePodTemplate(meta *metav1.ObjectMeta, spec *corev1.PodSpec, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	selectorPath := fldPath.Child("spec", "nodeSelector")
+	tpuTopo, ok := spec.NodeSelector[GKETPUNodeSelector]
+	if !ok {
+		return nil
+	}
+	accel, ok := spec.NodeSelector[GKEAcceleratorNodeSelector]
+	if !ok {
+		errs = append(errs, field.Required(selectorPath.Key(GKEAcceleratorNodeSelector),
+			fmt.Sprintf("required when %s is set", GKETPUNodeSelector)))
+	}
+
+	tpuRequest, err := sumTPURequests(&corev1.Pod{Spec: *spec})
+	if err != nil {
+		errs = append(errs, field.Invalid(fldPath.Child("spec", "containers"), tpuRequest, err.Error()))
+	} else if tpuRequest < 1 {
+		errs = append(errs, field.Required(fldPath.Child("spec", "containers"),
+			fmt.Sprintf("at least one container must request %s when %s is set", GoogleTPUResource, GKETPUNodeSelector)))
+	}
+
+	if accel != "" {
+		if _, err := tpuMachineType(accel, max(tpuRequest, 1)); err != nil {
+			errs = append(errs, field.NotSupported(selectorPath.Key(GKEAcceleratorNodeSelector), accel,
+				[]string{V4PodSliceAccelerator, V5ePodSliceAccelerator, V5pPodSliceAccelerator, V6eSliceAccelerator}))
+		} else if chipsPerHost, err := tpuChipsPerHost(accel, tpuTopo); err != nil {
+			errs = append(errs, field.Invalid(selectorPath.Key(GKETPUNodeSelector), tpuTopo, err.Error()))
+		} else if tpuRequest > 0 && tpuRequest != chipsPerHost {
+			errs = append(errs, field.Invalid(fldPath.Child("spec", "containers"), tpuRequest,
+				fmt.Sprintf("%s request must equal the %v chips per host of a %s %s slice", GoogleTPUResource, chipsPerHost, tpuTopo, accel)))
+		}
+	}
+
+	if csv := meta.Annotations[AnnotationAdditionalNodeNetworks]; csv != "" {
+		if _, err := parseAdditionalNodeNetworks(csv); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Child("metadata", "annotations").Key(AnnotationAdditionalNodeNetworks), csv,
+				fmt.Sprintf("%v, expected format: \"<network>:<subnet>, ...\"", err)))
+		}
+	}
+
+	return errs
+}
+
+// ValidatePod validates a pod the same way ValidatePodTemplate does. When the
+// pod is part of a JobSet, the name of its node pool is validated as well.
+func ValidatePod(p *corev1.Pod) field.ErrorList {
+	errs := ValidatePodTemplate(&p.ObjectMeta, &p.Spec, nil)
+	if len(errs) > 0 || p.Labels[jobset.JobSetNameKey] == "" || p.Labels[jobset.JobKey] == "" {
+		return errs
+	}
+	if _, ok := p.Spec.NodeSelector[GKETPUNodeSelector]; !ok {
+		return errs
+	}
+
+	// All candidate names (see nodePoolName) share the JobSet name prefix and
+	// the hash length, so validating the first one is enough.
+	name, err := podToNodePoolName(p)
+	if err != nil {
+		return append(errs, field.Invalid(field.NewPath("metadata", "labels"), p.Labels, err.Error()))
+	}
+	if msg := validateNodePoolName(name); msg != "" {
+		errs = append(errs, field.Invalid(field.NewPath("metadata", "labels").Key(jobset.JobSetNameKey), p.Labels[jobset.JobSetNameKey], msg))
+	}
+	return errs
+}
+
+// ValidateJobSet validates the pod templates of all replicated jobs that select
+// a TPU topology and checks that their node pool names are valid.
+// Jobs whose node pool names collide are not rejected, the collision is
+// resolved when the node pools are created (see resolveNodePoolName).
+func ValidateJobSet(js *jobset.JobSet) field.ErrorList {
+	var errs field.ErrorList
+
+	rjobsPath := field.NewPath("spec", "replicatedJobs")
+	for i, rjob := range js.Spec.ReplicatedJobs {
+		tmplPath := rjobsPath.Index(i).Child("template", "spec", "template")
+		tmpl := &rjob.Template.Spec.Template
+		if _, ok := tmpl.Spec.NodeSelector[GKETPUNodeSelector]; !ok {
+			continue
+		}
+		errs = append(errs, ValidatePodTemplate(&tmpl.ObjectMeta, &tmpl.Spec, tmplPath)...)
+
+		// The names of all jobs share the JobSet name prefix and the hash
+		// length, so validating the name of the first job is enough.
+		jobName := fmt.Sprintf("%s-%s-%d", js.Name, rjob.Name, 0)
+		name := nodePoolName(js.Namespace, js.Name, jobKey(js.Namespace, jobName), 0)
+		if msg := validateNodePoolName(name); msg != "" {
+			errs = append(errs, field.Invalid(field.NewPath("metadata", "name"), js.Name, msg))
+			return errs
+		}
+	}
+
+	return errs
+}
+
+// validateNodePoolName returns a message describing why the given name is
+// not a valid GKE node pool name, or an empty string if it is valid.
+func validateNodePoolName(name string) string {
+	if len(name) > maxNodePoolNameLength {
+		return fmt.Sprintf("node pool name %q must be no more than %v characters", name, maxNodePoolNameLength)
+	}
+	if !nodePoolNameRegexp.MatchString(name) {
+		return fmt.Sprintf("node pool name %q must consist of lower case alphanumeric characters or '-', start with a letter and end with an alphanumeric character", name)
+	}
+	return ""
+}
+
+// jobKey returns the job-key label value that JobSet sets on pods of the given job,
+// which is the SHA1 hash of the namespaced job name.
+func jobKey(namespace, jobName string) string {
+	sum := sha1.Sum([]byte(namespace + "/" + jobName))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,166 @@
+package cloud
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	jobset "sigs.k8s.io/jobset/api/jobset/v1alpha2"
+)
+
+func errorFields(errs field.ErrorList) []string {
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	return fields
+}
+
+func tpuPodSpec(accel, topo string, chips string) *corev1.PodSpec {
+	spec := &corev1.PodSpec{
+		NodeSelector: map[string]string{
+			GKETPUNodeSelector: topo,
+		},
+		Containers: []corev1.Container{{Name: "main"}},
+	}
+	if accel != "" {
+		spec.NodeSelector[GKEAcceleratorNodeSelector] = accel
+	}
+	if chips != "" {
+		spec.Containers[0].Resources.Requests = corev1.ResourceList{
+			GoogleTPUResource: resource.MustParse(chips),
+		}
+	}
+	return spec
+}
+
+func TestValidatePod(t *testing.T) {
+	cases := []struct {
+		desc        string
+		labels      map[string]string
+		annotations map[string]string
+		spec        *corev1.PodSpec
+		wantFields  []string
+	}{
+		{
+			desc: "valid",
+		},
+		{
+			desc: "valid single host v5e",
+			spec: tpuPodSpec(V5ePodSliceAccelerator, "1x1", "1"),
+		},
+		{
+			desc: "not a TPU pod",
+			spec: &corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}},
+		},
+		{
+			desc:       "missing accelerator",
+			spec:       tpuPodSpec("", "2x2x1", "4"),
+			wantFields: []string{"spec.nodeSelector[cloud.google.com/gke-tpu-accelerator]"},
+		},
+		{
+			desc:       "unknown accelerator",
+			spec:       tpuPodSpec("tpu-v1-slice", "2x2x1", "4"),
+			wantFields: []string{"spec.nodeSelector[cloud.google.com/gke-tpu-accelerator]"},
+		},
+		{
+			desc:       "wrong topology dimensions",
+			spec:       tpuPodSpec(V5pPodSliceAccelerator, "2x2", "4"),
+			wantFields: []string{"spec.nodeSelector[cloud.google.com/gke-tpu-topology]"},
+		},
+		{
+			desc:       "request does not match chips per host",
+			spec:       tpuPodSpec(V5pPodSliceAccelerator, "2x2x4", "8"),
+			wantFields: []string{"spec.containers"},
+		},
+		{
+			desc:       "no TPU request",
+			spec:       tpuPodSpec(V4PodSliceAccelerator, "2x2x1", ""),
+			wantFields: []string{"spec.containers"},
+		},
+		{
+			desc:        "invalid additional networks annotation",
+			annotations: map[string]string{AnnotationAdditionalNodeNetworks: "vpc1:subnet1, vpc2"},
+			wantFields:  []string{"metadata.annotations[tpu-provisioner.cloud.google.com/additional-node-networks]"},
+		},
+		{
+			desc:       "invalid node pool name",
+			labels:     map[string]string{jobset.JobSetNameKey: "1-jobset"},
+			wantFields: []string{"metadata.labels[jobset.sigs.k8s.io/jobset-name]"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			errs := ValidatePod(buildPod(c.labels, c.annotations, nil, c.spec))
+			if diff := cmp.Diff(c.wantFields, errorFields(errs)); diff != "" {
+				t.Errorf("unexpected invalid fields, diff (-want +got): \n%s\nerrors: %v", diff, errs)
+			}
+		})
+	}
+}
+
+func TestValidateJobSet(t *testing.T) {
+	buildJobSet := func(name string, replicas int32, spec *corev1.PodSpec) *jobset.JobSet {
+		return &jobset.JobSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: jobset.JobSetSpec{
+				ReplicatedJobs: []jobset.ReplicatedJob{
+					{
+						Name:     "tpu",
+						Replicas: replicas,
+						Template: batchv1.JobTemplateSpec{
+							Spec: batchv1.JobSpec{
+								Template: corev1.PodTemplateSpec{Spec: *spec},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	cases := []struct {
+		desc       string
+		js         *jobset.JobSet
+		wantFields []string
+	}{
+		{
+			desc: "valid",
+			js:   buildJobSet("train", 4, tpuPodSpec(V5pPodSliceAccelerator, "2x2x4", "4")),
+		},
+		{
+			desc: "not a TPU jobset",
+			js:   buildJobSet("train", 1, &corev1.PodSpec{}),
+		},
+		{
+			desc:       "invalid pod template",
+			js:         buildJobSet("train", 1, tpuPodSpec(V6eSliceAccelerator, "2x2", "1")),
+			wantFields: []string{"spec.replicatedJobs[0].template.spec.template.spec.containers"},
+		},
+		{
+			desc:       "invalid node pool name",
+			js:         buildJobSet("Train", 1, tpuPodSpec(V5pPodSliceAccelerator, "2x2x4", "4")),
+			wantFields: []string{"metadata.name"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			errs := ValidateJobSet(c.js)
+			if diff := cmp.Diff(c.wantFields, errorFields(errs)); diff != "" {
+				t.Errorf("unexpected invalid fields, diff (-want +got): \n%s\nerrors: %v", diff, errs)
+			}
+		})
+	}
+}
+
+func Test_jobKey(t *testing.T) {
+	// Value as set by the JobSet controller on pods of job "jobset-test-job-1-0".
+	want := "78c32d3b806d5259ce7902ed2e8e4979a05d8875"
+	if got := jobKey("default", "jobset-test-job-1-0"); got != want {
+		t.Errorf("expected: %v, got: %v", want, got)
+	}
+}
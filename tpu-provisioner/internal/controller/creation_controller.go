@@ -20,13 +20,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/cloud"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -34,11 +37,24 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	jobset "sigs.k8s.io/jobset/api/jobset/v1alpha2"
 )
 
 // When this pod label is set to "true", the TPU provisioner will not reconcile the pod.
 const DisableAutoProvisioningLabel = "tpu-provisioner.cloud.google.com/disable-autoprovisioning"
 
+// AutoProvisioningDisabled returns true if the object has
+// "tpu-provisioner.cloud.google.com/disable-autoprovisioning=true"
+// set as a label or annotation. Otherwise, it returns false.
+func AutoProvisioningDisabled(obj metav1.Object) bool {
+	return obj.GetLabels()[DisableAutoProvisioningLabel] == "true" || obj.GetAnnotations()[DisableAutoProvisioningLabel] == "true"
+}
+
+// driftRecheckInterval is how long to wait before checking again whether a
+// drifted node pool can be changed.
+const driftRecheckInterval = 30 * time.Second
+
 // CreationReconciler watches Pods and creates Node Pools.
 type CreationReconciler struct {
 	client.Client
@@ -75,8 +91,11 @@ func (r *CreationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 
 	lg.Info("Ensuring node pool for unschedulable pod")
 	if err := r.Provider.EnsureNodePoolForPod(&pod, "pod is currently unschedulable"); err != nil {
+		var drift *cloud.NodePoolDriftError
 		if errors.Is(err, cloud.ErrDuplicateRequest) {
 			lg.V(3).Info("Ignoring duplicate request to create node pool", "message", err.Error())
+		} else if errors.As(err, &drift) {
+			return r.handleNodePoolDrift(ctx, &pod, drift)
 		} else if errors.Is(err, cloud.ErrNodePoolStopping) {
 			wait := 5 * time.Second
 			lg.Info("Attempted to create a node pool that is currently undergoing deletion, retrying soon",
@@ -90,6 +109,63 @@ func (r *CreationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	return ctrl.Result{}, nil
 }
 
+// handleNodePoolDrift resizes or replaces a node pool that no longer matches the
+// pod it was created for. This is only done while the JobSet is still active and
+// none of the pods of the job are running, so that running workloads are never
+// disrupted. Replacing a node pool deletes it, it is then recreated on requeue.
+func (r *CreationReconciler) handleNodePoolDrift(ctx context.Context, pod *corev1.Pod, drift *cloud.NodePoolDriftError) (ctrl.Result, error) {
+	lg := ctrllog.FromContext(ctx).WithValues("nodepool", drift.NodePool, "drift", drift.Drift)
+
+	r.Recorder.Event(pod, corev1.EventTypeWarning, cloud.EventNodePoolDriftDetected, drift.Error())
+
+	var js jobset.JobSet
+	if err := r.Get(ctx, types.NamespacedName{Name: pod.Labels[jobset.JobSetNameKey], Namespace: pod.Namespace}, &js); err != nil {
+		if apierrors.IsNotFound(err) {
+			lg.Info("JobSet no longer exists, not changing drifted node pool")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("getting jobset: %w", err)
+	}
+	if jobSetCompleted(&js) || jobSetFailed(&js) || (js.Spec.Suspend != nil && *js.Spec.Suspend) {
+		lg.Info("JobSet is not active, not changing drifted node pool")
+		return ctrl.Result{}, nil
+	}
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(pod.Namespace), client.MatchingLabels{jobset.JobKey: pod.Labels[jobset.JobKey]}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("listing pods of job: %w", err)
+	}
+	for _, p := range pods.Items {
+		// Pending pods that are bound to a node are about to run on it.
+		if p.Status.Phase == corev1.PodRunning || (p.Status.Phase == corev1.PodPending && p.Spec.NodeName != "") {
+			lg.Info("Pods of the job are still running, waiting before changing drifted node pool", "pod", p.Name, "wait", driftRecheckInterval)
+			return ctrl.Result{RequeueAfter: driftRecheckInterval}, nil
+		}
+	}
+
+	why := fmt.Sprintf("it does not match pod %s (%s)", pod.Name, strings.Join(drift.Drift, ", "))
+	if resizer, ok := r.Provider.(cloud.NodePoolResizer); ok && drift.Resizable {
+		lg.Info("Resizing drifted node pool", "nodeCount", drift.NodeCount)
+		if err := resizer.ResizeNodePool(drift.NodePool, drift.NodeCount, pod, why); err != nil {
+			if errors.Is(err, cloud.ErrDuplicateRequest) {
+				return ctrl.Result{RequeueAfter: driftRecheckInterval}, nil
+			}
+			return ctrl.Result{}, fmt.Errorf("resizing node pool: %w", err)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	lg.Info("Replacing drifted node pool")
+	if err := r.Provider.DeleteNodePool(drift.NodePool, pod, why); err != nil {
+		if errors.Is(err, cloud.ErrDuplicateRequest) {
+			return ctrl.Result{RequeueAfter: driftRecheckInterval}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("deleting node pool: %w", err)
+	}
+	// Requeue to create the node pool again, now matching the pod.
+	return ctrl.Result{Requeue: true}, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *CreationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
@@ -107,7 +183,7 @@ func (r *CreationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				isUnschedulable(pod) &&
 				doesRequestResource(pod, r.PodCriteria.ResourceType) &&
 				hasNodeSelectors(pod, cloud.GKETPUNodeSelector) &&
-				!autoProvisioningDisabled(pod) &&
+				!AutoProvisioningDisabled(pod) &&
 				!podDeleted(pod)
 		})).
 		Complete(r)
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/cloud"
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/usage"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -21,6 +22,9 @@ type NodePoolGarbageCollector struct {
 	Interval time.Duration
 	client.Client
 	Provider cloud.Provider
+	// Usage is an optional ledger whose records are ended for node pools
+	// that no longer exist.
+	Usage *usage.Ledger
 }
 
 func (g *NodePoolGarbageCollector) Run(ctx context.Context) {
@@ -44,6 +48,16 @@ func (g *NodePoolGarbageCollector) Run(ctx context.Context) {
 			continue
 		}
 
+		if g.Usage != nil {
+			names := make([]string, 0, len(nodepools))
+			for _, np := range nodepools {
+				names = append(names, np.Name)
+			}
+			if err := g.Usage.Reconcile(names, time.Now()); err != nil {
+				log.Error(err, "failed to reconcile node pool usage")
+			}
+		}
+
 		for _, np := range nodepools {
 			log := log.WithValues(
 				"nodepool", np.Name,
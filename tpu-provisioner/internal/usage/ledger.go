@@ -0,0 +1,315 @@
+package usage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("usage")
+
+// Tier is the capacity tier a node pool was provisioned from.
+type Tier string
+
+const (
+	TierOnDemand    Tier = "on-demand"
+	TierSpot        Tier = "spot"
+	TierReservation Tier = "reservation"
+)
+
+func (t Tier) Valid() bool {
+	switch t {
+	case TierOnDemand, TierSpot, TierReservation:
+		return true
+	}
+	return false
+}
+
+// Record describes the usage of a single provisioned node pool.
+type Record struct {
+	NodePool     string `json:"nodePool"`
+	JobSetName   string `json:"jobSetName"`
+	Namespace    string `json:"namespace"`
+	MachineType  string `json:"machineType"`
+	NodeCount    int    `json:"nodeCount"`
+	ChipsPerNode int    `json:"chipsPerNode"`
+	Tier         Tier   `json:"tier"`
+
+	Start time.Time `json:"start"`
+	// End, ChipHours and Cost are only set once the node pool is gone.
+	End       *time.Time `json:"end,omitempty"`
+	ChipHours float64    `json:"chipHours,omitempty"`
+	Cost      float64    `json:"cost,omitempty"`
+}
+
+// Chips returns the total number of TPU chips in the node pool.
+func (r Record) Chips() int {
+	return r.NodeCount * r.ChipsPerNode
+}
+
+const (
+	eventStart = "start"
+	eventEnd   = "end"
+)
+
+// entry is a single line in the ledger file.
+type entry struct {
+	Event string `json:"event"`
+	Record
+}
+
+type openRecord struct {
+	Record
+	accruedUntil time.Time
+}
+
+// Ledger keeps track of node pool lifetimes and accounts chip-hours (and their
+// cost) per namespace, JobSet and tier.
+//
+// Every start and end of a node pool is appended to an optional JSONL file so
+// that usage survives controller restarts and can be exported for chargeback.
+// Chip-hours are also continuously accrued into Prometheus counters while a node
+// pool is alive (see Run).
+type Ledger struct {
+	Prices PriceTable
+
+	mu   sync.Mutex
+	path string
+	open map[string]*openRecord
+
+	now func() time.Time
+}
+
+// NewLedger returns a Ledger that appends to the JSONL file at path (if not empty).
+// Node pools that were started but not ended in an existing file are restored.
+func NewLedger(path string, prices PriceTable) (*Ledger, error) {
+	l := &Ledger{
+		Prices: prices,
+		path:   path,
+		open:   map[string]*openRecord{},
+		now:    time.Now,
+	}
+	if path != "" {
+		if err := l.replay(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *Ledger) replay() error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("opening usage ledger: %w", err)
+	}
+	defer f.Close()
+
+	now := l.now()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("parsing usage ledger %q line %d: %w", l.path, line, err)
+		}
+		switch e.Event {
+		case eventStart:
+			// Counters do not survive restarts, only accrue from now on. The full
+			// duration is still accounted for in the end entry.
+			l.open[e.NodePool] = &openRecord{Record: e.Record, accruedUntil: now}
+		case eventEnd:
+			delete(l.open, e.NodePool)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading usage ledger: %w", err)
+	}
+
+	for _, r := range l.open {
+		activeChips.WithLabelValues(r.Namespace, string(r.Tier)).Add(float64(r.Chips()))
+	}
+	return nil
+}
+
+// Start records that a node pool has been created. Starting a node pool that
+// is already being tracked is a no-op.
+func (l *Ledger) Start(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.open[r.NodePool]; ok {
+		return nil
+	}
+	if r.Start.IsZero() {
+		r.Start = l.now()
+	}
+	r.End, r.ChipHours, r.Cost = nil, 0, 0
+
+	if err := l.append(entry{Event: eventStart, Record: r}); err != nil {
+		return err
+	}
+	l.open[r.NodePool] = &openRecord{Record: r, accruedUntil: r.Start}
+	activeChips.WithLabelValues(r.Namespace, string(r.Tier)).Add(float64(r.Chips()))
+	return nil
+}
+
+// End records that a node pool has been deleted and returns the final record.
+// The boolean is false if the node pool was not being tracked.
+func (l *Ledger) End(nodePool string, at time.Time) (Record, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.open[nodePool]
+	if !ok {
+		return Record{}, false, nil
+	}
+	l.accrue(r, at)
+
+	final := r.Record
+	end := at
+	if end.Before(final.Start) {
+		end = final.Start
+	}
+	final.End = &end
+	final.ChipHours = float64(final.Chips()) * end.Sub(final.Start).Hours()
+	final.Cost = final.ChipHours * l.Prices.ChipHourPrice(final.MachineType, final.Tier)
+
+	if err := l.append(entry{Event: eventEnd, Record: final}); err != nil {
+		return final, true, err
+	}
+	delete(l.open, nodePool)
+	activeChips.WithLabelValues(final.Namespace, string(final.Tier)).Sub(float64(final.Chips()))
+	return final, true, nil
+}
+
+// Resize records that the number of nodes of a tracked node pool has changed,
+// by ending its current record and starting a new one with the new node count.
+// Resizing to the node count that is already recorded is a no-op, so that
+// repeated resizes do not split the record.
+func (l *Ledger) Resize(nodePool string, nodeCount int, at time.Time) error {
+	l.mu.Lock()
+	open, tracked := l.open[nodePool]
+	unchanged := tracked && open.NodeCount == nodeCount
+	l.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	r, ok, err := l.End(nodePool, at)
+	if err != nil || !ok {
+		return err
+	}
+	r.NodeCount = nodeCount
+	r.Start = at
+	return l.Start(r)
+}
+
+// Reconcile ends all tracked node pools that are not in the given list of
+// existing node pools, for example because they were deleted out-of-band.
+func (l *Ledger) Reconcile(existing []string, at time.Time) error {
+	exists := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		exists[name] = true
+	}
+
+	var gone []string
+	l.mu.Lock()
+	for name := range l.open {
+		if !exists[name] {
+			gone = append(gone, name)
+		}
+	}
+	l.mu.Unlock()
+
+	var errs []error
+	for _, name := range gone {
+		log.Info("node pool no longer exists, ending usage record", "nodepool", name)
+		if _, _, err := l.End(name, at); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Accrue adds the chip-hours (and cost) used by all open node pools up until
+// the given time to the Prometheus counters.
+func (l *Ledger) Accrue(at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, r := range l.open {
+		l.accrue(r, at)
+	}
+}
+
+func (l *Ledger) accrue(r *openRecord, at time.Time) {
+	if !at.After(r.accruedUntil) {
+		return
+	}
+	chipHours := float64(r.Chips()) * at.Sub(r.accruedUntil).Hours()
+	r.accruedUntil = at
+
+	labels := []string{r.Namespace, r.JobSetName, string(r.Tier), r.MachineType}
+	chipHoursTotal.WithLabelValues(labels...).Add(chipHours)
+	costTotal.WithLabelValues(labels...).Add(chipHours * l.Prices.ChipHourPrice(r.MachineType, r.Tier))
+}
+
+// Run periodically accrues usage of open node pools until the context is done.
+func (l *Ledger) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.Accrue(l.now())
+			return
+		case <-t.C:
+			l.Accrue(l.now())
+		}
+	}
+}
+
+// Open returns a snapshot of the node pools currently being tracked, ordered by name.
+func (l *Ledger) Open() []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	records := make([]Record, 0, len(l.open))
+	for _, r := range l.open {
+		records = append(records, r.Record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].NodePool < records[j].NodePool })
+	return records
+}
+
+func (l *Ledger) append(e entry) error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshalling usage entry: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("opening usage ledger: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing usage ledger: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,181 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testRecord(name string) Record {
+	return Record{
+		NodePool:     name,
+		JobSetName:   "jobset-test",
+		Namespace:    "team-a",
+		MachineType:  "ct5p-hightpu-4t",
+		NodeCount:    2,
+		ChipsPerNode: 4,
+		Tier:         TierSpot,
+		Start:        t0,
+	}
+}
+
+func TestLedgerStartEnd(t *testing.T) {
+	prices := PriceTable{"ct5p": {TierSpot: 1.5}}
+	l, err := NewLedger(filepath.Join(t.TempDir(), "usage.jsonl"), prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Start(testRecord("np-a")); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	// Starting the same node pool again must not reset its start time.
+	again := testRecord("np-a")
+	again.Start = t0.Add(time.Hour)
+	if err := l.Start(again); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	got, ok, err := l.End("np-a", t0.Add(90*time.Minute))
+	if err != nil || !ok {
+		t.Fatalf("End: ok=%v err=%v", ok, err)
+	}
+	end := t0.Add(90 * time.Minute)
+	want := testRecord("np-a")
+	want.End = &end
+	want.ChipHours = 12 // 8 chips * 1.5h
+	want.Cost = 18      // 12 chip-hours * 1.5
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("End() unexpected record, diff (-want +got): \n%s", diff)
+	}
+
+	if _, ok, _ := l.End("np-a", t0.Add(2*time.Hour)); ok {
+		t.Errorf("End() of an already ended node pool should report not tracked")
+	}
+}
+
+func TestLedgerResize(t *testing.T) {
+	l, err := NewLedger("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(testRecord("np-a")); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := l.Resize("np-a", 4, t0.Add(time.Hour)); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+
+	want := testRecord("np-a")
+	want.NodeCount = 4
+	want.Start = t0.Add(time.Hour)
+	if diff := cmp.Diff([]Record{want}, l.Open()); diff != "" {
+		t.Errorf("unexpected open records after resize, diff (-want +got): \n%s", diff)
+	}
+
+	if err := l.Resize("np-a", 4, t0.Add(2*time.Hour)); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	if diff := cmp.Diff([]Record{want}, l.Open()); diff != "" {
+		t.Errorf("resizing to the same node count should not change the open record, diff (-want +got): \n%s", diff)
+	}
+
+	if err := l.Resize("np-untracked", 4, t0.Add(time.Hour)); err != nil {
+		t.Errorf("Resize() of an untracked node pool should be a no-op, got: %v", err)
+	}
+}
+
+func TestLedgerReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	l, err := NewLedger(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"np-a", "np-b"} {
+		if err := l.Start(testRecord(name)); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+	}
+	if _, _, err := l.End("np-a", t0.Add(time.Hour)); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("expected 3 ledger entries, got %v", lines)
+	}
+
+	restored, err := NewLedger(path, nil)
+	if err != nil {
+		t.Fatalf("NewLedger: %v", err)
+	}
+	if diff := cmp.Diff([]Record{testRecord("np-b")}, restored.Open()); diff != "" {
+		t.Errorf("unexpected open records after replay, diff (-want +got): \n%s", diff)
+	}
+}
+
+func TestLedgerAccrueAndReconcile(t *testing.T) {
+	l, err := NewLedger("", PriceTable{"ct5p-hightpu-4t": {TierSpot: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := testRecord("np-accrue")
+	r.JobSetName = "jobset-accrue"
+	if err := l.Start(r); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	labels := []string{r.Namespace, r.JobSetName, string(r.Tier), r.MachineType}
+	l.Accrue(t0.Add(30 * time.Minute))
+	if got := testutil.ToFloat64(chipHoursTotal.WithLabelValues(labels...)); got != 4 {
+		t.Errorf("chip hours after 30m: expected 4, got %v", got)
+	}
+
+	if err := l.Reconcile([]string{"some-other-pool"}, t0.Add(time.Hour)); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if open := l.Open(); len(open) != 0 {
+		t.Errorf("expected node pool to be ended by reconcile, still open: %v", open)
+	}
+	if got := testutil.ToFloat64(chipHoursTotal.WithLabelValues(labels...)); got != 8 {
+		t.Errorf("chip hours after 1h: expected 8, got %v", got)
+	}
+	if got := testutil.ToFloat64(costTotal.WithLabelValues(labels...)); got != 16 {
+		t.Errorf("cost after 1h: expected 16, got %v", got)
+	}
+}
+
+func TestPriceTableChipHourPrice(t *testing.T) {
+	prices := PriceTable{
+		"ct5p":             {TierOnDemand: 4.2, TierSpot: 1.9},
+		"ct5lp-hightpu-8t": {TierOnDemand: 1.2},
+	}
+	cases := []struct {
+		machineType string
+		tier        Tier
+		price       float64
+	}{
+		{"ct5p-hightpu-4t", TierOnDemand, 4.2},
+		{"ct5p-hightpu-4t", TierSpot, 1.9},
+		{"ct5p-hightpu-4t", TierReservation, 0},
+		{"ct5lp-hightpu-8t", TierOnDemand, 1.2},
+		{"ct5lp-hightpu-4t", TierOnDemand, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.machineType+"_"+string(c.tier), func(t *testing.T) {
+			if got := prices.ChipHourPrice(c.machineType, c.tier); got != c.price {
+				t.Errorf("expected: %v, got: %v", c.price, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package usage
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	chipHoursTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tpu_provisioner_chip_hours_total",
+		Help: "TPU chip-hours used by provisioned node pools.",
+	}, []string{"namespace", "jobset", "tier", "machine_type"})
+
+	costTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tpu_provisioner_cost_total",
+		Help: "Cost of TPU chip-hours used by provisioned node pools, according to the configured price table.",
+	}, []string{"namespace", "jobset", "tier", "machine_type"})
+
+	activeChips = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tpu_provisioner_active_chips",
+		Help: "TPU chips in node pools that are currently provisioned.",
+	}, []string{"namespace", "tier"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(chipHoursTotal, costTotal, activeChips)
+}
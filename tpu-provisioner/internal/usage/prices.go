@@ -0,0 +1,61 @@
+package usage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// PriceTable maps a machine type (for example "ct5p-hightpu-4t") or a machine
+// family prefix (for example "ct5p") to a per chip-hour price for each Tier.
+//
+// Example YAML:
+//
+//	ct5p:
+//	  on-demand: 4.20
+//	  spot: 1.89
+//	  reservation: 2.94
+//	ct5lp-hightpu-8t:
+//	  on-demand: 1.20
+type PriceTable map[string]map[Tier]float64
+
+// LoadPriceTable reads a PriceTable from a YAML or JSON file.
+func LoadPriceTable(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading price table: %w", err)
+	}
+	var table PriceTable
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("parsing price table %q: %w", path, err)
+	}
+	for key, tiers := range table {
+		for tier, price := range tiers {
+			if !tier.Valid() {
+				return nil, fmt.Errorf("price table entry %q: unknown tier %q", key, tier)
+			}
+			if price < 0 {
+				return nil, fmt.Errorf("price table entry %q: negative price for tier %q", key, tier)
+			}
+		}
+	}
+	return table, nil
+}
+
+// ChipHourPrice returns the price of one chip-hour for the given machine type
+// and tier. An exact machine type entry takes precedence over a family entry.
+// Zero is returned when no price is configured.
+func (p PriceTable) ChipHourPrice(machineType string, tier Tier) float64 {
+	if tiers, ok := p[machineType]; ok {
+		if price, ok := tiers[tier]; ok {
+			return price
+		}
+	}
+	family, _, _ := strings.Cut(machineType, "-")
+	if tiers, ok := p[family]; ok {
+		return tiers[tier]
+	}
+	return 0
+}
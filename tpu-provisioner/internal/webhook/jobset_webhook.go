@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/cloud"
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/controller"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	jobset "sigs.k8s.io/jobset/api/jobset/v1alpha2"
+)
+
+//+kubebuilder:webhook:path=/validate-jobset-x-k8s-io-v1alpha2-jobset,mutating=false,failurePolicy=ignore,sideEffects=None,groups=jobset.x-k8s.io,resources=jobsets,verbs=create;update,versions=v1alpha2,name=vjobset.tpu-provisioner.cloud.google.com,admissionReviewVersions=v1
+
+// JobSetValidator rejects JobSets with TPU pod templates that the provisioner
+// would not be able to create node pools for, or whose name does not make a
+// valid node pool name.
+type JobSetValidator struct{}
+
+var _ admission.CustomValidator = &JobSetValidator{}
+
+// SetupWithManager registers the webhook with the Manager's webhook server.
+func (v *JobSetValidator) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&jobset.JobSet{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *JobSetValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(obj)
+}
+
+func (v *JobSetValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(newObj)
+}
+
+func (v *JobSetValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *JobSetValidator) validate(obj runtime.Object) error {
+	js, ok := obj.(*jobset.JobSet)
+	if !ok {
+		return fmt.Errorf("expected a JobSet but got a %T", obj)
+	}
+	if controller.AutoProvisioningDisabled(js) {
+		return nil
+	}
+
+	if errs := cloud.ValidateJobSet(js); len(errs) > 0 {
+		return apierrors.NewInvalid(jobset.GroupVersion.WithKind("JobSet").GroupKind(), js.Name, errs)
+	}
+	return nil
+}
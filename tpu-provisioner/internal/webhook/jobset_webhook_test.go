@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/cloud"
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/controller"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	jobset "sigs.k8s.io/jobset/api/jobset/v1alpha2"
+)
+
+func TestJobSetValidator(t *testing.T) {
+	buildJobSet := func(name string, labels map[string]string, spec corev1.PodSpec) *jobset.JobSet {
+		return &jobset.JobSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec: jobset.JobSetSpec{
+				ReplicatedJobs: []jobset.ReplicatedJob{{
+					Name:     "tpu",
+					Replicas: 2,
+					Template: batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{Spec: spec},
+						},
+					},
+				}},
+			},
+		}
+	}
+	invalidSpec := tpuPodSpec(cloud.V6eSliceAccelerator, "2x2", "1")
+
+	cases := []struct {
+		desc    string
+		js      *jobset.JobSet
+		invalid bool
+	}{
+		{
+			desc: "valid TPU JobSet",
+			js:   buildJobSet("train", nil, tpuPodSpec(cloud.V5pPodSliceAccelerator, "2x2x4", "4")),
+		},
+		{
+			desc: "not a TPU JobSet",
+			js:   buildJobSet("train", nil, corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}}),
+		},
+		{
+			desc:    "invalid pod template",
+			js:      buildJobSet("train", nil, invalidSpec),
+			invalid: true,
+		},
+		{
+			desc:    "name is not a valid node pool name",
+			js:      buildJobSet("Train", nil, tpuPodSpec(cloud.V5pPodSliceAccelerator, "2x2x4", "4")),
+			invalid: true,
+		},
+		{
+			desc: "auto-provisioning disabled",
+			js:   buildJobSet("train", map[string]string{controller.DisableAutoProvisioningLabel: "true"}, invalidSpec),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			v := &JobSetValidator{}
+			_, err := v.ValidateCreate(context.Background(), c.js)
+			if c.invalid != apierrors.IsInvalid(err) || (!c.invalid && err != nil) {
+				t.Errorf("ValidateCreate() expected invalid: %v, got error: %v", c.invalid, err)
+			}
+			_, err = v.ValidateUpdate(context.Background(), c.js, c.js)
+			if c.invalid != apierrors.IsInvalid(err) || (!c.invalid && err != nil) {
+				t.Errorf("ValidateUpdate() expected invalid: %v, got error: %v", c.invalid, err)
+			}
+		})
+	}
+
+	t.Run("not a JobSet", func(t *testing.T) {
+		if _, err := (&JobSetValidator{}).ValidateCreate(context.Background(), &corev1.Pod{}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
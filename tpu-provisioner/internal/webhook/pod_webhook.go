@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/cloud"
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/controller"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/validate--v1-pod,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=vpod.tpu-provisioner.cloud.google.com,admissionReviewVersions=v1
+
+// PodValidator rejects TPU pods that the provisioner would not be able to
+// create a node pool for.
+type PodValidator struct{}
+
+var _ admission.CustomValidator = &PodValidator{}
+
+// SetupWithManager registers the webhook with the Manager's webhook server.
+func (v *PodValidator) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&corev1.Pod{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *PodValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("expected a Pod but got a %T", obj)
+	}
+	if controller.AutoProvisioningDisabled(pod) {
+		return nil, nil
+	}
+
+	if errs := cloud.ValidatePod(pod); len(errs) > 0 {
+		name := pod.Name
+		if name == "" {
+			name = pod.GenerateName
+		}
+		return nil, apierrors.NewInvalid(corev1.SchemeGroupVersion.WithKind("Pod").GroupKind(), name, errs)
+	}
+	return nil, nil
+}
+
+func (v *PodValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *PodValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
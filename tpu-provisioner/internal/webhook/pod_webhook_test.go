@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/cloud"
+	"github.com/GoogleCloudPlatform/ai-on-gke/tpu-provisioner/internal/controller"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	jobset "sigs.k8s.io/jobset/api/jobset/v1alpha2"
+)
+
+// tpuPodSpec returns a pod spec selecting the given TPU slice with a single
+// container requesting the given number of chips.
+func tpuPodSpec(accelerator, topology, chips string) corev1.PodSpec {
+	return corev1.PodSpec{
+		NodeSelector: map[string]string{
+			cloud.GKEAcceleratorNodeSelector: accelerator,
+			cloud.GKETPUNodeSelector:         topology,
+		},
+		Containers: []corev1.Container{{
+			Name: "main",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{cloud.GoogleTPUResource: resource.MustParse(chips)},
+			},
+		}},
+	}
+}
+
+func TestPodValidator(t *testing.T) {
+	buildPod := func(labels, annotations map[string]string, spec corev1.PodSpec) *corev1.Pod {
+		l := map[string]string{
+			jobset.JobSetNameKey: "train",
+			jobset.JobKey:        "759730a97e4373f3a0ee12805db065e3a4a649a5",
+		}
+		for k, v := range labels {
+			l[k] = v
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "train-", Namespace: "default", Labels: l, Annotations: annotations},
+			Spec:       spec,
+		}
+	}
+	invalidSpec := tpuPodSpec(cloud.V6eSliceAccelerator, "2x2", "1")
+
+	cases := []struct {
+		desc    string
+		pod     *corev1.Pod
+		invalid bool
+	}{
+		{
+			desc: "valid TPU pod",
+			pod:  buildPod(nil, nil, tpuPodSpec(cloud.V5pPodSliceAccelerator, "2x2x4", "4")),
+		},
+		{
+			desc: "not a TPU pod",
+			pod:  buildPod(nil, nil, corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}}),
+		},
+		{
+			desc:    "TPU request does not match chips per host",
+			pod:     buildPod(nil, nil, invalidSpec),
+			invalid: true,
+		},
+		{
+			desc:    "JobSet name is not a valid node pool name",
+			pod:     buildPod(map[string]string{jobset.JobSetNameKey: "Train"}, nil, tpuPodSpec(cloud.V5pPodSliceAccelerator, "2x2x4", "4")),
+			invalid: true,
+		},
+		{
+			desc: "auto-provisioning disabled by label",
+			pod:  buildPod(map[string]string{controller.DisableAutoProvisioningLabel: "true"}, nil, invalidSpec),
+		},
+		{
+			desc: "auto-provisioning disabled by annotation",
+			pod:  buildPod(nil, map[string]string{controller.DisableAutoProvisioningLabel: "true"}, invalidSpec),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			_, err := (&PodValidator{}).ValidateCreate(context.Background(), c.pod)
+			if c.invalid != apierrors.IsInvalid(err) || (!c.invalid && err != nil) {
+				t.Errorf("expected invalid: %v, got error: %v", c.invalid, err)
+			}
+		})
+	}
+
+	t.Run("not a pod", func(t *testing.T) {
+		if _, err := (&PodValidator{}).ValidateCreate(context.Background(), &jobset.JobSet{}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}